@@ -0,0 +1,189 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Bundled advisory data, replaced at startup by -advisories-file
+//
+//go:embed data/advisories.json
+var bundledAdvisories []byte
+
+// advisoryData is the format of data/advisories.json.
+type advisoryData struct {
+	Updated    string          `json:"updated"`
+	Advisories []advisoryEntry `json:"advisories"`
+	EndOfLife  []eolEntry      `json:"endOfLife"`
+}
+
+// advisoryEntry applies to versions from introduced up to, but not
+// including, fixed.
+type advisoryEntry struct {
+	Product    string `json:"product"`
+	Introduced string `json:"introduced"`
+	Fixed      string `json:"fixed"`
+	ID         string `json:"id"`
+	Summary    string `json:"summary"`
+	URL        string `json:"url"`
+}
+
+// eolEntry marks versions below a release as unsupported.
+type eolEntry struct {
+	Product string `json:"product"`
+	Below   string `json:"below"`
+	Note    string `json:"note"`
+	URL     string `json:"url"`
+}
+
+var advisories = mustParseAdvisories(bundledAdvisories)
+
+func mustParseAdvisories(data []byte) *advisoryData {
+	parsed, err := parseAdvisories(data)
+	if err != nil {
+		panic("invalid bundled advisories: " + err.Error())
+	}
+	return parsed
+}
+
+func parseAdvisories(data []byte) (*advisoryData, error) {
+	var parsed advisoryData
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// loadAdvisoriesFile replaces the bundled advisory data.
+func loadAdvisoriesFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	parsed, err := parseAdvisories(data)
+	if err != nil {
+		return err
+	}
+	advisories = parsed
+	return nil
+}
+
+const advisoryDisclaimer = "Version-based heuristic from response headers. Banners can be wrong or hide backported fixes, confirm before acting."
+
+// fingerprintServer extracts every versioned product advertised by the
+// Server and X-Powered-By headers.
+func fingerprintServer(server, poweredBy string) []ProductAdvisory {
+	var products []ProductAdvisory
+	for _, h := range []struct{ source, value string }{{"Server", server}, {"X-Powered-By", poweredBy}} {
+		info := parseServerBanner(h.value)
+		if info == nil {
+			continue
+		}
+		for _, c := range info.Components {
+			if c.Product != "" && c.Version != "" {
+				products = append(products, ProductAdvisory{Source: h.source, Product: strings.ToLower(c.Product), Version: c.Version})
+			}
+		}
+	}
+	return products
+}
+
+// serverAdvisories matches the fingerprinted products against the advisory
+// data. It returns nil when no versioned product was found.
+func serverAdvisories(server, poweredBy string) *ServerAdvisories {
+	products := fingerprintServer(server, poweredBy)
+	if len(products) == 0 {
+		return nil
+	}
+
+	data := advisories
+	for i := range products {
+		p := &products[i]
+		for _, a := range data.Advisories {
+			if a.Product == p.Product && compareVersions(p.Version, a.Introduced) >= 0 && compareVersions(p.Version, a.Fixed) < 0 {
+				p.Advisories = append(p.Advisories, Advisory{ID: a.ID, Summary: a.Summary, FixedIn: a.Fixed, URL: a.URL})
+			}
+		}
+		for _, e := range data.EndOfLife {
+			if e.Product == p.Product && compareVersions(p.Version, e.Below) < 0 {
+				p.EndOfLife = true
+				p.EOLNote = e.Note
+				p.EOLURL = e.URL
+			}
+		}
+	}
+
+	return &ServerAdvisories{Products: products, DataUpdated: data.Updated, Disclaimer: advisoryDisclaimer}
+}
+
+// compareVersions orders dotted versions numerically part by part as semver
+// does, with OpenSSL style letter suffixes ordered so that
+// 1.1.1 < 1.1.1n < 1.1.1za. Build and distribution suffixes after a "-" or
+// "+" are ignored.
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		if i >= len(pa) {
+			return -1
+		}
+		if i >= len(pb) {
+			return 1
+		}
+		x, errX := strconv.Atoi(pa[i])
+		y, errY := strconv.Atoi(pb[i])
+		switch {
+		case errX == nil && errY == nil:
+			if x != y {
+				return sign(x - y)
+			}
+		case len(pa[i]) != len(pb[i]):
+			return sign(len(pa[i]) - len(pb[i]))
+		case pa[i] != pb[i]:
+			return strings.Compare(pa[i], pb[i])
+		}
+	}
+	return 0
+}
+
+// versionParts splits "1.1.1n" into 1, 1, 1, n, stopping at the first
+// character that is neither alphanumeric nor a dot, such as "-" in
+// "8.1.2-1ubuntu2".
+func versionParts(v string) []string {
+	var parts []string
+	current := ""
+	digits := false
+	for _, r := range strings.ToLower(v) {
+		if r != '.' && !unicode.IsDigit(r) && !unicode.IsLetter(r) {
+			break
+		}
+		if r == '.' || (current != "" && unicode.IsDigit(r) != digits) {
+			if current != "" {
+				parts = append(parts, current)
+			}
+			current = ""
+			if r == '.' {
+				continue
+			}
+		}
+		current += string(r)
+		digits = unicode.IsDigit(r)
+	}
+	if current != "" {
+		parts = append(parts, current)
+	}
+	return parts
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
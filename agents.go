@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often agents re-register; the coordinator forgets agents that miss
+// three heartbeats
+const (
+	agentHeartbeat = 30 * time.Second
+	agentTTL       = 3 * agentHeartbeat
+)
+
+// Largest analysis an agent may return to the coordinator
+const maxAgentResponseSize = 32 * 1024 * 1024
+
+// agentRegistration is what an agent sends to the coordinator
+type agentRegistration struct {
+	Region string `json:"region"`
+	URL    string `json:"url"` // Where the coordinator reaches the agent
+}
+
+// agentRegistry holds the agents that registered with this coordinator,
+// keyed by URL so a restarted agent replaces its old entry.
+type agentRegistry struct {
+	mu     sync.Mutex
+	agents map[string]*AgentInfo
+}
+
+var agents = &agentRegistry{agents: make(map[string]*AgentInfo)}
+
+func (reg *agentRegistry) register(a agentRegistration) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	now := time.Now()
+	if existing, ok := reg.agents[a.URL]; ok && existing.Region == a.Region {
+		existing.LastSeen = now
+		return
+	}
+	reg.agents[a.URL] = &AgentInfo{Region: a.Region, URL: a.URL, RegisteredAt: now, LastSeen: now}
+	log.Printf("Agent registered: %s at %s", a.Region, a.URL)
+}
+
+// live returns the agents seen within agentTTL, sorted by region, and
+// drops the rest.
+func (reg *agentRegistry) live() []AgentInfo {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	var live []AgentInfo
+	for u, a := range reg.agents {
+		if time.Since(a.LastSeen) > agentTTL {
+			delete(reg.agents, u)
+			continue
+		}
+		live = append(live, *a)
+	}
+	sort.Slice(live, func(i, j int) bool {
+		if live[i].Region != live[j].Region {
+			return live[i].Region < live[j].Region
+		}
+		return live[i].URL < live[j].URL
+	})
+	return live
+}
+
+// runAgent registers this instance with the coordinator and keeps
+// re-registering until ctx is cancelled.
+func runAgent(ctx context.Context) {
+	reg := agentRegistration{Region: cfg.AgentRegion, URL: cfg.AgentURL}
+	ticker := time.NewTicker(agentHeartbeat)
+	defer ticker.Stop()
+
+	registered := false
+	for {
+		err := registerAgent(ctx, reg)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Registering with coordinator %s failed: %v", cfg.Coordinator, err)
+		} else if err == nil && !registered {
+			log.Printf("Registered with coordinator %s as region %s", cfg.Coordinator, reg.Region)
+		}
+		registered = err == nil
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func registerAgent(ctx context.Context, reg agentRegistration) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	body, _ := json.Marshal(reg)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(cfg.Coordinator, "/")+"/api/agents", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", cfg.AgentKey)
+
+	resp, err := (&http.Client{Transport: auditedTransport}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("coordinator returned %s", resp.Status)
+	}
+	return nil
+}
+
+// agentsHandler lets agents register (POST) and lists the live ones (GET).
+// Both need the shared -agent-key.
+func agentsHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg.AgentKey == "" {
+		http.Error(w, "Agent registration is disabled", http.StatusNotFound)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-API-Key")), []byte(cfg.AgentKey)) != 1 {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(agents.live())
+	case http.MethodPost:
+		var reg agentRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		u, err := url.Parse(reg.URL)
+		if reg.Region == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, "region and an http(s) url are required", http.StatusBadRequest)
+			return
+		}
+		agents.register(reg)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// regionsHandler fans an analysis request out to every live agent and
+// returns their results side by side with the differences between them.
+func regionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1024*1024))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	var reqData analysisRequest
+	if err := json.Unmarshal(body, &reqData); err != nil || reqData.Domain == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	live := agents.live()
+	if len(live) == 0 {
+		http.Error(w, "No agents registered", http.StatusServiceUnavailable)
+		return
+	}
+
+	report := &RegionalReport{Domain: reqData.Domain, Regions: make([]RegionResult, len(live))}
+	var wg sync.WaitGroup
+	for i, a := range live {
+		wg.Add(1)
+		go func(i int, a AgentInfo) {
+			defer wg.Done()
+			report.Regions[i] = analyzeViaAgent(r.Context(), a, body)
+		}(i, a)
+	}
+	wg.Wait()
+	report.Differences = compareRegions(report.Regions)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// analyzeViaAgent forwards the original request body to an agent's
+// /analyze endpoint.
+func analyzeViaAgent(ctx context.Context, a AgentInfo, body []byte) (result RegionResult) {
+	result = RegionResult{Region: a.Region, Agent: a.URL}
+	start := time.Now()
+	defer func() { result.DurationMs = millisecondsSince(start) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(a.URL, "/")+"/analyze", bytes.NewReader(body))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := (&http.Client{Transport: auditedTransport}).Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		result.Error = fmt.Sprintf("agent returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		return result
+	}
+
+	var analysis response
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxAgentResponseSize)).Decode(&analysis); err != nil {
+		result.Error = fmt.Sprintf("invalid agent response: %v", err)
+		return result
+	}
+	result.Result = &analysis
+	return result
+}
+
+// compareRegions lists what the regions saw differently: the DNS answers,
+// and headers that identify the POP or backend that answered.
+func compareRegions(regions []RegionResult) []string {
+	fields := []struct {
+		name  string
+		value func(*response) string
+	}{
+		{"A records", func(r *response) string {
+			ips := append([]string(nil), r.ARecords...)
+			sort.Strings(ips)
+			return strings.Join(ips, ", ")
+		}},
+		{"CNAME chain", func(r *response) string { return strings.Join(r.CnameRecords, " -> ") }},
+		{"TLS version", func(r *response) string { return r.TLSVersion }},
+		{"Server header", func(r *response) string { return r.ServerHeader }},
+		{"Keep-Alive timeout", func(r *response) string { return r.KeepAliveTimeout }},
+		{"Connection header", func(r *response) string { return r.ConnectionHeader }},
+		{"Cache status", func(r *response) string { return r.XCacheHeader }},
+	}
+
+	var differences []string
+	for _, f := range fields {
+		seen := make(map[string][]string) // Value to regions
+		var order []string
+		for _, region := range regions {
+			if region.Result == nil {
+				continue
+			}
+			v := f.value(region.Result)
+			if _, ok := seen[v]; !ok {
+				order = append(order, v)
+			}
+			seen[v] = append(seen[v], region.Region)
+		}
+		if len(seen) < 2 {
+			continue
+		}
+		var parts []string
+		for _, v := range order {
+			label := v
+			if label == "" {
+				label = "none"
+			}
+			parts = append(parts, fmt.Sprintf("%s: %s", strings.Join(seen[v], ", "), label))
+		}
+		differences = append(differences, fmt.Sprintf("%s differ by region (%s)", f.name, strings.Join(parts, "; ")))
+	}
+	return differences
+}
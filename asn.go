@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// lookupASN finds the network announcing ip through Team Cymru's IP to
+// ASN mapping over DNS: a TXT query for the reversed address under
+// origin.asn.cymru.com (origin6 for IPv6) gives the AS number and prefix,
+// one for AS<number>.asn.cymru.com the AS name.
+func lookupASN(ctx context.Context, resolver, ip string) ASNInfo {
+	info := ASNInfo{IP: ip}
+	name, err := cymruOriginName(ip)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+
+	// "13335 | 104.16.0.0/13 | US | arin | 2014-03-28"
+	fields, err := cymruTXT(ctx, resolver, name)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	if len(fields) < 4 {
+		info.Error = "unexpected answer from " + name
+		return info
+	}
+	// Prefixes announced by several networks list all of them
+	asns := strings.Fields(fields[0])
+	if len(asns) == 0 {
+		info.Error = "no AS number in the answer from " + name
+		return info
+	}
+	info.ASN, _ = strconv.Atoi(asns[0])
+	info.Prefix = fields[1]
+	info.Country = fields[2]
+	info.Registry = fields[3]
+
+	// "13335 | US | arin | 2010-07-14 | CLOUDFLARENET, US"
+	if fields, err := cymruTXT(ctx, resolver, fmt.Sprintf("AS%d.asn.cymru.com", info.ASN)); err == nil && len(fields) >= 5 {
+		info.Name = fields[4]
+	}
+	return info
+}
+
+// cymruOriginName returns the query name for ip, its address reversed by
+// octet or, for IPv6, by nibble.
+func cymruOriginName(ip string) (string, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", fmt.Errorf("invalid IP address %q", ip)
+	}
+	if ip4 := parsed.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", ip4[3], ip4[2], ip4[1], ip4[0]), nil
+	}
+	var nibbles []string
+	for i := net.IPv6len - 1; i >= 0; i-- {
+		nibbles = append(nibbles, fmt.Sprintf("%x.%x", parsed[i]&0xf, parsed[i]>>4))
+	}
+	return strings.Join(nibbles, ".") + ".origin6.asn.cymru.com", nil
+}
+
+// cymruTXT returns the "|" separated fields of the TXT record of name.
+func cymruTXT(ctx context.Context, resolver, name string) ([]string, error) {
+	m, _, err := sharedExchange(ctx, resolver, name, dnsTypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	for _, rr := range m.answers {
+		if txt := rr.txt(); txt != "" {
+			fields := strings.Split(txt, "|")
+			for i := range fields {
+				fields[i] = strings.TrimSpace(fields[i])
+			}
+			return fields, nil
+		}
+	}
+	return nil, fmt.Errorf("no TXT record for %s (%s)", name, dnsRCodeName(m.rcode))
+}
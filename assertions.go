@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Most assertions one request may define
+const maxAssertions = 50
+
+// metric <op> value, e.g. keepAliveTimeout >= 60 or tlsVersion == "TLS 1.3"
+var assertionPattern = regexp.MustCompile(`^\s*([A-Za-z][A-Za-z0-9]*)\s*(==|!=|>=|<=|>|<)\s*(.+?)\s*$`)
+
+// assertionMetric reads one value from a result. ordered metrics compare
+// as numbers, through number when the value is not one.
+type assertionMetric struct {
+	value   func(r *response) (string, bool) // false when the result has no value
+	ordered bool
+	number  func(s string) (float64, bool)
+}
+
+// Metrics assertions can test. Durations are in milliseconds, the keep-alive
+// timeout in seconds.
+var assertionMetrics = map[string]assertionMetric{
+	"keepAliveTimeout": {ordered: true, value: func(r *response) (string, bool) {
+		_, err := strconv.Atoi(r.KeepAliveTimeout)
+		return r.KeepAliveTimeout, err == nil
+	}},
+	"keepAliveMax": {ordered: true, value: func(r *response) (string, bool) {
+		if r.KeepAlive == nil || r.KeepAlive.Max == nil {
+			return "", false
+		}
+		return strconv.Itoa(*r.KeepAlive.Max), true
+	}},
+	"connectionHeader": {value: func(r *response) (string, bool) { return r.ConnectionHeader, true }},
+	"serverHeader":     {value: func(r *response) (string, bool) { return r.ServerHeader, true }},
+	"requestDurationMs": {ordered: true, value: func(r *response) (string, bool) {
+		return strconv.FormatInt(r.RequestDuration, 10), true
+	}},
+	"tlsVersion": {ordered: true, number: tlsVersionNumber, value: func(r *response) (string, bool) {
+		return r.TLSVersion, r.TLSVersion != "Unknown"
+	}},
+	"tlsGrade": {value: func(r *response) (string, bool) {
+		if r.TLSScore == nil {
+			return "", false
+		}
+		return r.TLSScore.Grade, true
+	}},
+	"certVerified": {value: func(r *response) (string, bool) {
+		if r.Certificate == nil {
+			return "", false
+		}
+		return strconv.FormatBool(r.Certificate.Verified), true
+	}},
+	"certExpiryDays": {ordered: true, value: func(r *response) (string, bool) {
+		if r.Certificate == nil {
+			return "", false
+		}
+		notAfter, err := time.Parse(time.RFC3339, r.Certificate.NotAfter)
+		if err != nil {
+			return "", false
+		}
+		return strconv.FormatFloat(time.Until(notAfter).Hours()/24, 'f', 1, 64), true
+	}},
+	"ttfbMs":    {ordered: true, value: func(r *response) (string, bool) { return ttfbPercentile(r, 50) }},
+	"p95TtfbMs": {ordered: true, value: func(r *response) (string, bool) { return ttfbPercentile(r, 95) }},
+	"maxTtfbMs": {ordered: true, value: func(r *response) (string, bool) { return ttfbPercentile(r, 100) }},
+}
+
+// assertion is a parsed assertion of analysisRequest.Assertions.
+type assertion struct {
+	raw      string
+	metric   string
+	op       string
+	expected string
+}
+
+// parseAssertions checks every assertion up front, so a typo fails the
+// request before anything is sent.
+func parseAssertions(raw []string) ([]assertion, error) {
+	if len(raw) > maxAssertions {
+		return nil, fmt.Errorf("%d assertions, at most %d are allowed", len(raw), maxAssertions)
+	}
+	var assertions []assertion
+	for _, s := range raw {
+		m := assertionPattern.FindStringSubmatch(s)
+		if m == nil {
+			return nil, fmt.Errorf("invalid assertion %q, expected metric operator value", s)
+		}
+		a := assertion{raw: s, metric: m[1], op: m[2], expected: unquote(m[3])}
+		metric, ok := assertionMetrics[a.metric]
+		if !ok {
+			return nil, fmt.Errorf("unknown metric %q in assertion %q, known are %s", a.metric, s, strings.Join(assertionMetricNames(), ", "))
+		}
+		if a.op != "==" && a.op != "!=" {
+			if !metric.ordered {
+				return nil, fmt.Errorf("%s in assertion %q can only be compared with == or !=", a.metric, s)
+			}
+			if _, ok := metric.toNumber(a.expected); !ok {
+				return nil, fmt.Errorf("%q in assertion %q is not a number", a.expected, s)
+			}
+		}
+		assertions = append(assertions, a)
+	}
+	return assertions, nil
+}
+
+func assertionMetricNames() []string {
+	names := make([]string, 0, len(assertionMetrics))
+	for name := range assertionMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func (m assertionMetric) toNumber(s string) (float64, bool) {
+	if m.number != nil {
+		return m.number(s)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+// evaluateAssertions tests each assertion against the finished result. An
+// assertion on a value the result doesn't have fails.
+func evaluateAssertions(assertions []assertion, r *response) *AssertionReport {
+	if len(assertions) == 0 {
+		return nil
+	}
+	report := &AssertionReport{Status: "pass"}
+	for _, a := range assertions {
+		metric := assertionMetrics[a.metric]
+		result := AssertionResult{Assertion: a.raw}
+		actual, ok := metric.value(r)
+		switch {
+		case !ok:
+			result.Error = a.metric + " is not in the result"
+		case a.op == "==" || a.op == "!=":
+			equal := strings.EqualFold(actual, a.expected)
+			if x, okx := metric.toNumber(actual); okx && metric.ordered {
+				y, oky := metric.toNumber(a.expected)
+				equal = oky && x == y
+			}
+			result.Actual = actual
+			result.Passed = equal == (a.op == "==")
+		default:
+			result.Actual = actual
+			x, okx := metric.toNumber(actual)
+			y, _ := metric.toNumber(a.expected)
+			if !okx {
+				result.Error = fmt.Sprintf("%s is %q, not a number", a.metric, actual)
+				break
+			}
+			switch a.op {
+			case ">":
+				result.Passed = x > y
+			case ">=":
+				result.Passed = x >= y
+			case "<":
+				result.Passed = x < y
+			case "<=":
+				result.Passed = x <= y
+			}
+		}
+		if !result.Passed {
+			report.Status = "fail"
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
+
+// tlsVersionNumber orders "TLS 1.2" and "1.2" alike.
+func tlsVersionNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) > 3 && strings.EqualFold(s[:3], "TLS") {
+		s = strings.TrimSpace(s[3:])
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+// ttfbPercentile is the nearest-rank percentile p of the time to first byte
+// over the per-IP requests. With sampleCount, each address contributes its
+// own percentile p, and the worst address counts.
+func ttfbPercentile(r *response, p float64) (string, bool) {
+	var values []float64
+	sampled := 0.0
+	for _, ip := range r.IPResults {
+		if ip.Error != "" {
+			continue
+		}
+		if s := ip.Samples; s != nil && s.TTFBMs != nil {
+			v := s.TTFBMs.Median
+			switch {
+			case p >= 100:
+				v = s.TTFBMs.Max
+			case p >= 95:
+				v = s.TTFBMs.P95
+			}
+			if v > sampled {
+				sampled = v
+			}
+			continue
+		}
+		values = append(values, ip.TTFBMs)
+	}
+	if sampled > 0 {
+		return strconv.FormatFloat(sampled, 'f', 1, 64), true
+	}
+	if len(values) == 0 {
+		return "", false
+	}
+	sort.Float64s(values)
+	return strconv.FormatFloat(percentile(values, p), 'f', 1, 64), true
+}
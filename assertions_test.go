@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAssertions(t *testing.T) {
+	valid := []string{
+		"keepAliveTimeout >= 60",
+		`tlsVersion == "TLS 1.3"`,
+		"tlsVersion>=1.2",
+		"serverHeader != 'nginx'",
+		"  p95TtfbMs < 250.5  ",
+	}
+	if assertions, err := parseAssertions(valid); err != nil || len(assertions) != len(valid) {
+		t.Fatalf("parseAssertions(%q) = %v, %v", valid, assertions, err)
+	}
+
+	tests := []struct {
+		assertion string
+		err       string
+	}{
+		{"keepAliveTimeout", "expected metric operator value"},
+		{"keepAliveTimeout => 5", "expected metric operator value"},
+		{"idleTimeout > 5", "unknown metric"},
+		{"serverHeader > 5", "can only be compared with == or !="},
+		{"keepAliveTimeout > soon", "is not a number"},
+		{"tlsVersion > TLS", "is not a number"},
+	}
+	for _, tt := range tests {
+		_, err := parseAssertions([]string{tt.assertion})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parseAssertions(%q) error = %v, want %q", tt.assertion, err, tt.err)
+		}
+	}
+
+	if _, err := parseAssertions(make([]string, maxAssertions+1)); err == nil {
+		t.Errorf("parseAssertions accepted %d assertions", maxAssertions+1)
+	}
+}
+
+func TestEvaluateAssertions(t *testing.T) {
+	max := 100
+	r := &response{
+		KeepAliveTimeout: "60",
+		KeepAlive:        &KeepAliveHeader{Max: &max},
+		ConnectionHeader: "keep-alive",
+		ServerHeader:     "nginx",
+		RequestDuration:  120,
+		TLSVersion:       "TLS 1.2",
+		Certificate: &CertificateCheck{Verified: true,
+			NotAfter: time.Now().Add(30 * 24 * time.Hour).Format(time.RFC3339)},
+		IPResults: []IPResult{{TTFBMs: 30}, {TTFBMs: 10}, {TTFBMs: 20}, {TTFBMs: 500, Error: "timeout"}},
+	}
+
+	tests := []struct {
+		assertion string
+		passed    bool
+		actual    string
+		err       string
+	}{
+		{assertion: "keepAliveTimeout >= 60", passed: true, actual: "60"},
+		{assertion: "keepAliveTimeout > 60", actual: "60"},
+		{assertion: "keepAliveTimeout == 60.0", passed: true, actual: "60"},
+		{assertion: "keepAliveMax < 50", actual: "100"},
+		{assertion: "connectionHeader == Keep-Alive", passed: true, actual: "keep-alive"},
+		{assertion: `serverHeader != "nginx"`, actual: "nginx"},
+		{assertion: "requestDurationMs <= 120", passed: true, actual: "120"},
+		{assertion: "tlsVersion >= 1.2", passed: true, actual: "TLS 1.2"},
+		{assertion: `tlsVersion == "TLS 1.3"`, actual: "TLS 1.2"},
+		{assertion: "tlsVersion == 1.2", passed: true, actual: "TLS 1.2"},
+		{assertion: "certVerified == true", passed: true, actual: "true"},
+		{assertion: "certExpiryDays > 29", passed: true},
+		{assertion: "ttfbMs == 20", passed: true, actual: "20.0"},
+		{assertion: "maxTtfbMs < 30", actual: "30.0"},
+		{assertion: "tlsGrade == A", err: "tlsGrade is not in the result"},
+	}
+	for _, tt := range tests {
+		assertions, err := parseAssertions([]string{tt.assertion})
+		if err != nil {
+			t.Fatalf("parseAssertions(%q): %v", tt.assertion, err)
+		}
+		report := evaluateAssertions(assertions, r)
+		result := report.Results[0]
+		if result.Passed != tt.passed || result.Error != tt.err || (tt.actual != "" && result.Actual != tt.actual) {
+			t.Errorf("%q = %+v, want passed %v, actual %q, error %q", tt.assertion, result, tt.passed, tt.actual, tt.err)
+		}
+		if want := map[bool]string{true: "pass", false: "fail"}[tt.passed]; report.Status != want {
+			t.Errorf("%q: status %s, want %s", tt.assertion, report.Status, want)
+		}
+	}
+
+	if report := evaluateAssertions(nil, r); report != nil {
+		t.Errorf("evaluateAssertions without assertions = %+v, want nil", report)
+	}
+}
+
+func TestTTFBPercentileSamples(t *testing.T) {
+	r := &response{IPResults: []IPResult{
+		{Samples: &LatencySamples{TTFBMs: &LatencyStats{Median: 12, P95: 40, Max: 90}}},
+		{Samples: &LatencySamples{TTFBMs: &LatencyStats{Median: 15, P95: 30, Max: 60}}},
+	}}
+	// The worst address counts
+	for p, want := range map[float64]string{50: "15.0", 95: "40.0", 100: "90.0"} {
+		if got, ok := ttfbPercentile(r, p); !ok || got != want {
+			t.Errorf("ttfbPercentile(%v) = %q, %v, want %q", p, got, ok, want)
+		}
+	}
+	if got, ok := ttfbPercentile(&response{}, 50); ok {
+		t.Errorf("ttfbPercentile without results = %q, want none", got)
+	}
+}
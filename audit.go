@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry records who asked for an analysis of which target, and every
+// outbound connection the analysis opened.
+type AuditEntry struct {
+	ID           string            `json:"id"`
+	Time         time.Time         `json:"time"`
+	ClientIP     string            `json:"clientIp"`
+	ForwardedFor string            `json:"forwardedFor,omitempty"` // X-Forwarded-For as sent, not trusted
+	APIKey       string            `json:"apiKey,omitempty"`       // SHA-256 prefix of X-API-Key, never the key
+	Target       string            `json:"target"`
+	DurationMs   float64           `json:"durationMs"`
+	StatusCode   int               `json:"statusCode"`
+	Connections  []AuditConnection `json:"connections"`
+}
+
+// AuditConnection is one outbound dial made on behalf of an analysis, or
+// a pooled connection another analysis dialed that it reused.
+type AuditConnection struct {
+	Time       time.Time `json:"time"`
+	Network    string    `json:"network"`
+	Address    string    `json:"address"`              // As requested, host:port
+	RemoteAddr string    `json:"remoteAddr,omitempty"` // Address actually connected to
+	LocalAddr  string    `json:"localAddr,omitempty"`  // Source address and port used
+	Reused     bool      `json:"reused,omitempty"`     // An idle pooled connection, not a new dial
+	Error      string    `json:"error,omitempty"`
+}
+
+// auditLog keeps entries in memory for the retention period and optionally
+// appends each one to a JSON lines file, whose retention is left to the
+// operator.
+type auditLog struct {
+	mu        sync.Mutex
+	entries   []AuditEntry
+	retention time.Duration
+	file      *os.File
+}
+
+// audit is nil unless auditing is enabled with -audit.
+var audit *auditLog
+
+func newAuditLog(retention time.Duration, path string) (*auditLog, error) {
+	a := &auditLog{retention: retention}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		a.file = f
+	}
+	return a, nil
+}
+
+func (a *auditLog) add(entry AuditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.entries = append(a.entries, entry)
+	a.prune()
+
+	if a.file != nil {
+		line, _ := json.Marshal(entry)
+		if _, err := a.file.Write(append(line, '\n')); err != nil {
+			log.Printf("Failed to write audit log: %v", err)
+		}
+	}
+}
+
+// prune drops entries older than the retention period. Callers hold mu.
+func (a *auditLog) prune() {
+	cutoff := time.Now().Add(-a.retention)
+	i := 0
+	for i < len(a.entries) && a.entries[i].Time.Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		a.entries = append([]AuditEntry(nil), a.entries[i:]...)
+	}
+}
+
+// since returns the retained entries recorded at or after t.
+func (a *auditLog) since(t time.Time) []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.prune()
+
+	entries := []AuditEntry{}
+	for _, e := range a.entries {
+		if !e.Time.Before(t) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// auditTrail collects the connections of one analysis.
+type auditTrail struct {
+	mu    sync.Mutex
+	conns []AuditConnection
+}
+
+type auditTrailKey struct{}
+
+func withAuditTrail(ctx context.Context, trail *auditTrail) context.Context {
+	return context.WithValue(ctx, auditTrailKey{}, trail)
+}
+
+// dialContext dials through dialer and records the connection in the audit
+// trail carried by ctx, if any. Every outbound connection goes through it,
+// which is also where the analysis budget is enforced and the source,
+// DSCP marking and proxy carried by ctx are applied.
+func dialContext(ctx context.Context, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	b := budgetFrom(ctx)
+	proxy := proxyFrom(ctx)
+	if proxy != nil && !proxy.carries(network) {
+		proxy = nil
+	}
+	source := sourceFrom(ctx)
+	if source != nil {
+		bindAddr := addr
+		if proxy != nil {
+			bindAddr = proxy.url.Host
+		}
+		dialer = source.bind(dialer, network, bindAddr)
+	}
+	dialer = markDialer(ctx, dialer)
+	var conn net.Conn
+	var err error
+	if b != nil {
+		err = b.check()
+	}
+	if err == nil && proxy != nil && !targetPolicyExempt(ctx) {
+		// The proxy resolves host names itself, only literal addresses
+		// can be checked here
+		if host, _, splitErr := net.SplitHostPort(addr); splitErr == nil {
+			if ip := net.ParseIP(host); ip != nil {
+				err = checkTargetAddress(ip)
+			}
+		}
+	} else if proxy == nil {
+		dialer = policyDialer(ctx, dialer)
+	}
+	if err == nil && proxy != nil {
+		conn, err = proxy.dial(ctx, dialer, addr)
+	} else if err == nil {
+		conn, err = dialer.DialContext(ctx, network, addr)
+	}
+	if err == nil && source != nil {
+		source.record(conn.LocalAddr())
+	}
+	if err == nil && b != nil {
+		if pc, ok := conn.(net.PacketConn); ok {
+			conn = budgetPacketConn{budgetConn: &budgetConn{Conn: conn, b: b}, pc: pc}
+		} else {
+			conn = &budgetConn{Conn: conn, b: b}
+		}
+	}
+
+	record := AuditConnection{Time: time.Now().UTC(), Network: network, Address: addr}
+	if err != nil {
+		record.Error = err.Error()
+	} else {
+		record.RemoteAddr = conn.RemoteAddr().String()
+		record.LocalAddr = conn.LocalAddr().String()
+	}
+	auditConn(ctx, record)
+
+	return conn, err
+}
+
+// auditConn adds record to the audit trail carried by ctx, if any.
+func auditConn(ctx context.Context, record AuditConnection) {
+	if trail, ok := ctx.Value(auditTrailKey{}).(*auditTrail); ok {
+		trail.mu.Lock()
+		trail.conns = append(trail.conns, record)
+		trail.mu.Unlock()
+	}
+}
+
+// auditDial has the signature of http.Transport.DialContext.
+func auditDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	return dialContext(ctx, &net.Dialer{}, network, addr)
+}
+
+// auditedTransport is http.DefaultTransport with its dials audited, for
+// clients that talk to third-party services rather than the target.
+var auditedTransport = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return auditDial(withoutTargetPolicy(ctx), network, addr)
+	}
+	return t
+}()
+
+// auditHandler records the analysis a request asks for once it completes.
+func auditHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if audit == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		trail := &auditTrail{}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		// The target is in the JSON body, keep a copy for the entry
+		body := &teeBody{ReadCloser: r.Body}
+		r.Body = body
+		next.ServeHTTP(rec, r.WithContext(withAuditTrail(r.Context(), trail)))
+
+		// Analyses name a domain, batches a list, TCP probes an address,
+		// checks a base URL
+		var req struct {
+			Domain  string   `json:"domain"`
+			Domains []string `json:"domains"`
+			Address string   `json:"address"`
+			BaseURL string   `json:"baseUrl"`
+		}
+		json.Unmarshal(body.buf, &req)
+		if req.Domain == "" {
+			req.Domain = strings.Join(req.Domains, ",")
+		}
+		if req.Domain == "" {
+			req.Domain = r.URL.Query().Get("domain") // The results page
+		}
+		if req.Domain == "" {
+			req.Domain = req.Address
+		}
+		if req.Domain == "" {
+			req.Domain = req.BaseURL
+		}
+
+		id := runID(r.Context())
+		if id == "" {
+			id = newAuditID()
+		}
+		entry := AuditEntry{
+			ID:           id,
+			Time:         start.UTC(),
+			ClientIP:     remoteIP(r),
+			ForwardedFor: r.Header.Get("X-Forwarded-For"),
+			APIKey:       apiKeyFingerprint(r.Header.Get("X-API-Key")),
+			Target:       req.Domain,
+			DurationMs:   millisecondsSince(start),
+			StatusCode:   rec.status,
+		}
+		trail.mu.Lock()
+		entry.Connections = append([]AuditConnection{}, trail.conns...)
+		trail.mu.Unlock()
+
+		events.publish(r.Context(), Event{Type: eventRequestCompleted, RunID: id, Target: entry.Target, DurationMs: entry.DurationMs, Audit: &entry})
+	})
+}
+
+// auditExportHandler returns the retained audit entries, optionally from
+// ?since=<RFC 3339 time>, as JSON or with ?format=jsonl as JSON lines.
+func auditExportHandler(w http.ResponseWriter, r *http.Request) {
+	if audit == nil {
+		http.Error(w, "Audit logging is disabled", http.StatusNotFound)
+		return
+	}
+	if cfg.AuditExportKey == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-API-Key")), []byte(cfg.AuditExportKey)) != 1 {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var since time.Time
+	if s := r.URL.Query().Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "Invalid since time", http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+	entries := audit.since(since)
+
+	if r.URL.Query().Get("format") == "jsonl" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		for _, e := range entries {
+			enc.Encode(e)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func newAuditID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// apiKeyFingerprint identifies a key in the log without storing it.
+func apiKeyFingerprint(key string) string {
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("sha256:%s", hex.EncodeToString(sum[:])[:16])
+}
+
+// statusRecorder remembers the status code a handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// teeBody keeps a copy of what the handler read from the request body.
+type teeBody struct {
+	io.ReadCloser
+	buf []byte
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if len(b.buf) < maxAuditBodySize {
+		b.buf = append(b.buf, p[:n]...)
+	}
+	return n, err
+}
+
+// Largest request body kept to find the target
+const maxAuditBodySize = 64 * 1024
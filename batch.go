@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Most domains one batch analyzes, and how many run at once
+const (
+	maxBatchDomains         = 100
+	defaultBatchConcurrency = 4
+	maxBatchConcurrency     = 8
+)
+
+// batchRequest applies the options of one analysis request to many
+// domains. Domain, when set, is analyzed too and is the zone subdomain
+// discovery searches.
+type batchRequest struct {
+	analysisRequest
+	Domains     []string          `json:"domains,omitempty"`
+	Subdomains  *subdomainOptions `json:"subdomains,omitempty"` // Also analyze the subdomains found
+	Concurrency int               `json:"concurrency,omitempty"`
+}
+
+// batchHandler analyzes a list of domains, optionally extended with the
+// subdomains discovered below Domain, so a whole zone can be assessed in
+// one run. Each analysis has its own budget.
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Subdomains != nil && req.Domain == "" {
+		http.Error(w, "Subdomain discovery needs a domain", http.StatusBadRequest)
+		return
+	}
+
+	result := &BatchResult{}
+	domains := append([]string{req.Domain}, req.Domains...)
+	if req.Subdomains != nil {
+		result.Subdomains = discoverSubdomains(r.Context(), req.Domain, *req.Subdomains)
+		for _, candidate := range result.Subdomains.Candidates {
+			domains = append(domains, candidate.Name)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, domain := range domains {
+		domain = strings.TrimSpace(domain)
+		if domain == "" || seen[strings.ToLower(domain)] {
+			continue
+		}
+		seen[strings.ToLower(domain)] = true
+		if len(result.Results) == maxBatchDomains {
+			result.Skipped++
+			continue
+		}
+		result.Results = append(result.Results, BatchEntry{Domain: domain})
+	}
+	if len(result.Results) == 0 {
+		http.Error(w, "No domains to analyze", http.StatusBadRequest)
+		return
+	}
+
+	concurrency := req.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	if concurrency > maxBatchConcurrency {
+		concurrency = maxBatchConcurrency
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range result.Results {
+		wg.Add(1)
+		go func(entry *BatchEntry) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			opts := req.analysisRequest
+			opts.Domain = entry.Domain
+			analysis, err := runAnalysis(r.Context(), opts)
+			if err != nil {
+				entry.Error = err.Error()
+				return
+			}
+			entry.KeepAliveTimeout = analysis.KeepAliveTimeout
+			entry.TLSVersion = analysis.TLSVersion
+			entry.RequestDuration = analysis.RequestDuration
+			if analysis.TLSScore != nil {
+				entry.TLSGrade = analysis.TLSScore.Grade
+			}
+			entry.Result = analysis
+		}(&result.Results[i])
+	}
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+var errBudgetExceeded = errors.New("analysis budget exceeded")
+
+// budgetOptions lets a request lower the server's caps for its analysis.
+type budgetOptions struct {
+	MaxRequests int   `json:"maxRequests,omitempty"`
+	MaxBytes    int64 `json:"maxBytes,omitempty"`
+	MaxSeconds  int   `json:"maxSeconds,omitempty"`
+}
+
+// budget tracks what an analysis has consumed. Requests are counted when
+// a probe is marked, bytes as they are read off any connection the
+// analysis dials; once either runs out, new dials and writes fail. The
+// duration is enforced with a context deadline.
+type budget struct {
+	maxRequests int64
+	maxBytes    int64
+	maxDuration time.Duration
+	start       time.Time
+
+	requests int64 // Updated atomically
+	denied   int64 // Requests refused once the budget was spent
+	bytes    int64 // Updated atomically
+}
+
+type budgetKey struct{}
+
+// newBudget applies the requested limits, which may only be stricter than
+// the -max-* flags.
+func newBudget(opts *budgetOptions) *budget {
+	b := &budget{
+		maxRequests: int64(cfg.MaxRequests),
+		maxBytes:    cfg.MaxDownload,
+		maxDuration: cfg.MaxDuration,
+		start:       time.Now(),
+	}
+	if opts == nil {
+		return b
+	}
+	if opts.MaxRequests > 0 && int64(opts.MaxRequests) < b.maxRequests {
+		b.maxRequests = int64(opts.MaxRequests)
+	}
+	if opts.MaxBytes > 0 && opts.MaxBytes < b.maxBytes {
+		b.maxBytes = opts.MaxBytes
+	}
+	if d := time.Duration(opts.MaxSeconds) * time.Second; d > 0 && d < b.maxDuration {
+		b.maxDuration = d
+	}
+	return b
+}
+
+// withBudget attaches b to ctx along with its deadline.
+func withBudget(ctx context.Context, b *budget) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, b.maxDuration)
+	return context.WithValue(ctx, budgetKey{}, b), cancel
+}
+
+func budgetFrom(ctx context.Context) *budget {
+	b, _ := ctx.Value(budgetKey{}).(*budget)
+	return b
+}
+
+// countRequest records one outbound request, or a denied one when the
+// request budget is already spent.
+func (b *budget) countRequest() {
+	if atomic.AddInt64(&b.requests, 1) > b.maxRequests {
+		atomic.AddInt64(&b.requests, -1)
+		atomic.AddInt64(&b.denied, 1)
+	}
+}
+
+// check returns an error naming the limit that ran out, if any.
+func (b *budget) check() error {
+	if atomic.LoadInt64(&b.denied) > 0 {
+		return fmt.Errorf("%w: more than %d requests", errBudgetExceeded, b.maxRequests)
+	}
+	if n := atomic.LoadInt64(&b.bytes); n > b.maxBytes {
+		return fmt.Errorf("%w: more than %d bytes downloaded", errBudgetExceeded, b.maxBytes)
+	}
+	return nil
+}
+
+// usage reports the consumption so far.
+func (b *budget) usage() *BudgetUsage {
+	u := &BudgetUsage{
+		Requests:      atomic.LoadInt64(&b.requests),
+		MaxRequests:   b.maxRequests,
+		Denied:        atomic.LoadInt64(&b.denied),
+		Bytes:         atomic.LoadInt64(&b.bytes),
+		MaxBytes:      b.maxBytes,
+		DurationMs:    millisecondsSince(b.start),
+		MaxDurationMs: b.maxDuration.Milliseconds(),
+	}
+	switch err := b.check(); {
+	case err != nil:
+		u.Exceeded = err.Error()
+	case time.Since(b.start) >= b.maxDuration:
+		u.Exceeded = fmt.Sprintf("%v: longer than %v", errBudgetExceeded, b.maxDuration)
+	}
+	return u
+}
+
+// budgetConn charges the bytes read from a connection to a budget and
+// refuses to send once it is spent.
+type budgetConn struct {
+	net.Conn
+	b *budget
+}
+
+func (c *budgetConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.b.bytes, int64(n))
+	return n, err
+}
+
+func (c *budgetConn) Write(p []byte) (int, error) {
+	if err := c.b.check(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+// budgetPacketConn is a budgetConn over a datagram socket. It keeps the
+// net.PacketConn interface, which the Go resolver checks to choose between
+// datagram and stream framing.
+type budgetPacketConn struct {
+	*budgetConn
+	pc net.PacketConn
+}
+
+func (c budgetPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.pc.ReadFrom(p)
+	atomic.AddInt64(&c.b.bytes, int64(n))
+	return n, addr, err
+}
+
+func (c budgetPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if err := c.b.check(); err != nil {
+		return 0, err
+	}
+	return c.pc.WriteTo(p, addr)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Number of plain GETs the cache analyzer sends, and the pause between them
+// so that Age has a chance to advance
+const cacheSampleCount = 3
+const cacheSampleInterval = 2 * time.Second
+
+// Headers caches and CDNs use to report a hit or miss, most specific first
+var cacheStatusHeaders = []string{"Cache-Status", "X-Cache", "CF-Cache-Status", "X-Cache-Status", "X-Proxy-Cache"}
+
+// analyzeCaching requests the target repeatedly and conditionally, reporting
+// how intermediate caches treat it and whether its caching directives make
+// sense together.
+func analyzeCaching(ctx context.Context, target *url.URL, tlsConfig *tls.Config) *CacheAnalysis {
+	result := &CacheAnalysis{}
+	client := newProbeClient(tlsConfig)
+	defer client.CloseIdleConnections()
+
+	var last *http.Response
+	for i := 0; i < cacheSampleCount; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				result.Error = ctx.Err().Error()
+				return result
+			case <-time.After(cacheSampleInterval):
+			}
+		}
+
+		resp, _, err := doRequest(ctx, client, http.MethodGet, target, nil)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Samples = append(result.Samples, cacheSample(resp))
+		last = resp
+	}
+
+	result.CacheControl = last.Header.Get("Cache-Control")
+	result.Directives = parseCacheControl(result.CacheControl)
+	result.ETag = last.Header.Get("ETag")
+	result.LastModified = last.Header.Get("Last-Modified")
+	result.Expires = last.Header.Get("Expires")
+	result.Transitions = cacheTransitions(result.Samples)
+
+	if result.ETag != "" {
+		result.IfNoneMatch = conditionalRequest(ctx, client, target, "If-None-Match", result.ETag)
+	}
+	if result.LastModified != "" {
+		result.IfModifiedSince = conditionalRequest(ctx, client, target, "If-Modified-Since", result.LastModified)
+	}
+
+	result.Issues = cacheIssues(result)
+	return result
+}
+
+// cacheSample records the cache related parts of one response.
+func cacheSample(resp *http.Response) CacheSample {
+	sample := CacheSample{StatusCode: resp.StatusCode, Age: -1}
+	for _, name := range cacheStatusHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			sample.CacheStatus = value
+			break
+		}
+	}
+	if age, err := strconv.Atoi(strings.TrimSpace(resp.Header.Get("Age"))); err == nil {
+		sample.Age = age
+	}
+	return sample
+}
+
+// conditionalRequest revalidates the target with one validator header.
+func conditionalRequest(ctx context.Context, client *http.Client, target *url.URL, name, value string) *ConditionalResult {
+	result := &ConditionalResult{Header: name, Value: value}
+
+	resp, body, err := doRequest(ctx, client, http.MethodGet, target, http.Header{name: {value}})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.StatusCode = resp.StatusCode
+	result.NotModified = resp.StatusCode == http.StatusNotModified
+	result.BodyBytes = len(body)
+	result.ETag = resp.Header.Get("ETag")
+	return result
+}
+
+// parseCacheControl splits a Cache-Control header into lower-cased
+// directives, with an empty value for those without an argument.
+func parseCacheControl(header string) map[string]string {
+	if header == "" {
+		return nil
+	}
+	directives := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		directives[name] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return directives
+}
+
+// cacheTransitions describes how the cache status changed between samples,
+// such as "MISS -> HIT".
+func cacheTransitions(samples []CacheSample) []string {
+	var transitions []string
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1].CacheStatus, samples[i].CacheStatus
+		if prev != cur {
+			transitions = append(transitions, fmt.Sprintf("%s -> %s", orNone(prev), orNone(cur)))
+		}
+	}
+	return transitions
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// cacheIssues flags directive combinations and revalidation behaviour that
+// caches will not handle the way the origin probably intends.
+func cacheIssues(result *CacheAnalysis) []string {
+	var issues []string
+	d := result.Directives
+
+	for _, name := range []string{"max-age", "s-maxage", "stale-while-revalidate", "stale-if-error"} {
+		value, ok := d[name]
+		if !ok {
+			continue
+		}
+		if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+			issues = append(issues, fmt.Sprintf("%s has an invalid value %q", name, value))
+		}
+	}
+
+	_, noStore := d["no-store"]
+	_, private := d["private"]
+	_, public := d["public"]
+	_, maxAge := d["max-age"]
+	_, sMaxAge := d["s-maxage"]
+	_, swr := d["stale-while-revalidate"]
+
+	if noStore && (maxAge || sMaxAge || public) {
+		issues = append(issues, "no-store is combined with directives that allow caching")
+	}
+	if private && public {
+		issues = append(issues, "private and public are both set")
+	}
+	if private && sMaxAge {
+		issues = append(issues, "s-maxage has no effect on a private response")
+	}
+	if swr && !maxAge && !sMaxAge {
+		issues = append(issues, "stale-while-revalidate without max-age or s-maxage has no freshness lifetime to extend")
+	}
+
+	// Age must grow as a cached copy gets older
+	var ages []int
+	for _, s := range result.Samples {
+		if s.Age >= 0 {
+			ages = append(ages, s.Age)
+		}
+	}
+	for i := 1; i < len(ages); i++ {
+		if ages[i] < ages[i-1] && ages[i] != 0 {
+			issues = append(issues, "Age went backwards between requests, responses may come from different caches")
+			break
+		}
+	}
+	if lifetime, ok := freshnessLifetime(d); ok && len(ages) > 0 && ages[len(ages)-1] > lifetime {
+		issues = append(issues, fmt.Sprintf("Age %d exceeds the freshness lifetime of %d seconds, a stale copy was served", ages[len(ages)-1], lifetime))
+	}
+
+	if c := result.IfNoneMatch; c != nil && c.Error == "" {
+		if !c.NotModified {
+			issues = append(issues, fmt.Sprintf("If-None-Match with the current ETag returned %d instead of 304", c.StatusCode))
+		} else if c.ETag != "" && c.ETag != result.ETag {
+			issues = append(issues, "304 response carries a different ETag than the cached copy")
+		}
+	}
+	if c := result.IfModifiedSince; c != nil && c.Error == "" && !c.NotModified {
+		issues = append(issues, fmt.Sprintf("If-Modified-Since with the current Last-Modified returned %d instead of 304", c.StatusCode))
+	}
+	for _, c := range []*ConditionalResult{result.IfNoneMatch, result.IfModifiedSince} {
+		if c != nil && c.NotModified && c.BodyBytes > 0 {
+			issues = append(issues, fmt.Sprintf("304 response to %s included a body", c.Header))
+		}
+	}
+
+	return issues
+}
+
+// freshnessLifetime returns the shared cache lifetime in seconds, s-maxage
+// taking precedence over max-age.
+func freshnessLifetime(d map[string]string) (int, bool) {
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, ok := d[name]; ok {
+			if seconds, err := strconv.Atoi(value); err == nil {
+				return seconds, true
+			}
+		}
+	}
+	return 0, false
+}
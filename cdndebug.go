@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Request headers asking CDNs to explain how they handled a request.
+// Fastly answers Fastly-Debug with the Fastly-Debug-* headers, Akamai
+// answers each Pragma directive with the matching X-* header.
+var cdnDebugHeaders = http.Header{
+	"Fastly-Debug": {"1"},
+	"Pragma": {strings.Join([]string{
+		"akamai-x-cache-on",
+		"akamai-x-cache-remote-on",
+		"akamai-x-check-cacheable",
+		"akamai-x-get-cache-key",
+		"akamai-x-get-true-cache-key",
+		"akamai-x-get-request-id",
+		"akamai-x-serial-no",
+		"akamai-x-get-extracted-values",
+	}, ", ")},
+}
+
+var (
+	// Fastly-Debug-Path entries, e.g. "(D cache-lcy-eglc8600040-LCY 1700000000)"
+	fastlyPathEntry = regexp.MustCompile(`\(([A-Z]) (\S+) (\d+)\)`)
+	// Fastly-Debug-TTL entries, e.g. "(H cache-lcy-eglc8600040-LCY 86000.000 0.000 400)"
+	fastlyTTLEntry = regexp.MustCompile(`\(([A-Z]) (\S+) (\S+) (\S+) (\S+)\)`)
+	// The TTL field of an Akamai cache key, e.g. the 1d in /L/1/2345/1d/host/path
+	akamaiKeyTTL = regexp.MustCompile(`^(\d+[smhd]|000)$`)
+)
+
+// requestCDNDebug repeats the request with the CDN debug headers and reads
+// the cache diagnostics from the answer. CDNs that don't know the headers
+// ignore them.
+func requestCDNDebug(ctx context.Context, target *url.URL, tlsConfig *tls.Config) *CDNDebugInfo {
+	info := &CDNDebugInfo{RequestHeaders: make(map[string]string)}
+	for name, values := range cdnDebugHeaders {
+		info.RequestHeaders[name] = strings.Join(values, ", ")
+	}
+
+	client := newProbeClient(tlsConfig)
+	defer client.CloseIdleConnections()
+	resp, _, err := doRequest(ctx, client, http.MethodGet, target, cdnDebugHeaders.Clone())
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.StatusCode = resp.StatusCode
+	info.Fastly = parseFastlyDebug(resp.Header)
+	info.Akamai = parseAkamaiDebug(resp.Header)
+	if cdn := detectCDN(resp.Header); cdn != nil {
+		info.POPs = cdn.POPs
+	}
+	info.Summary = summarizeCDNDebug(info)
+	return info
+}
+
+// parseFastlyDebug reads the headers Fastly adds when asked with
+// Fastly-Debug. It returns nil when none are present.
+func parseFastlyDebug(headers http.Header) *FastlyDebug {
+	path := headers.Get("Fastly-Debug-Path")
+	ttl := headers.Get("Fastly-Debug-TTL")
+	digest := headers.Get("Fastly-Debug-Digest")
+	if path == "" && ttl == "" && digest == "" {
+		return nil
+	}
+
+	debug := &FastlyDebug{
+		Digest:           digest,
+		Cache:            headers.Get("X-Cache"),
+		CacheHits:        headers.Get("X-Cache-Hits"),
+		SurrogateKey:     headers.Get("Surrogate-Key"),
+		SurrogateControl: headers.Get("Surrogate-Control"),
+	}
+	for _, m := range fastlyPathEntry.FindAllStringSubmatch(path, -1) {
+		node := FastlyDebugNode{Role: m[1], Node: m[2]}
+		switch m[1] {
+		case "D":
+			node.Role = "delivery"
+		case "F":
+			node.Role = "fetch"
+		}
+		if code := fastlyNode.FindStringSubmatch(m[2]); code != nil {
+			node.Code = code[1]
+		}
+		node.Time, _ = strconv.ParseInt(m[3], 10, 64)
+		debug.Path = append(debug.Path, node)
+	}
+	for _, m := range fastlyTTLEntry.FindAllStringSubmatch(ttl, -1) {
+		entry := FastlyDebugTTL{Status: m[1], Node: m[2]}
+		switch m[1] {
+		case "H":
+			entry.Status = "hit"
+		case "M":
+			entry.Status = "miss"
+		case "P":
+			entry.Status = "pass"
+		}
+		// Misses and passes have "-" in place of the numbers
+		entry.TTL = parseDebugSeconds(m[3])
+		entry.Grace = parseDebugSeconds(m[4])
+		entry.Age = parseDebugSeconds(m[5])
+		debug.TTL = append(debug.TTL, entry)
+	}
+	return debug
+}
+
+func parseDebugSeconds(s string) *float64 {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+// parseAkamaiDebug reads the headers Akamai adds for the Pragma debug
+// directives. It returns nil when none are present.
+func parseAkamaiDebug(headers http.Header) *AkamaiDebug {
+	debug := &AkamaiDebug{
+		CacheKey:     headers.Get("X-Cache-Key"),
+		TrueCacheKey: headers.Get("X-True-Cache-Key"),
+		RequestID:    headers.Get("X-Akamai-Request-ID"),
+		Serial:       headers.Get("X-Serial"),
+		Staging:      headers.Get("X-Akamai-Staging") != "",
+	}
+	if akamaiNode.MatchString(headers.Get("X-Cache")) {
+		debug.CacheStatus = strings.Fields(headers.Get("X-Cache"))[0]
+	}
+	if remote := headers.Get("X-Cache-Remote"); remote != "" {
+		debug.ParentStatus = strings.Fields(remote)[0]
+	}
+	switch strings.ToUpper(strings.TrimSpace(headers.Get("X-Check-Cacheable"))) {
+	case "YES":
+		cacheable := true
+		debug.Cacheable = &cacheable
+	case "NO":
+		cacheable := false
+		debug.Cacheable = &cacheable
+	}
+	for _, field := range strings.Split(debug.CacheKey, "/") {
+		if akamaiKeyTTL.MatchString(field) {
+			debug.CacheKeyTTL = field
+			break
+		}
+	}
+
+	// One header per value: "name=AKA_PM_CACHEABLE_OBJECT; value=true"
+	for _, session := range headers.Values("X-Akamai-Session-Info") {
+		var name, value string
+		for _, part := range strings.Split(session, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch k {
+			case "name":
+				name = v
+			case "value":
+				value = v
+			}
+		}
+		if name != "" {
+			if debug.SessionInfo == nil {
+				debug.SessionInfo = make(map[string]string)
+			}
+			debug.SessionInfo[name] = value
+		}
+	}
+
+	if debug.CacheStatus == "" && debug.ParentStatus == "" && debug.Cacheable == nil &&
+		debug.CacheKey == "" && debug.TrueCacheKey == "" && debug.RequestID == "" && debug.Serial == "" {
+		return nil
+	}
+	return debug
+}
+
+func summarizeCDNDebug(info *CDNDebugInfo) string {
+	var parts []string
+	if f := info.Fastly; f != nil {
+		var nodes []string
+		for _, entry := range f.TTL {
+			node := entry.Status + " at " + entry.Node
+			if entry.TTL != nil {
+				node += fmt.Sprintf(" (%.0f s left)", *entry.TTL)
+			}
+			nodes = append(nodes, node)
+		}
+		if len(nodes) > 0 {
+			parts = append(parts, "Fastly: "+strings.Join(nodes, ", "))
+		} else {
+			parts = append(parts, "Fastly answered the debug request")
+		}
+	}
+	if a := info.Akamai; a != nil {
+		s := "Akamai: " + orNone(a.CacheStatus)
+		if a.ParentStatus != "" {
+			s += ", parent " + a.ParentStatus
+		}
+		if a.Cacheable != nil && !*a.Cacheable {
+			s += ", not cacheable"
+		}
+		if a.CacheKeyTTL != "" {
+			s += ", cache key TTL " + a.CacheKeyTTL
+		}
+		parts = append(parts, s)
+	}
+	if len(parts) == 0 {
+		return "No CDN answered the debug headers"
+	}
+	return strings.Join(parts, "; ")
+}
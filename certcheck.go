@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Certificates expiring sooner than this are flagged
+const certExpiryWarning = 30 * 24 * time.Hour
+
+// checkCertificate verifies the chain a target presented against the
+// system roots and -ca-bundle, independently of whether the analysis
+// itself verified it. Each reason a verifying client would reject the
+// certificate becomes a finding, so skip-verify analyses don't hide them.
+func checkCertificate(state *tls.ConnectionState, host string) *CertificateCheck {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	leaf := state.PeerCertificates[0]
+	check := &CertificateCheck{
+		Subject:     leaf.Subject.String(),
+		Issuer:      leaf.Issuer.String(),
+		DNSNames:    leaf.DNSNames,
+		NotBefore:   leaf.NotBefore.UTC().Format(time.RFC3339),
+		NotAfter:    leaf.NotAfter.UTC().Format(time.RFC3339),
+		ChainLength: len(state.PeerCertificates),
+	}
+	add := func(severity, id, message, detail string) {
+		check.Findings = append(check.Findings, Finding{Severity: severity, ID: id, Message: message, Detail: detail})
+	}
+
+	now := time.Now()
+	for i, cert := range state.PeerCertificates {
+		name := "The certificate"
+		if i > 0 {
+			name = fmt.Sprintf("Chain certificate %q", cert.Subject.CommonName)
+		}
+		switch {
+		case now.After(cert.NotAfter):
+			add(severityHigh, "cert-expired", name+" has expired",
+				fmt.Sprintf("Expired %s.", cert.NotAfter.UTC().Format(time.RFC3339)))
+		case now.Before(cert.NotBefore):
+			add(severityHigh, "cert-not-yet-valid", name+" is not valid yet",
+				fmt.Sprintf("Valid from %s; check the issuing system's clock.", cert.NotBefore.UTC().Format(time.RFC3339)))
+		case i == 0 && cert.NotAfter.Sub(now) < certExpiryWarning:
+			add(severityMedium, "cert-expiring", "The certificate expires soon",
+				fmt.Sprintf("Expires %s.", cert.NotAfter.UTC().Format(time.RFC3339)))
+		}
+	}
+
+	if host != "" {
+		if err := leaf.VerifyHostname(host); err != nil {
+			add(severityHigh, "cert-hostname-mismatch", fmt.Sprintf("The certificate is not valid for %s", host),
+				fmt.Sprintf("It covers %s.", strings.Join(certNames(leaf), ", ")))
+		}
+	}
+
+	// Verify the chain at a time the leaf is valid, so an expired
+	// certificate doesn't mask a chain problem
+	opts := x509.VerifyOptions{
+		Roots:         cfg.rootCAs,
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   now,
+	}
+	if now.After(leaf.NotAfter) {
+		opts.CurrentTime = leaf.NotAfter
+	} else if now.Before(leaf.NotBefore) {
+		opts.CurrentTime = leaf.NotBefore
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(opts)
+
+	var unknown x509.UnknownAuthorityError
+	last := state.PeerCertificates[len(state.PeerCertificates)-1]
+	switch {
+	case err == nil:
+	case errors.As(err, &unknown) && len(state.PeerCertificates) == 1 && isSelfSigned(leaf):
+		add(severityHigh, "cert-self-signed", "The certificate is self-signed", "")
+	case errors.As(err, &unknown) && !isSelfSigned(last) && len(last.IssuingCertificateURL) > 0:
+		add(severityHigh, "cert-incomplete-chain", "The server does not send the complete certificate chain",
+			fmt.Sprintf("The issuer of %q is missing. Browsers may fetch it from %s, but most other clients fail.",
+				last.Subject.CommonName, last.IssuingCertificateURL[0]))
+	case errors.As(err, &unknown):
+		add(severityHigh, "cert-unknown-ca", "The certificate is issued by an untrusted CA",
+			fmt.Sprintf("%q is not signed by a trusted root.", last.Subject.CommonName))
+	default:
+		add(severityHigh, "cert-invalid-chain", "The certificate chain does not verify", err.Error())
+	}
+
+	check.Verified = len(check.Findings) == 0 || onlyExpiryWarning(check.Findings)
+	return check
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return cert.CheckSignatureFrom(cert) == nil
+}
+
+// certNames lists the names a certificate is valid for.
+func certNames(cert *x509.Certificate) []string {
+	names := append([]string(nil), cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return names
+}
+
+// onlyExpiryWarning reports whether an upcoming expiry is the only
+// finding, which does not make verification fail today.
+func onlyExpiryWarning(findings []Finding) bool {
+	return len(findings) == 1 && findings[0].ID == "cert-expiring"
+}
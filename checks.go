@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Most steps a single check may define
+const maxCheckSteps = 20
+
+// checkDefinition is a declarative synthetic check: requests run in order,
+// sharing cookies, each with assertions on its response.
+type checkDefinition struct {
+	Name    string      `json:"name"`
+	BaseURL string      `json:"baseUrl"` // Resolves relative step URLs
+	Steps   []checkStep `json:"steps"`
+}
+
+type checkStep struct {
+	Name    string            `json:"name"`
+	Method  string            `json:"method"` // Defaults to GET
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	Expect  checkExpectations `json:"expect"`
+}
+
+// checkExpectations are the assertions for one step. Without a status
+// list any status below 400 passes.
+type checkExpectations struct {
+	Status       []int             `json:"status"`
+	Headers      map[string]string `json:"headers"` // Header name to regular expression
+	Body         string            `json:"body"`    // Regular expression
+	MaxLatencyMs int64             `json:"maxLatencyMs"`
+}
+
+// compiledStep is a validated step ready to run
+type compiledStep struct {
+	checkStep
+	target  *url.URL
+	headers map[string]*regexp.Regexp
+	body    *regexp.Regexp
+}
+
+// compileCheck validates a definition up front, so a typo in the last
+// step's regular expression fails before any request is sent.
+func compileCheck(def checkDefinition) ([]compiledStep, error) {
+	if len(def.Steps) == 0 {
+		return nil, fmt.Errorf("check has no steps")
+	}
+	if len(def.Steps) > maxCheckSteps {
+		return nil, fmt.Errorf("check has %d steps, at most %d are allowed", len(def.Steps), maxCheckSteps)
+	}
+
+	var base *url.URL
+	if def.BaseURL != "" {
+		var err error
+		if base, err = url.Parse(def.BaseURL); err != nil {
+			return nil, fmt.Errorf("invalid baseUrl: %v", err)
+		}
+	}
+
+	steps := make([]compiledStep, 0, len(def.Steps))
+	for i, step := range def.Steps {
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step %d", i+1)
+		}
+		step.Method = strings.ToUpper(step.Method)
+		if step.Method == "" {
+			step.Method = http.MethodGet
+		}
+
+		target, err := url.Parse(step.URL)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid url: %v", step.Name, err)
+		}
+		if base != nil {
+			target = base.ResolveReference(target)
+		}
+		if target.Scheme != "http" && target.Scheme != "https" {
+			return nil, fmt.Errorf("%s: url must be absolute http or https, or relative to baseUrl", step.Name)
+		}
+
+		compiled := compiledStep{checkStep: step, target: target, headers: make(map[string]*regexp.Regexp)}
+		for name, pattern := range step.Expect.Headers {
+			if compiled.headers[name], err = regexp.Compile(pattern); err != nil {
+				return nil, fmt.Errorf("%s: invalid pattern for header %s: %v", step.Name, name, err)
+			}
+		}
+		if step.Expect.Body != "" {
+			if compiled.body, err = regexp.Compile(step.Expect.Body); err != nil {
+				return nil, fmt.Errorf("%s: invalid body pattern: %v", step.Name, err)
+			}
+		}
+		steps = append(steps, compiled)
+	}
+	return steps, nil
+}
+
+// runCheck executes the steps in order. A failing step does not stop the
+// check, so one run reports every broken assertion.
+func runCheck(ctx context.Context, name string, steps []compiledStep) *CheckResult {
+	result := &CheckResult{Name: name, Passed: true}
+	start := time.Now()
+
+	client := newProbeClient(nil)
+	defer client.CloseIdleConnections()
+	client.Jar, _ = cookiejar.New(nil) // Only fails with options set
+
+	for _, step := range steps {
+		stepResult := runCheckStep(ctx, client, step)
+		if !stepResult.Passed {
+			result.Passed = false
+		}
+		result.Steps = append(result.Steps, stepResult)
+	}
+
+	result.DurationMs = millisecondsSince(start)
+	return result
+}
+
+func runCheckStep(ctx context.Context, client *http.Client, step compiledStep) CheckStepResult {
+	result := CheckStepResult{Name: step.Name, Method: step.Method, URL: step.target.String()}
+
+	var body io.Reader
+	if step.Body != "" {
+		body = strings.NewReader(step.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, step.Method, result.URL, body)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	markProbe(req)
+	for name, value := range step.Headers {
+		req.Header.Set(name, value)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Error = describeConnError(err)
+		return result
+	}
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBodySize))
+	resp.Body.Close()
+	result.LatencyMs = millisecondsSince(start)
+	result.StatusCode = resp.StatusCode
+	if err != nil {
+		result.Error = describeConnError(err)
+		return result
+	}
+
+	expect := step.Expect
+	if len(expect.Status) > 0 {
+		if !containsInt(expect.Status, resp.StatusCode) {
+			result.Failures = append(result.Failures, fmt.Sprintf("status %d, expected one of %v", resp.StatusCode, expect.Status))
+		}
+	} else if resp.StatusCode >= 400 {
+		result.Failures = append(result.Failures, fmt.Sprintf("status %d", resp.StatusCode))
+	}
+	names := make([]string, 0, len(step.headers))
+	for name := range step.headers {
+		names = append(names, name)
+	}
+	sort.Strings(names) // Report failures in a stable order
+	for _, name := range names {
+		pattern := step.headers[name]
+		values, ok := resp.Header[http.CanonicalHeaderKey(name)]
+		if !ok {
+			result.Failures = append(result.Failures, fmt.Sprintf("header %s missing", name))
+		} else if value := strings.Join(values, ", "); !pattern.MatchString(value) {
+			result.Failures = append(result.Failures, fmt.Sprintf("header %s is %q, does not match %s", name, value, pattern))
+		}
+	}
+	if step.body != nil && !step.body.Match(respBody) {
+		result.Failures = append(result.Failures, fmt.Sprintf("body does not match %s", step.body))
+	}
+	if expect.MaxLatencyMs > 0 && result.LatencyMs > float64(expect.MaxLatencyMs) {
+		result.Failures = append(result.Failures, fmt.Sprintf("took %.0fms, budget is %dms", result.LatencyMs, expect.MaxLatencyMs))
+	}
+
+	result.Passed = len(result.Failures) == 0
+	return result
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHandler runs a check definition posted as JSON and reports which
+// steps passed.
+func checkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var def checkDefinition
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	steps, err := compileCheck(def)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid check: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	result := runCheck(r.Context(), def.Name, steps)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// clusterBackends groups the per-IP responses by Server, X-Powered-By and
+// ETag format. Addresses answered by different builds usually sit in
+// separate backend pools behind one load balancer. It returns nil when
+// fewer than two addresses answered.
+func clusterBackends(results []IPResult) *BackendAnalysis {
+	var clusters []BackendCluster
+	index := make(map[string]int)
+	bodies := make(map[string]bool)
+	answered := 0
+
+	for _, r := range results {
+		if r.StatusCode == 0 {
+			continue
+		}
+		answered++
+		if r.BodyHash != "" {
+			bodies[r.BodyHash] = true
+		}
+
+		c := BackendCluster{Server: r.Server, PoweredBy: r.PoweredBy, ETagFormat: etagFormat(r.ETag)}
+		key := c.Server + "\x00" + c.PoweredBy + "\x00" + c.ETagFormat
+		i, ok := index[key]
+		if !ok {
+			i = len(clusters)
+			index[key] = i
+			clusters = append(clusters, c)
+		}
+		clusters[i].IPs = append(clusters[i].IPs, r.IP)
+	}
+	if answered < 2 {
+		return nil
+	}
+
+	// Largest pool first
+	sort.SliceStable(clusters, func(i, j int) bool { return len(clusters[i].IPs) > len(clusters[j].IPs) })
+
+	analysis := &BackendAnalysis{
+		Clusters:         clusters,
+		ContentVersions:  len(bodies),
+		IdenticalContent: len(bodies) == 1,
+	}
+	if len(clusters) == 1 {
+		analysis.Summary = fmt.Sprintf("All %d addresses look like the same server build", answered)
+		return analysis
+	}
+
+	analysis.Summary = fmt.Sprintf("This load balancer fronts %d distinct server builds", len(clusters))
+	fields := map[string]func(BackendCluster) string{
+		"Server":       func(c BackendCluster) string { return c.Server },
+		"X-Powered-By": func(c BackendCluster) string { return c.PoweredBy },
+		"ETag":         func(c BackendCluster) string { return c.ETagFormat },
+	}
+	for _, name := range []string{"Server", "X-Powered-By", "ETag"} {
+		for _, c := range clusters[1:] {
+			if fields[name](c) != fields[name](clusters[0]) {
+				analysis.DifferingHeaders = append(analysis.DifferingHeaders, name)
+				break
+			}
+		}
+	}
+
+	return analysis
+}
+
+// etagFormat reduces an ETag to its shape, so that tags generated the same
+// way compare equal: runs of hex digits become "x", other letters "a", and
+// quotes, separators and the weak prefix are kept. An Apache style
+// `"5f3a-1b2c4"` becomes `"x-x"`.
+func etagFormat(etag string) string {
+	if etag == "" {
+		return "none"
+	}
+
+	prefix := ""
+	if strings.HasPrefix(etag, "W/") {
+		prefix = "W/"
+		etag = etag[2:]
+	}
+
+	var shape []rune
+	for _, r := range etag {
+		class := r
+		switch {
+		case unicode.Is(unicode.ASCII_Hex_Digit, r):
+			class = 'x'
+		case unicode.IsLetter(r):
+			class = 'a'
+		}
+		// A run mixing hex digits and other letters counts as letters
+		if n := len(shape); n > 0 && (class == 'x' || class == 'a') && (shape[n-1] == 'x' || shape[n-1] == 'a') {
+			if class == 'a' {
+				shape[n-1] = 'a'
+			}
+			continue
+		}
+		shape = append(shape, class)
+	}
+	return prefix + string(shape)
+}
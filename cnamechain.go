@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Most names of a CNAME chain probed, the queried name included
+const maxCNAMEChainProbes = 8
+
+// A change in time to first byte between two names of the chain is
+// reported when it at least doubles and grows by this much
+const cnameLatencyJumpMs = 50
+
+// probeCNAMEChain requests the target under each name of its CNAME chain,
+// e.g. www.example.com, then example.cdn.net, then lb.provider.net, and
+// reports where latency or keep-alive behavior changes. Every name is
+// resolved and requested on its own, with its own Host and SNI, so a
+// layer that answers differently for its own name than for the site shows
+// up as well.
+func probeCNAMEChain(ctx context.Context, target *url.URL, name string, tlsConfig *tls.Config) *CNAMEChainProbe {
+	probe := &CNAMEChainProbe{}
+
+	servers, err := systemNameservers()
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	chain, err := lookupCNAMEChain(ctx, servers[0], name)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	if len(chain) == 0 {
+		probe.Error = name + " has no CNAME records"
+		return probe
+	}
+
+	names := []string{strings.TrimSuffix(name, ".")}
+	for _, cname := range chain {
+		names = append(names, strings.TrimSuffix(cname, "."))
+	}
+	if len(names) > maxCNAMEChainProbes {
+		names = names[:maxCNAMEChainProbes]
+	}
+
+	for _, n := range names {
+		probe.Hops = append(probe.Hops, probeCNAMEHop(ctx, target, n, tlsConfig.Clone()))
+	}
+	probe.Changes = cnameChainChanges(probe.Hops)
+	return probe
+}
+
+// probeCNAMEHop resolves name and sends one keep-alive request for the
+// target's path to it.
+func probeCNAMEHop(ctx context.Context, target *url.URL, name string, tlsConfig *tls.Config) CNAMEHop {
+	hop := CNAMEHop{Name: name}
+
+	ctx, cancel := context.WithTimeout(ctx, ipProbeTimeout)
+	defer cancel()
+
+	addrs, err := resolverFor(ctx).LookupHost(ctx, name)
+	if err != nil {
+		hop.Error = err.Error()
+		return hop
+	}
+	hop.Addresses = addrs
+
+	u := *target
+	u.Host = name
+	if port := target.Port(); port != "" {
+		u.Host = net.JoinHostPort(name, port)
+	}
+
+	// HTTP/1.1 only, as the Keep-Alive header is what is compared
+	transport := &http.Transport{TLSClientConfig: tlsConfig, DialContext: auditDial}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	var start, connectStart, tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(network, addr string) {
+			connectStart = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			hop.ConnectMs = millisecondsSince(connectStart)
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			hop.TLSHandshakeMs = millisecondsSince(tlsStart)
+		},
+		GotFirstResponseByte: func() {
+			hop.TTFBMs = millisecondsSince(start)
+		},
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, u.String(), nil)
+	if err != nil {
+		hop.Error = err.Error()
+		return hop
+	}
+	markProbe(req)
+
+	start = time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		hop.Error = err.Error()
+		return hop
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxProbeBodySize))
+	hop.TotalMs = millisecondsSince(start)
+
+	hop.StatusCode = resp.StatusCode
+	hop.Server = resp.Header.Get("Server")
+	hop.Connection = resp.Header.Get("Connection")
+	hop.KeepAliveTimeout = extractTimeoutValue(resp.Header.Get("Keep-Alive"))
+	hop.KeepAlive = parseKeepAlive(resp.Header.Get("Keep-Alive"))
+	if resp.TLS != nil {
+		hop.TLSVersion = tlsVersionToString(resp.TLS.Version)
+	}
+	return hop
+}
+
+// cnameChainChanges describes what differs between each name of the chain
+// and the one before it.
+func cnameChainChanges(hops []CNAMEHop) []string {
+	var changes []string
+	for i := 1; i < len(hops); i++ {
+		prev, hop := hops[i-1], hops[i]
+		if prev.Error != "" || hop.Error != "" {
+			continue
+		}
+		at := fmt.Sprintf(" between %s and %s", prev.Name, hop.Name)
+		if hop.TTFBMs >= 2*prev.TTFBMs && hop.TTFBMs-prev.TTFBMs >= cnameLatencyJumpMs {
+			changes = append(changes, fmt.Sprintf("Time to first byte rises from %.0f ms to %.0f ms%s", prev.TTFBMs, hop.TTFBMs, at))
+		} else if prev.TTFBMs >= 2*hop.TTFBMs && prev.TTFBMs-hop.TTFBMs >= cnameLatencyJumpMs {
+			changes = append(changes, fmt.Sprintf("Time to first byte falls from %.0f ms to %.0f ms%s", prev.TTFBMs, hop.TTFBMs, at))
+		}
+		if hop.KeepAliveTimeout != prev.KeepAliveTimeout {
+			changes = append(changes, fmt.Sprintf("Keep-Alive timeout changes from %s to %s%s", prev.KeepAliveTimeout, hop.KeepAliveTimeout, at))
+		}
+		if !strings.EqualFold(hop.Connection, prev.Connection) {
+			changes = append(changes, fmt.Sprintf("Connection header changes from %q to %q%s", prev.Connection, hop.Connection, at))
+		}
+		if hop.Server != prev.Server {
+			changes = append(changes, fmt.Sprintf("Server changes from %q to %q%s", prev.Server, hop.Server, at))
+		}
+		if hop.StatusCode != prev.StatusCode {
+			changes = append(changes, fmt.Sprintf("Status changes from %d to %d%s", prev.StatusCode, hop.StatusCode, at))
+		}
+	}
+	return changes
+}
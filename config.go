@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// Server-wide settings, populated from command line flags
+type config struct {
+	ClientCertFile string
+	ClientKeyFile  string
+	RDAP           bool   // Allow the RDAP registration lookup stage
+	RDAPURL        string // RDAP service, rdap.org redirects to the right registry
+
+	CTLogs bool   // Allow subdomain discovery to search certificate transparency logs
+	CTURL  string // crt.sh or a service with the same JSON output
+
+	HSTSPreloadFile   string // Snapshot of Chromium's transport_security_state_static.json
+	HSTSPreloadOnline bool   // Also ask hstspreload.org for the current status
+
+	Audit          bool          // Record who analyzed what and the connections made
+	AuditFile      string        // Also append audit entries to this JSON lines file
+	AuditRetention time.Duration // How long entries stay in memory
+	AuditExportKey string        // X-API-Key required by /api/audit
+
+	History          bool          // Keep the outcome of every analysis for /api/sla
+	HistoryFile      string        // Also append runs to this JSON lines file, read back at startup
+	HistoryRetention time.Duration // How long runs are kept
+	HistoryMaxRuns   int           // Most runs kept per target, 0 for no limit
+
+	// Where /api/history/export writes: files in ExportDir, objects in an
+	// S3-compatible bucket
+	ExportDir  string
+	S3Endpoint string
+	S3Bucket   string
+	S3Region   string
+
+	ResultStore string // Where complete results are kept: dir, in ResultDir, or s3
+	ResultDir   string
+
+	InfluxURL   string // InfluxDB write endpoint every run's measurements go to
+	InfluxToken string
+
+	AdvisoriesFile string // Replaces the bundled data/advisories.json
+	ThirdPartyFile string // Replaces the bundled data/thirdparty.json
+
+	CABundle  string // PEM roots trusted in addition to the system ones
+	VerifyTLS bool   // Verify target certificates for every analysis
+
+	AgentKey    string // Shared secret between agents and the coordinator
+	Coordinator string // Run as an agent registering with this coordinator
+	AgentRegion string // Region this agent reports
+	AgentURL    string // Where the coordinator reaches this agent
+
+	// Where probe traffic leaves this host, see sourceOptions
+	SourceIP        string
+	SourceInterface string
+
+	Proxy string // HTTP CONNECT or SOCKS5 proxy for target connections
+
+	BlockPrivateTargets bool // Only connect to public target addresses
+
+	EgressIPs   string // Published by /api/probe-ips, comma separated
+	RunIDHeader string // Request header carrying the run ID to targets
+
+	// Hard caps on what one analysis may consume
+	MaxRequests int
+	MaxDownload int64 // Bytes
+	MaxDuration time.Duration
+
+	TCPCaptureBytes int // Read off a connection for the TCP analysis
+
+	// How much of a page the CSP analysis scans
+	CSPMaxPageBytes int64
+	CSPMaxResources int
+
+	ChromePath string // Headless Chrome or Chromium rendering pages for cspRender
+
+	PprofAddr string // Serve profiling endpoints on this address, e.g. localhost:6060
+
+	SelfTest    bool   // Check outbound connectivity before serving
+	SelfTestURL string // Fetched by the self-test
+
+	// Loaded from ClientCertFile/ClientKeyFile and CABundle at startup
+	clientCert *tls.Certificate
+	rootCAs    *x509.CertPool
+}
+
+var cfg config
+
+func loadConfig(lc *lifecycle) error {
+	flag.StringVar(&cfg.ClientCertFile, "client-cert", "", "PEM client certificate presented to targets that request one")
+	flag.StringVar(&cfg.ClientKeyFile, "client-key", "", "PEM private key for -client-cert")
+	flag.BoolVar(&cfg.RDAP, "rdap", false, "allow requests to include RDAP domain registration data")
+	flag.StringVar(&cfg.RDAPURL, "rdap-url", "https://rdap.org", "RDAP service used for registration lookups")
+	flag.BoolVar(&cfg.CTLogs, "ct-logs", false, "allow subdomain discovery to search certificate transparency logs")
+	flag.StringVar(&cfg.CTURL, "ct-url", "https://crt.sh", "certificate transparency search service used by subdomain discovery")
+	flag.StringVar(&cfg.HSTSPreloadFile, "hsts-preload-file", "", "snapshot of Chromium's HSTS preload list (transport_security_state_static.json)")
+	flag.BoolVar(&cfg.HSTSPreloadOnline, "hsts-preload-online", false, "check the HSTS preload status with hstspreload.org")
+	flag.BoolVar(&cfg.Audit, "audit", false, "record requested targets and outbound connections in an audit log")
+	flag.StringVar(&cfg.AuditFile, "audit-file", "", "append audit entries to this JSON lines file")
+	flag.DurationVar(&cfg.AuditRetention, "audit-retention", 30*24*time.Hour, "how long audit entries are kept in memory")
+	flag.StringVar(&cfg.AuditExportKey, "audit-export-key", "", "X-API-Key required to export the audit log from /api/audit")
+	flag.BoolVar(&cfg.History, "history", false, "keep the outcome of every analysis for availability reports at /api/sla")
+	flag.StringVar(&cfg.HistoryFile, "history-file", "", "append runs to this JSON lines file and read it back at startup")
+	flag.DurationVar(&cfg.HistoryRetention, "history-retention", 90*24*time.Hour, "how long runs are kept")
+	flag.IntVar(&cfg.HistoryMaxRuns, "history-max-runs", 0, "most runs kept per target, the oldest are dropped first; 0 for no limit")
+	flag.StringVar(&cfg.ExportDir, "export-dir", "", "directory /api/history/export may write exports to")
+	flag.StringVar(&cfg.S3Endpoint, "s3-endpoint", "https://s3.amazonaws.com", "S3-compatible service for exports, credentials from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	flag.StringVar(&cfg.S3Bucket, "s3-bucket", "", "bucket /api/history/export may write exports to, and -result-store s3 keeps results in")
+	flag.StringVar(&cfg.S3Region, "s3-region", "us-east-1", "region requests to -s3-endpoint are signed for")
+	flag.StringVar(&cfg.ResultStore, "result-store", "", "keep the complete result of every analysis for /api/results: dir (in -result-dir) or s3 (in -s3-bucket)")
+	flag.StringVar(&cfg.ResultDir, "result-dir", "", "directory -result-store dir keeps results in")
+	flag.StringVar(&cfg.InfluxURL, "influx-url", "", "InfluxDB write URL, e.g. http://influxdb:8086/api/v2/write?org=ops&bucket=keepalive, every run's timings are written to")
+	flag.StringVar(&cfg.InfluxToken, "influx-token", "", "API token for -influx-url")
+	flag.StringVar(&cfg.AdvisoriesFile, "advisories-file", "", "vulnerability and end of life data replacing the bundled data/advisories.json")
+	flag.StringVar(&cfg.ThirdPartyFile, "third-party-file", "", "third-party origin categories replacing the bundled data/thirdparty.json")
+	flag.StringVar(&cfg.CABundle, "ca-bundle", "", "PEM CA certificates trusted in addition to the system roots when verifying targets")
+	flag.BoolVar(&cfg.VerifyTLS, "verify-tls", false, "verify target certificates for every analysis, not only those that ask")
+	flag.StringVar(&cfg.AgentKey, "agent-key", "", "shared secret agents present to the coordinator; enables /api/agents")
+	flag.StringVar(&cfg.Coordinator, "coordinator", "", "run as a probe agent registering with the coordinator at this URL")
+	flag.StringVar(&cfg.AgentRegion, "agent-region", "", "region name this agent reports to the coordinator")
+	flag.StringVar(&cfg.AgentURL, "agent-url", "", "URL the coordinator uses to reach this agent")
+	flag.StringVar(&cfg.SourceIP, "source-ip", "", "local address probe connections and DNS queries are sent from")
+	flag.StringVar(&cfg.SourceInterface, "source-interface", "", "network interface probe connections and DNS queries are sent from")
+	flag.StringVar(&cfg.Proxy, "proxy", "", "tunnel target connections through this http:// or socks5:// proxy")
+	flag.BoolVar(&cfg.BlockPrivateTargets, "block-private-targets", false, "refuse to probe loopback, private, link-local, multicast and unspecified addresses")
+	flag.StringVar(&cfg.EgressIPs, "egress-ips", "", "comma separated egress addresses of probe traffic, published at /api/probe-ips")
+	flag.StringVar(&cfg.RunIDHeader, "run-id-header", "X-Analyzer-Run-ID", "header identifying probe traffic with the analysis run ID, empty to disable")
+	flag.IntVar(&cfg.MaxRequests, "max-requests", 500, "most outbound requests one analysis may send")
+	flag.Int64Var(&cfg.MaxDownload, "max-download", 256*1024*1024, "most bytes one analysis may download")
+	flag.DurationVar(&cfg.MaxDuration, "max-duration", 30*time.Minute, "longest one analysis may run")
+	flag.IntVar(&cfg.TCPCaptureBytes, "tcp-capture-bytes", 256, "bytes of each connection the TCP analysis keeps, from 60 to 65536")
+	flag.Int64Var(&cfg.CSPMaxPageBytes, "csp-max-page-bytes", 2*1024*1024, "most bytes of a page the CSP analysis downloads and scans")
+	flag.IntVar(&cfg.CSPMaxResources, "csp-max-resources", 2000, "most resources of a page the CSP analysis checks, 0 for no limit")
+	flag.StringVar(&cfg.ChromePath, "chrome", "", "Chrome or Chromium binary rendering pages for cspRender; rendering is disabled without it")
+	flag.StringVar(&cfg.PprofAddr, "pprof-addr", "", "serve /debug/pprof/ profiles on this address, e.g. localhost:6060; keep it private")
+	flag.BoolVar(&cfg.SelfTest, "self-test", false, "check DNS and outbound HTTPS at startup and exit if they fail")
+	flag.StringVar(&cfg.SelfTestURL, "self-test-url", "https://example.com", "URL fetched by -self-test")
+	flag.Parse()
+
+	if cfg.Coordinator != "" && (cfg.AgentKey == "" || cfg.AgentRegion == "" || cfg.AgentURL == "") {
+		return fmt.Errorf("-coordinator needs -agent-key, -agent-region and -agent-url")
+	}
+
+	// A TCP header with options takes up to 60 bytes
+	if cfg.TCPCaptureBytes < 60 || cfg.TCPCaptureBytes > 65536 {
+		return fmt.Errorf("-tcp-capture-bytes must be from 60 to 65536")
+	}
+
+	if cfg.CSPMaxPageBytes <= 0 {
+		return fmt.Errorf("-csp-max-page-bytes must be positive")
+	}
+
+	if cfg.HistoryMaxRuns < 0 {
+		return fmt.Errorf("-history-max-runs must not be negative")
+	}
+
+	if _, err := newSourceSelection(nil); err != nil {
+		return err
+	}
+
+	if _, err := newProxyTunnel(""); err != nil {
+		return err
+	}
+
+	s3, err := newS3Client()
+	if err != nil {
+		return err
+	}
+	exportS3 = s3
+
+	store, err := newResultStore()
+	if err != nil {
+		return err
+	}
+	results = store
+
+	if cfg.ClientCertFile != "" || cfg.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCertFile, cfg.ClientKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		cfg.clientCert = &cert
+	}
+
+	if cfg.CABundle != "" {
+		pool, err := loadCABundle(cfg.CABundle)
+		if err != nil {
+			return fmt.Errorf("failed to load CA bundle: %v", err)
+		}
+		cfg.rootCAs = pool
+	}
+
+	if cfg.HSTSPreloadFile != "" {
+		list, err := loadHSTSPreloadList(cfg.HSTSPreloadFile)
+		if err != nil {
+			return fmt.Errorf("failed to load HSTS preload list: %v", err)
+		}
+		hstsPreloadList = list
+	}
+
+	if cfg.AdvisoriesFile != "" {
+		if err := loadAdvisoriesFile(cfg.AdvisoriesFile); err != nil {
+			return fmt.Errorf("failed to load advisories: %v", err)
+		}
+	}
+
+	if cfg.ThirdPartyFile != "" {
+		if err := loadThirdPartyFile(cfg.ThirdPartyFile); err != nil {
+			return fmt.Errorf("failed to load third-party origins: %v", err)
+		}
+	}
+
+	if cfg.Audit {
+		a, err := newAuditLog(cfg.AuditRetention, cfg.AuditFile)
+		if err != nil {
+			return fmt.Errorf("failed to open audit log: %v", err)
+		}
+		audit = a
+		events.subscribe(func(e Event) {
+			if e.Type == eventRequestCompleted && e.Audit != nil {
+				audit.add(*e.Audit)
+			}
+		})
+	}
+
+	var sinks []metricsSink
+	if cfg.InfluxURL != "" {
+		sink, err := newInfluxSink(cfg.InfluxURL, cfg.InfluxToken)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(sinks) > 0 {
+		events.subscribe(newMetricsExporter(lc, sinks).record)
+	}
+
+	if results != nil {
+		events.subscribe(newResultWriter(lc, results).record)
+	}
+
+	if cfg.History {
+		h, err := newRunHistory(cfg.HistoryRetention, cfg.HistoryMaxRuns, cfg.HistoryFile)
+		if err != nil {
+			return fmt.Errorf("failed to open run history: %v", err)
+		}
+		history = h
+		events.subscribe(h.record)
+		lc.Go(h.pruneJob)
+		lc.afterDrain(h.close)
+	}
+
+	return nil
+}
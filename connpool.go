@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long a pooled connection may sit idle, and most transports the pool
+// keeps; past that, the least recently used is closed
+const (
+	connPoolIdleTimeout   = 90 * time.Second
+	connPoolMaxTransports = 1000
+)
+
+// connPool keeps a keep-alive transport per address, port, SNI and
+// connection settings, so analyses with reuseConnections send their per-IP
+// requests over connections an earlier analysis or sample left open
+// instead of handshaking again. A connection is dialed on behalf of, and
+// audited for, the request that needed it; every request it later serves
+// is charged to, and audited for, its own analysis.
+type connPool struct {
+	mu         sync.Mutex
+	transports map[string]*pooledTransport
+}
+
+type pooledTransport struct {
+	transport *http.Transport
+	lastUsed  time.Time
+}
+
+var reusePool = &connPool{transports: make(map[string]*pooledTransport)}
+
+var errNoPooledTLS = errors.New("no TLS configuration for the pooled connection")
+
+// connReuseKey carries the connection settings of an analysis that opted
+// into reuse, see withConnReuse.
+type connReuseKey struct{}
+
+// pooledTLSKey carries the TLS configuration of the request a pooled
+// transport dials for.
+type pooledTLSKey struct{}
+
+// withConnReuse lets probeIP use the pool for this analysis. Connections
+// are only shared between analyses with the same TLS profile,
+// verification, pins, client certificate, proxy and source, as all of
+// them shape or vet the connection.
+func withConnReuse(ctx context.Context, opts analysisRequest) context.Context {
+	pins := append([]string(nil), opts.PinSPKI...)
+	sort.Strings(pins)
+	proxy := opts.Proxy
+	if proxy == "" {
+		proxy = cfg.Proxy
+	}
+	parts := []string{
+		opts.TLSProfile,
+		strings.Join(pins, ","),
+		opts.ClientCert,
+		proxy,
+	}
+	if opts.VerifyTLS || cfg.VerifyTLS {
+		parts = append(parts, "verify")
+	}
+	if opts.Source != nil {
+		parts = append(parts, opts.Source.IP, opts.Source.Interface)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return context.WithValue(ctx, connReuseKey{}, hex.EncodeToString(sum[:8]))
+}
+
+// connReuseFrom returns the settings key of ctx, or false without reuse.
+func connReuseFrom(ctx context.Context) (string, bool) {
+	scope, ok := ctx.Value(connReuseKey{}).(string)
+	return scope, ok
+}
+
+// transport returns the pooled transport dialing addr for sni with the
+// settings of ctx, creating it if needed. The request must carry its TLS
+// configuration under pooledTLSKey.
+func (p *connPool) transport(ctx context.Context, addr, sni string) http.RoundTripper {
+	scope, _ := connReuseFrom(ctx)
+	key := addr + "|" + sni + "|" + scope
+	if dscp, ok := ctx.Value(dscpKey{}).(int); ok {
+		key += "|" + strconv.Itoa(dscp) // Marking is set on the socket
+	}
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prune(now)
+	if pt, ok := p.transports[key]; ok {
+		pt.lastUsed = now
+		return chargedTransport{pt.transport}
+	}
+
+	dialer := &net.Dialer{}
+	t := &http.Transport{
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     connPoolIdleTimeout,
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return pooledDial(ctx, dialer, network, addr)
+		},
+		DialTLSContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			conn, err := pooledDial(ctx, dialer, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return pooledTLSClient(ctx, conn)
+		},
+	}
+	p.transports[key] = &pooledTransport{transport: t, lastUsed: now}
+	return chargedTransport{t}
+}
+
+// prune closes transports idle past the timeout, and the least recently
+// used beyond the limit. Callers hold mu.
+func (p *connPool) prune(now time.Time) {
+	var oldest string
+	for key, pt := range p.transports {
+		if now.Sub(pt.lastUsed) > connPoolIdleTimeout {
+			pt.transport.CloseIdleConnections()
+			delete(p.transports, key)
+			continue
+		}
+		if oldest == "" || pt.lastUsed.Before(p.transports[oldest].lastUsed) {
+			oldest = key
+		}
+	}
+	if len(p.transports) >= connPoolMaxTransports {
+		p.transports[oldest].transport.CloseIdleConnections()
+		delete(p.transports, oldest)
+	}
+}
+
+// pooledTLSClient wraps conn for TLS with the configuration of the request
+// dialing, not the one the transport was created for, so client
+// certificate requests are recorded for the right analysis. The transport
+// runs the handshake, and its trace hooks, on the returned connection.
+func pooledTLSClient(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	tlsConfig, _ := ctx.Value(pooledTLSKey{}).(*tls.Config)
+	if tlsConfig == nil {
+		conn.Close()
+		return nil, errNoPooledTLS
+	}
+	return tls.Client(conn, tlsConfig), nil
+}
+
+// pooledDial is dialContext for a pooled connection. The dial is audited
+// for the analysis of ctx, whose budget must allow it, but the connection
+// is not tied to that budget for good: a pooledConn charges whichever
+// request is using it.
+func pooledDial(ctx context.Context, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	b := budgetFrom(ctx)
+	if b != nil {
+		if err := b.check(); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := dialContext(context.WithValue(ctx, budgetKey{}, nil), dialer, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	pc := &pooledConn{Conn: conn}
+	pc.charge(b) // The handshake, if any, is the dialing request's
+	return pc, nil
+}
+
+// pooledConn is a pooled connection that charges the bytes read to, and
+// refuses to send past, the budget of the request currently using it.
+type pooledConn struct {
+	net.Conn
+	b atomic.Pointer[budget]
+}
+
+func (c *pooledConn) charge(b *budget) {
+	c.b.Store(b)
+}
+
+func (c *pooledConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if b := c.b.Load(); b != nil {
+		atomic.AddInt64(&b.bytes, int64(n))
+	}
+	return n, err
+}
+
+func (c *pooledConn) Write(p []byte) (int, error) {
+	if b := c.b.Load(); b != nil {
+		if err := b.check(); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Write(p)
+}
+
+// chargedTransport hands each request the pooled connection under the
+// budget, audit trail and source of its own analysis.
+type chargedTransport struct {
+	t *http.Transport
+}
+
+func (t chargedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			conn := info.Conn
+			if tlsConn, ok := conn.(*tls.Conn); ok {
+				conn = tlsConn.NetConn()
+			}
+			pc, ok := conn.(*pooledConn)
+			if !ok {
+				return
+			}
+			// A fresh connection may have been dialed for another request
+			pc.charge(budgetFrom(ctx))
+			if source := sourceFrom(ctx); source != nil {
+				source.record(pc.LocalAddr())
+			}
+			if !info.Reused {
+				return
+			}
+			auditConn(ctx, AuditConnection{
+				Time:       time.Now().UTC(),
+				Network:    pc.RemoteAddr().Network(),
+				Address:    pc.RemoteAddr().String(),
+				RemoteAddr: pc.RemoteAddr().String(),
+				LocalAddr:  pc.LocalAddr().String(),
+				Reused:     true,
+			})
+		},
+	}
+	return t.t.RoundTrip(req.WithContext(httptrace.WithClientTrace(ctx, trace)))
+}
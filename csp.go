@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// cspPolicy is one parsed Content-Security-Policy. Directive names are
+// lower-cased; only the first occurrence of a directive counts.
+type cspPolicy struct {
+	raw        string
+	source     string // header, report-only, meta or request
+	directives map[string][]string
+	order      []string // Directive names as they appear
+	from       int      // First resource of the page it covers, for <meta> policies
+}
+
+// parseCSP splits a header value into its policies. A header may carry
+// several, separated by commas, and each is enforced on its own.
+func parseCSP(value, source string) []*cspPolicy {
+	var policies []*cspPolicy
+	for _, raw := range strings.Split(value, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		p := &cspPolicy{raw: raw, source: source, directives: make(map[string][]string)}
+		for _, directive := range strings.Split(raw, ";") {
+			fields := strings.Fields(directive)
+			if len(fields) == 0 {
+				continue
+			}
+			name := strings.ToLower(fields[0])
+			if _, ok := p.directives[name]; ok {
+				continue
+			}
+			p.directives[name] = fields[1:]
+			p.order = append(p.order, name)
+		}
+		policies = append(policies, p)
+	}
+	return policies
+}
+
+func (p *cspPolicy) info() CSPPolicy {
+	directives := make(map[string][]string, len(p.directives))
+	for name, sources := range p.directives {
+		directives[name] = append([]string{}, sources...)
+	}
+	return CSPPolicy{Source: p.source, Raw: p.raw, Directives: directives}
+}
+
+// Fallback order of each directive a resource can be checked against,
+// CSP Level 3 section 6.8.3. form-action and base-uri have no fallback.
+var cspFallbacks = map[string][]string{
+	"script-src-elem": {"script-src-elem", "script-src", "default-src"},
+	"script-src-attr": {"script-src-attr", "script-src", "default-src"},
+	"style-src-elem":  {"style-src-elem", "style-src", "default-src"},
+	"style-src-attr":  {"style-src-attr", "style-src", "default-src"},
+	"img-src":         {"img-src", "default-src"},
+	"font-src":        {"font-src", "default-src"},
+	"media-src":       {"media-src", "default-src"},
+	"object-src":      {"object-src", "default-src"},
+	"manifest-src":    {"manifest-src", "default-src"},
+	"connect-src":     {"connect-src", "default-src"},
+	"frame-src":       {"frame-src", "child-src", "default-src"},
+	"worker-src":      {"worker-src", "child-src", "script-src", "default-src"},
+	"form-action":     {"form-action"},
+	"base-uri":        {"base-uri"},
+}
+
+// governing returns the directive of p that decides for the effective
+// directive and its source list, or "" when none applies.
+func (p *cspPolicy) governing(effective string) (string, []string) {
+	for _, name := range cspFallbacks[effective] {
+		if sources, ok := p.directives[name]; ok {
+			return name, sources
+		}
+	}
+	return "", nil
+}
+
+// allows reports whether p lets the page load or run r. The page URL is
+// the origin 'self' refers to.
+func (p *cspPolicy) allows(r PageResource, page *url.URL, content string, nonce string) (string, bool) {
+	directive, sources := p.governing(r.Directive)
+	if directive == "" {
+		return "", true
+	}
+	if r.URL == "" {
+		return directive, cspAllowsInline(sources, r, content, nonce)
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return directive, true
+	}
+	strictDynamic := containsString(lowerAll(sources), "'strict-dynamic'") && strings.HasPrefix(directive, "script-src")
+	if strictDynamic {
+		// Only nonces and hashes count; a parser-inserted script
+		// without a matching nonce is blocked
+		return directive, nonce != "" && cspHasNonce(sources, nonce)
+	}
+	if nonce != "" && cspHasNonce(sources, nonce) {
+		return directive, true
+	}
+	for _, source := range sources {
+		if cspSourceMatches(source, u, page) {
+			return directive, true
+		}
+	}
+	return directive, false
+}
+
+// cspAllowsInline decides for inline script and style, attributes and
+// javascript: URLs. A nonce or hash in the list disables 'unsafe-inline'.
+// Nonces only apply to elements, and hashes to anything but elements
+// only with 'unsafe-hashes'.
+func cspAllowsInline(sources []string, r PageResource, content, nonce string) bool {
+	element := r.Kind == "inline-script" || r.Kind == "inline-style"
+	hashAllowed := element
+	unsafeInline, hasNonceOrHash := false, false
+	for _, source := range sources {
+		lower := strings.ToLower(source)
+		switch {
+		case lower == "'unsafe-inline'":
+			unsafeInline = true
+		case lower == "'unsafe-hashes'":
+			hashAllowed = true
+		case strings.HasPrefix(lower, "'nonce-"):
+			hasNonceOrHash = true
+		case strings.HasPrefix(lower, "'sha256-"), strings.HasPrefix(lower, "'sha384-"), strings.HasPrefix(lower, "'sha512-"):
+			hasNonceOrHash = true
+		case lower == "'strict-dynamic'" && strings.HasPrefix(r.Directive, "script-src"):
+			hasNonceOrHash = true
+		}
+	}
+	if element && nonce != "" && cspHasNonce(sources, nonce) {
+		return true
+	}
+	if hashAllowed && cspHasHash(sources, content) {
+		return true
+	}
+	return unsafeInline && !hasNonceOrHash
+}
+
+func cspHasNonce(sources []string, nonce string) bool {
+	for _, source := range sources {
+		if source == "'nonce-"+nonce+"'" {
+			return true
+		}
+	}
+	return false
+}
+
+func cspHasHash(sources []string, content string) bool {
+	sum256 := sha256.Sum256([]byte(content))
+	sum384 := sha512.Sum384([]byte(content))
+	sum512 := sha512.Sum512([]byte(content))
+	hashes := []string{
+		"'sha256-" + base64.StdEncoding.EncodeToString(sum256[:]) + "'",
+		"'sha384-" + base64.StdEncoding.EncodeToString(sum384[:]) + "'",
+		"'sha512-" + base64.StdEncoding.EncodeToString(sum512[:]) + "'",
+	}
+	for _, source := range sources {
+		if containsString(hashes, source) {
+			return true
+		}
+	}
+	return false
+}
+
+// cspSourceMatches implements CSP Level 3 section 6.7.2.8 for one source
+// expression, without redirects. Scheme upgrades from http to https, and
+// from ws to wss, match as the specification allows.
+func cspSourceMatches(source string, u, page *url.URL) bool {
+	lower := strings.ToLower(source)
+	switch {
+	case lower == "*":
+		// Any network scheme, not data:, blob: or filesystem:
+		return u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "ws" || u.Scheme == "wss" || u.Scheme == page.Scheme
+	case lower == "'self'":
+		return selfMatches(u, page)
+	case strings.HasPrefix(lower, "'"):
+		return false // Keywords, nonces and hashes are for inline content
+	case strings.HasSuffix(lower, ":") && !strings.Contains(lower, "/"):
+		return schemeMatches(strings.TrimSuffix(lower, ":"), u.Scheme)
+	}
+
+	// host-source: [scheme://]host[:port][/path], only the path is case
+	// sensitive
+	rest := source
+	scheme := ""
+	if i := strings.Index(rest, "://"); i >= 0 {
+		scheme, rest = strings.ToLower(rest[:i]), rest[i+3:]
+	}
+	path := ""
+	if i := strings.Index(rest, "/"); i >= 0 {
+		rest, path = rest[:i], rest[i:]
+	}
+	rest = strings.ToLower(rest)
+	host, port := rest, ""
+	if i := strings.LastIndex(rest, ":"); i >= 0 && !strings.HasSuffix(rest, "]") {
+		host, port = rest[:i], rest[i+1:]
+	}
+
+	if scheme == "" {
+		scheme = page.Scheme
+	}
+	if !schemeMatches(scheme, u.Scheme) {
+		return false
+	}
+	if !cspHostMatches(host, strings.ToLower(u.Hostname())) {
+		return false
+	}
+	switch port {
+	case "*":
+	case "":
+		if effectivePort(u) != defaultPort(u.Scheme) {
+			return false
+		}
+	default:
+		if port != effectivePort(u) {
+			return false
+		}
+	}
+	if path != "" && path != "/" {
+		decoded := u.EscapedPath()
+		if unescaped, err := url.PathUnescape(decoded); err == nil {
+			decoded = unescaped
+		}
+		if strings.HasSuffix(path, "/") {
+			return strings.HasPrefix(decoded, path)
+		}
+		return decoded == path
+	}
+	return true
+}
+
+// schemeMatches lets a source's http match https and ws match wss.
+func schemeMatches(expression, scheme string) bool {
+	switch expression {
+	case scheme:
+		return true
+	case "http":
+		return scheme == "https"
+	case "ws":
+		return scheme == "wss" || scheme == "http" || scheme == "https"
+	case "wss":
+		return scheme == "https"
+	}
+	return false
+}
+
+func cspHostMatches(pattern, host string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return pattern == host
+}
+
+func defaultPort(scheme string) string {
+	switch scheme {
+	case "http", "ws":
+		return "80"
+	case "https", "wss":
+		return "443"
+	}
+	return ""
+}
+
+func effectivePort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	return defaultPort(u.Scheme)
+}
+
+// selfMatches reports whether u is same origin with the page, or on its
+// host after a secure upgrade: http to https, ws or wss, and https to wss.
+func selfMatches(u, page *url.URL) bool {
+	if !strings.EqualFold(u.Hostname(), page.Hostname()) {
+		return false
+	}
+	if u.Scheme == page.Scheme && effectivePort(u) == effectivePort(page) {
+		return true
+	}
+	upgrade := page.Scheme == "http" && (u.Scheme == "https" || u.Scheme == "ws" || u.Scheme == "wss") ||
+		page.Scheme == "https" && u.Scheme == "wss"
+	return upgrade && (effectivePort(u) == effectivePort(page) || u.Port() == "" && page.Port() == "")
+}
+
+func lowerAll(values []string) []string {
+	lower := make([]string, len(values))
+	for i, v := range values {
+		lower[i] = strings.ToLower(v)
+	}
+	return lower
+}
+
+// analyzeCSP fetches the page, lists the resources its HTML loads and runs
+// each against the page's policies, from its headers and <meta> elements,
+// or against policy when one is given, so the result names exactly what
+// enforcement would block.
+func analyzeCSP(ctx context.Context, target *url.URL, tlsConfig *tls.Config, policy string, probeReporting, render bool) *CSPAnalysis {
+	analysis := &CSPAnalysis{}
+
+	client := newProbeClient(tlsConfig)
+	defer client.CloseIdleConnections()
+	// One byte past the limit tells a page that fits from a longer one
+	resp, body, err := doRequestLimit(ctx, client, http.MethodGet, target, nil, cfg.CSPMaxPageBytes+1)
+	if err != nil {
+		analysis.Error = err.Error()
+		return analysis
+	}
+
+	var policies []*cspPolicy
+	if policy != "" {
+		policies = parseCSP(policy, "request")
+	} else {
+		for _, value := range resp.Header.Values("Content-Security-Policy") {
+			policies = append(policies, parseCSP(value, "header")...)
+		}
+		for _, value := range resp.Header.Values("Content-Security-Policy-Report-Only") {
+			policies = append(policies, parseCSP(value, "report-only")...)
+		}
+	}
+	for _, p := range policies {
+		analysis.Policies = append(analysis.Policies, p.info())
+	}
+
+	if !isHTML(resp) {
+		analysis.Reporting = analyzeCSPReporting(ctx, resp.Header, policies, target, client, probeReporting)
+		analysis.Error = "The page is not HTML, no resources to check"
+		return analysis
+	}
+	if int64(len(body)) > cfg.CSPMaxPageBytes {
+		body = body[:cfg.CSPMaxPageBytes]
+		analysis.Findings = append(analysis.Findings, Finding{Severity: severityInfo, ID: "csp-page-truncated",
+			Message: fmt.Sprintf("Only the first %d bytes of the page were scanned for resources", cfg.CSPMaxPageBytes),
+			Detail:  "Resources later in the page are not checked, see -csp-max-page-bytes"})
+	}
+	page, metas, truncated := scanPage(string(body), target, cfg.CSPMaxResources)
+	if truncated {
+		analysis.Findings = append(analysis.Findings, Finding{Severity: severityInfo, ID: "csp-resources-truncated",
+			Message: fmt.Sprintf("Scanning stopped after the first %d resources of the page", cfg.CSPMaxResources),
+			Detail:  "Resources later in the page are not checked, see -csp-max-resources"})
+	}
+	if render {
+		var added []pageResource
+		analysis.Rendering, added = renderedResources(ctx, target, tlsConfig, page)
+		page = append(page, added...)
+	}
+	scripts := pageScripts(ctx, client, page)
+	page = append(page, analyzeWorkers(ctx, client, page, scripts, target, analysis)...)
+	if connects := findConnectEndpoints(scripts, target); len(connects) > 0 {
+		page = append(page, connects...)
+		analysis.ConnectSrc = suggestConnectSrc(connects, target)
+	}
+	for _, r := range page {
+		analysis.Resources = append(analysis.Resources, r.resource)
+	}
+
+	if policy == "" {
+		metaPolicies, findings := metaCSPPolicies(metas)
+		analysis.Findings = append(analysis.Findings, findings...)
+		analysis.Findings = append(analysis.Findings, cspPrecedenceFindings(policies, metaPolicies)...)
+		for _, p := range metaPolicies {
+			policies = append(policies, p)
+			analysis.Policies = append(analysis.Policies, p.info())
+		}
+	}
+	analysis.Reporting = analyzeCSPReporting(ctx, resp.Header, policies, target, client, probeReporting)
+
+	for j, r := range page {
+		for i, p := range policies {
+			if j < p.from {
+				continue
+			}
+			directive, ok := p.allows(r.resource, target, r.content, r.nonce)
+			if ok {
+				continue
+			}
+			analysis.Blocked = append(analysis.Blocked, CSPViolation{
+				Resource:   r.resource,
+				Policy:     i,
+				Directive:  directive,
+				ReportOnly: p.source == "report-only",
+			})
+		}
+	}
+
+	analysis.Origins = cspOrigins(target, analysis.Resources, policies)
+	analysis.Findings = append(analysis.Findings, trackerFindings(analysis.Origins)...)
+	return analysis
+}
+
+// isHTML reports whether resp declares an HTML body.
+func isHTML(resp *http.Response) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]))
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
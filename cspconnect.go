@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Calls in scripts that open connections checked against connect-src, by
+// the attribute the resource is listed under
+var connectCalls = []struct {
+	attribute string
+	pattern   *regexp.Regexp
+}{
+	{"WebSocket", regexp.MustCompile("new\\s+WebSocket\\s*\\(\\s*['\"`]([^'\"`]+)['\"`]")},
+	{"EventSource", regexp.MustCompile("new\\s+EventSource\\s*\\(\\s*['\"`]([^'\"`]+)['\"`]")},
+	{"fetch", regexp.MustCompile("\\bfetch\\s*\\(\\s*['\"`]([^'\"`]+)['\"`]")},
+	{"XMLHttpRequest", regexp.MustCompile("\\.open\\s*\\(\\s*['\"`][A-Za-z]+['\"`]\\s*,\\s*['\"`]([^'\"`]+)['\"`]")},
+	{"sendBeacon", regexp.MustCompile("sendBeacon\\s*\\(\\s*['\"`]([^'\"`]+)['\"`]")},
+	// API base URLs in configuration, e.g. baseURL: "https://api.example.com"
+	{"api-base", regexp.MustCompile("(?i)\\b(?:base_?url|api_?(?:base_?)?url|api_?base|api_?endpoint|api_?host)['\"`]?\\s*[:=]\\s*['\"`]((?:https?|wss?)://[^'\"`]+)['\"`]")},
+	// WebSocket URLs kept in variables or configuration
+	{"WebSocket", regexp.MustCompile("['\"`](wss?://[^'\"`\\s]+)['\"`]")},
+}
+
+// findConnectEndpoints lists the URLs the page's scripts connect to with
+// WebSocket, EventSource, fetch, XMLHttpRequest and sendBeacon, and the
+// API base URLs they configure. Template literals are cut at the first
+// substitution and relative URLs resolve against the page.
+func findConnectEndpoints(scripts []scriptSource, base *url.URL) []pageResource {
+	var endpoints []pageResource
+	seen := make(map[string]bool)
+	for _, s := range scripts {
+		for _, call := range connectCalls {
+			for _, m := range call.pattern.FindAllStringSubmatch(s.text, -1) {
+				raw := m[1]
+				templated := false
+				if i := strings.Index(raw, "${"); i >= 0 {
+					raw, templated = raw[:i], true
+				}
+				u, err := base.Parse(strings.TrimSpace(raw))
+				if err != nil || u.Host == "" || templated && !strings.Contains(m[1], "://") {
+					continue
+				}
+				switch u.Scheme {
+				case "http", "https", "ws", "wss":
+				default:
+					continue
+				}
+				u.Fragment = ""
+				if seen[u.String()] {
+					continue
+				}
+				seen[u.String()] = true
+				endpoints = append(endpoints, pageResource{resource: PageResource{
+					Element: "script", Attribute: call.attribute, Kind: "url", URL: u.String(), Directive: "connect-src",
+					Snippet: snippet(m[0]),
+				}})
+			}
+		}
+	}
+	return endpoints
+}
+
+// suggestConnectSrc builds a connect-src directive allowing 'self' and the
+// origin of every endpoint on another origin.
+func suggestConnectSrc(endpoints []pageResource, page *url.URL) string {
+	sources := []string{"'self'"}
+	var origins []string
+	for _, e := range endpoints {
+		u, err := url.Parse(e.resource.URL)
+		if err != nil || selfMatches(u, page) {
+			continue
+		}
+		if origin := u.Scheme + "://" + u.Host; !containsString(origins, origin) {
+			origins = append(origins, origin)
+		}
+	}
+	sort.Strings(origins)
+	return "connect-src " + strings.Join(append(sources, origins...), " ")
+}
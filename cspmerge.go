@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Directives a baseline mandates as a whole. A policy or overlay cannot
+// widen or replace them, so reporting and sandboxing stay as set.
+var cspMandatedDirectives = map[string]bool{
+	"report-to":                 true,
+	"report-uri":                true,
+	"sandbox":                   true,
+	"upgrade-insecure-requests": true,
+	"block-all-mixed-content":   true,
+	"require-trusted-types-for": true,
+	"trusted-types":             true,
+}
+
+// cspMergeRequest is the body of /api/csp/merge.
+type cspMergeRequest struct {
+	Baseline    string            `json:"baseline"`              // Team-provided policy, its mandated directives are kept
+	Policy      string            `json:"policy"`                // Policy to merge in, e.g. one written for the site
+	Overlays    map[string]string `json:"overlays,omitempty"`    // Extra sources by environment name
+	Environment string            `json:"environment,omitempty"` // Overlay to apply
+}
+
+// cspMerger builds a merged policy, keeping directives in the order they
+// were first seen and logging every change.
+type cspMerger struct {
+	directives map[string][]string
+	order      []string
+	changes    []CSPChange
+}
+
+// mergeCSP merges policy and then the overlay of environment into
+// baseline. Source lists are combined; 'none' gives way to any source.
+func mergeCSP(req cspMergeRequest) (*CSPMerge, error) {
+	var overlay string
+	if req.Environment != "" {
+		var ok bool
+		if overlay, ok = req.Overlays[req.Environment]; !ok {
+			return nil, fmt.Errorf("no overlay for environment %q", req.Environment)
+		}
+	}
+
+	m := &cspMerger{directives: make(map[string][]string)}
+	for _, layer := range []struct{ name, policy string }{
+		{"baseline", req.Baseline},
+		{"policy", req.Policy},
+		{"overlay", overlay},
+	} {
+		policies := parseCSP(layer.policy, layer.name)
+		if len(policies) > 1 {
+			return nil, fmt.Errorf("%s has %d policies, merge them one at a time", layer.name, len(policies))
+		}
+		if len(policies) == 1 {
+			m.add(policies[0], layer.name)
+		}
+	}
+
+	merged := &CSPMerge{Directives: m.directives, Changes: m.changes}
+	directives := make([]string, 0, len(m.order))
+	for _, name := range m.order {
+		directives = append(directives, strings.TrimSpace(name+" "+strings.Join(m.directives[name], " ")))
+	}
+	merged.Policy = strings.Join(directives, "; ")
+	return merged, nil
+}
+
+func (m *cspMerger) add(p *cspPolicy, layer string) {
+	for _, name := range p.order {
+		sources := p.directives[name]
+		current, ok := m.directives[name]
+		switch {
+		case !ok:
+			m.directives[name] = append([]string{}, sources...)
+			m.order = append(m.order, name)
+			if layer != "baseline" {
+				m.changes = append(m.changes, CSPChange{Directive: name, Change: "added", Sources: sources, From: layer})
+			}
+		case cspMandatedDirectives[name]:
+			if strings.Join(current, " ") != strings.Join(sources, " ") {
+				m.changes = append(m.changes, CSPChange{Directive: name, Change: "kept-baseline", Sources: sources, From: layer})
+			}
+		default:
+			combined, added := combineSources(current, sources)
+			m.directives[name] = combined
+			if containsString(lowerAll(current), "'none'") && !containsString(lowerAll(combined), "'none'") {
+				m.changes = append(m.changes, CSPChange{Directive: name, Change: "none-dropped", From: layer})
+			}
+			if len(added) > 0 {
+				m.changes = append(m.changes, CSPChange{Directive: name, Change: "sources-added", Sources: added, From: layer})
+			}
+		}
+	}
+}
+
+// combineSources appends the sources of b missing from a. 'none' is
+// dropped once the list allows anything, as it must stand alone.
+func combineSources(a, b []string) ([]string, []string) {
+	combined := append([]string{}, a...)
+	var added []string
+	for _, source := range b {
+		if containsString(lowerAll(combined), strings.ToLower(source)) {
+			continue
+		}
+		combined = append(combined, source)
+		if !strings.EqualFold(source, "'none'") {
+			added = append(added, source)
+		}
+	}
+	if len(combined) > 1 {
+		var without []string
+		for _, source := range combined {
+			if !strings.EqualFold(source, "'none'") {
+				without = append(without, source)
+			}
+		}
+		combined = without
+	}
+	return combined, added
+}
+
+// cspMergeHandler merges a baseline policy with another and an environment
+// overlay, returning the merged policy and what changed.
+func cspMergeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req cspMergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	merged, err := mergeCSP(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(merged)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeCSP(t *testing.T) {
+	overlays := map[string]string{
+		"staging": "connect-src https://api.staging.example.com; report-uri /other",
+	}
+	tests := []struct {
+		name    string
+		req     cspMergeRequest
+		policy  string
+		changes []CSPChange
+		err     string
+	}{
+		{
+			name:   "baseline only",
+			req:    cspMergeRequest{Baseline: "default-src 'self'; report-uri /csp"},
+			policy: "default-src 'self'; report-uri /csp",
+		},
+		{
+			name: "sources combined",
+			req: cspMergeRequest{Baseline: "default-src 'self'; script-src 'self'",
+				Policy: "script-src 'SELF' https://cdn.example.com; img-src data:"},
+			policy: "default-src 'self'; script-src 'self' https://cdn.example.com; img-src data:",
+			changes: []CSPChange{
+				{Directive: "script-src", Change: "sources-added", Sources: []string{"https://cdn.example.com"}, From: "policy"},
+				{Directive: "img-src", Change: "added", Sources: []string{"data:"}, From: "policy"},
+			},
+		},
+		{
+			name:   "none gives way",
+			req:    cspMergeRequest{Baseline: "object-src 'none'; frame-src 'none'", Policy: "frame-src https://video.example.com; object-src 'none'"},
+			policy: "object-src 'none'; frame-src https://video.example.com",
+			changes: []CSPChange{
+				{Directive: "frame-src", Change: "none-dropped", From: "policy"},
+				{Directive: "frame-src", Change: "sources-added", Sources: []string{"https://video.example.com"}, From: "policy"},
+			},
+		},
+		{
+			name: "mandated directives kept",
+			req: cspMergeRequest{Baseline: "default-src 'self'; report-uri /csp; upgrade-insecure-requests",
+				Policy: "report-uri /mine; upgrade-insecure-requests", Overlays: overlays, Environment: "staging"},
+			policy: "default-src 'self'; report-uri /csp; upgrade-insecure-requests; connect-src https://api.staging.example.com",
+			changes: []CSPChange{
+				{Directive: "report-uri", Change: "kept-baseline", Sources: []string{"/mine"}, From: "policy"},
+				{Directive: "connect-src", Change: "added", Sources: []string{"https://api.staging.example.com"}, From: "overlay"},
+				{Directive: "report-uri", Change: "kept-baseline", Sources: []string{"/other"}, From: "overlay"},
+			},
+		},
+		{
+			name:   "overlay not applied without environment",
+			req:    cspMergeRequest{Baseline: "default-src 'self'", Overlays: overlays},
+			policy: "default-src 'self'",
+		},
+		{
+			name: "unknown environment",
+			req:  cspMergeRequest{Baseline: "default-src 'self'", Overlays: overlays, Environment: "production"},
+			err:  `no overlay for environment "production"`,
+		},
+		{
+			name: "several policies",
+			req:  cspMergeRequest{Baseline: "default-src 'self'", Policy: "script-src 'self', img-src 'self'"},
+			err:  "policy has 2 policies",
+		},
+	}
+	for _, tt := range tests {
+		merged, err := mergeCSP(tt.req)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if merged.Policy != tt.policy {
+			t.Errorf("%s: policy\n%s\nwant\n%s", tt.name, merged.Policy, tt.policy)
+		}
+		if !reflect.DeepEqual(merged.Changes, tt.changes) {
+			t.Errorf("%s: changes\n%+v\nwant\n%+v", tt.name, merged.Changes, tt.changes)
+		}
+	}
+}
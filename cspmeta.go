@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Directives a policy delivered in <meta> may not use, CSP Level 3
+// section 3.3, and what is lost when they are ignored
+var cspMetaIgnored = []struct{ directive, detail string }{
+	{"frame-ancestors", "The page can still be framed by any site; send frame-ancestors in the Content-Security-Policy header, or X-Frame-Options."},
+	{"report-uri", "Violations of the meta policy are not reported; reporting needs the policy in a header."},
+	{"sandbox", "The page is not sandboxed; sandbox only works in the Content-Security-Policy header."},
+}
+
+// metaCSPPolicies parses the <meta> policies the browser honors, without
+// the directives it ignores there, and reports what goes wrong with each.
+// A meta policy only covers the resources that come after it.
+func metaCSPPolicies(metas []metaPolicy) ([]*cspPolicy, []Finding) {
+	var policies []*cspPolicy
+	var findings []Finding
+	for _, meta := range metas {
+		if meta.httpEquiv == "content-security-policy-report-only" {
+			findings = append(findings, Finding{Severity: severityLow, ID: "meta-csp-report-only",
+				Message: "A Content-Security-Policy-Report-Only <meta> element is ignored",
+				Detail:  "Report-only policies can only be delivered in a header."})
+			continue
+		}
+		if !meta.inHead {
+			findings = append(findings, Finding{Severity: severityMedium, ID: "meta-csp-outside-head",
+				Message: "A Content-Security-Policy <meta> element outside <head> is ignored",
+				Detail:  meta.content})
+			continue
+		}
+
+		for _, p := range parseCSP(meta.content, "meta") {
+			for _, ignored := range cspMetaIgnored {
+				if _, ok := p.directives[ignored.directive]; ok {
+					delete(p.directives, ignored.directive)
+					findings = append(findings, Finding{Severity: severityMedium, ID: "meta-csp-ignored-directive",
+						Message: ignored.directive + " is ignored in a <meta> policy", Detail: ignored.detail})
+				}
+			}
+			p.from = meta.position
+			if meta.position > 0 {
+				findings = append(findings, Finding{Severity: severityLow, ID: "meta-csp-late",
+					Message: fmt.Sprintf("The <meta> policy does not cover the %d resources of the page before it", meta.position),
+					Detail:  "Place the <meta> element first in <head>."})
+			}
+			policies = append(policies, p)
+		}
+	}
+	return policies, findings
+}
+
+// cspPrecedenceFindings explains how header and <meta> policies combine.
+// Every policy is enforced, so a meta policy can only restrict what the
+// header allows, never widen it.
+func cspPrecedenceFindings(headers, metas []*cspPolicy) []Finding {
+	var enforced []*cspPolicy
+	for _, p := range headers {
+		if p.source == "header" {
+			enforced = append(enforced, p)
+		}
+	}
+	if len(enforced) == 0 || len(metas) == 0 {
+		return nil
+	}
+
+	findings := []Finding{{Severity: severityInfo, ID: "csp-header-and-meta",
+		Message: "Policies come from both the header and <meta>; both are enforced",
+		Detail:  "A resource must pass every policy, so the effective policy is their intersection."}}
+	for _, meta := range metas {
+		for _, name := range meta.order {
+			sources, ok := meta.directives[name]
+			if !ok || !strings.HasSuffix(name, "-src") {
+				continue
+			}
+			for _, header := range enforced {
+				allowed, ok := header.directives[name]
+				if !ok {
+					allowed, ok = header.directives["default-src"]
+				}
+				if !ok {
+					continue
+				}
+				var extra []string
+				for _, source := range sources {
+					if !containsString(lowerAll(allowed), strings.ToLower(source)) {
+						extra = append(extra, source)
+					}
+				}
+				if len(extra) > 0 {
+					findings = append(findings, Finding{Severity: severityLow, ID: "meta-csp-cannot-relax",
+						Message: fmt.Sprintf("The <meta> policy allows %s in %s, which the header policy doesn't", strings.Join(extra, " "), name),
+						Detail:  "The header policy still blocks them; a <meta> policy can only restrict further."})
+				}
+			}
+		}
+	}
+	return findings
+}
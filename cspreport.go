@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Name the suggested Reporting-Endpoints header gives the report-uri
+// endpoint
+const suggestedReportingEndpoint = "csp-endpoint"
+
+// reportToGroup is one group of the legacy Report-To header.
+type reportToGroup struct {
+	Group     string `json:"group"`
+	MaxAge    int    `json:"max_age"`
+	Endpoints []struct {
+		URL string `json:"url"`
+	} `json:"endpoints"`
+}
+
+// parseReportingEndpoints reads the Reporting-Endpoints structured field
+// dictionary, name="url" pairs separated by commas. Parameters after a
+// semicolon are ignored.
+func parseReportingEndpoints(value string) (map[string]string, []string) {
+	endpoints := make(map[string]string)
+	var invalid []string
+	for _, member := range splitQuoted(value, ',') {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+		name, raw, ok := strings.Cut(member, "=")
+		raw = strings.TrimSpace(strings.SplitN(raw, ";", 2)[0])
+		if !ok || len(raw) < 2 || raw[0] != '"' || raw[len(raw)-1] != '"' {
+			invalid = append(invalid, member)
+			continue
+		}
+		endpoints[strings.TrimSpace(name)] = raw[1 : len(raw)-1]
+	}
+	return endpoints, invalid
+}
+
+// splitQuoted splits s on sep outside double quotes.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseReportTo reads the JSON groups of Report-To headers, which may be
+// comma-joined into one value.
+func parseReportTo(values []string) ([]reportToGroup, error) {
+	var groups []reportToGroup
+	for _, value := range values {
+		var parsed []reportToGroup
+		if err := json.Unmarshal([]byte("["+value+"]"), &parsed); err != nil {
+			return groups, fmt.Errorf("invalid Report-To header: %v", err)
+		}
+		groups = append(groups, parsed...)
+	}
+	return groups, nil
+}
+
+// analyzeCSPReporting collects where violations of the page's policies are
+// reported, checks that every report-to group is defined, and with probe
+// set sends each endpoint an empty report batch. It suggests the
+// Reporting-Endpoints header and directives for a configuration browsers
+// all understand.
+func analyzeCSPReporting(ctx context.Context, header http.Header, policies []*cspPolicy, page *url.URL, client *http.Client, probe bool) *CSPReporting {
+	reporting := &CSPReporting{}
+	var findings []Finding
+
+	named := make(map[string]string) // Endpoint URLs by name or group
+	endpoints, invalid := parseReportingEndpoints(strings.Join(header.Values("Reporting-Endpoints"), ","))
+	for _, member := range invalid {
+		findings = append(findings, Finding{Severity: severityMedium, ID: "reporting-endpoints-invalid",
+			Message: "A Reporting-Endpoints member is not name=\"url\"", Detail: member})
+	}
+	for name, raw := range endpoints {
+		named[name] = raw
+		reporting.Endpoints = append(reporting.Endpoints, ReportingEndpoint{Name: name, URL: resolveReportURL(page, raw), Header: "Reporting-Endpoints"})
+	}
+
+	groups, err := parseReportTo(header.Values("Report-To"))
+	if err != nil {
+		findings = append(findings, Finding{Severity: severityMedium, ID: "report-to-invalid", Message: "The Report-To header is not valid JSON", Detail: err.Error()})
+	}
+	for _, g := range groups {
+		name := g.Group
+		if name == "" {
+			name = "default"
+		}
+		for _, e := range g.Endpoints {
+			if _, ok := named[name]; !ok {
+				named[name] = e.URL
+			}
+			reporting.Endpoints = append(reporting.Endpoints, ReportingEndpoint{Name: name, URL: resolveReportURL(page, e.URL), Header: "Report-To", MaxAge: g.MaxAge})
+		}
+	}
+	if len(groups) > 0 && len(endpoints) == 0 {
+		findings = append(findings, Finding{Severity: severityLow, ID: "report-to-header-deprecated",
+			Message: "Endpoints are only defined in the deprecated Report-To header",
+			Detail:  "Current browsers read Reporting-Endpoints; send it alongside Report-To."})
+	}
+
+	enforced := false
+	for _, p := range policies {
+		if p.source == "header" || p.source == "report-only" {
+			enforced = true
+		}
+		for _, raw := range p.directives["report-uri"] {
+			u := resolveReportURL(page, raw)
+			if !containsString(reporting.ReportURI, u) {
+				reporting.ReportURI = append(reporting.ReportURI, u)
+			}
+		}
+		for _, group := range p.directives["report-to"] {
+			if containsString(reporting.ReportTo, group) {
+				continue
+			}
+			reporting.ReportTo = append(reporting.ReportTo, group)
+			if _, ok := named[group]; !ok {
+				findings = append(findings, Finding{Severity: severityMedium, ID: "csp-report-to-undefined",
+					Message: fmt.Sprintf("report-to names %q, which neither Reporting-Endpoints nor Report-To defines", group),
+					Detail:  "Browsers that use report-to send no reports for this policy."})
+			}
+		}
+	}
+	sort.Slice(reporting.Endpoints, func(i, j int) bool {
+		if reporting.Endpoints[i].Name != reporting.Endpoints[j].Name {
+			return reporting.Endpoints[i].Name < reporting.Endpoints[j].Name
+		}
+		return reporting.Endpoints[i].Header > reporting.Endpoints[j].Header
+	})
+
+	var suggested []string // Members of a Reporting-Endpoints header
+	switch {
+	case !enforced:
+	case len(reporting.ReportURI) == 0 && len(reporting.ReportTo) == 0:
+		findings = append(findings, Finding{Severity: severityInfo, ID: "csp-no-reporting",
+			Message: "Violations of the policy are not reported",
+			Detail:  "Add report-to, with a Reporting-Endpoints header, and report-uri for browsers without the Reporting API."})
+	case len(reporting.ReportTo) == 0:
+		findings = append(findings, Finding{Severity: severityLow, ID: "csp-report-uri-only",
+			Message: "The policy only uses the deprecated report-uri",
+			Detail:  "Keep report-uri for browsers without the Reporting API and add report-to; see the suggested header."})
+		suggested = append(suggested, fmt.Sprintf("%s=%q", suggestedReportingEndpoint, reporting.ReportURI[0]))
+		reporting.SuggestedDirectives = "report-to " + suggestedReportingEndpoint + "; report-uri " + strings.Join(reporting.ReportURI, " ")
+	case len(reporting.ReportURI) == 0:
+		findings = append(findings, Finding{Severity: severityInfo, ID: "csp-report-to-only",
+			Message: "The policy only uses report-to",
+			Detail:  "Browsers without the Reporting API only send reports to report-uri; add it with the same endpoint."})
+		if u, ok := named[reporting.ReportTo[0]]; ok {
+			reporting.SuggestedDirectives = "report-to " + reporting.ReportTo[0] + "; report-uri " + resolveReportURL(page, u)
+		}
+	}
+	if len(endpoints) == 0 {
+		for _, g := range groups {
+			if name := g.Group; len(g.Endpoints) > 0 {
+				if name == "" {
+					name = "default"
+				}
+				suggested = append(suggested, fmt.Sprintf("%s=%q", name, g.Endpoints[0].URL))
+			}
+		}
+	}
+	reporting.SuggestedReportingEndpoints = strings.Join(suggested, ", ")
+
+	for i, e := range reporting.Endpoints {
+		if u, err := url.Parse(e.URL); err != nil || u.Scheme != "https" {
+			findings = append(findings, Finding{Severity: severityMedium, ID: "reporting-endpoint-insecure",
+				Message: fmt.Sprintf("The %s endpoint %s is not HTTPS", e.Name, e.URL),
+				Detail:  "The Reporting API only delivers to secure URLs."})
+		}
+		if probe {
+			reporting.Endpoints[i].Probe = probeReportEndpoint(ctx, client, e.URL, "application/reports+json")
+		}
+	}
+	if probe {
+		for _, u := range reporting.ReportURI {
+			reporting.ReportURIProbes = append(reporting.ReportURIProbes, *probeReportEndpoint(ctx, client, u, "application/csp-report"))
+		}
+	}
+	for _, p := range append(reporting.ReportURIProbes, endpointProbes(reporting.Endpoints)...) {
+		if !p.Accepts {
+			findings = append(findings, Finding{Severity: severityMedium, ID: "reporting-endpoint-failing",
+				Message: "The reporting endpoint " + p.URL + " does not accept reports", Detail: p.Error})
+		}
+	}
+
+	reporting.Findings = findings
+	return reporting
+}
+
+func endpointProbes(endpoints []ReportingEndpoint) []ReportEndpointProbe {
+	var probes []ReportEndpointProbe
+	for _, e := range endpoints {
+		if e.Probe != nil {
+			probes = append(probes, *e.Probe)
+		}
+	}
+	return probes
+}
+
+func resolveReportURL(page *url.URL, raw string) string {
+	u, err := page.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return raw
+	}
+	return u.String()
+}
+
+// probeReportEndpoint POSTs an empty report batch, which a collector
+// accepts without recording anything.
+func probeReportEndpoint(ctx context.Context, client *http.Client, endpoint, contentType string) *ReportEndpointProbe {
+	probe := &ReportEndpointProbe{URL: endpoint}
+	body := "[]"
+	if contentType == "application/csp-report" {
+		body = "{}"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBufferString(body))
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	markProbe(req)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := client.Do(req)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	resp.Body.Close()
+	probe.StatusCode = resp.StatusCode
+	probe.Accepts = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !probe.Accepts {
+		probe.Error = resp.Status
+	}
+	return probe
+}
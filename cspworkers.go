@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Most external scripts of a page fetched to look for what they load
+const maxScriptFetches = 20
+
+var (
+	// navigator.serviceWorker.register("/sw.js", {scope: "/app/"})
+	serviceWorkerRegister = regexp.MustCompile("serviceWorker\\s*\\.\\s*register\\s*\\(\\s*(['\"`])([^'\"`]+)['\"`]\\s*(?:,\\s*\\{([^}]*)\\})?")
+	serviceWorkerScope    = regexp.MustCompile("scope\\s*:\\s*['\"`]([^'\"`]+)['\"`]")
+	// importScripts("a.js", "b.js") in a service worker
+	importScriptsCall = regexp.MustCompile(`importScripts\s*\(([^)]*)\)`)
+	quotedString      = regexp.MustCompile("['\"`]([^'\"`]+)['\"`]")
+)
+
+// scriptSource is the text of one script of the page and the URL relative
+// references in it resolve against.
+type scriptSource struct {
+	location string // The script URL, or "inline"
+	text     string
+}
+
+// pageScripts returns the inline scripts of the page and the text of up to
+// maxScriptFetches of its external ones.
+func pageScripts(ctx context.Context, client *http.Client, page []pageResource) []scriptSource {
+	var scripts []scriptSource
+	fetched := 0
+	for _, r := range page {
+		switch {
+		case r.resource.Kind == "inline-script":
+			scripts = append(scripts, scriptSource{location: "inline", text: r.content})
+		case r.resource.Kind == "url" && r.resource.Directive == "script-src-elem" && fetched < maxScriptFetches:
+			u, err := url.Parse(r.resource.URL)
+			if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+				continue
+			}
+			fetched++
+			resp, body, err := doRequest(ctx, client, http.MethodGet, u, nil)
+			if err != nil || resp.StatusCode != http.StatusOK {
+				continue
+			}
+			scripts = append(scripts, scriptSource{location: u.String(), text: string(body)})
+		}
+	}
+	return scripts
+}
+
+// findServiceWorkers looks for navigator.serviceWorker.register calls with
+// a literal script URL. The URL resolves against the page, like the
+// browser does.
+func findServiceWorkers(scripts []scriptSource, base *url.URL) []CSPServiceWorker {
+	var workers []CSPServiceWorker
+	seen := make(map[string]bool)
+	for _, s := range scripts {
+		for _, m := range serviceWorkerRegister.FindAllStringSubmatch(s.text, -1) {
+			u, err := base.Parse(m[2])
+			if err != nil || seen[u.String()] {
+				continue
+			}
+			seen[u.String()] = true
+			w := CSPServiceWorker{URL: u.String(), RegisteredIn: s.location}
+			if scope := serviceWorkerScope.FindStringSubmatch(m[3]); scope != nil {
+				if su, err := base.Parse(scope[1]); err == nil {
+					w.Scope = su.String()
+				}
+			}
+			workers = append(workers, w)
+		}
+	}
+	return workers
+}
+
+// checkServiceWorker fetches the worker script, lists its importScripts
+// and checks the scope it is registered with is allowed.
+func checkServiceWorker(ctx context.Context, client *http.Client, w *CSPServiceWorker) []Finding {
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		w.Error = err.Error()
+		return nil
+	}
+	resp, body, err := doRequest(ctx, client, http.MethodGet, u, nil)
+	if err != nil {
+		w.Error = err.Error()
+		return nil
+	}
+	w.StatusCode = resp.StatusCode
+	w.ServiceWorkerAllowed = resp.Header.Get("Service-Worker-Allowed")
+	w.ContentSecurityPolicy = resp.Header.Get("Content-Security-Policy")
+	if resp.StatusCode != http.StatusOK {
+		return []Finding{{Severity: severityMedium, ID: "service-worker-unavailable",
+			Message: fmt.Sprintf("The service worker %s answers %s", w.URL, resp.Status),
+			Detail:  "Registration fails, and an installed worker is not updated."}}
+	}
+
+	for _, call := range importScriptsCall.FindAllStringSubmatch(string(body), -1) {
+		for _, arg := range quotedString.FindAllStringSubmatch(call[1], -1) {
+			if iu, err := u.Parse(arg[1]); err == nil && !containsString(w.Imports, iu.String()) {
+				w.Imports = append(w.Imports, iu.String())
+			}
+		}
+	}
+
+	// Without Service-Worker-Allowed, the scope can't be above the
+	// directory of the worker script
+	maxScope := *u
+	maxScope.RawQuery = ""
+	maxScope.Path = path.Dir(u.Path)
+	if !strings.HasSuffix(maxScope.Path, "/") {
+		maxScope.Path += "/"
+	}
+	if w.ServiceWorkerAllowed != "" {
+		if allowed, err := u.Parse(w.ServiceWorkerAllowed); err == nil {
+			maxScope = *allowed
+		}
+	}
+	if w.Scope == "" {
+		w.Scope = maxScope.String()
+		return nil
+	}
+	if scope, err := url.Parse(w.Scope); err == nil && !strings.HasPrefix(scope.Path, maxScope.Path) {
+		return []Finding{{Severity: severityMedium, ID: "service-worker-scope-not-allowed",
+			Message: fmt.Sprintf("The service worker %s is registered for %s, above its maximum scope %s", w.URL, w.Scope, maxScope.String()),
+			Detail:  "Registration fails; send Service-Worker-Allowed with the scope, or move the worker script up."}}
+	}
+	return nil
+}
+
+// webManifest is the part of a web app manifest that loads resources or
+// decides where the app runs.
+type webManifest struct {
+	StartURL string `json:"start_url"`
+	Scope    string `json:"scope"`
+	Icons    []struct {
+		Src string `json:"src"`
+	} `json:"icons"`
+	Screenshots []struct {
+		Src string `json:"src"`
+	} `json:"screenshots"`
+}
+
+// checkManifest fetches the web app manifest at raw and lists the images
+// it references, which are checked against img-src.
+func checkManifest(ctx context.Context, client *http.Client, raw string) *CSPManifest {
+	manifest := &CSPManifest{URL: raw}
+	u, err := url.Parse(raw)
+	if err != nil {
+		manifest.Error = err.Error()
+		return manifest
+	}
+	resp, body, err := doRequest(ctx, client, http.MethodGet, u, nil)
+	if err != nil {
+		manifest.Error = err.Error()
+		return manifest
+	}
+	manifest.StatusCode = resp.StatusCode
+	if resp.StatusCode != http.StatusOK {
+		manifest.Error = resp.Status
+		return manifest
+	}
+	var parsed webManifest
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		manifest.Error = "invalid manifest: " + err.Error()
+		return manifest
+	}
+
+	resolve := func(ref string) string {
+		if ref == "" {
+			return ""
+		}
+		if r, err := u.Parse(ref); err == nil {
+			return r.String()
+		}
+		return ref
+	}
+	manifest.StartURL = resolve(parsed.StartURL)
+	manifest.Scope = resolve(parsed.Scope)
+	for _, icon := range parsed.Icons {
+		if src := resolve(icon.Src); src != "" {
+			manifest.Images = append(manifest.Images, src)
+		}
+	}
+	for _, shot := range parsed.Screenshots {
+		if src := resolve(shot.Src); src != "" {
+			manifest.Images = append(manifest.Images, src)
+		}
+	}
+	return manifest
+}
+
+// analyzeWorkers finds the service workers and web app manifest of the
+// page, fetches them and returns the resources they add: each worker
+// script under worker-src and each manifest image under img-src.
+func analyzeWorkers(ctx context.Context, client *http.Client, page []pageResource, scripts []scriptSource, base *url.URL, analysis *CSPAnalysis) []pageResource {
+	var added []pageResource
+	for _, w := range findServiceWorkers(scripts, base) {
+		analysis.Findings = append(analysis.Findings, checkServiceWorker(ctx, client, &w)...)
+		analysis.ServiceWorkers = append(analysis.ServiceWorkers, w)
+		added = append(added, pageResource{resource: PageResource{Element: "script", Attribute: "serviceWorker.register", Kind: "url", URL: w.URL, Directive: "worker-src"}})
+	}
+
+	for _, r := range page {
+		if r.resource.Directive != "manifest-src" || analysis.Manifest != nil {
+			continue
+		}
+		analysis.Manifest = checkManifest(ctx, client, r.resource.URL)
+		for _, image := range analysis.Manifest.Images {
+			added = append(added, pageResource{resource: PageResource{Element: "manifest", Attribute: "images", Kind: "url", URL: image, Directive: "img-src"}})
+		}
+	}
+	return added
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Bounds on the servers a delegation check queries
+const (
+	maxDelegatedServers   = 8
+	maxAddressesPerServer = 4
+)
+
+// checkDelegation follows the authoritative path of name: it finds the
+// zone, asks a parent server for the delegation and its glue, then asks
+// every delegated server for the zone's SOA and NS records without
+// recursion. Servers that don't answer authoritatively are lame, and
+// servers disagreeing on the serial or NS set break resolution only for
+// the clients that happen to reach them.
+func checkDelegation(ctx context.Context, name string) *DelegationCheck {
+	check := &DelegationCheck{}
+	servers, err := systemNameservers()
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	resolver := servers[0]
+
+	check.Zone, err = findZone(ctx, resolver, name)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	_, parent, _ := strings.Cut(strings.TrimSuffix(check.Zone, "."), ".")
+	if parent == "" {
+		check.Error = fmt.Sprintf("%s is a top-level domain", check.Zone)
+		return check
+	}
+	check.Parent = parent + "."
+
+	delegation, glue, err := askParent(ctx, resolver, check)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.Delegation = delegation
+
+	for i, ns := range delegation {
+		if i == maxDelegatedServers {
+			break
+		}
+		server := DelegatedNameserver{Name: ns, Glue: glue[ns]}
+		addrs := server.Glue
+		if len(addrs) == 0 {
+			addrs, _, _ = sharedLookupA(ctx, resolver, ns)
+		}
+		if len(addrs) == 0 {
+			server.Error = "does not resolve"
+		}
+		for j, addr := range addrs {
+			if j == maxAddressesPerServer {
+				break
+			}
+			if net.ParseIP(addr).To4() == nil {
+				continue // IPv6 glue is listed but only IPv4 is queried
+			}
+			server.Addresses = append(server.Addresses, queryAuthoritative(ctx, addr, check.Zone))
+		}
+		check.Nameservers = append(check.Nameservers, server)
+	}
+
+	check.Findings = delegationFindings(ctx, resolver, check)
+	return check
+}
+
+// findZone returns the zone name belongs to, from the owner of the SOA
+// record in the answer or, for names below the apex, the authority section.
+func findZone(ctx context.Context, resolver, name string) (string, error) {
+	m, _, err := sharedExchange(ctx, resolver, name, dnsTypeSOA)
+	if err != nil {
+		return "", err
+	}
+	for _, rr := range append(m.answers, m.authority...) {
+		if rr.rtype == dnsTypeSOA {
+			return strings.ToLower(rr.name), nil
+		}
+	}
+	return "", fmt.Errorf("no SOA record found for %s", name)
+}
+
+// askParent queries the parent zone's servers in turn until one returns
+// the delegation for the zone, returning the NS names and the glue
+// addresses by name.
+func askParent(ctx context.Context, resolver string, check *DelegationCheck) ([]string, map[string][]string, error) {
+	m, _, err := sharedExchange(ctx, resolver, check.Parent, dnsTypeNS)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find the servers of %s: %v", check.Parent, err)
+	}
+
+	var lastErr error
+	for _, rr := range m.answers {
+		parentServer := rr.target()
+		if parentServer == "" {
+			continue
+		}
+		addrs, _, err := sharedLookupA(ctx, resolver, parentServer)
+		if err != nil || len(addrs) == 0 {
+			lastErr = fmt.Errorf("%s does not resolve", parentServer)
+			continue
+		}
+
+		referral, _, err := dnsExchange(ctx, net.JoinHostPort(addrs[0], "53"), check.Zone, dnsTypeNS, false)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %v", parentServer, err)
+			continue
+		}
+		check.ParentServer = parentServer
+
+		var delegation []string
+		glue := make(map[string][]string)
+		// A referral has the NS records in the authority section, a parent
+		// that also serves the child answers with them
+		for _, rr := range append(referral.answers, referral.authority...) {
+			if ns := rr.target(); rr.rtype == dnsTypeNS && strings.EqualFold(rr.name, check.Zone) {
+				delegation = append(delegation, strings.ToLower(ns))
+			}
+		}
+		for _, rr := range referral.additional {
+			if ip := rr.ip(); ip != nil {
+				owner := strings.ToLower(rr.name)
+				glue[owner] = append(glue[owner], ip.String())
+			}
+		}
+		if len(delegation) == 0 {
+			return nil, nil, fmt.Errorf("%s returned no delegation for %s (%s)", parentServer, check.Zone, dnsRCodeName(referral.rcode))
+		}
+		sort.Strings(delegation)
+		return delegation, glue, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no servers found for %s", check.Parent)
+	}
+	return nil, nil, lastErr
+}
+
+// queryAuthoritative asks one server address for the zone's SOA and NS
+// records without recursion.
+func queryAuthoritative(ctx context.Context, addr, zone string) DelegatedServerAddress {
+	result := DelegatedServerAddress{Address: addr}
+	server := net.JoinHostPort(addr, "53")
+
+	soa, rtt, err := dnsExchange(ctx, server, zone, dnsTypeSOA, false)
+	if err != nil {
+		result.Error = describeConnError(err)
+		return result
+	}
+	result.RTTMs = rtt
+	result.RCode = dnsRCodeName(soa.rcode)
+	result.Authoritative = soa.authoritative && soa.rcode == 0
+	for _, rr := range soa.answers {
+		if serial, ok := rr.soaSerial(); ok {
+			result.Serial = serial
+		}
+	}
+
+	if ns, _, err := dnsExchange(ctx, server, zone, dnsTypeNS, false); err == nil {
+		for _, rr := range ns.answers {
+			if target := rr.target(); rr.rtype == dnsTypeNS {
+				result.NS = append(result.NS, strings.ToLower(target))
+			}
+		}
+		sort.Strings(result.NS)
+	}
+	return result
+}
+
+func delegationFindings(ctx context.Context, resolver string, check *DelegationCheck) []Finding {
+	var findings []Finding
+	serials := make(map[uint32]bool)
+	delegated := strings.Join(check.Delegation, " ")
+
+	for _, ns := range check.Nameservers {
+		inBailiwick := strings.HasSuffix(ns.Name, "."+check.Zone) || ns.Name == check.Zone
+		if inBailiwick && len(ns.Glue) == 0 {
+			findings = append(findings, Finding{Severity: severityHigh, ID: "dns-missing-glue",
+				Message: fmt.Sprintf("%s is inside %s but the parent has no glue for it", ns.Name, check.Zone),
+				Detail:  "Without glue, resolvers cannot find the server's address without already knowing it."})
+		}
+		if inBailiwick && len(ns.Glue) > 0 {
+			if addrs, _, err := sharedLookupA(ctx, resolver, ns.Name); err == nil && len(addrs) > 0 && !sameAddresses(ipv4Only(ns.Glue), addrs) {
+				findings = append(findings, Finding{Severity: severityMedium, ID: "dns-stale-glue",
+					Message: fmt.Sprintf("Glue for %s (%s) differs from its A records (%s)", ns.Name, strings.Join(ipv4Only(ns.Glue), ", "), strings.Join(addrs, ", ")),
+					Detail:  "Resolvers use the glue from the parent, which may point at a retired server."})
+			}
+		}
+		if ns.Error != "" {
+			findings = append(findings, Finding{Severity: severityHigh, ID: "dns-lame-delegation",
+				Message: fmt.Sprintf("%s is delegated to but %s", ns.Name, ns.Error)})
+		}
+
+		for _, addr := range ns.Addresses {
+			switch {
+			case addr.Error != "":
+				findings = append(findings, Finding{Severity: severityHigh, ID: "dns-server-unreachable",
+					Message: fmt.Sprintf("%s (%s) did not answer: %s", ns.Name, addr.Address, addr.Error),
+					Detail:  "Resolvers time out on this server before trying another, slowing or failing lookups."})
+			case !addr.Authoritative:
+				findings = append(findings, Finding{Severity: severityHigh, ID: "dns-lame-delegation",
+					Message: fmt.Sprintf("%s (%s) is not authoritative for %s (%s)", ns.Name, addr.Address, check.Zone, addr.RCode),
+					Detail:  "A lame server makes resolution fail for the share of queries sent to it."})
+			default:
+				serials[addr.Serial] = true
+				if len(addr.NS) > 0 && strings.Join(addr.NS, " ") != delegated {
+					findings = append(findings, Finding{Severity: severityLow, ID: "dns-ns-mismatch",
+						Message: fmt.Sprintf("%s (%s) lists NS %s, the parent delegates to %s", ns.Name, addr.Address, strings.Join(addr.NS, ", "), strings.Join(check.Delegation, ", ")),
+						Detail:  "The parent and child NS sets should match, or servers drop out of rotation."})
+				}
+			}
+		}
+	}
+
+	if len(serials) > 1 {
+		var list []string
+		for serial := range serials {
+			list = append(list, fmt.Sprint(serial))
+		}
+		sort.Strings(list)
+		findings = append(findings, Finding{Severity: severityMedium, ID: "dns-serial-mismatch",
+			Message: fmt.Sprintf("Authoritative servers return different SOA serials: %s", strings.Join(list, ", ")),
+			Detail:  "Zone transfers are failing or lagging, so answers depend on which server is asked."})
+	}
+	return findings
+}
+
+func ipv4Only(addrs []string) []string {
+	var ipv4 []string
+	for _, addr := range addrs {
+		if net.ParseIP(addr).To4() != nil {
+			ipv4 = append(ipv4, addr)
+		}
+	}
+	return ipv4
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HeaderDetector inspects the final response headers of an analysis and
+// returns fields to add to the result, or nil when it found nothing.
+type HeaderDetector func(headers http.Header) map[string]interface{}
+
+var (
+	detectorsMu     sync.RWMutex
+	headerDetectors = map[string]HeaderDetector{}
+)
+
+// RegisterHeaderDetector adds a detector whose fields appear in the result
+// under extensions.<vendor>. Detectors are meant to be registered from an
+// init function in a file dropped into this package; registering the same
+// vendor twice panics.
+func RegisterHeaderDetector(vendor string, detector HeaderDetector) {
+	detectorsMu.Lock()
+	defer detectorsMu.Unlock()
+
+	if detector == nil {
+		panic("RegisterHeaderDetector: detector is nil")
+	}
+	if _, dup := headerDetectors[vendor]; dup {
+		panic("RegisterHeaderDetector: called twice for vendor " + vendor)
+	}
+	headerDetectors[vendor] = detector
+}
+
+// runHeaderDetectors collects the output of every registered detector. A
+// detector that panics is logged and skipped rather than failing the
+// analysis.
+func runHeaderDetectors(headers http.Header) map[string]map[string]interface{} {
+	detectorsMu.RLock()
+	defer detectorsMu.RUnlock()
+
+	vendors := make([]string, 0, len(headerDetectors))
+	for vendor := range headerDetectors {
+		vendors = append(vendors, vendor)
+	}
+	sort.Strings(vendors)
+
+	var extensions map[string]map[string]interface{}
+	for _, vendor := range vendors {
+		fields, err := runHeaderDetector(headerDetectors[vendor], headers)
+		if err != nil {
+			log.Printf("Header detector %s failed: %v", vendor, err)
+			continue
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if extensions == nil {
+			extensions = map[string]map[string]interface{}{}
+		}
+		extensions[vendor] = fields
+	}
+
+	return extensions
+}
+
+func runHeaderDetector(detector HeaderDetector, headers http.Header) (fields map[string]interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	// Detectors get a copy so they cannot change what later ones see
+	return detector(headers.Clone()), nil
+}
+
+func init() {
+	RegisterHeaderDetector("fastly", detectFastly)
+}
+
+// detectFastly reports Fastly cache nodes and hit counts.
+func detectFastly(headers http.Header) map[string]interface{} {
+	servedBy := headers.Get("X-Served-By")
+	if !strings.Contains(servedBy, "cache-") && headers.Get("Fastly-Debug-Digest") == "" {
+		return nil
+	}
+
+	fields := map[string]interface{}{
+		"servedBy": strings.Split(servedBy, ", "),
+	}
+	if hits := headers.Get("X-Cache-Hits"); hits != "" {
+		fields["cacheHits"] = hits
+	}
+	if timer := headers.Get("X-Timer"); timer != "" {
+		fields["timer"] = timer
+	}
+	return fields
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Sequential A queries used to observe rotation
+const dnsRotationQueries = 6
+
+// analyzeDNSBehavior asks the first system resolver for the name's A
+// records several times in a row and compares the answers, then queries
+// random labels to find wildcard records. The system resolver can't be
+// used for this, it sorts the addresses.
+func analyzeDNSBehavior(ctx context.Context, name string) *DNSBehavior {
+	behavior := &DNSBehavior{}
+	servers, err := systemNameservers()
+	if err != nil {
+		behavior.Error = err.Error()
+		return behavior
+	}
+	behavior.Server = servers[0]
+
+	var answers [][]string
+	for i := 0; i < dnsRotationQueries && ctx.Err() == nil; i++ {
+		addrs, _, err := lookupA(ctx, behavior.Server, name)
+		if err != nil {
+			behavior.Error = err.Error()
+			return behavior
+		}
+		answers = append(answers, addrs)
+	}
+	behavior.Answers = answers
+	behavior.Stable, behavior.Rotates = compareAnswers(answers)
+	for _, addrs := range answers {
+		behavior.Addresses = mergeAddresses(behavior.Addresses, addrs)
+	}
+
+	// A random label next to the name shows whether the name itself may
+	// come from a wildcard, one below it whether it has one for children
+	for _, probe := range wildcardProbeNames(name) {
+		addrs, rcode, err := lookupA(ctx, behavior.Server, probe)
+		result := DNSWildcardProbe{Name: probe, RCode: rcode, Addresses: addrs}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		result.Wildcard = len(addrs) > 0
+		result.SameAsName = result.Wildcard && sameAddresses(addrs, behavior.Addresses)
+		behavior.Wildcard = behavior.Wildcard || result.Wildcard
+		behavior.WildcardProbes = append(behavior.WildcardProbes, result)
+	}
+
+	behavior.Summary = summarizeDNSBehavior(behavior)
+	return behavior
+}
+
+// lookupA returns the A records of name in answer order.
+func lookupA(ctx context.Context, server, name string) ([]string, string, error) {
+	m, _, err := dnsExchange(ctx, server, name, dnsTypeA, true)
+	if err != nil {
+		return nil, "", err
+	}
+	return answerAddresses(m), dnsRCodeName(m.rcode), nil
+}
+
+// answerAddresses returns the addresses in the answer section, in order.
+func answerAddresses(m *dnsMessage) []string {
+	var addrs []string
+	for _, rr := range m.answers {
+		if ip := rr.ip(); ip != nil {
+			addrs = append(addrs, ip.String())
+		}
+	}
+	return addrs
+}
+
+// lookupCNAMEChain returns the names name is aliased to, in the order the
+// CNAME records are followed. The system resolver only gives the last.
+func lookupCNAMEChain(ctx context.Context, server, name string) ([]string, error) {
+	m, _, err := sharedExchange(ctx, server, name, dnsTypeA)
+	if err != nil {
+		return nil, err
+	}
+	var chain []string
+	current := strings.TrimSuffix(name, ".") + "."
+	for len(chain) <= len(m.answers) {
+		next := ""
+		for _, rr := range m.answers {
+			if rr.rtype == dnsTypeCNAME && strings.EqualFold(rr.name, current) {
+				next = rr.target()
+				break
+			}
+		}
+		if next == "" {
+			break
+		}
+		chain = append(chain, next)
+		current = next
+	}
+	return chain, nil
+}
+
+// wildcardProbeNames returns a random sibling of name and a random child.
+// No sibling is probed for a name directly below a TLD.
+func wildcardProbeNames(name string) []string {
+	name = strings.TrimSuffix(name, ".")
+	probes := []string{randomLabel() + "." + name}
+	if _, parent, ok := strings.Cut(name, "."); ok && strings.Contains(parent, ".") {
+		probes = append([]string{randomLabel() + "." + parent}, probes...)
+	}
+	return probes
+}
+
+// compareAnswers reports whether every answer had the same set of
+// addresses, and whether the order changed between answers.
+func compareAnswers(answers [][]string) (stable, rotates bool) {
+	stable = true
+	for i := 1; i < len(answers); i++ {
+		if !sameAddresses(answers[i], answers[0]) {
+			stable = false
+		}
+		if strings.Join(answers[i], ",") != strings.Join(answers[i-1], ",") {
+			rotates = true
+		}
+	}
+	return stable, rotates && stable
+}
+
+func sameAddresses(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
+
+func mergeAddresses(all, addrs []string) []string {
+	for _, addr := range addrs {
+		found := false
+		for _, existing := range all {
+			found = found || existing == addr
+		}
+		if !found {
+			all = append(all, addr)
+		}
+	}
+	return all
+}
+
+func summarizeDNSBehavior(b *DNSBehavior) string {
+	var parts []string
+	switch {
+	case len(b.Addresses) == 0:
+		parts = append(parts, "No A records")
+	case b.Rotates:
+		parts = append(parts, fmt.Sprintf("Round-robin: %d addresses rotate between answers", len(b.Addresses)))
+	case b.Stable && len(b.Addresses) > 1:
+		parts = append(parts, fmt.Sprintf("%d addresses in a fixed order", len(b.Addresses)))
+	case b.Stable:
+		parts = append(parts, "A single stable address")
+	default:
+		parts = append(parts, fmt.Sprintf("The answer set changes between queries, %d addresses seen, suggesting GeoDNS or load-based answers", len(b.Addresses)))
+	}
+	for _, probe := range b.WildcardProbes {
+		if probe.Wildcard {
+			parts = append(parts, fmt.Sprintf("wildcard record answers for %s", probe.Name))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long a resolver's NXDOMAIN or empty answer is reused, and most such
+// answers kept
+const (
+	dnsNegativeTTL     = 30 * time.Second
+	dnsNegativeEntries = 10000
+)
+
+// dnsShared deduplicates the recursive queries concurrent analyses send to
+// the same resolver, such as the zone and parent NS lookups of a batch of
+// subdomains, and briefly remembers names that don't exist. Positive
+// answers are not cached, the resolver does that with the proper TTLs.
+type dnsShared struct {
+	mu       sync.Mutex
+	inFlight map[string]*dnsCall
+	negative map[string]dnsNegative
+
+	hits, misses, shared int64 // Updated atomically
+}
+
+type dnsCall struct {
+	done chan struct{}
+	msg  *dnsMessage
+	rtt  float64
+	err  error
+}
+
+type dnsNegative struct {
+	msg     *dnsMessage
+	expires time.Time
+}
+
+var dnsLookups = &dnsShared{inFlight: make(map[string]*dnsCall), negative: make(map[string]dnsNegative)}
+
+// sharedExchange is dnsExchange for a recursive query to resolver, answered
+// from the negative cache or by joining an identical query in flight when
+// possible. Callers must not modify the message. Lookups that observe the
+// resolver itself, such as rotation, use dnsExchange.
+//
+// Queries are only shared between analyses with the same source and proxy.
+// The query is sent in the background on behalf of all of them, detached
+// from the cancellation of the analysis that started it, within
+// dnsExchangeTimeout. Every caller stops waiting when its own ctx is done.
+// A budget error only holds for the analysis that sent the query; it is
+// neither shared nor cached, and the others send the query again.
+func sharedExchange(ctx context.Context, resolver, name string, qtype uint16) (*dnsMessage, float64, error) {
+	key := resolver + "|" + strings.ToLower(strings.TrimSuffix(name, ".")) + "|" + strconv.Itoa(int(qtype))
+	if source := sourceFrom(ctx); source != nil && source.selected() {
+		key += "|" + source.ip.String() + "|" + source.iface
+	}
+	if proxy := proxyFrom(ctx); proxy != nil {
+		key += "|" + proxy.url.String()
+	}
+
+	s := dnsLookups
+	for {
+		s.mu.Lock()
+		if n, ok := s.negative[key]; ok && time.Now().Before(n.expires) {
+			s.mu.Unlock()
+			atomic.AddInt64(&s.hits, 1)
+			return n.msg, 0, nil
+		}
+		call, joined := s.inFlight[key]
+		if joined {
+			atomic.AddInt64(&s.shared, 1)
+		} else {
+			call = &dnsCall{done: make(chan struct{})}
+			s.inFlight[key] = call
+			atomic.AddInt64(&s.misses, 1)
+			go s.exchange(detachedContext{ctx}, key, call, resolver, name, qtype)
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-call.done:
+			if !joined || !errors.Is(call.err, errBudgetExceeded) {
+				return call.msg, call.rtt, call.err
+			}
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+}
+
+// exchange sends the query of call and caches a negative answer.
+func (s *dnsShared) exchange(ctx context.Context, key string, call *dnsCall, resolver, name string, qtype uint16) {
+	call.msg, call.rtt, call.err = dnsExchange(ctx, resolver, name, qtype, true)
+
+	s.mu.Lock()
+	delete(s.inFlight, key)
+	if call.err == nil && (call.msg.rcode == dnsRCodeNXDomain || call.msg.rcode == 0 && len(call.msg.answers) == 0) {
+		s.storeNegative(key, call.msg)
+	}
+	s.mu.Unlock()
+	close(call.done)
+}
+
+// detachedContext keeps the values of a context, such as its budget and
+// audit trail, but not its deadline or cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// storeNegative caches msg under key, first dropping expired entries when
+// the cache is full, or all of them if none has expired. Callers hold mu.
+func (s *dnsShared) storeNegative(key string, msg *dnsMessage) {
+	now := time.Now()
+	if len(s.negative) >= dnsNegativeEntries {
+		for k, n := range s.negative {
+			if now.After(n.expires) {
+				delete(s.negative, k)
+			}
+		}
+		if len(s.negative) >= dnsNegativeEntries {
+			s.negative = make(map[string]dnsNegative)
+		}
+	}
+	s.negative[key] = dnsNegative{msg: msg, expires: now.Add(dnsNegativeTTL)}
+}
+
+// stats returns the counters served at /debug/vars.
+func (s *dnsShared) stats() map[string]int64 {
+	s.mu.Lock()
+	cached := len(s.negative)
+	s.mu.Unlock()
+	return map[string]int64{
+		"negativeCacheHits":    atomic.LoadInt64(&s.hits),
+		"negativeCacheEntries": int64(cached),
+		"queriesSent":          atomic.LoadInt64(&s.misses),
+		"queriesShared":        atomic.LoadInt64(&s.shared),
+	}
+}
+
+// sharedLookupA is lookupA through sharedExchange.
+func sharedLookupA(ctx context.Context, resolver, name string) ([]string, string, error) {
+	m, _, err := sharedExchange(ctx, resolver, name, dnsTypeA)
+	if err != nil {
+		return nil, "", err
+	}
+	return answerAddresses(m), dnsRCodeName(m.rcode), nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// How long one DNS exchange may take
+const dnsExchangeTimeout = 5 * time.Second
+
+// EDNS0 payload size offered, the DNS flag day 2020 recommendation
+const dnsUDPSize = 1232
+
+// newDNSQuery builds a query for name with an EDNS0 OPT record. Recursion
+// is requested from resolvers but not from authoritative servers.
+func newDNSQuery(name string, qtype uint16, recurse bool) ([]byte, error) {
+	msg := make([]byte, 12, 512)
+	rand.Read(msg[0:2]) // ID
+	if recurse {
+		binary.BigEndian.PutUint16(msg[2:], 0x0100) // RD
+	}
+	binary.BigEndian.PutUint16(msg[4:], 1)  // QDCOUNT
+	binary.BigEndian.PutUint16(msg[10:], 1) // ARCOUNT, the OPT record
+	msg, err := appendDNSName(msg, name)
+	if err != nil {
+		return nil, err
+	}
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, 1) // Class IN
+	// OPT: root name, type, payload size in place of the class, no flags
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, dnsTypeOPT)
+	msg = binary.BigEndian.AppendUint16(msg, dnsUDPSize)
+	return append(msg, 0, 0, 0, 0, 0, 0), nil
+}
+
+// dnsExchange sends one query to server (host:port) and returns the
+// response and its round trip time, retrying over TCP when the UDP answer
+// is truncated. Unlike the system resolver it keeps the answer order and
+// talks to any server, authoritative ones included.
+func dnsExchange(ctx context.Context, server, name string, qtype uint16, recurse bool) (*dnsMessage, float64, error) {
+	query, err := newDNSQuery(name, qtype, recurse)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(withoutTargetPolicy(withoutProxy(ctx)), dnsExchangeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	reply, err := dnsRoundTrip(ctx, "udp", server, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	if reply.truncated {
+		warn(ctx, Finding{Severity: severityInfo, ID: "dns-tcp-fallback",
+			Message: fmt.Sprintf("The DNS answer for %s from %s was truncated over UDP and repeated over TCP", name, server)})
+		if reply, err = dnsRoundTrip(ctx, "tcp", server, query); err != nil {
+			return nil, 0, err
+		}
+	}
+	return reply, millisecondsSince(start), nil
+}
+
+func dnsRoundTrip(ctx context.Context, network, server string, query []byte) (*dnsMessage, error) {
+	conn, err := dialContext(ctx, &net.Dialer{}, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	id := binary.BigEndian.Uint16(query)
+	if network == "tcp" {
+		query = append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	for {
+		var reply []byte
+		if network == "tcp" {
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err != nil {
+				return nil, err
+			}
+			reply = make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(conn, reply); err != nil {
+				return nil, err
+			}
+		} else {
+			reply = make([]byte, dnsUDPSize)
+			n, err := conn.Read(reply)
+			if err != nil {
+				return nil, err
+			}
+			reply = reply[:n]
+		}
+
+		m, err := parseDNSMessage(reply)
+		if err != nil {
+			return nil, err
+		}
+		// Stray datagrams with another ID are skipped
+		if m.response && m.id == id {
+			return m, nil
+		}
+		if network == "tcp" {
+			return nil, errors.New("DNS response does not match the query")
+		}
+	}
+}
+
+// systemNameservers reads the resolvers from /etc/resolv.conf.
+func systemNameservers() ([]string, error) {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" && net.ParseIP(fields[1]) != nil {
+			servers = append(servers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("no nameservers in /etc/resolv.conf")
+	}
+	return servers, scanner.Err()
+}
+
+// randomLabel returns a label no zone is expected to have.
+func randomLabel() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("hka-%x", b)
+}
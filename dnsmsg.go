@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DNS record types the analyzer reads
+const (
+	dnsTypeA     = 1
+	dnsTypeNS    = 2
+	dnsTypeCNAME = 5
+	dnsTypeSOA   = 6
+	dnsTypeTXT   = 16
+	dnsTypeAAAA  = 28
+	dnsTypeOPT   = 41
+)
+
+var dnsTypeNames = map[uint16]string{
+	dnsTypeA: "A", dnsTypeNS: "NS", dnsTypeCNAME: "CNAME", dnsTypeSOA: "SOA",
+	dnsTypeTXT: "TXT", dnsTypeAAAA: "AAAA", dnsTypeOPT: "OPT", 12: "PTR", 15: "MX",
+	33: "SRV", 65: "HTTPS", 255: "ANY",
+}
+
+func dnsTypeName(t uint16) string {
+	if name, ok := dnsTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+var dnsRCodes = []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED"}
+
+const dnsRCodeNXDomain = 3
+
+func dnsRCodeName(rcode int) string {
+	if rcode < len(dnsRCodes) {
+		return dnsRCodes[rcode]
+	}
+	return fmt.Sprintf("RCODE %d", rcode)
+}
+
+var errDNSMessage = errors.New("malformed DNS message")
+
+// dnsMessage is the part of a DNS message the analyzer looks at.
+type dnsMessage struct {
+	id                 uint16
+	response           bool
+	authoritative      bool
+	truncated          bool
+	recursionAvailable bool
+	rcode              int
+	questions          []dnsQuestion
+	answers            []dnsRR
+	authority          []dnsRR
+	additional         []dnsRR
+	udpSize            uint16 // EDNS0 payload size, zero without an OPT record
+}
+
+type dnsQuestion struct {
+	name  string
+	qtype uint16
+}
+
+// dnsRR is a resource record. Its data stays in the message so names in it
+// can be decompressed.
+type dnsRR struct {
+	name  string
+	rtype uint16
+	class uint16
+	ttl   uint32
+	msg   []byte
+	off   int // Start of the record data in msg
+	size  int
+}
+
+// parseDNSMessage decodes the header and every section of msg.
+func parseDNSMessage(msg []byte) (*dnsMessage, error) {
+	if len(msg) < 12 {
+		return nil, errDNSMessage
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	m := &dnsMessage{
+		id:                 binary.BigEndian.Uint16(msg),
+		response:           flags&0x8000 != 0,
+		authoritative:      flags&0x0400 != 0,
+		truncated:          flags&0x0200 != 0,
+		recursionAvailable: flags&0x0080 != 0,
+		rcode:              int(flags & 0x000f),
+	}
+
+	off := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[4:])); i++ {
+		name, next, err := readDNSName(msg, off)
+		if err != nil || next+4 > len(msg) {
+			return nil, errDNSMessage
+		}
+		m.questions = append(m.questions, dnsQuestion{name: name, qtype: binary.BigEndian.Uint16(msg[next:])})
+		off = next + 4
+	}
+
+	sections := []*[]dnsRR{&m.answers, &m.authority, &m.additional}
+	for s, section := range sections {
+		for i := 0; i < int(binary.BigEndian.Uint16(msg[6+2*s:])); i++ {
+			name, next, err := readDNSName(msg, off)
+			if err != nil || next+10 > len(msg) {
+				return nil, errDNSMessage
+			}
+			rr := dnsRR{
+				name:  name,
+				rtype: binary.BigEndian.Uint16(msg[next:]),
+				class: binary.BigEndian.Uint16(msg[next+2:]),
+				ttl:   binary.BigEndian.Uint32(msg[next+4:]),
+				msg:   msg,
+				off:   next + 10,
+				size:  int(binary.BigEndian.Uint16(msg[next+8:])),
+			}
+			if rr.off+rr.size > len(msg) {
+				return nil, errDNSMessage
+			}
+			if rr.rtype == dnsTypeOPT {
+				m.udpSize = rr.class // OPT carries the payload size in CLASS
+			}
+			*section = append(*section, rr)
+			off = rr.off + rr.size
+		}
+	}
+	return m, nil
+}
+
+// readDNSName decodes the possibly compressed name at off, returning it
+// with a trailing dot and the offset just past it.
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errDNSMessage
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case length&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errDNSMessage
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+length > len(msg) {
+				return "", 0, errDNSMessage
+			}
+			labels = append(labels, string(msg[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
+
+// appendDNSName encodes name uncompressed.
+func appendDNSName(msg []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("invalid DNS name %q", name)
+			}
+			msg = append(msg, byte(len(label)))
+			msg = append(msg, label...)
+		}
+	}
+	return append(msg, 0), nil
+}
+
+// ip returns the address of an A or AAAA record.
+func (rr dnsRR) ip() net.IP {
+	if (rr.rtype == dnsTypeA && rr.size == net.IPv4len) || (rr.rtype == dnsTypeAAAA && rr.size == net.IPv6len) {
+		return net.IP(rr.msg[rr.off : rr.off+rr.size])
+	}
+	return nil
+}
+
+// target returns the name an NS or CNAME record points to.
+func (rr dnsRR) target() string {
+	if rr.rtype != dnsTypeNS && rr.rtype != dnsTypeCNAME {
+		return ""
+	}
+	name, _, err := readDNSName(rr.msg, rr.off)
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// soaSerial returns the serial of a SOA record.
+func (rr dnsRR) soaSerial() (uint32, bool) {
+	if rr.rtype != dnsTypeSOA {
+		return 0, false
+	}
+	_, off, err := readDNSName(rr.msg, rr.off) // MNAME
+	if err != nil {
+		return 0, false
+	}
+	if _, off, err = readDNSName(rr.msg, off); err != nil || off+4 > len(rr.msg) { // RNAME
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(rr.msg[off:]), true
+}
+
+// txt returns the strings of a TXT record joined together.
+func (rr dnsRR) txt() string {
+	if rr.rtype != dnsTypeTXT {
+		return ""
+	}
+	var b strings.Builder
+	for off, end := rr.off, rr.off+rr.size; off < end; {
+		length := int(rr.msg[off])
+		if off+1+length > end {
+			break
+		}
+		b.Write(rr.msg[off+1 : off+1+length])
+		off += 1 + length
+	}
+	return b.String()
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+// dnsTrace records what the Go resolver sends on the wire. The resolver
+// dials once per query attempt and server, so wrapping each connection is
+// enough to see every query's round trip time, retries, and the fallback
+// to TCP after a truncated answer.
+type dnsTrace struct {
+	start time.Time
+
+	mu      sync.Mutex
+	queries []DNSQuery
+}
+
+type dnsTraceKey struct{}
+
+// withDNSTrace makes lookups through resolverFor(ctx) record into t.
+func withDNSTrace(ctx context.Context, t *dnsTrace) context.Context {
+	t.start = time.Now()
+	return context.WithValue(ctx, dnsTraceKey{}, t)
+}
+
+// add records a query. Its attempt number counts the unanswered queries
+// for the same name and type since the last answer, from any server, so
+// separate lookups of the same name are not mistaken for retries.
+func (t *dnsTrace) add(q DNSQuery) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	q.Attempt = 1
+	for _, previous := range t.queries {
+		if previous.Name != q.Name || previous.Type != q.Type {
+			continue
+		}
+		if previous.Error != "" {
+			q.Attempt++
+		} else {
+			q.Attempt = 1
+		}
+	}
+	t.queries = append(t.queries, q)
+}
+
+// result summarizes the lookups traced so far, nil when nothing was.
+func (t *dnsTrace) result() *DNSResolution {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := &DNSResolution{DurationMs: millisecondsSince(t.start), Queries: append([]DNSQuery(nil), t.queries...)}
+	for _, q := range t.queries {
+		if q.Attempt > 1 {
+			r.Retries++
+		}
+		if q.Network == "tcp" {
+			r.TCPFallback = true
+		}
+	}
+	return r
+}
+
+// resolverFor returns the resolver for target lookups. Without a trace or
+// a selected source it is the default one. A trace keeps the host's choice
+// between the Go resolver and the C library, and only sees the queries when
+// the Go resolver is chosen, as the C library does its own dialing. A
+// selected source forces the Go resolver, whose dials go through
+// dialContext, so its queries leave from the same source as the probes.
+// Proxies only carry the probes, DNS queries go direct.
+func resolverFor(ctx context.Context) *net.Resolver {
+	trace, _ := ctx.Value(dnsTraceKey{}).(*dnsTrace)
+	s := sourceFrom(ctx)
+	bound := s != nil && s.selected()
+	if trace == nil && !bound {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: bound,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := dialContext(withoutTargetPolicy(withoutProxy(ctx)), &net.Dialer{}, network, address)
+			if trace == nil {
+				return conn, err
+			}
+			if err != nil {
+				trace.add(DNSQuery{Server: address, Network: network, Error: describeConnError(err)})
+				return nil, err
+			}
+			tc := &dnsTraceConn{Conn: conn, trace: trace, query: DNSQuery{Server: address, Network: network}}
+			// The resolver frames messages for streams unless it gets a
+			// PacketConn
+			if _, ok := conn.(net.PacketConn); ok {
+				return dnsTracePacketConn{tc}, nil
+			}
+			return tc, nil
+		},
+	}
+}
+
+// dnsTraceConn records the one query the resolver sends on it and the
+// response it reads.
+type dnsTraceConn struct {
+	net.Conn
+	trace *dnsTrace
+	query DNSQuery
+	sent  time.Time
+	buf   []byte // Stream bytes read so far
+	done  bool   // Response seen
+	once  sync.Once
+}
+
+func (c *dnsTraceConn) stream() bool {
+	return c.query.Network == "tcp"
+}
+
+func (c *dnsTraceConn) Write(p []byte) (int, error) {
+	if c.sent.IsZero() {
+		msg := p
+		if c.stream() && len(msg) >= 2 {
+			msg = msg[2:] // Length prefix
+		}
+		c.query.RequestBytes = len(msg)
+		if m, err := parseDNSMessage(msg); err == nil {
+			if len(m.questions) > 0 {
+				c.query.Name = m.questions[0].name
+				c.query.Type = dnsTypeName(m.questions[0].qtype)
+			}
+			c.query.ClientUDPSize = m.udpSize
+		}
+		c.sent = time.Now()
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *dnsTraceConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && !c.done {
+		if !c.stream() {
+			c.response(p[:n])
+		} else {
+			c.buf = append(c.buf, p[:n]...)
+			if len(c.buf) >= 2 {
+				if length := int(binary.BigEndian.Uint16(c.buf)); len(c.buf) >= 2+length {
+					c.response(c.buf[2 : 2+length])
+				}
+			}
+		}
+	}
+	return n, err
+}
+
+func (c *dnsTraceConn) response(msg []byte) {
+	m, err := parseDNSMessage(msg)
+	if err != nil || !m.response {
+		return
+	}
+	c.done = true
+	c.query.RTTMs = millisecondsSince(c.sent)
+	c.query.ResponseBytes = len(msg)
+	c.query.RCode = dnsRCodeName(m.rcode)
+	c.query.Truncated = m.truncated
+	c.query.Authoritative = m.authoritative
+	c.query.ServerUDPSize = m.udpSize
+	c.query.Answers = len(m.answers)
+}
+
+// Close records the query, as unanswered when no response was read.
+func (c *dnsTraceConn) Close() error {
+	c.once.Do(func() {
+		if !c.done {
+			c.query.Error = "no response"
+			if !c.sent.IsZero() {
+				c.query.RTTMs = millisecondsSince(c.sent)
+			}
+		}
+		c.trace.add(c.query)
+	})
+	return c.Conn.Close()
+}
+
+// dnsTracePacketConn keeps the net.PacketConn interface of UDP sockets.
+type dnsTracePacketConn struct {
+	*dnsTraceConn
+}
+
+func (c dnsTracePacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, err := c.Read(p)
+	return n, c.RemoteAddr(), err
+}
+
+func (c dnsTracePacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return c.Write(p)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// probeDowngrade sends an HTTP/1.1 request, an HTTP/1.0 request and an
+// HTTP/1.1 request without a Host header over raw connections. Old proxies
+// and load balancers show up as HTTP/1.0 answers to 1.1 requests, refusals
+// of 1.0, or a default virtual host answering without Host.
+func probeDowngrade(ctx context.Context, target *url.URL, tlsConfig *tls.Config) *DowngradeResult {
+	path := target.RequestURI()
+	host := "Host: " + target.Host + "\r\n" + markRawProbe(ctx)
+
+	result := &DowngradeResult{
+		HTTP11: rawRequest(ctx, target, tlsConfig.Clone(), "GET "+path+" HTTP/1.1\r\n"+host+"Connection: close\r\n\r\n"),
+		HTTP10: rawRequest(ctx, target, tlsConfig.Clone(), "GET "+path+" HTTP/1.0\r\n"+host+"\r\n"),
+		NoHost: rawRequest(ctx, target, tlsConfig.Clone(), "GET "+path+" HTTP/1.1\r\n"+markRawProbe(ctx)+"Connection: close\r\n\r\n"),
+	}
+
+	if r := result.HTTP11; r.Error == "" && r.Proto == "HTTP/1.0" {
+		result.Findings = append(result.Findings, "HTTP/1.1 requests are answered with HTTP/1.0, an intermediary downgrades the connection and keep-alive is off by default")
+	}
+
+	switch r := result.HTTP10; {
+	case r.Error != "":
+	case r.StatusCode == http.StatusUpgradeRequired:
+		result.Findings = append(result.Findings, fmt.Sprintf("HTTP/1.0 clients are refused with 426 Upgrade Required (Upgrade: %s)", r.Upgrade))
+	case r.StatusCode == http.StatusHTTPVersionNotSupported:
+		result.Findings = append(result.Findings, "HTTP/1.0 clients are refused with 505 HTTP Version Not Supported")
+	}
+
+	if r := result.NoHost; r.Error == "" && r.StatusCode < 400 {
+		if result.HTTP11.Error == "" && r.BodyHash != result.HTTP11.BodyHash {
+			result.Findings = append(result.Findings, "Requests without Host reach a default virtual host serving different content")
+		} else {
+			result.Findings = append(result.Findings, "Requests without Host are served instead of rejected with 400 as RFC 9112 requires")
+		}
+	}
+
+	return result
+}
+
+// rawRequest writes a hand-built request on a new connection and reads the
+// response.
+func rawRequest(ctx context.Context, target *url.URL, tlsConfig *tls.Config, request string) *RawResponse {
+	result := &RawResponse{}
+
+	conn, _, err := dialHTTP1(ctx, target, tlsConfig, &net.Dialer{})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ipProbeTimeout))
+
+	if _, err := io.WriteString(conn, request); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxProbeBodySize))
+
+	result.Proto = resp.Proto
+	result.StatusCode = resp.StatusCode
+	result.Server = resp.Header.Get("Server")
+	result.Connection = resp.Header.Get("Connection")
+	if result.Connection == "" && resp.Close {
+		result.Connection = "close" // ReadResponse strips Connection: close
+	}
+	result.Upgrade = resp.Header.Get("Upgrade")
+	result.BodyHash = truncatedSHA256(string(body))
+	return result
+}
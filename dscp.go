@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var errTOSUnsupported = errors.New("DSCP marking is not supported on this platform")
+
+// Default and most request pairs of a DSCP comparison
+const (
+	defaultDSCPSamples = 5
+	maxDSCPSamples     = 20
+)
+
+// dscpOptions asks for a latency and loss comparison of marked and
+// unmarked connections.
+type dscpOptions struct {
+	Codepoint string `json:"codepoint"`         // Name such as EF or AF41, or 0-63
+	Samples   int    `json:"samples,omitempty"` // Request pairs, default 5
+}
+
+// Standard per-hop behavior names, RFC 2474, 2597, 3246, 5865 and 8622
+var dscpNames = map[string]int{
+	"CS0": 0, "CS1": 8, "CS2": 16, "CS3": 24, "CS4": 32, "CS5": 40, "CS6": 48, "CS7": 56,
+	"AF11": 10, "AF12": 12, "AF13": 14,
+	"AF21": 18, "AF22": 20, "AF23": 22,
+	"AF31": 26, "AF32": 28, "AF33": 30,
+	"AF41": 34, "AF42": 36, "AF43": 38,
+	"EF": 46, "VA": 44, "LE": 1,
+}
+
+// parseDSCP accepts a codepoint name or number.
+func parseDSCP(codepoint string) (int, error) {
+	codepoint = strings.ToUpper(strings.TrimSpace(codepoint))
+	if value, ok := dscpNames[codepoint]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(codepoint)
+	if err != nil || value < 0 || value > 63 {
+		return 0, fmt.Errorf("invalid DSCP codepoint %q", codepoint)
+	}
+	return value, nil
+}
+
+type dscpKey struct{}
+
+// withDSCP marks every connection dialed with ctx with the codepoint.
+func withDSCP(ctx context.Context, dscp int) context.Context {
+	return context.WithValue(ctx, dscpKey{}, dscp)
+}
+
+// markDialer returns a copy of dialer that sets the TOS byte of its
+// sockets when ctx carries a codepoint.
+func markDialer(ctx context.Context, dialer *net.Dialer) *net.Dialer {
+	dscp, ok := ctx.Value(dscpKey{}).(int)
+	if !ok {
+		return dialer
+	}
+
+	d := *dialer
+	control := d.Control
+	d.Control = func(network, address string, c syscall.RawConn) error {
+		if control != nil {
+			if err := control(network, address, c); err != nil {
+				return err
+			}
+		}
+		var tosErr error
+		if err := c.Control(func(fd uintptr) {
+			// DSCP is the upper six bits, the lower two are ECN
+			tosErr = setTOS(fd, network, dscp<<2)
+		}); err != nil {
+			return err
+		}
+		return tosErr
+	}
+	return &d
+}
+
+// compareDSCP alternates unmarked and marked requests to one address of
+// the target, so both see the same path conditions, and compares their
+// timings and failures. Networks that police or remark the codepoint show
+// up as extra latency or loss on the marked side.
+func compareDSCP(ctx context.Context, target *url.URL, tlsConfig *tls.Config, opts dscpOptions) *DSCPComparison {
+	result := &DSCPComparison{Codepoint: strings.ToUpper(opts.Codepoint)}
+
+	dscp, err := parseDSCP(opts.Codepoint)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.DSCP = dscp
+
+	samples := opts.Samples
+	if samples < 1 {
+		samples = defaultDSCPSamples
+	}
+	if samples > maxDSCPSamples {
+		samples = maxDSCPSamples
+	}
+
+	// Pin one address so the comparison isn't spread across a pool
+	ip := target.Hostname()
+	if net.ParseIP(ip) == nil {
+		addrs, err := resolverFor(ctx).LookupHost(ctx, ip)
+		if err != nil || len(addrs) == 0 {
+			result.Error = fmt.Sprintf("failed to resolve %s: %v", ip, err)
+			return result
+		}
+		ip = addrs[0]
+	}
+	result.IP = ip
+
+	markedCtx := withDSCP(ctx, dscp)
+	var unmarked, marked []IPResult
+	for i := 0; i < samples && ctx.Err() == nil; i++ {
+		unmarked = append(unmarked, probeIP(ctx, target, ip, tlsConfig.Clone()))
+		sample := probeIP(markedCtx, target, ip, tlsConfig.Clone())
+		if strings.Contains(sample.Error, errTOSUnsupported.Error()) {
+			result.Error = errTOSUnsupported.Error()
+			return result
+		}
+		marked = append(marked, sample)
+	}
+
+	result.Unmarked = summarizeSamples(unmarked)
+	result.Marked = summarizeSamples(marked)
+	result.Summary = summarizeDSCP(result)
+	return result
+}
+
+// summarizeDSCP compares median time to first byte and failures.
+func summarizeDSCP(c *DSCPComparison) string {
+	failures := fmt.Sprintf("%d/%d marked and %d/%d unmarked requests failed",
+		c.Marked.Failed, c.Marked.Count, c.Unmarked.Failed, c.Unmarked.Count)
+	if c.Marked.TTFBMs == nil || c.Unmarked.TTFBMs == nil {
+		return failures
+	}
+	return fmt.Sprintf("Median TTFB %.1f ms marked %s vs %.1f ms unmarked; %s",
+		c.Marked.TTFBMs.Median, c.Codepoint, c.Unmarked.TTFBMs.Median, failures)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How long to wait for an interim 100 Continue before sending the body
+// anyway, matching common client defaults
+const expectContinueWait = 3 * time.Second
+
+// probeProtocolEdgeCases checks HTTP/1.1 features that clients and proxies
+// often get wrong: trailers, Expect: 100-continue on a chunked POST, and
+// keep-alive negotiated by an HTTP/1.0 client.
+func probeProtocolEdgeCases(ctx context.Context, target *url.URL, tlsConfig *tls.Config) *ProtocolEdgeCases {
+	result := &ProtocolEdgeCases{
+		Trailers:        probeTrailers(ctx, target, tlsConfig.Clone()),
+		ExpectContinue:  probeExpectContinue(ctx, target, tlsConfig.Clone()),
+		HTTP10KeepAlive: probeHTTP10KeepAlive(ctx, target, tlsConfig.Clone()),
+	}
+
+	if t := result.Trailers; t != nil && len(t.Received) > 0 && len(t.Declared) == 0 {
+		result.Issues = append(result.Issues, "Trailers were sent without a Trailer header announcing them")
+	}
+	if e := result.ExpectContinue; e != nil && e.Behavior == "No interim response" {
+		result.Issues = append(result.Issues, "Expect: 100-continue is ignored, clients stall until their continue timeout before sending the body")
+	}
+	if h := result.HTTP10KeepAlive; h != nil && h.Error == "" {
+		if h.Framing == "chunked" {
+			result.Issues = append(result.Issues, "Chunked response sent to an HTTP/1.0 client, which cannot decode it")
+		}
+		if h.KeepAliveOffered && h.Framing == "close-delimited" {
+			result.Issues = append(result.Issues, "Keep-alive offered to an HTTP/1.0 client on a response without Content-Length")
+		}
+		if h.KeepAliveOffered && h.ReuseAttempted && !h.Reused {
+			result.Issues = append(result.Issues, "Keep-alive offered to an HTTP/1.0 client but the connection could not be reused")
+		}
+	}
+
+	return result
+}
+
+// probeTrailers asks for trailers and reports the ones that arrive.
+func probeTrailers(ctx context.Context, target *url.URL, tlsConfig *tls.Config) *TrailerResult {
+	result := &TrailerResult{}
+
+	client := newProbeClient(tlsConfig)
+	defer client.CloseIdleConnections()
+	resp, _, err := doRequest(ctx, client, http.MethodGet, target, http.Header{"TE": {"trailers"}})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	for _, value := range resp.Header.Values("Trailer") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				result.Declared = append(result.Declared, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	// Declared trailers are present as empty keys until the body is read
+	for name, values := range resp.Trailer {
+		if len(values) > 0 {
+			result.Received = append(result.Received, name)
+		}
+	}
+	sort.Strings(result.Received)
+
+	return result
+}
+
+// probeExpectContinue sends the headers of a chunked POST with
+// Expect: 100-continue and records whether the server answers with an
+// interim 100, a final response, or nothing.
+func probeExpectContinue(ctx context.Context, target *url.URL, tlsConfig *tls.Config) *ExpectContinueResult {
+	result := &ExpectContinueResult{}
+
+	conn, _, err := dialHTTP1(ctx, target, tlsConfig, &net.Dialer{})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ipProbeTimeout))
+	br := bufio.NewReader(conn)
+
+	head := "POST " + target.RequestURI() + " HTTP/1.1\r\n" +
+		"Host: " + target.Host + "\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"Expect: 100-continue\r\n" +
+		markRawProbe(ctx) +
+		"Connection: close\r\n\r\n"
+	if _, err := io.WriteString(conn, head); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(expectContinueWait))
+	_, err = br.Peek(1)
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		result.Behavior = "No interim response"
+	case err != nil:
+		result.Error = err.Error()
+		return result
+	default:
+		conn.SetReadDeadline(time.Now().Add(ipProbeTimeout))
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.InterimMs = millisecondsSince(start)
+		if resp.StatusCode != http.StatusContinue {
+			// The server rejected or answered the request without the body
+			result.Behavior = "Final response"
+			result.StatusCode = resp.StatusCode
+			return result
+		}
+		result.Behavior = "100 Continue"
+	}
+
+	conn.SetReadDeadline(time.Now().Add(ipProbeTimeout))
+	if _, err := io.WriteString(conn, "5\r\nhello\r\n0\r\n\r\n"); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.StatusCode = resp.StatusCode
+
+	return result
+}
+
+// probeHTTP10KeepAlive sends an HTTP/1.0 request asking for keep-alive and
+// checks how the response is framed and whether the connection is reusable.
+func probeHTTP10KeepAlive(ctx context.Context, target *url.URL, tlsConfig *tls.Config) *HTTP10Result {
+	result := &HTTP10Result{}
+
+	conn, _, err := dialHTTP1(ctx, target, tlsConfig, &net.Dialer{})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	resp, err := http10Request(ctx, conn, br, target)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.ResponseProto = resp.Proto
+	result.StatusCode = resp.StatusCode
+	result.Connection = resp.Header.Get("Connection")
+	result.KeepAlive = resp.Header.Get("Keep-Alive")
+	result.Framing = responseFraming(resp)
+	result.KeepAliveOffered = !resp.Close
+
+	// A close-delimited body has already consumed the connection
+	if result.Framing == "close-delimited" {
+		return result
+	}
+
+	result.ReuseAttempted = true
+	if _, err := http10Request(ctx, conn, br, target); err != nil {
+		result.ReuseError = describeConnError(err)
+		return result
+	}
+	result.Reused = true
+
+	return result
+}
+
+// http10Request writes an HTTP/1.0 keep-alive GET and reads the full
+// response.
+func http10Request(ctx context.Context, conn net.Conn, br *bufio.Reader, target *url.URL) (*http.Response, error) {
+	conn.SetDeadline(time.Now().Add(ipProbeTimeout))
+	req := "GET " + target.RequestURI() + " HTTP/1.0\r\n" +
+		"Host: " + target.Host + "\r\n" +
+		markRawProbe(ctx) +
+		"Connection: keep-alive\r\n\r\n"
+	if _, err := io.WriteString(conn, req); err != nil {
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return nil, fmt.Errorf("reading body: %v", err)
+	}
+	resp.Body.Close()
+	return resp, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ETag layouts of common servers, matched against the value without the
+// weak prefix and quotes
+var (
+	// nginx: hex mtime in seconds, hex size
+	nginxETag = regexp.MustCompile(`^([0-9a-f]{8})-([0-9a-f]+)$`)
+	// Apache FileETag MTime Size, the 2.4 default: hex size, hex mtime in µs,
+	// plus a suffix such as -gzip from mod_deflate
+	apacheETag = regexp.MustCompile(`^([0-9a-f]+)-([0-9a-f]{12,14})(-[a-z]+)?$`)
+	// Apache FileETag INode MTime Size: inode, size, mtime
+	apacheInodeETag = regexp.MustCompile(`^([0-9a-f]+)-([0-9a-f]+)-([0-9a-f]{12,14})(-[a-z]+)?$`)
+	// IIS: hex FILETIME, then a per-server change number
+	iisETag = regexp.MustCompile(`^([0-9a-f]{14,16}):([0-9a-f]+)$`)
+	// Express and other Node servers: hex length, base64 hash
+	expressETag = regexp.MustCompile(`^([0-9a-f]+)-([A-Za-z0-9+/_-]{27})$`)
+	// Opaque digests: MD5, SHA-1 or SHA-256 in hex
+	digestETag = regexp.MustCompile(`^([0-9a-f]{32}|[0-9a-f]{40}|[0-9a-f]{64})$`)
+)
+
+// Plausible file modification times, to tell nginx mtimes from random hex
+var (
+	minPlausibleMTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	maxPlausibleMTime = time.Now().AddDate(1, 0, 0)
+)
+
+// classifyETag infers which server generated an ETag and what it reveals.
+func classifyETag(etag string) ETagInfo {
+	info := ETagInfo{ETag: etag, Format: "opaque"}
+	value := etag
+	if strings.HasPrefix(value, "W/") {
+		info.Weak = true
+		value = value[2:]
+	}
+	value = strings.Trim(value, `"`)
+	lower := strings.ToLower(value)
+
+	if m := apacheInodeETag.FindStringSubmatch(lower); m != nil {
+		info.Format, info.Backend = "inode-size-mtime", "Apache"
+		info.LeaksInode = true
+		info.MTime = formatMTime(m[3], time.Microsecond)
+	} else if m := apacheETag.FindStringSubmatch(lower); m != nil {
+		info.Format, info.Backend = "size-mtime", "Apache"
+		info.MTime = formatMTime(m[2], time.Microsecond)
+	} else if m := nginxETag.FindStringSubmatch(lower); m != nil && formatMTime(m[1], time.Second) != "" {
+		info.Format, info.Backend = "mtime-size", "nginx"
+		info.MTime = formatMTime(m[1], time.Second)
+	} else if m := iisETag.FindStringSubmatch(lower); m != nil {
+		info.Format, info.Backend = "filetime:changenumber", "IIS"
+		info.MTime = formatFileTime(m[1])
+	} else if expressETag.MatchString(value) {
+		info.Format, info.Backend = "length-hash", "Express"
+	} else if digestETag.MatchString(lower) {
+		info.Format = "content-digest"
+	}
+	info.LeaksMTime = info.MTime != ""
+
+	return info
+}
+
+// formatMTime decodes a hex timestamp counted in unit since the Unix
+// epoch, returning "" when it is not a plausible file time.
+func formatMTime(hex string, unit time.Duration) string {
+	n, err := strconv.ParseInt(hex, 16, 64)
+	if err != nil {
+		return ""
+	}
+	t := time.Unix(n, 0).UTC()
+	if unit == time.Microsecond {
+		t = time.UnixMicro(n).UTC()
+	}
+	if t.Before(minPlausibleMTime) || t.After(maxPlausibleMTime) {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// formatFileTime decodes a hex Windows FILETIME, 100ns intervals since 1601.
+func formatFileTime(hex string) string {
+	n, err := strconv.ParseUint(hex, 16, 64)
+	if err != nil {
+		return ""
+	}
+	const epochDelta = 116444736000000000 // 1601 to 1970 in 100ns
+	if n < epochDelta {
+		return ""
+	}
+	t := time.Unix(0, 0).Add(time.Duration((n-epochDelta)/10) * time.Microsecond).UTC()
+	if t.Before(minPlausibleMTime) || t.After(maxPlausibleMTime) {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// analyzeETags classifies the main response's ETag and the ones each A
+// record returned. ETags generated per server break conditional requests
+// and shared caching whenever the load balancer switches backend. It
+// returns nil when no ETag was seen.
+func analyzeETags(etag string, ips []IPResult) *ETagAnalysis {
+	analysis := &ETagAnalysis{ConsistentAcrossPool: true}
+	if etag != "" {
+		info := classifyETag(etag)
+		analysis.Main = &info
+	}
+
+	byContent := make(map[string]map[string]bool) // Body hash to ETags
+	for _, r := range ips {
+		if r.ETag == "" {
+			continue
+		}
+		info := classifyETag(r.ETag)
+		info.IP = r.IP
+		analysis.PerIP = append(analysis.PerIP, info)
+		if r.BodyHash != "" {
+			if byContent[r.BodyHash] == nil {
+				byContent[r.BodyHash] = make(map[string]bool)
+			}
+			byContent[r.BodyHash][r.ETag] = true
+		}
+	}
+	if analysis.Main == nil && len(analysis.PerIP) == 0 {
+		return nil
+	}
+
+	for _, etags := range byContent {
+		if len(etags) > 1 {
+			analysis.ConsistentAcrossPool = false
+		}
+	}
+
+	sample := analysis.Main
+	if sample == nil {
+		sample = &analysis.PerIP[0]
+	}
+	if !analysis.ConsistentAcrossPool {
+		detail := "Clients revalidating with If-None-Match get a full response whenever they reach another server, and shared caches store one copy per backend."
+		if sample.Backend != "" {
+			detail += fmt.Sprintf(" %s's %s ETags depend on per-server file metadata; configure a content based ETag or align it across the pool.", sample.Backend, sample.Format)
+		}
+		analysis.Findings = append(analysis.Findings, Finding{Severity: severityMedium, ID: "etag-differs-across-pool", Header: "ETag",
+			Message: "Identical content is served with different ETags by different IPs", Detail: detail})
+	}
+	if sample.LeaksInode {
+		analysis.Findings = append(analysis.Findings, Finding{Severity: severityLow, ID: "etag-leaks-inode", Header: "ETag",
+			Message: "The ETag includes the file's inode number",
+			Detail:  "Inode numbers differ between servers, breaking caching across a pool, and disclose file system details. Use FileETag MTime Size."})
+	}
+	if sample.LeaksMTime {
+		analysis.Findings = append(analysis.Findings, Finding{Severity: severityInfo, ID: "etag-leaks-mtime", Header: "ETag",
+			Message: fmt.Sprintf("The ETag encodes the file modification time (%s)", sample.MTime),
+			Detail:  "Deployments that touch files on each server produce different ETags for the same content."})
+	}
+
+	return analysis
+}
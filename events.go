@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Analysis lifecycle events
+const (
+	eventAnalysisStarted   = "AnalysisStarted"
+	eventStageCompleted    = "StageCompleted"
+	eventAnalysisCompleted = "AnalysisCompleted"
+	eventAnalysisFailed    = "AnalysisFailed"
+	eventAssertionsFailed  = "AssertionsFailed" // Before AnalysisCompleted, with the result
+	eventRequestCompleted  = "RequestCompleted" // An API request finished, with its audit entry
+)
+
+// Event is published on the event bus. Fields that don't apply to the type
+// are left empty.
+type Event struct {
+	Type       string      `json:"type"`
+	Time       time.Time   `json:"time"`
+	RunID      string      `json:"runId,omitempty"`
+	Target     string      `json:"target,omitempty"`
+	Stage      string      `json:"stage,omitempty"`
+	DurationMs float64     `json:"durationMs,omitempty"` // Of the stage or the whole analysis
+	Error      string      `json:"error,omitempty"`
+	Result     *response   `json:"-"` // AnalysisCompleted and AssertionsFailed
+	Audit      *AuditEntry `json:"-"` // RequestCompleted
+}
+
+// eventBus delivers events to every subscriber, synchronously and in the
+// order they subscribed, so cross-cutting features such as the audit log
+// hook into analyses without the analysis code knowing about them.
+// Subscribers must return quickly and not publish themselves.
+type eventBus struct {
+	mu          sync.RWMutex
+	subscribers []func(Event)
+}
+
+var events = &eventBus{}
+
+func (b *eventBus) subscribe(fn func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, fn)
+}
+
+// publish stamps e with the time and the run ID of ctx and delivers it.
+func (b *eventBus) publish(ctx context.Context, e Event) {
+	e.Time = time.Now().UTC()
+	if e.RunID == "" {
+		e.RunID = runID(ctx)
+	}
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+	for _, fn := range subscribers {
+		fn(e)
+	}
+}
+
+// stageClock publishes StageCompleted for the stages of one analysis, each
+// timed from the end of the previous one.
+type stageClock struct {
+	ctx    context.Context
+	target string
+	last   time.Time
+}
+
+func newStageClock(ctx context.Context, target string) *stageClock {
+	return &stageClock{ctx: ctx, target: target, last: time.Now()}
+}
+
+func (c *stageClock) done(stage string) {
+	events.publish(c.ctx, Event{Type: eventStageCompleted, Target: c.target, Stage: stage, DurationMs: millisecondsSince(c.last)})
+	c.last = time.Now()
+}
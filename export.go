@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportS3 is nil unless -s3-bucket is set.
+var exportS3 *s3Client
+
+// Columns of the CSV export, one row per run
+var exportColumns = []string{"time", "runId", "target", "status", "durationMs", "error", "requestDurationMs", "ttfbMs", "keepAliveTimeout", "tlsVersion", "resultKey"}
+
+// encodeRuns writes runs as CSV with a header row, or as JSON lines.
+func encodeRuns(runs []RunRecord, format string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	switch format {
+	case "", "csv":
+		w := csv.NewWriter(&buf)
+		w.Write(exportColumns)
+		for _, r := range runs {
+			w.Write([]string{
+				r.Time.UTC().Format(time.RFC3339Nano),
+				r.RunID,
+				r.Target,
+				r.Status,
+				strconv.FormatFloat(r.DurationMs, 'f', -1, 64),
+				r.Error,
+				strconv.FormatInt(r.RequestDurationMs, 10),
+				strconv.FormatFloat(r.TTFBMs, 'f', -1, 64),
+				r.KeepAliveTimeout,
+				r.TLSVersion,
+				r.ResultKey,
+			})
+		}
+		w.Flush()
+		return buf.Bytes(), "text/csv", w.Error()
+	case "jsonl":
+		enc := json.NewEncoder(&buf)
+		for _, r := range runs {
+			if err := enc.Encode(r); err != nil {
+				return nil, "", err
+			}
+		}
+		return buf.Bytes(), "application/x-ndjson", nil
+	}
+	return nil, "", fmt.Errorf("unknown format %q, use csv or jsonl", format)
+}
+
+// exportName checks a file or object name given to the export. Names are
+// relative, without . or .. elements, so a file stays inside -export-dir.
+func exportName(name string) error {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, "\\") {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid name %q", name)
+		}
+	}
+	return nil
+}
+
+// historyExportHandler exports the recorded runs of ?target=, or of all
+// targets, over the time range of parseTimeRange. GET downloads them; POST
+// writes them to ?destination=file, in -export-dir, or ?destination=s3, to
+// -s3-bucket, under ?name=.
+func historyExportHandler(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		http.Error(w, "Run history is disabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	from, to, err := parseTimeRange(r, time.Now().UTC())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := q.Get("format")
+	runs := history.between(strings.TrimSpace(q.Get("target")), from, to)
+	data, contentType, err := encodeRuns(runs, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if format == "" {
+		format = "csv"
+	}
+
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"runs-%s.%s\"", from.Format("20060102T150405Z"), format))
+		w.Write(data)
+		return
+	}
+
+	name := q.Get("name")
+	if name == "" {
+		name = fmt.Sprintf("runs-%s-%s.%s", from.Format("20060102T150405Z"), to.Format("20060102T150405Z"), format)
+	}
+	if err := exportName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := ExportResult{Destination: q.Get("destination"), Runs: len(runs), Bytes: len(data)}
+	switch result.Destination {
+	case "file":
+		if cfg.ExportDir == "" {
+			http.Error(w, "Exports to files are disabled, see -export-dir", http.StatusBadRequest)
+			return
+		}
+		path := filepath.Join(cfg.ExportDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := os.WriteFile(path, data, 0640); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result.Location = path
+	case "s3":
+		if exportS3 == nil {
+			http.Error(w, "Exports to S3 are disabled, see -s3-bucket", http.StatusBadRequest)
+			return
+		}
+		if err := exportS3.put(r.Context(), name, data, contentType); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		result.Location = exportS3.objectURL(name)
+	default:
+		http.Error(w, "destination must be file or s3", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
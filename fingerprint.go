@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// tlsProfile is a ClientHello variant the analyzer can present. Only knobs
+// exposed by crypto/tls are available, so these change the fingerprint but
+// cannot impersonate a real browser (that needs a uTLS style library).
+type tlsProfile struct {
+	description string
+	http2       bool // Offer h2 in ALPN and speak it when negotiated
+	apply       func(*tls.Config)
+}
+
+var tlsProfiles = map[string]tlsProfile{
+	"default": {
+		description: "Go crypto/tls defaults",
+		apply:       func(*tls.Config) {},
+	},
+	"tls12": {
+		description: "TLS 1.2 only ClientHello",
+		apply: func(c *tls.Config) {
+			c.MaxVersion = tls.VersionTLS12
+		},
+	},
+	"h2": {
+		description: "Browser-like ALPN offering h2 and http/1.1",
+		http2:       true,
+		apply: func(c *tls.Config) {
+			c.NextProtos = []string{"h2", "http/1.1"}
+		},
+	},
+}
+
+// Order in which profiles are compared, default first
+var tlsProfileOrder = []string{"default", "tls12", "h2"}
+
+// profileName returns the TLS profile a request uses
+func profileName(opts analysisRequest) string {
+	if opts.TLSProfile == "" {
+		return "default"
+	}
+	return opts.TLSProfile
+}
+
+// clientHelloRecorder keeps the first bytes written on a connection, which
+// for TLS is the ClientHello record.
+type clientHelloRecorder struct {
+	mu    sync.Mutex
+	hello []byte
+}
+
+// wrap returns a conn that copies its first write into the recorder.
+func (rec *clientHelloRecorder) wrap(conn net.Conn) net.Conn {
+	return &recordingConn{Conn: conn, rec: rec}
+}
+
+func (rec *clientHelloRecorder) fingerprint(profile string) *TLSFingerprint {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.hello == nil {
+		return nil
+	}
+	fp, err := fingerprintClientHello(rec.hello)
+	if err != nil {
+		return nil
+	}
+	fp.Profile = profile
+	return fp
+}
+
+type recordingConn struct {
+	net.Conn
+	rec  *clientHelloRecorder
+	once sync.Once
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.once.Do(func() {
+		c.rec.mu.Lock()
+		if c.rec.hello == nil {
+			c.rec.hello = append([]byte(nil), b...)
+		}
+		c.rec.mu.Unlock()
+	})
+	return c.Conn.Write(b)
+}
+
+// clientHello holds the ClientHello fields used by JA3 and JA4
+type clientHello struct {
+	version        uint16
+	ciphers        []uint16
+	extensions     []uint16
+	curves         []uint16
+	pointFormats   []uint8
+	sigAlgs        []uint16
+	alpn           []string
+	versions       []uint16 // supported_versions extension
+	serverNamePres bool
+}
+
+// fingerprintClientHello computes the JA3 and JA4 fingerprints of a raw
+// TLS record carrying a ClientHello.
+func fingerprintClientHello(record []byte) (*TLSFingerprint, error) {
+	hello, err := parseClientHello(record)
+	if err != nil {
+		return nil, err
+	}
+
+	ja3 := strings.Join([]string{
+		strconv.Itoa(int(hello.version)),
+		joinUint16(hello.ciphers, "-", "%d"),
+		joinUint16(hello.extensions, "-", "%d"),
+		joinUint16(hello.curves, "-", "%d"),
+		joinUint8(hello.pointFormats, "-"),
+	}, ",")
+	sum := md5.Sum([]byte(ja3))
+
+	return &TLSFingerprint{
+		JA3:     ja3,
+		JA3Hash: hex.EncodeToString(sum[:]),
+		JA4:     ja4(hello),
+	}, nil
+}
+
+// ja4 follows the FoxIO JA4 TLS client specification.
+func ja4(hello *clientHello) string {
+	version := hello.version
+	for _, v := range hello.versions {
+		if v > version {
+			version = v
+		}
+	}
+	versionCode := map[uint16]string{
+		tls.VersionTLS13: "13",
+		tls.VersionTLS12: "12",
+		tls.VersionTLS11: "11",
+		tls.VersionTLS10: "10",
+		0x0300:           "s3",
+	}[version]
+	if versionCode == "" {
+		versionCode = "00"
+	}
+
+	sni := "i"
+	if hello.serverNamePres {
+		sni = "d"
+	}
+
+	alpn := "00"
+	if len(hello.alpn) > 0 && len(hello.alpn[0]) > 0 {
+		first := hello.alpn[0]
+		alpn = string(first[0]) + string(first[len(first)-1])
+	}
+
+	a := fmt.Sprintf("t%s%s%02d%02d%s", versionCode, sni,
+		minInt(len(hello.ciphers), 99), minInt(len(hello.extensions), 99), alpn)
+
+	ciphers := append([]uint16(nil), hello.ciphers...)
+	sort.Slice(ciphers, func(i, j int) bool { return ciphers[i] < ciphers[j] })
+
+	// SNI and ALPN are counted above but left out of the extension hash
+	var extensions []uint16
+	for _, ext := range hello.extensions {
+		if ext != 0x0000 && ext != 0x0010 {
+			extensions = append(extensions, ext)
+		}
+	}
+	sort.Slice(extensions, func(i, j int) bool { return extensions[i] < extensions[j] })
+
+	c := joinUint16(extensions, ",", "%04x")
+	if len(hello.sigAlgs) > 0 {
+		c += "_" + joinUint16(hello.sigAlgs, ",", "%04x")
+	}
+
+	return a + "_" + truncatedSHA256(joinUint16(ciphers, ",", "%04x")) + "_" + truncatedSHA256(c)
+}
+
+func parseClientHello(record []byte) (*clientHello, error) {
+	// Record header: type(1) version(2) length(2), then handshake type(1) length(3)
+	if len(record) < 9 || record[0] != 0x16 || record[5] != 0x01 {
+		return nil, fmt.Errorf("not a TLS ClientHello")
+	}
+	body := record[9:]
+
+	r := &byteReader{buf: body}
+	hello := &clientHello{version: r.uint16()}
+	r.skip(32)             // Random
+	r.skip(int(r.uint8())) // Session ID
+	ciphers := r.bytes(int(r.uint16()))
+	r.skip(int(r.uint8())) // Compression methods
+	extensions := r.bytes(int(r.uint16()))
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	for i := 0; i+1 < len(ciphers); i += 2 {
+		if cs := binary.BigEndian.Uint16(ciphers[i:]); !isGREASE(cs) {
+			hello.ciphers = append(hello.ciphers, cs)
+		}
+	}
+
+	er := &byteReader{buf: extensions}
+	for len(er.buf) > 0 && er.err == nil {
+		extType := er.uint16()
+		data := &byteReader{buf: er.bytes(int(er.uint16()))}
+		if isGREASE(extType) {
+			continue
+		}
+		hello.extensions = append(hello.extensions, extType)
+
+		switch extType {
+		case 0x0000: // server_name
+			hello.serverNamePres = true
+		case 0x000a: // supported_groups
+			list := data.bytes(int(data.uint16()))
+			for i := 0; i+1 < len(list); i += 2 {
+				if g := binary.BigEndian.Uint16(list[i:]); !isGREASE(g) {
+					hello.curves = append(hello.curves, g)
+				}
+			}
+		case 0x000b: // ec_point_formats
+			hello.pointFormats = data.bytes(int(data.uint8()))
+		case 0x000d: // signature_algorithms
+			list := data.bytes(int(data.uint16()))
+			for i := 0; i+1 < len(list); i += 2 {
+				hello.sigAlgs = append(hello.sigAlgs, binary.BigEndian.Uint16(list[i:]))
+			}
+		case 0x0010: // application_layer_protocol_negotiation
+			list := &byteReader{buf: data.bytes(int(data.uint16()))}
+			for len(list.buf) > 0 && list.err == nil {
+				hello.alpn = append(hello.alpn, string(list.bytes(int(list.uint8()))))
+			}
+		case 0x002b: // supported_versions
+			list := data.bytes(int(data.uint8()))
+			for i := 0; i+1 < len(list); i += 2 {
+				if v := binary.BigEndian.Uint16(list[i:]); !isGREASE(v) {
+					hello.versions = append(hello.versions, v)
+				}
+			}
+		}
+	}
+	if er.err != nil {
+		return nil, er.err
+	}
+
+	return hello, nil
+}
+
+// isGREASE reports whether v is one of the reserved RFC 8701 values
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// byteReader reads big endian values, remembering the first short read
+type byteReader struct {
+	buf []byte
+	err error
+}
+
+func (r *byteReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.buf) {
+		r.err = io.ErrUnexpectedEOF
+		r.buf = nil
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *byteReader) skip(n int) {
+	r.bytes(n)
+}
+
+func (r *byteReader) uint8() uint8 {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *byteReader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func joinUint16(values []uint16, sep, format string) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf(format, v)
+	}
+	return strings.Join(parts, sep)
+}
+
+func joinUint8(values []uint8, sep string) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(parts, sep)
+}
+
+func truncatedSHA256(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// compareFingerprints requests the target once per TLS profile and reports
+// whether the status or keep-alive related headers change with the
+// ClientHello the analyzer presents.
+func compareFingerprints(ctx context.Context, target string, opts analysisRequest, rec *clientAuthRecorder) []FingerprintResult {
+	var results []FingerprintResult
+
+	for _, name := range tlsProfileOrder {
+		profileOpts := opts
+		profileOpts.TLSProfile = name
+		tlsConfig := newTLSConfig(profileOpts, rec)
+
+		result := FingerprintResult{Profile: name}
+		hello := &clientHelloRecorder{}
+		dialer := &net.Dialer{}
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:   tlsConfig,
+				ForceAttemptHTTP2: tlsProfiles[name].http2,
+				DisableKeepAlives: true,
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					conn, err := dialContext(ctx, dialer, network, addr)
+					if err != nil {
+						return nil, err
+					}
+					return hello.wrap(conn), nil
+				},
+			},
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		markProbe(req)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		result.Fingerprint = hello.fingerprint(name)
+		result.StatusCode = resp.StatusCode
+		result.Protocol = resp.Proto
+		result.KeepAlive = resp.Header.Get("Keep-Alive")
+		result.Connection = resp.Header.Get("Connection")
+		result.Server = resp.Header.Get("Server")
+		result.CacheStatus = resp.Header.Get("X-Cache")
+
+		results = append(results, result)
+	}
+
+	// Flag profiles that were served differently from the default one
+	if len(results) > 0 && results[0].Error == "" {
+		base := results[0]
+		for i := range results[1:] {
+			r := &results[i+1]
+			r.Differs = r.Error == "" && (r.StatusCode != base.StatusCode ||
+				r.Server != base.Server || r.CacheStatus != base.CacheStatus)
+
+			// HTTP/2 has no connection headers, only compare them on the same protocol
+			if r.Protocol == base.Protocol {
+				r.Differs = r.Differs || r.KeepAlive != base.KeepAlive || r.Connection != base.Connection
+			}
+		}
+	}
+
+	return results
+}
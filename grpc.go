@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reflection services tried, newest first
+var grpcReflectionServices = []string{
+	"grpc.reflection.v1.ServerReflection",
+	"grpc.reflection.v1alpha.ServerReflection",
+}
+
+var grpcHealthStatuses = []string{"UNKNOWN", "SERVING", "NOT_SERVING", "SERVICE_UNKNOWN"}
+
+var grpcStatusNames = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND",
+	"ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION",
+	"ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+const grpcUnimplemented = 12
+
+// Most services listed from reflection
+const maxGRPCServices = 100
+
+// grpcCall is the outcome of one unary or half-closed streaming call.
+type grpcCall struct {
+	grpc     bool     // The answer was gRPC, whatever its status
+	status   int      // grpc-status, -1 when missing
+	message  string   // grpc-message
+	messages [][]byte // Response messages
+}
+
+// probeGRPC checks whether an https target is a gRPC service by calling
+// the standard health service and listing services through server
+// reflection. headers are those of the analysis response, which hint at
+// gRPC when they carry its content type or a grpc-status.
+func probeGRPC(ctx context.Context, target *url.URL, tlsConfig *tls.Config, headers http.Header) *GRPCInfo {
+	info := &GRPCInfo{
+		Suspected: strings.HasPrefix(headers.Get("Content-Type"), "application/grpc") || headers.Get("Grpc-Status") != "",
+	}
+	if target.Scheme != "https" {
+		info.Error = "gRPC is only probed on https targets"
+		return info
+	}
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig:   tlsConfig,
+			DialContext:       auditDial,
+			ForceAttemptHTTP2: true,
+		},
+	}
+
+	health, err := callGRPC(ctx, client, target, "grpc.health.v1.Health/Check", nil)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.IsGRPC = health.grpc
+	switch {
+	case !health.grpc:
+		return info
+	case health.status == 0 && len(health.messages) > 0:
+		info.Health = grpcHealthStatuses[0]
+		if status, ok := protoVarint(health.messages[0], 1); ok && int(status) < len(grpcHealthStatuses) {
+			info.Health = grpcHealthStatuses[status]
+		}
+	default:
+		info.Health = grpcStatusName(health.status)
+	}
+
+	// ServerReflectionRequest with list_services (field 7) set
+	request := protoAppendBytes(nil, 7, []byte{})
+	for _, service := range grpcReflectionServices {
+		call, err := callGRPC(ctx, client, target, service+"/ServerReflectionInfo", request)
+		if err != nil {
+			info.Error = err.Error()
+			return info
+		}
+		if call.status == grpcUnimplemented {
+			continue
+		}
+		if call.status != 0 {
+			info.ReflectionError = grpcStatusName(call.status) + ": " + call.message
+			return info
+		}
+		info.Reflection = service
+		info.Services = reflectionServices(call.messages)
+		return info
+	}
+	return info
+}
+
+// callGRPC posts one message to method and reads the response messages
+// and trailers. A nil message sends an empty one.
+func callGRPC(ctx context.Context, client *http.Client, target *url.URL, method string, message []byte) (*grpcCall, error) {
+	body := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(message)))
+	body = append(body, message...)
+
+	u := *target
+	u.Path, u.RawQuery = "/"+method, ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	markProbe(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		return nil, errors.New("the server did not negotiate h2")
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBodySize))
+	if err != nil {
+		return nil, err
+	}
+
+	call := &grpcCall{status: -1}
+	call.grpc = strings.HasPrefix(resp.Header.Get("Content-Type"), "application/grpc")
+	// Errors without a body come as trailers-only responses in the headers
+	for _, h := range []http.Header{resp.Trailer, resp.Header} {
+		if s := h.Get("Grpc-Status"); s != "" && call.status < 0 {
+			call.grpc = true
+			call.status, _ = strconv.Atoi(s)
+			call.message = h.Get("Grpc-Message")
+		}
+	}
+	for len(data) >= 5 {
+		n := int(binary.BigEndian.Uint32(data[1:5]))
+		if n > len(data)-5 {
+			return nil, fmt.Errorf("truncated gRPC message from %s", method)
+		}
+		call.messages = append(call.messages, data[5:5+n])
+		data = data[5+n:]
+	}
+	return call, nil
+}
+
+// reflectionServices returns the service names of ServerReflectionResponse
+// messages: list_services_response (field 6) holding ServiceResponse
+// entries (field 1) with a name (field 1).
+func reflectionServices(messages [][]byte) []string {
+	var services []string
+	for _, message := range messages {
+		for _, list := range protoBytes(message, 6) {
+			for _, service := range protoBytes(list, 1) {
+				for _, name := range protoBytes(service, 1) {
+					if len(services) < maxGRPCServices {
+						services = append(services, string(name))
+					}
+				}
+			}
+		}
+	}
+	return services
+}
+
+func grpcStatusName(code int) string {
+	if code >= 0 && code < len(grpcStatusNames) {
+		return grpcStatusNames[code]
+	}
+	return strconv.Itoa(code)
+}
+
+// protoAppendBytes appends a length-delimited field.
+func protoAppendBytes(b []byte, field int, value []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// protoBytes returns the values of a length-delimited field, skipping the
+// others. Malformed input ends the scan.
+func protoBytes(msg []byte, field int) [][]byte {
+	var values [][]byte
+	protoScan(msg, func(f int, wireType uint64, varint uint64, value []byte) {
+		if f == field && wireType == 2 {
+			values = append(values, value)
+		}
+	})
+	return values
+}
+
+// protoVarint returns the last value of a varint field.
+func protoVarint(msg []byte, field int) (uint64, bool) {
+	var found bool
+	var result uint64
+	protoScan(msg, func(f int, wireType uint64, varint uint64, value []byte) {
+		if f == field && wireType == 0 {
+			result, found = varint, true
+		}
+	})
+	return result, found
+}
+
+func protoScan(msg []byte, visit func(field int, wireType, varint uint64, value []byte)) {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return
+		}
+		msg = msg[n:]
+		field, wireType := int(key>>3), key&7
+		switch wireType {
+		case 0:
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return
+			}
+			visit(field, wireType, v, nil)
+			msg = msg[n:]
+		case 1, 5:
+			size := 8
+			if wireType == 5 {
+				size = 4
+			}
+			if len(msg) < size {
+				return
+			}
+			msg = msg[size:]
+		case 2:
+			length, n := binary.Uvarint(msg)
+			if n <= 0 || length > uint64(len(msg)-n) {
+				return
+			}
+			visit(field, wireType, 0, msg[n:n+int(length)])
+			msg = msg[n+int(length):]
+		default:
+			return
+		}
+	}
+}
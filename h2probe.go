@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// HTTP/2 frame types and flags, RFC 9113 section 6
+const (
+	h2FrameHeaders   = 0x1
+	h2FrameRSTStream = 0x3
+	h2FrameSettings  = 0x4
+	h2FramePing      = 0x6
+	h2FrameGoAway    = 0x7
+
+	h2FlagAck        = 0x1
+	h2FlagEndHeaders = 0x4
+)
+
+const h2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// Largest advertised stream limit the probe tries to exceed, and how long
+// it waits for the streams to be answered or refused
+const (
+	maxH2StreamTest = 100
+	h2StreamWait    = 5 * time.Second
+)
+
+// Largest frame read; the default SETTINGS_MAX_FRAME_SIZE is 16384
+const maxH2FrameSize = 1 << 20
+
+var h2SettingNames = map[uint16]string{
+	0x1: "HEADER_TABLE_SIZE",
+	0x2: "ENABLE_PUSH",
+	0x3: "MAX_CONCURRENT_STREAMS",
+	0x4: "INITIAL_WINDOW_SIZE",
+	0x5: "MAX_FRAME_SIZE",
+	0x6: "MAX_HEADER_LIST_SIZE",
+	0x8: "ENABLE_CONNECT_PROTOCOL",
+	0x9: "NO_RFC7540_PRIORITIES",
+}
+
+var h2ErrorNames = []string{
+	"NO_ERROR", "PROTOCOL_ERROR", "INTERNAL_ERROR", "FLOW_CONTROL_ERROR",
+	"SETTINGS_TIMEOUT", "STREAM_CLOSED", "FRAME_SIZE_ERROR", "REFUSED_STREAM",
+	"CANCEL", "COMPRESSION_ERROR", "CONNECT_ERROR", "ENHANCE_YOUR_CALM",
+	"INADEQUATE_SECURITY", "HTTP_1_1_REQUIRED",
+}
+
+const h2RefusedStream = 0x7
+const h2Cancel = 0x8
+
+type h2Frame struct {
+	typ     byte
+	flags   byte
+	stream  uint32
+	payload []byte
+}
+
+// h2Conn is an HTTP/2 connection driven frame by frame, which the
+// net/http client doesn't allow.
+type h2Conn struct {
+	conn        net.Conn
+	raw         *closeRecorder
+	br          *bufio.Reader
+	serverPings int
+}
+
+func (c *h2Conn) writeFrame(typ, flags byte, stream uint32, payload []byte) error {
+	header := make([]byte, 9, 9+len(payload))
+	header[0], header[1], header[2] = byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload))
+	header[3], header[4] = typ, flags
+	binary.BigEndian.PutUint32(header[5:], stream&0x7fffffff)
+	_, err := c.conn.Write(append(header, payload...))
+	return err
+}
+
+// readFrame returns the next frame, acknowledging the server's SETTINGS
+// and PINGs along the way as the protocol requires.
+func (c *h2Conn) readFrame() (*h2Frame, error) {
+	var header [9]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return nil, err
+	}
+	length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+	if length > maxH2FrameSize {
+		return nil, fmt.Errorf("HTTP/2 frame of %d bytes", length)
+	}
+	f := &h2Frame{typ: header[3], flags: header[4], stream: binary.BigEndian.Uint32(header[5:]) & 0x7fffffff, payload: make([]byte, length)}
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case f.typ == h2FrameSettings && f.flags&h2FlagAck == 0:
+		return f, c.writeFrame(h2FrameSettings, h2FlagAck, 0, nil)
+	case f.typ == h2FramePing && f.flags&h2FlagAck == 0:
+		c.serverPings++
+		return f, c.writeFrame(h2FramePing, h2FlagAck, 0, f.payload)
+	}
+	return f, nil
+}
+
+// probeHTTP2 opens an h2 connection and checks what the HTTP/1.1
+// Keep-Alive header can't describe: the server's SETTINGS, whether it
+// answers a PING, whether it refuses streams beyond its advertised
+// MAX_CONCURRENT_STREAMS, and how long it keeps the idle connection before
+// sending GOAWAY or closing it.
+func probeHTTP2(ctx context.Context, target *url.URL, tlsConfig *tls.Config, maxWait time.Duration) *HTTP2Result {
+	result := &HTTP2Result{}
+	if target.Scheme != "https" {
+		result.Error = "HTTP/2 is only probed on https targets"
+		return result
+	}
+
+	tcpConn, err := dialContext(ctx, &net.Dialer{}, "tcp", hostPort(target))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	raw := &closeRecorder{Conn: tcpConn}
+	tlsConfig.ServerName = target.Hostname()
+	tlsConfig.NextProtos = []string{"h2"}
+	tlsConn := tls.Client(raw, tlsConfig)
+	defer tlsConn.Close()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if tlsConn.ConnectionState().NegotiatedProtocol != "h2" {
+		result.Error = "The server did not negotiate h2"
+		return result
+	}
+	c := &h2Conn{conn: tlsConn, raw: raw, br: bufio.NewReader(tlsConn)}
+	defer func() { result.ServerPings = c.serverPings }()
+
+	// Unblock reads if the analysis is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			tlsConn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	tlsConn.SetDeadline(time.Now().Add(ipProbeTimeout))
+	noPush := []byte{0, 0x2, 0, 0, 0, 0}
+	if _, err := io.WriteString(tlsConn, h2Preface); err == nil {
+		err = c.writeFrame(h2FrameSettings, 0, 0, noPush)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	// The server's connection preface is a SETTINGS frame
+	f, err := c.readFrame()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if f.typ != h2FrameSettings {
+		result.Error = fmt.Sprintf("HTTP/2 connection began with frame type %d instead of SETTINGS", f.typ)
+		return result
+	}
+	result.Settings = parseH2Settings(f.payload)
+
+	if !c.ping(result) || !c.testStreamLimit(ctx, target, result) {
+		return result
+	}
+	c.waitForGoAway(maxWait, result)
+	return result
+}
+
+// ping sends a PING and times the acknowledgement. It returns false when
+// the connection ended.
+func (c *h2Conn) ping(result *HTTP2Result) bool {
+	payload := []byte("h2probe!")
+	start := time.Now()
+	if err := c.writeFrame(h2FramePing, 0, 0, payload); err != nil {
+		result.Error = err.Error()
+		return false
+	}
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return true // Not answered, the connection may still be usable
+			}
+			result.Error = err.Error()
+			return false
+		}
+		switch {
+		case f.typ == h2FrameGoAway:
+			result.GoAway = parseH2GoAway(f.payload, "ping", time.Since(start))
+			return false
+		case f.typ == h2FramePing && f.flags&h2FlagAck != 0 && bytes.Equal(f.payload, payload):
+			result.PingAnswered = true
+			result.PingRTTMs = millisecondsSince(start)
+			return true
+		}
+	}
+}
+
+// testStreamLimit opens one stream more than MAX_CONCURRENT_STREAMS allows
+// and counts the streams answered and refused. The streams are GETs whose
+// request side is left open, so they all stay active until cancelled. It
+// returns false when the connection ended.
+func (c *h2Conn) testStreamLimit(ctx context.Context, target *url.URL, result *HTTP2Result) bool {
+	limit, ok := result.Settings["MAX_CONCURRENT_STREAMS"]
+	if !ok || limit > maxH2StreamTest {
+		return true
+	}
+	streams := &HTTP2StreamLimit{Advertised: limit}
+	result.Streams = streams
+
+	status := make(map[uint32]string)
+	for i := 0; i <= int(limit); i++ {
+		id := uint32(2*i + 1)
+		if b := budgetFrom(ctx); b != nil && b.check() != nil {
+			break
+		}
+		if err := c.writeFrame(h2FrameHeaders, h2FlagEndHeaders, id, h2RequestHeaders(ctx, target)); err != nil {
+			result.Error = err.Error()
+			return false
+		}
+		status[id] = ""
+		streams.Opened++
+	}
+
+	start := time.Now()
+	c.conn.SetReadDeadline(start.Add(h2StreamWait))
+	for pending := len(status); pending > 0; {
+		f, err := c.readFrame()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			result.Error = err.Error()
+			return false
+		}
+		if f.typ == h2FrameGoAway {
+			result.GoAway = parseH2GoAway(f.payload, "streams", time.Since(start))
+			streams.Enforced = streams.Opened > int(limit)
+			return false
+		}
+		if s, ok := status[f.stream]; !ok || s != "" {
+			continue
+		}
+		switch f.typ {
+		case h2FrameHeaders:
+			status[f.stream] = "answered"
+			streams.Answered++
+			pending--
+		case h2FrameRSTStream:
+			status[f.stream] = "reset"
+			if len(f.payload) == 4 && binary.BigEndian.Uint32(f.payload) == h2RefusedStream {
+				streams.Refused++
+			} else {
+				streams.Reset++
+			}
+			pending--
+		}
+	}
+	streams.Enforced = streams.Refused+streams.Reset > 0 && streams.Answered <= int(limit)
+
+	for id, s := range status {
+		if s != "reset" {
+			if err := c.writeFrame(h2FrameRSTStream, 0, id, []byte{0, 0, 0, h2Cancel}); err != nil {
+				result.Error = err.Error()
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// waitForGoAway leaves the connection idle until the server sends GOAWAY,
+// closes it, or maxWait elapses. Frames still arriving for the cancelled
+// streams don't count as activity.
+func (c *h2Conn) waitForGoAway(maxWait time.Duration, result *HTTP2Result) {
+	idleFrom := time.Now()
+	result.WaitedSeconds = maxWait.Seconds()
+	c.conn.SetDeadline(idleFrom.Add(maxWait))
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			var netErr net.Error
+			rawErr := c.raw.readErr()
+			switch {
+			case errors.Is(rawErr, io.EOF), errors.Is(err, io.EOF):
+				result.CloseType = "FIN"
+			case errors.Is(rawErr, syscall.ECONNRESET):
+				result.CloseType = "RST"
+			case errors.As(err, &netErr) && netErr.Timeout():
+				result.CloseType = "None"
+				return
+			default:
+				result.Error = err.Error()
+				return
+			}
+			result.ClosedAfterSeconds = float64(time.Since(idleFrom).Milliseconds()) / 1000
+			return
+		}
+		if f.typ == h2FrameGoAway {
+			result.GoAway = parseH2GoAway(f.payload, "idle", time.Since(idleFrom))
+			return
+		}
+	}
+}
+
+// h2RequestHeaders HPACK-encodes a GET for target as literal fields
+// without indexing or Huffman coding, which every decoder accepts.
+func h2RequestHeaders(ctx context.Context, target *url.URL) []byte {
+	fields := [][2]string{
+		{":method", "GET"},
+		{":scheme", "https"},
+		{":authority", target.Host},
+		{":path", target.RequestURI()},
+	}
+	if line := markRawProbe(ctx); line != "" {
+		name, value, _ := strings.Cut(strings.TrimSpace(line), ":")
+		fields = append(fields, [2]string{strings.ToLower(name), strings.TrimSpace(value)})
+	}
+
+	var block []byte
+	for _, field := range fields {
+		block = append(block, 0)
+		for _, s := range field {
+			block = appendHPACKInt(block, 7, uint64(len(s)))
+			block = append(block, s...)
+		}
+	}
+	return block
+}
+
+// appendHPACKInt encodes n with an N-bit prefix, RFC 7541 section 5.1.
+func appendHPACKInt(b []byte, prefix uint, n uint64) []byte {
+	max := uint64(1)<<prefix - 1
+	if n < max {
+		return append(b, byte(n))
+	}
+	b = append(b, byte(max))
+	for n -= max; n >= 128; n >>= 7 {
+		b = append(b, byte(n&0x7f|0x80))
+	}
+	return append(b, byte(n))
+}
+
+func parseH2Settings(payload []byte) map[string]uint32 {
+	settings := make(map[string]uint32)
+	for i := 0; i+6 <= len(payload); i += 6 {
+		id := binary.BigEndian.Uint16(payload[i:])
+		name, ok := h2SettingNames[id]
+		if !ok {
+			name = fmt.Sprintf("0x%x", id)
+		}
+		settings[name] = binary.BigEndian.Uint32(payload[i+2:])
+	}
+	return settings
+}
+
+func parseH2GoAway(payload []byte, during string, after time.Duration) *HTTP2GoAway {
+	g := &HTTP2GoAway{During: during, AfterSeconds: float64(after.Milliseconds()) / 1000}
+	if len(payload) < 8 {
+		return g
+	}
+	g.LastStreamID = binary.BigEndian.Uint32(payload) & 0x7fffffff
+	g.ErrorCode = h2ErrorName(binary.BigEndian.Uint32(payload[4:]))
+	g.Debug = string(payload[8:])
+	return g
+}
+
+func h2ErrorName(code uint32) string {
+	if int(code) < len(h2ErrorNames) {
+		return h2ErrorNames[code]
+	}
+	return fmt.Sprintf("0x%x", code)
+}
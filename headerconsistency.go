@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lintHeaderConsistency flags security headers that contradict each other,
+// where browsers of different ages would then enforce different things,
+// and recommends the modern configuration. values holds the raw header
+// values by canonical name.
+func lintHeaderConsistency(values map[string][]string) []Finding {
+	var findings []Finding
+
+	var policies []*cspPolicy
+	for _, value := range values["Content-Security-Policy"] {
+		policies = append(policies, parseCSP(value, "header")...)
+	}
+	findings = append(findings, lintFraming(values["X-Frame-Options"], policies, values["Content-Security-Policy-Report-Only"])...)
+
+	if xss := values["X-Xss-Protection"]; len(xss) > 0 && len(policies) > 0 && strings.TrimSpace(xss[0]) != "0" {
+		findings = append(findings, Finding{Severity: severityLow, ID: "x-xss-protection-with-csp", Header: "X-Xss-Protection",
+			Message: fmt.Sprintf("X-XSS-Protection: %s is sent along with a Content Security Policy", xss[0]),
+			Detail:  "The policy is the supported protection against script injection; the XSS auditor, where it remains, can be abused to block legitimate scripts. Send X-XSS-Protection: 0 or omit it."})
+	}
+
+	if hsts := values["Strict-Transport-Security"]; len(hsts) > 1 {
+		for _, v := range hsts[1:] {
+			if !strings.EqualFold(strings.TrimSpace(v), strings.TrimSpace(hsts[0])) {
+				findings = append(findings, Finding{Severity: severityMedium, ID: "conflicting-hsts", Header: "Strict-Transport-Security",
+					Message: fmt.Sprintf("Strict-Transport-Security is sent with different values (%s)", strings.Join(hsts, " | ")),
+					Detail:  "Browsers only honor the first header, so the policy depends on the order intermediaries emit them in. Send one."})
+				break
+			}
+		}
+	}
+
+	if len(values["Feature-Policy"]) > 0 && len(values["Permissions-Policy"]) > 0 {
+		findings = append(findings, Finding{Severity: severityInfo, ID: "feature-policy-with-permissions-policy", Header: "Feature-Policy",
+			Message: "Feature-Policy is sent along with Permissions-Policy",
+			Detail:  "Browsers that support Permissions-Policy ignore Feature-Policy, so differences between them are silent. Keep only Permissions-Policy."})
+	}
+
+	for _, p := range policies {
+		_, upgrade := p.directives["upgrade-insecure-requests"]
+		_, block := p.directives["block-all-mixed-content"]
+		if upgrade && block {
+			findings = append(findings, Finding{Severity: severityInfo, ID: "block-all-mixed-content-with-upgrade", Header: "Content-Security-Policy",
+				Message: "The policy has both upgrade-insecure-requests and block-all-mixed-content",
+				Detail:  "block-all-mixed-content is deprecated and has no effect once requests are upgraded; remove it."})
+		}
+	}
+	return findings
+}
+
+// lintFraming compares X-Frame-Options with the frame-ancestors of the
+// enforced policies. Browsers supporting frame-ancestors ignore
+// X-Frame-Options when it is present, older ones only read
+// X-Frame-Options.
+func lintFraming(xfo []string, policies []*cspPolicy, reportOnly []string) []Finding {
+	var findings []Finding
+	const canonical = "Send frame-ancestors 'self' (or 'none') in Content-Security-Policy, with X-Frame-Options: SAMEORIGIN (or DENY) for older browsers."
+
+	var ancestors []string
+	hasAncestors := false
+	for _, p := range policies {
+		if sources, ok := p.directives["frame-ancestors"]; ok {
+			ancestors, hasAncestors = lowerAll(sources), true
+			break
+		}
+	}
+
+	// Repeated headers are combined, and the combination is invalid
+	// unless every value is the same
+	option := ""
+	for i, v := range xfo {
+		v = strings.ToUpper(strings.TrimSpace(v))
+		if i > 0 && v != option {
+			findings = append(findings, Finding{Severity: severityMedium, ID: "conflicting-x-frame-options", Header: "X-Frame-Options",
+				Message: fmt.Sprintf("X-Frame-Options is sent with different values (%s)", strings.Join(xfo, ", ")),
+				Detail:  "Browsers treat conflicting values as invalid and do not restrict framing. " + canonical})
+			option = "INVALID"
+			break
+		}
+		option = v
+	}
+
+	switch {
+	case option == "" || option == "INVALID":
+	case strings.HasPrefix(option, "ALLOW-FROM"):
+		severity := severityMedium
+		if hasAncestors {
+			severity = severityLow
+		}
+		findings = append(findings, Finding{Severity: severity, ID: "x-frame-options-allow-from", Header: "X-Frame-Options",
+			Message: "X-Frame-Options: ALLOW-FROM is not supported by current browsers",
+			Detail:  "Without frame-ancestors, browsers ignore it and allow framing by any site. List the allowed sites in frame-ancestors instead."})
+	case option != "DENY" && option != "SAMEORIGIN":
+		findings = append(findings, Finding{Severity: severityMedium, ID: "invalid-x-frame-options", Header: "X-Frame-Options",
+			Message: fmt.Sprintf("X-Frame-Options has the invalid value %q", xfo[0]),
+			Detail:  "Browsers ignore it and allow framing. " + canonical})
+	case hasAncestors:
+		findings = append(findings, compareFraming(option, ancestors)...)
+	}
+
+	if !hasAncestors {
+		for _, value := range reportOnly {
+			for _, p := range parseCSP(value, "report-only") {
+				if _, ok := p.directives["frame-ancestors"]; ok {
+					findings = append(findings, Finding{Severity: severityLow, ID: "frame-ancestors-report-only", Header: "Content-Security-Policy-Report-Only",
+						Message: "frame-ancestors is only in the report-only policy",
+						Detail:  "Framing is reported but not prevented by the policy. " + canonical})
+				}
+			}
+		}
+	}
+	switch {
+	case len(xfo) == 0 && !hasAncestors:
+		findings = append(findings, Finding{Severity: severityLow, ID: "no-framing-protection", Header: "X-Frame-Options",
+			Message: "Neither X-Frame-Options nor frame-ancestors restricts who can frame the page",
+			Detail:  "Any site can embed the page, enabling clickjacking. " + canonical})
+	case len(xfo) == 0:
+		findings = append(findings, Finding{Severity: severityInfo, ID: "frame-ancestors-without-x-frame-options", Header: "X-Frame-Options",
+			Message: "frame-ancestors is set without X-Frame-Options",
+			Detail:  "Browsers without frame-ancestors support allow framing. Add the matching X-Frame-Options value."})
+	}
+	return findings
+}
+
+// compareFraming reports where an X-Frame-Options value and a
+// frame-ancestors source list disagree.
+func compareFraming(option string, ancestors []string) []Finding {
+	none := len(ancestors) == 0 || len(ancestors) == 1 && ancestors[0] == "'none'"
+	selfOnly := len(ancestors) == 1 && ancestors[0] == "'self'"
+	switch {
+	case option == "DENY" && none, option == "SAMEORIGIN" && selfOnly:
+		return nil
+	case option == "DENY":
+		return []Finding{{Severity: severityMedium, ID: "x-frame-options-frame-ancestors-conflict", Header: "X-Frame-Options",
+			Message: fmt.Sprintf("X-Frame-Options: DENY forbids all framing, but frame-ancestors allows %s", strings.Join(ancestors, " ")),
+			Detail:  "Current browsers follow frame-ancestors and allow it, older ones deny it. Make X-Frame-Options match, or frame-ancestors 'none'."}}
+	case none:
+		return []Finding{{Severity: severityLow, ID: "x-frame-options-frame-ancestors-conflict", Header: "X-Frame-Options",
+			Message: "X-Frame-Options: SAMEORIGIN allows same-origin framing, but frame-ancestors is 'none'",
+			Detail:  "Current browsers follow frame-ancestors and forbid all framing, older ones allow same-origin frames. Send X-Frame-Options: DENY."}}
+	default:
+		return []Finding{{Severity: severityMedium, ID: "x-frame-options-frame-ancestors-conflict", Header: "X-Frame-Options",
+			Message: fmt.Sprintf("X-Frame-Options: SAMEORIGIN only allows the same origin, but frame-ancestors allows %s", strings.Join(ancestors, " ")),
+			Detail:  "Current browsers follow frame-ancestors, older ones only allow same-origin frames, so embedding works in some browsers only. X-Frame-Options cannot express other origins; keep SAMEORIGIN for older browsers deliberately or drop it."}}
+	}
+}
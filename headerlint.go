@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Finding severities, lowest to highest
+const (
+	severityInfo   = "info"
+	severityLow    = "low"
+	severityMedium = "medium"
+	severityHigh   = "high"
+)
+
+// Largest response header block the linter reads
+const maxHeaderBlockSize = 64 * 1024
+
+// Proxies commonly buffer response headers in 4 or 8KB
+const headerBlockWarnSize = 8 * 1024
+const headerValueWarnSize = 4 * 1024
+
+// rawHeader is one header line exactly as received.
+type rawHeader struct {
+	name  string
+	value string
+	line  int
+}
+
+// Obsolete response headers and why they should go
+var obsoleteHeaders = map[string]Finding{
+	"P3p": {Severity: severityLow, ID: "obsolete-p3p",
+		Detail: "P3P compact policies are ignored by every current browser."},
+	"X-Xss-Protection": {Severity: severityLow, ID: "obsolete-x-xss-protection",
+		Detail: "Browsers removed the XSS auditor, and where it remains it can introduce cross-site leaks. Omit the header or send 0 and rely on Content-Security-Policy."},
+	"Public-Key-Pins": {Severity: severityMedium, ID: "obsolete-hpkp",
+		Detail: "HTTP Public Key Pinning was removed from browsers; a stale pin only risks locking out clients that still honor it."},
+	"Expect-Ct": {Severity: severityInfo, ID: "obsolete-expect-ct",
+		Detail: "Certificate Transparency is enforced by browsers, Expect-CT is deprecated."},
+	"Feature-Policy": {Severity: severityInfo, ID: "obsolete-feature-policy",
+		Detail: "Feature-Policy was replaced by Permissions-Policy."},
+	"X-Content-Security-Policy": {Severity: severityLow, ID: "obsolete-prefixed-csp",
+		Detail: "Prefixed CSP headers are only read by long unsupported browsers, use Content-Security-Policy."},
+	"X-Webkit-Csp": {Severity: severityLow, ID: "obsolete-prefixed-csp",
+		Detail: "Prefixed CSP headers are only read by long unsupported browsers, use Content-Security-Policy."},
+	"Pragma": {Severity: severityInfo, ID: "obsolete-pragma",
+		Detail: "Pragma is an HTTP/1.0 request directive; in responses Cache-Control is what caches follow."},
+}
+
+// lintHeaders fetches the target's response headers over a raw connection,
+// so duplicates and malformed lines survive, and reports hygiene problems.
+func lintHeaders(ctx context.Context, target *url.URL, tlsConfig *tls.Config) []Finding {
+	headers, err := fetchRawHeaders(ctx, target, tlsConfig)
+	if err != nil {
+		return []Finding{{Severity: severityInfo, ID: "lint-failed", Message: "Header lint could not fetch the response", Detail: err.Error()}}
+	}
+	return lintRawHeaders(headers)
+}
+
+// fetchRawHeaders sends a GET and returns the response header lines in
+// the order received, leaving folded lines marked with a leading space.
+func fetchRawHeaders(ctx context.Context, target *url.URL, tlsConfig *tls.Config) ([]rawHeader, error) {
+	conn, _, err := dialHTTP1(ctx, target, tlsConfig, &net.Dialer{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ipProbeTimeout))
+
+	request := "GET " + target.RequestURI() + " HTTP/1.1\r\nHost: " + target.Host + "\r\n" + markRawProbe(ctx) + "Connection: close\r\n\r\n"
+	if _, err := io.WriteString(conn, request); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(io.LimitReader(conn, maxHeaderBlockSize))
+	if _, err := br.ReadString('\n'); err != nil { // Status line
+		return nil, err
+	}
+
+	var headers []rawHeader
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("reading headers: %v", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return headers, nil
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			headers = append(headers, rawHeader{name: " ", value: line, line: n})
+			continue
+		}
+		name, value, _ := strings.Cut(line, ":")
+		headers = append(headers, rawHeader{name: name, value: strings.TrimSpace(value), line: n})
+	}
+}
+
+func lintRawHeaders(headers []rawHeader) []Finding {
+	var findings []Finding
+	values := make(map[string][]string)
+	total := 0
+
+	for _, h := range headers {
+		total += len(h.name) + len(h.value) + 4
+		if h.name == " " {
+			findings = append(findings, Finding{Severity: severityMedium, ID: "obs-fold",
+				Message: fmt.Sprintf("Header line %d is folded onto the previous one", h.line),
+				Detail:  "Line folding is obsolete (RFC 9112 section 5.2); clients may reject the response or parse the continuation as a new header."})
+			continue
+		}
+		name := canonicalName(h.name)
+		values[name] = append(values[name], h.value)
+
+		if len(h.value) > headerValueWarnSize {
+			findings = append(findings, Finding{Severity: severityLow, ID: "oversized-header", Header: name,
+				Message: fmt.Sprintf("%s is %d bytes long", name, len(h.value)),
+				Detail:  "Single headers over 4KB are often truncated or rejected by proxies."})
+		}
+		if bad := badHeaderByte(h.value); bad != "" {
+			severity := severityMedium
+			if bad == "control" {
+				severity = severityHigh
+			}
+			findings = append(findings, Finding{Severity: severity, ID: "non-ascii-header", Header: name,
+				Message: fmt.Sprintf("%s contains %s characters", name, bad),
+				Detail:  "Header values should be visible ASCII; other bytes are decoded differently by clients and intermediaries, and control characters can enable response splitting."})
+		}
+		if f, ok := obsoleteHeaders[name]; ok {
+			f.Header = name
+			f.Message = fmt.Sprintf("Obsolete header %s is sent", h.name)
+			findings = append(findings, f)
+		}
+	}
+
+	if total > headerBlockWarnSize {
+		findings = append(findings, Finding{Severity: severityMedium, ID: "oversized-header-block",
+			Message: fmt.Sprintf("The response headers total %d bytes", total),
+			Detail:  "Many reverse proxies buffer response headers in 4 or 8KB by default and fail the request with a 502 when they do not fit."})
+	}
+
+	if cl := values["Content-Length"]; len(cl) > 1 {
+		severity, detail := severityMedium, "Repeated Content-Length headers are tolerated only when identical, and some clients reject them anyway."
+		for _, v := range cl[1:] {
+			if v != cl[0] {
+				severity, detail = severityHigh, "Conflicting Content-Length values let a client and an intermediary disagree on where the body ends, the basis of response smuggling."
+			}
+		}
+		findings = append(findings, Finding{Severity: severity, ID: "duplicate-content-length", Header: "Content-Length",
+			Message: fmt.Sprintf("Content-Length is sent %d times (%s)", len(cl), strings.Join(cl, ", ")), Detail: detail})
+	}
+	if len(values["Content-Length"]) > 0 && len(values["Transfer-Encoding"]) > 0 {
+		findings = append(findings, Finding{Severity: severityHigh, ID: "content-length-with-transfer-encoding", Header: "Content-Length",
+			Message: "Both Content-Length and Transfer-Encoding are sent",
+			Detail:  "RFC 9112 forbids sending both; intermediaries that pick different ones can be desynchronized."})
+	}
+
+	tokens := make(map[string]bool)
+	for _, v := range values["Connection"] {
+		for _, token := range strings.Split(v, ",") {
+			tokens[strings.ToLower(strings.TrimSpace(token))] = true
+		}
+	}
+	if tokens["close"] && tokens["keep-alive"] {
+		findings = append(findings, Finding{Severity: severityMedium, ID: "conflicting-connection", Header: "Connection",
+			Message: "Connection asks to both close and keep alive the connection",
+			Detail:  "Clients resolve the conflict differently, so some reuse a connection the server is about to close."})
+	}
+	if tokens["close"] && len(values["Keep-Alive"]) > 0 {
+		findings = append(findings, Finding{Severity: severityLow, ID: "keep-alive-with-close", Header: "Keep-Alive",
+			Message: "Keep-Alive parameters are sent on a response that closes the connection",
+			Detail:  "The parameters have no effect and suggest a proxy and the origin disagree about persistence."})
+	}
+	if len(values["Connection"]) > 1 {
+		findings = append(findings, Finding{Severity: severityLow, ID: "duplicate-connection", Header: "Connection",
+			Message: fmt.Sprintf("Connection is sent %d times", len(values["Connection"])),
+			Detail:  "Usually a proxy appending its own header instead of replacing the origin's."})
+	}
+
+	return append(findings, lintHeaderConsistency(values)...)
+}
+
+// canonicalName canonicalizes a header name, leaving invalid names as sent.
+func canonicalName(name string) string {
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f {
+			return name
+		}
+	}
+	return textproto.CanonicalMIMEHeaderKey(name)
+}
+
+// badHeaderByte returns "control" or "non-ASCII" for a value containing
+// such bytes, or "" when the value is clean. Tabs are allowed.
+func badHeaderByte(value string) string {
+	kind := ""
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\t':
+		case c < ' ' || c == 0x7f:
+			return "control"
+		case c >= 0x80:
+			kind = "non-ASCII"
+		}
+	}
+	return kind
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+// benchHeaders is a typical response header block: security and caching
+// headers, a CSP with several sources, and a few problems to report.
+var benchHeaders = []rawHeader{
+	{name: "Date", value: "Fri, 16 Oct 2026 12:00:00 GMT", line: 2},
+	{name: "Content-Type", value: "text/html; charset=utf-8", line: 3},
+	{name: "Content-Length", value: "48213", line: 4},
+	{name: "Connection", value: "keep-alive", line: 5},
+	{name: "Keep-Alive", value: "timeout=5, max=100", line: 6},
+	{name: "Cache-Control", value: "public, max-age=300", line: 7},
+	{name: "ETag", value: `"5f3a-1c2b3d4e5f6a7"`, line: 8},
+	{name: "Server", value: "nginx/1.24.0", line: 9},
+	{name: "X-Powered-By", value: "PHP/8.2.1", line: 10},
+	{name: "Strict-Transport-Security", value: "max-age=31536000; includeSubDomains; preload", line: 11},
+	{name: "Content-Security-Policy", value: "default-src 'self'; script-src 'self' https://cdn.example.net 'nonce-r4nd0m'; style-src 'self' 'unsafe-inline'; img-src * data:; frame-ancestors 'none'; report-uri /csp", line: 12},
+	{name: "X-Frame-Options", value: "SAMEORIGIN", line: 13},
+	{name: "X-Content-Type-Options", value: "nosniff", line: 14},
+	{name: "Referrer-Policy", value: "strict-origin-when-cross-origin", line: 15},
+	{name: "Permissions-Policy", value: "geolocation=(), camera=(), microphone=()", line: 16},
+	{name: "Set-Cookie", value: "session=abc123; Path=/; Secure; HttpOnly; SameSite=Lax", line: 17},
+	{name: "Vary", value: "Accept-Encoding", line: 18},
+	{name: "P3P", value: `CP="NOI DSP COR"`, line: 19},
+}
+
+// BenchmarkLintRawHeaders covers the header analysis of one response,
+// including the consistency checks. Budget: 30µs and 100 allocs per
+// response.
+func BenchmarkLintRawHeaders(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		lintRawHeaders(benchHeaders)
+	}
+}
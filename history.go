@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Run outcomes
+const (
+	runPassed = "pass"  // Completed, and every assertion passed
+	runFailed = "fail"  // Completed with a failing assertion
+	runError  = "error" // The analysis failed
+)
+
+// Expired lines a history file may hold before it is rewritten with only
+// the retained runs, unless they outnumber those
+const historyCompactLines = 1000
+
+// How often runs are pruned when no analysis adds one
+const historyPruneInterval = time.Hour
+
+// runHistory keeps the outcome of every analysis for the retention period,
+// and at most maxRuns per target when set, optionally appending each to a
+// JSON lines file that is read back at startup, so availability survives
+// restarts. The file is rewritten without expired runs as they pile up.
+type runHistory struct {
+	mu        sync.Mutex
+	runs      []RunRecord // Ordered by time
+	retention time.Duration
+	maxRuns   int
+	path      string
+	file      *os.File
+	stale     int // Lines of the file for runs already pruned
+}
+
+// history is nil unless run history is enabled with -history.
+var history *runHistory
+
+func newRunHistory(retention time.Duration, maxRuns int, path string) (*runHistory, error) {
+	h := &runHistory{retention: retention, maxRuns: maxRuns}
+	if path == "" {
+		return h, nil
+	}
+	if err := h.load(path); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	h.path, h.file = path, f
+	if h.stale > 0 {
+		if err := h.compact(); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// close flushes the history file to disk and stops writing it.
+func (h *runHistory) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file == nil {
+		return
+	}
+	if err := h.file.Sync(); err != nil {
+		log.Printf("Failed to sync run history: %v", err)
+	}
+	h.file.Close()
+	h.file = nil
+}
+
+// load reads the runs of a history file still within retention.
+func (h *runHistory) load(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var run RunRecord
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			h.stale++ // A line cut short by a crash
+			continue
+		}
+		h.runs = append(h.runs, run)
+	}
+	sort.SliceStable(h.runs, func(i, j int) bool { return h.runs[i].Time.Before(h.runs[j].Time) })
+	h.stale += h.prune()
+	return scanner.Err()
+}
+
+// record turns analysis events into runs.
+func (h *runHistory) record(e Event) {
+	switch e.Type {
+	case eventAnalysisFailed:
+		h.add(RunRecord{Time: e.Time, RunID: e.RunID, Target: e.Target, Status: runError, DurationMs: e.DurationMs, Error: e.Error})
+	case eventAnalysisCompleted:
+		if e.Result == nil {
+			return
+		}
+		r := e.Result
+		run := RunRecord{Time: e.Time, RunID: e.RunID, Target: e.Target, Status: runPassed, DurationMs: e.DurationMs,
+			RequestDurationMs: r.RequestDuration, KeepAliveTimeout: r.KeepAliveTimeout, TLSVersion: r.TLSVersion}
+		if results != nil {
+			run.ResultKey = resultKey(e)
+		}
+		if ttfb, ok := ttfbPercentile(r, 50); ok {
+			run.TTFBMs, _ = strconv.ParseFloat(ttfb, 64)
+		}
+		if r.Assertions != nil && r.Assertions.Status == "fail" {
+			run.Status = runFailed
+			for _, a := range r.Assertions.Results {
+				if !a.Passed {
+					run.Error = "assertion failed: " + a.Assertion
+					break
+				}
+			}
+		}
+		h.add(run)
+	}
+}
+
+func (h *runHistory) add(run RunRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.runs = append(h.runs, run)
+	if h.file != nil {
+		line, _ := json.Marshal(run)
+		if _, err := h.file.Write(append(line, '\n')); err != nil {
+			log.Printf("Failed to write run history: %v", err)
+		}
+	}
+	h.prune()
+}
+
+// pruneJob prunes the history every historyPruneInterval until ctx is
+// done, so expired runs leave the file while no analyses are recorded.
+func (h *runHistory) pruneJob(ctx context.Context) {
+	ticker := time.NewTicker(historyPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.mu.Lock()
+			h.prune()
+			h.mu.Unlock()
+		}
+	}
+}
+
+// prune drops runs older than the retention period and the oldest runs of
+// targets over maxRuns, compacting the file once enough of it has expired,
+// and returns how many it dropped. Callers hold mu.
+func (h *runHistory) prune() int {
+	cutoff := time.Now().Add(-h.retention)
+	i := 0
+	for i < len(h.runs) && h.runs[i].Time.Before(cutoff) {
+		i++
+	}
+	kept := h.runs[i:]
+	if h.maxRuns > 0 && len(kept) > h.maxRuns {
+		kept = h.limitRuns(kept)
+	}
+	i = len(h.runs) - len(kept)
+	if i == 0 {
+		return 0
+	}
+	h.runs = append([]RunRecord(nil), kept...)
+	if h.file != nil {
+		h.stale += i
+		if h.stale >= historyCompactLines || h.stale > len(h.runs) {
+			if err := h.compact(); err != nil {
+				log.Printf("Failed to compact run history: %v", err)
+			}
+		}
+	}
+	return i
+}
+
+// limitRuns returns runs without the oldest runs of targets that have
+// more than maxRuns, in a new slice when any are dropped.
+func (h *runHistory) limitRuns(runs []RunRecord) []RunRecord {
+	counts := make(map[string]int)
+	over := false
+	for _, r := range runs {
+		key := strings.ToLower(r.Target)
+		counts[key]++
+		over = over || counts[key] > h.maxRuns
+	}
+	if !over {
+		return runs
+	}
+	limited := make([]RunRecord, 0, len(runs))
+	for _, r := range runs {
+		key := strings.ToLower(r.Target)
+		if counts[key] > h.maxRuns {
+			counts[key]--
+			continue
+		}
+		limited = append(limited, r)
+	}
+	return limited
+}
+
+// compact replaces the history file with one holding only the retained
+// runs, written to a temporary file and renamed over it so a crash leaves
+// either the old file or the new one. Callers hold mu.
+func (h *runHistory) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), filepath.Base(h.path)+".*")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, run := range h.runs {
+		if err = enc.Encode(run); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), h.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// Appends must go to the new file
+	h.file.Close()
+	h.file, err = os.OpenFile(h.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		h.file = nil
+		return fmt.Errorf("reopening %s, no longer writing it: %v", h.path, err)
+	}
+	h.stale = 0
+	return nil
+}
+
+// between returns the runs of target, or of every target when it is
+// empty, from from up to but not including to.
+func (h *runHistory) between(target string, from, to time.Time) []RunRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.prune()
+
+	runs := []RunRecord{}
+	for _, r := range h.runs {
+		if (target == "" || strings.EqualFold(r.Target, target)) && !r.Time.Before(from) && r.Time.Before(to) {
+			runs = append(runs, r)
+		}
+	}
+	return runs
+}
+
+// last returns the latest run of target before t.
+func (h *runHistory) last(target string, t time.Time) (RunRecord, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := len(h.runs) - 1; i >= 0; i-- {
+		if r := h.runs[i]; strings.EqualFold(r.Target, target) && r.Time.Before(t) {
+			return r, true
+		}
+	}
+	return RunRecord{}, false
+}
+
+// computeSLA derives availability from the runs of one target. The target
+// is taken to stay in the state of its last run until the next one; an
+// outage lasts from its first unsuccessful run to the next successful one.
+// previous is the last run before from, if any, which decides the state at
+// the start of the window.
+func computeSLA(target string, from, to time.Time, previous *RunRecord, runs []RunRecord) *SLAReport {
+	report := &SLAReport{Target: target, From: from, To: to, Runs: len(runs), Outages: []Outage{}}
+
+	start := from
+	var outage *Outage
+	if previous != nil && previous.Status != runPassed {
+		outage = &Outage{Start: from, Error: previous.Error}
+	} else if previous == nil && len(runs) > 0 {
+		start = runs[0].Time // Nothing is known before the first run
+	}
+	if previous == nil && len(runs) == 0 {
+		return report
+	}
+
+	var downtime, repair time.Duration
+	repaired := 0
+	for _, r := range runs {
+		if r.Status != runPassed {
+			report.FailedRuns++
+			if outage == nil {
+				outage = &Outage{Start: r.Time, Error: r.Error}
+			}
+			continue
+		}
+		if outage != nil {
+			end := r.Time
+			outage.End = &end
+			outage.DurationSeconds = r.Time.Sub(outage.Start).Seconds()
+			downtime += r.Time.Sub(outage.Start)
+			repair += r.Time.Sub(outage.Start)
+			repaired++
+			report.Outages = append(report.Outages, *outage)
+			outage = nil
+		}
+	}
+	if outage != nil {
+		outage.Ongoing = true
+		outage.DurationSeconds = to.Sub(outage.Start).Seconds()
+		downtime += to.Sub(outage.Start)
+		report.Outages = append(report.Outages, *outage)
+	}
+
+	observed := to.Sub(start)
+	report.ObservedSeconds = observed.Seconds()
+	report.DowntimeSeconds = downtime.Seconds()
+	if observed > 0 {
+		report.Availability = 100 * (1 - downtime.Seconds()/observed.Seconds())
+	}
+	if len(runs) > 0 {
+		report.RunSuccessRate = 100 * float64(len(runs)-report.FailedRuns) / float64(len(runs))
+	}
+	if repaired > 0 {
+		report.MTTRSeconds = repair.Seconds() / float64(repaired)
+	}
+	return report
+}
+
+// parseTimeRange reads ?range= (e.g. 24h, 7d or 30d, default 24h) or
+// ?from= and ?to= in RFC 3339.
+func parseTimeRange(r *http.Request, now time.Time) (time.Time, time.Time, error) {
+	q := r.URL.Query()
+	to := now
+	if s := q.Get("to"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid to time")
+		}
+		to = t
+	}
+	if s := q.Get("from"); s != "" {
+		from, err := time.Parse(time.RFC3339, s)
+		if err != nil || !from.Before(to) {
+			return time.Time{}, time.Time{}, errors.New("invalid from time")
+		}
+		return from, to, nil
+	}
+
+	span := 24 * time.Hour
+	if s := q.Get("range"); s != "" {
+		var err error
+		if span, err = parseDays(s); err != nil || span <= 0 {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid range %q", s)
+		}
+	}
+	return to.Add(-span), to, nil
+}
+
+// parseDays is time.ParseDuration that also takes whole days, e.g. 7d.
+func parseDays(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// slaHandler reports the availability of ?target= over the selected time
+// range from the recorded runs.
+func slaHandler(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		http.Error(w, "Run history is disabled", http.StatusNotFound)
+		return
+	}
+	target := strings.TrimSpace(r.URL.Query().Get("target"))
+	if target == "" {
+		http.Error(w, "Missing target", http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseTimeRange(r, time.Now().UTC())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var previous *RunRecord
+	if run, ok := history.last(target, from); ok {
+		previous = &run
+	}
+	report := computeSLA(target, from, to, previous, history.between(target, from, to))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// Runs /api/history returns per page by default, and at most
+const (
+	historyPageSize    = 100
+	maxHistoryPageSize = 1000
+)
+
+// pageRuns returns up to limit of runs, which are ordered by time, after
+// the run cursor names, and the cursor of the run after the page, "" on the
+// last one. Runs pruned since the cursor was issued are skipped by time.
+func pageRuns(runs []RunRecord, cursor string, limit int) ([]RunRecord, string, error) {
+	start := 0
+	if cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(cursor)
+		parts := strings.SplitN(string(raw), "|", 3)
+		if err != nil || len(parts) != 3 {
+			return nil, "", errors.New("invalid cursor")
+		}
+		after, err := time.Parse(time.RFC3339Nano, parts[0])
+		if err != nil {
+			return nil, "", errors.New("invalid cursor")
+		}
+		start = len(runs)
+		for i, r := range runs {
+			if r.Time.After(after) {
+				start = i
+				break
+			}
+			if r.Time.Equal(after) && r.RunID == parts[1] && r.Target == parts[2] {
+				start = i + 1
+				break
+			}
+		}
+	}
+	runs = runs[start:]
+	if len(runs) <= limit {
+		return runs, "", nil
+	}
+	last := runs[limit-1]
+	next := base64.RawURLEncoding.EncodeToString([]byte(last.Time.Format(time.RFC3339Nano) + "|" + last.RunID + "|" + last.Target))
+	return runs[:limit], next, nil
+}
+
+// runFields lists the JSON names of the RunRecord fields.
+func runFields() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(RunRecord{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fields[name] = true
+	}
+	return fields
+}
+
+// selectRunFields encodes runs keeping only fields, and always the time.
+// Without fields every field is kept.
+func selectRunFields(runs []RunRecord, fields []string) []map[string]interface{} {
+	selected := make([]map[string]interface{}, 0, len(runs))
+	for _, run := range runs {
+		raw, _ := json.Marshal(run)
+		var all map[string]interface{}
+		json.Unmarshal(raw, &all)
+		if len(fields) == 0 {
+			selected = append(selected, all)
+			continue
+		}
+		kept := map[string]interface{}{"time": all["time"]}
+		for _, f := range fields {
+			if v, ok := all[f]; ok {
+				kept[f] = v
+			}
+		}
+		selected = append(selected, kept)
+	}
+	return selected
+}
+
+// historyHandler lists the recorded runs of ?target=, or of every target,
+// over the selected time range, oldest first, a page at a time.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		http.Error(w, "Run history is disabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	from, to, err := parseTimeRange(r, time.Now().UTC())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := historyPageSize
+	if s := q.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxHistoryPageSize {
+			http.Error(w, fmt.Sprintf("limit must be from 1 to %d", maxHistoryPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+	var fields []string
+	if s := q.Get("fields"); s != "" {
+		known := runFields()
+		for _, f := range strings.Split(s, ",") {
+			f = strings.TrimSpace(f)
+			if !known[f] {
+				http.Error(w, fmt.Sprintf("unknown field %q", f), http.StatusBadRequest)
+				return
+			}
+			fields = append(fields, f)
+		}
+	}
+
+	runs, next, err := pageRuns(history.between(strings.TrimSpace(q.Get("target")), from, to), q.Get("cursor"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RunPage{Runs: selectRunFields(runs, fields), NextCursor: next})
+}
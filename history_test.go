@@ -0,0 +1,170 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComputeSLA(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(10 * time.Hour)
+	at := func(hours float64) time.Time { return from.Add(time.Duration(hours * float64(time.Hour))) }
+	run := func(hours float64, status string) RunRecord {
+		r := RunRecord{Time: at(hours), Target: "example.com", Status: status}
+		if status != runPassed {
+			r.Error = "failed at " + r.Time.Format("15:04")
+		}
+		return r
+	}
+	previous := func(hours float64, status string) *RunRecord {
+		r := run(hours, status)
+		return &r
+	}
+	// Outages as [start, end] in hours from the start of the window, end -1
+	// when ongoing
+	type outage [2]float64
+
+	tests := []struct {
+		name         string
+		previous     *RunRecord
+		runs         []RunRecord
+		availability float64
+		observed     float64 // Hours
+		downtime     float64 // Hours
+		failed       int
+		mttr         float64 // Hours
+		outages      []outage
+	}{
+		{name: "empty window, no earlier run"},
+		{name: "empty window, passing before", previous: previous(-1, runPassed),
+			availability: 100, observed: 10},
+		{name: "empty window, failing before", previous: previous(-1, runError),
+			observed: 10, downtime: 10, outages: []outage{{0, -1}}},
+		{name: "no earlier run", runs: []RunRecord{run(2, runPassed), run(4, runFailed), run(6, runPassed)},
+			availability: 75, observed: 8, downtime: 2, failed: 1, mttr: 2, outages: []outage{{4, 6}}},
+		{name: "failing before the window", previous: previous(-5, runFailed),
+			runs:         []RunRecord{run(3, runPassed), run(6, runPassed)},
+			availability: 70, observed: 10, downtime: 3, mttr: 3, outages: []outage{{0, 3}}},
+		{name: "ongoing at the end", runs: []RunRecord{run(5, runPassed), run(8, runError), run(9, runError)},
+			availability: 60, observed: 5, downtime: 2, failed: 2, outages: []outage{{8, -1}}},
+		{name: "repaired and ongoing", previous: previous(-1, runPassed),
+			runs:         []RunRecord{run(1, runFailed), run(2, runError), run(3, runPassed), run(9, runError)},
+			availability: 70, observed: 10, downtime: 3, failed: 3, mttr: 2, outages: []outage{{1, 3}, {9, -1}}},
+	}
+	for _, tt := range tests {
+		report := computeSLA("example.com", from, to, tt.previous, tt.runs)
+		hours := func(seconds float64) float64 { return seconds / 3600 }
+		if report.Runs != len(tt.runs) || report.FailedRuns != tt.failed {
+			t.Errorf("%s: %d runs, %d failed, want %d and %d", tt.name, report.Runs, report.FailedRuns, len(tt.runs), tt.failed)
+		}
+		if report.Availability != tt.availability || hours(report.ObservedSeconds) != tt.observed ||
+			hours(report.DowntimeSeconds) != tt.downtime || hours(report.MTTRSeconds) != tt.mttr {
+			t.Errorf("%s: availability %v, observed %vh, downtime %vh, MTTR %vh, want %v, %vh, %vh, %vh", tt.name,
+				report.Availability, hours(report.ObservedSeconds), hours(report.DowntimeSeconds), hours(report.MTTRSeconds),
+				tt.availability, tt.observed, tt.downtime, tt.mttr)
+		}
+
+		var outages []outage
+		for _, o := range report.Outages {
+			end := -1.0
+			if o.End != nil {
+				end = o.End.Sub(from).Hours()
+			}
+			if o.Ongoing != (o.End == nil) {
+				t.Errorf("%s: outage at %v has end %v but ongoing %v", tt.name, o.Start, o.End, o.Ongoing)
+			}
+			if o.Error == "" {
+				t.Errorf("%s: outage at %v has no error", tt.name, o.Start)
+			}
+			outages = append(outages, outage{o.Start.Sub(from).Hours(), end})
+		}
+		if !reflect.DeepEqual(outages, tt.outages) {
+			t.Errorf("%s: outages %v, want %v", tt.name, outages, tt.outages)
+		}
+	}
+}
+
+func TestPageRuns(t *testing.T) {
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var runs []RunRecord
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		// b and c finished at the same time
+		offset := i
+		if i >= 2 {
+			offset--
+		}
+		runs = append(runs, RunRecord{Time: at.Add(time.Duration(offset) * time.Minute), RunID: id, Target: "example.com"})
+	}
+
+	var ids []string
+	cursor, pages := "", 0
+	for {
+		page, next, err := pageRuns(runs, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		for _, r := range page {
+			ids = append(ids, r.RunID)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if got := strings.Join(ids, ""); got != "abcde" || pages != 3 {
+		t.Errorf("paged %q in %d pages, want abcde in 3", got, pages)
+	}
+
+	// The run the cursor names was pruned
+	_, cursor, _ = pageRuns(runs, "", 2)
+	page, _, err := pageRuns(runs[3:], cursor, 2)
+	if err != nil || len(page) != 2 || page[0].RunID != "d" {
+		t.Errorf("after pruning: %+v, %v", page, err)
+	}
+
+	for _, invalid := range []string{"not base64!", "bm8gc2VwYXJhdG9ycw", "eWVzdGVyZGF5fGF8Yg"} {
+		if _, _, err := pageRuns(runs, invalid, 2); err == nil {
+			t.Errorf("cursor %q accepted", invalid)
+		}
+	}
+}
+
+func TestSelectRunFields(t *testing.T) {
+	runs := []RunRecord{{Time: time.Unix(0, 0).UTC(), Target: "example.com", Status: runPassed,
+		KeepAliveTimeout: "5", TLSVersion: "TLS 1.3"}}
+	got := selectRunFields(runs, []string{"keepAliveTimeout", "tlsVersion", "error"})
+	want := []map[string]interface{}{{"time": "1970-01-01T00:00:00Z", "keepAliveTimeout": "5", "tlsVersion": "TLS 1.3"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("selectRunFields = %v, want %v", got, want)
+	}
+	if all := selectRunFields(runs, nil); all[0]["status"] != runPassed || all[0]["target"] != "example.com" {
+		t.Errorf("selectRunFields without fields = %v", all)
+	}
+	if fields := runFields(); !fields["keepAliveTimeout"] || !fields["resultKey"] || fields["KeepAliveTimeout"] {
+		t.Errorf("runFields = %v", fields)
+	}
+}
+
+func TestPruneMaxRuns(t *testing.T) {
+	h, err := newRunHistory(time.Hour, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	h.add(RunRecord{Time: now.Add(-2 * time.Hour), RunID: "expired", Target: "c.example"})
+	for i, target := range []string{"a.example", "b.example", "A.example", "a.example", "b.example"} {
+		h.add(RunRecord{Time: now.Add(time.Duration(i) * time.Second), RunID: strconv.Itoa(i), Target: target})
+	}
+
+	var ids []string
+	for _, r := range h.between("", now.Add(-3*time.Hour), now.Add(time.Minute)) {
+		ids = append(ids, r.RunID)
+	}
+	if got := strings.Join(ids, ","); got != "1,2,3,4" {
+		t.Errorf("kept runs %s, want 1,2,3,4", got)
+	}
+}
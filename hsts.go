@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Preload list requirements, see https://hstspreload.org/#submission-requirements
+const hstsPreloadMinMaxAge = 31536000 // One year in seconds
+
+// hstsPreloadEntry is one entry of Chromium's
+// transport_security_state_static.json
+type hstsPreloadEntry struct {
+	Name              string `json:"name"`
+	Mode              string `json:"mode"`
+	IncludeSubdomains bool   `json:"include_subdomains"`
+}
+
+// Loaded from -hsts-preload-file at startup, keyed by domain name
+var hstsPreloadList map[string]hstsPreloadEntry
+
+// loadHSTSPreloadList reads a snapshot of Chromium's preload list. The
+// upstream file has // comment lines, which are dropped before decoding.
+func loadHSTSPreloadList(path string) (map[string]hstsPreloadEntry, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cleaned bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if !strings.HasPrefix(strings.TrimSpace(scanner.Text()), "//") {
+			cleaned.Write(scanner.Bytes())
+			cleaned.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var snapshot struct {
+		Entries []hstsPreloadEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(cleaned.Bytes(), &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid HSTS preload list: %v", err)
+	}
+
+	list := make(map[string]hstsPreloadEntry, len(snapshot.Entries))
+	for _, entry := range snapshot.Entries {
+		list[strings.ToLower(entry.Name)] = entry
+	}
+	return list, nil
+}
+
+// analyzeHSTS parses the Strict-Transport-Security header of the final
+// HTTPS response, checks the domain against the preload list and reports
+// which preload requirements are not met.
+func analyzeHSTS(ctx context.Context, final *url.URL, headers http.Header, host string) *HSTSInfo {
+	info := &HSTSInfo{
+		Header:        headers.Get("Strict-Transport-Security"),
+		PreloadStatus: "Unknown",
+	}
+
+	if final.Scheme != "https" {
+		info.Gaps = append(info.Gaps, "Final response was not served over HTTPS")
+	} else if info.Header == "" {
+		info.Gaps = append(info.Gaps, "No Strict-Transport-Security header")
+	} else {
+		parseSTSHeader(info)
+		if info.MaxAge < hstsPreloadMinMaxAge {
+			info.Gaps = append(info.Gaps, fmt.Sprintf("max-age is %d, preloading needs at least %d", info.MaxAge, hstsPreloadMinMaxAge))
+		}
+		if !info.IncludeSubDomains {
+			info.Gaps = append(info.Gaps, "includeSubDomains directive is missing")
+		}
+		if !info.Preload {
+			info.Gaps = append(info.Gaps, "preload directive is missing")
+		}
+	}
+
+	info.HTTPRedirect = checkHTTPSRedirect(ctx, host)
+	if info.HTTPRedirect == "No" {
+		info.Gaps = append(info.Gaps, "HTTP does not redirect to HTTPS on the same host")
+	}
+
+	if hstsPreloadList != nil {
+		info.PreloadStatus, info.PreloadSource = snapshotPreloadStatus(host), "snapshot"
+	}
+	if cfg.HSTSPreloadOnline {
+		if status, err := onlinePreloadStatus(ctx, host); err == nil {
+			info.PreloadStatus, info.PreloadSource = status, "hstspreload.org"
+		}
+	}
+
+	return info
+}
+
+// parseSTSHeader fills in the directives of an RFC 6797 header
+func parseSTSHeader(info *HSTSInfo) {
+	for _, directive := range strings.Split(info.Header, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "max-age":
+			if maxAge, err := strconv.ParseInt(strings.Trim(strings.TrimSpace(value), `"`), 10, 64); err == nil {
+				info.MaxAge = maxAge
+			}
+		case "includesubdomains":
+			info.IncludeSubDomains = true
+		case "preload":
+			info.Preload = true
+		}
+	}
+}
+
+// checkHTTPSRedirect reports whether http://host/ redirects to HTTPS on the
+// same host: Yes, No, or Not reachable when nothing listens on port 80.
+func checkHTTPSRedirect(ctx context.Context, host string) string {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &http.Client{
+		Transport: auditedTransport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/", nil)
+	if err != nil {
+		return "Not reachable"
+	}
+	markProbe(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "Not reachable"
+	}
+	resp.Body.Close()
+
+	location, err := resp.Location()
+	if err != nil || location.Scheme != "https" || !strings.EqualFold(location.Hostname(), host) {
+		return "No"
+	}
+	return "Yes"
+}
+
+// snapshotPreloadStatus looks the host and its parents up in the snapshot
+func snapshotPreloadStatus(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if entry, ok := hstsPreloadList[host]; ok && entry.Mode == "force-https" {
+		return "Preloaded"
+	}
+	for labels := strings.Split(host, "."); len(labels) > 1; labels = labels[1:] {
+		parent := strings.Join(labels[1:], ".")
+		if entry, ok := hstsPreloadList[parent]; ok && entry.Mode == "force-https" && entry.IncludeSubdomains {
+			return "Preloaded via " + parent
+		}
+	}
+	return "Not preloaded"
+}
+
+// onlinePreloadStatus asks hstspreload.org for the current status, which
+// also covers domains submitted after the snapshot was taken.
+func onlinePreloadStatus(ctx context.Context, host string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://hstspreload.org/api/v2/status?domain="+url.QueryEscape(host), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := (&http.Client{Transport: auditedTransport}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var status struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return "", err
+	}
+
+	switch status.Status {
+	case "preloaded":
+		return "Preloaded", nil
+	case "pending":
+		return "Pending", nil
+	case "rejected":
+		return "Rejected", nil
+	}
+	return "Not preloaded", nil
+}
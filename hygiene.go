@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Largest well-known file the hygiene probe reads
+const maxWellKnownSize = 64 * 1024
+
+// probeSiteHygiene fetches the security.txt, robots.txt and
+// change-password well-known endpoints from the target's origin.
+func probeSiteHygiene(ctx context.Context, target *url.URL, tlsConfig *tls.Config) *SiteHygiene {
+	origin := &url.URL{Scheme: target.Scheme, Host: target.Host}
+	client := newProbeClient(tlsConfig)
+	defer client.CloseIdleConnections()
+
+	return &SiteHygiene{
+		SecurityTxt:    fetchSecurityTxt(ctx, client, origin),
+		RobotsTxt:      fetchRobotsTxt(ctx, client, origin),
+		ChangePassword: fetchChangePassword(ctx, client, origin),
+	}
+}
+
+// fetchWellKnown GETs a path on the origin and returns the response with at
+// most maxWellKnownSize bytes of body.
+func fetchWellKnown(ctx context.Context, client *http.Client, origin *url.URL, path string) (*http.Response, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin.String()+path, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	markProbe(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxWellKnownSize))
+	if err != nil {
+		return nil, "", err
+	}
+	return resp, string(body), nil
+}
+
+// isPlainText filters out sites that answer every path with an HTML page
+func isPlainText(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && mediaType == "text/plain"
+}
+
+// fetchSecurityTxt looks for an RFC 9116 security.txt, falling back to the
+// legacy location at the root.
+func fetchSecurityTxt(ctx context.Context, client *http.Client, origin *url.URL) *SecurityTxt {
+	result := &SecurityTxt{}
+
+	for _, path := range []string{"/.well-known/security.txt", "/security.txt"} {
+		resp, body, err := fetchWellKnown(ctx, client, origin, path)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		if resp.StatusCode != http.StatusOK || !isPlainText(resp) {
+			continue
+		}
+
+		result.Present = true
+		result.Location = path
+		parseSecurityTxt(body, result)
+		return result
+	}
+
+	return result
+}
+
+func parseSecurityTxt(body string, result *SecurityTxt) {
+	result.Signed = strings.Contains(body, "-----BEGIN PGP SIGNED MESSAGE-----")
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "contact":
+			result.Contact = append(result.Contact, value)
+		case "expires":
+			result.Expires = value
+		case "encryption":
+			result.Encryption = append(result.Encryption, value)
+		case "policy":
+			result.Policy = append(result.Policy, value)
+		case "canonical":
+			result.Canonical = append(result.Canonical, value)
+		case "preferred-languages":
+			result.PreferredLanguages = value
+		case "acknowledgments":
+			result.Acknowledgments = append(result.Acknowledgments, value)
+		case "hiring":
+			result.Hiring = append(result.Hiring, value)
+		}
+	}
+
+	// Contact and Expires are the two required fields
+	if len(result.Contact) == 0 {
+		result.Problems = append(result.Problems, "Missing required Contact field")
+	}
+	if result.Expires == "" {
+		result.Problems = append(result.Problems, "Missing required Expires field")
+	} else if expires, err := time.Parse(time.RFC3339, result.Expires); err != nil {
+		result.Problems = append(result.Problems, "Expires is not an RFC 3339 date")
+	} else if expires.Before(time.Now()) {
+		result.Problems = append(result.Problems, "security.txt has expired")
+	}
+}
+
+func fetchRobotsTxt(ctx context.Context, client *http.Client, origin *url.URL) *RobotsTxt {
+	result := &RobotsTxt{}
+
+	resp, body, err := fetchWellKnown(ctx, client, origin, "/robots.txt")
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode != http.StatusOK || !isPlainText(resp) {
+		return result
+	}
+	result.Present = true
+
+	agents := map[string]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "user-agent":
+			agents[value] = true
+		case "disallow":
+			if value != "" {
+				result.DisallowRules++
+			}
+			if value == "/" {
+				result.DisallowsAll = true
+			}
+		case "allow":
+			result.AllowRules++
+		case "sitemap":
+			result.Sitemaps = append(result.Sitemaps, value)
+		}
+	}
+	for agent := range agents {
+		result.UserAgents = append(result.UserAgents, agent)
+	}
+	sort.Strings(result.UserAgents)
+
+	return result
+}
+
+// fetchChangePassword checks the well-known URL password managers use to
+// find a site's change password page; it should redirect there.
+func fetchChangePassword(ctx context.Context, client *http.Client, origin *url.URL) *WellKnownRedirect {
+	result := &WellKnownRedirect{}
+
+	resp, _, err := fetchWellKnown(ctx, client, origin, "/.well-known/change-password")
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.StatusCode = resp.StatusCode
+	if location, err := resp.Location(); err == nil {
+		result.Location = location.String()
+	}
+	result.Present = result.Location != "" || resp.StatusCode == http.StatusOK
+
+	return result
+}
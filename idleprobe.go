@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Default and maximum time the idle probe waits for the server to close
+const defaultIdleWait = 90 * time.Second
+const maxIdleWait = 300 * time.Second
+
+// Extra time allowed past the advertised Keep-Alive timeout
+const idleWaitMargin = 5 * time.Second
+
+// idleConn is a keep-alive connection that has completed one request.
+type idleConn struct {
+	conn     net.Conn
+	br       *bufio.Reader
+	raw      *closeRecorder
+	target   *url.URL
+	response *http.Response
+	idleFrom time.Time // When the response was fully read
+}
+
+// closeRecorder wraps the TCP connection underneath TLS so the way the
+// socket was closed can be told apart from a TLS close_notify.
+type closeRecorder struct {
+	net.Conn
+	mu  sync.Mutex
+	err error
+}
+
+func (c *closeRecorder) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		c.mu.Lock()
+		if c.err == nil {
+			c.err = err
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *closeRecorder) readErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// openIdleConnection dials the target, sends one keep-alive request and
+// reads the full response, leaving the connection idle.
+func openIdleConnection(ctx context.Context, target *url.URL, tlsConfig *tls.Config, dialer *net.Dialer) (*idleConn, error) {
+	conn, raw, err := dialHTTP1(ctx, target, tlsConfig, dialer)
+	if err != nil {
+		return nil, err
+	}
+
+	ic := &idleConn{conn: conn, br: bufio.NewReader(conn), raw: raw, target: target}
+	if _, err := ic.request(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ic, nil
+}
+
+// dialHTTP1 connects to the target, completing the TLS handshake for https
+// with only http/1.1 offered. It also returns the underlying TCP connection.
+func dialHTTP1(ctx context.Context, target *url.URL, tlsConfig *tls.Config, dialer *net.Dialer) (net.Conn, *closeRecorder, error) {
+	tcpConn, err := dialContext(ctx, dialer, "tcp", hostPort(target))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw := &closeRecorder{Conn: tcpConn}
+	if target.Scheme != "https" {
+		return raw, raw, nil
+	}
+
+	tlsConfig.ServerName = target.Hostname()
+	tlsConfig.NextProtos = []string{"http/1.1"} // Keep-Alive is an HTTP/1.1 concept
+	tlsConn := tls.Client(raw, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, nil, err
+	}
+	return tlsConn, raw, nil
+}
+
+// request writes a keep-alive GET on the connection and drains the
+// response, after which the connection counts as idle again.
+func (ic *idleConn) request(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ic.target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Connection", "keep-alive")
+	markProbe(req)
+
+	ic.conn.SetDeadline(time.Now().Add(ipProbeTimeout))
+	if err := req.Write(ic.conn); err != nil {
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(ic.br, req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	ic.conn.SetDeadline(time.Time{})
+
+	ic.response = resp
+	ic.idleFrom = time.Now()
+	return resp, nil
+}
+
+// waitForClose blocks until the server closes the connection, sends
+// unexpected data, or wait elapses. It returns FIN, RST, KeepAliveFailed,
+// CloseNotify, Data or None, how long the connection was idle, and whether a TLS close_notify
+// preceded the close.
+func (ic *idleConn) waitForClose(ctx context.Context, wait time.Duration) (string, time.Duration, bool) {
+	ic.conn.SetReadDeadline(ic.idleFrom.Add(wait))
+
+	// Unblock the read if the analysis is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ic.conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	_, err := ic.br.Peek(1)
+	after := time.Since(ic.idleFrom)
+	if err == nil {
+		return "Data", after, false
+	}
+
+	// A TLS close_notify ends the stream before the socket itself is closed
+	rawErr := ic.raw.readErr()
+	closeNotify := err == io.EOF && rawErr == nil && ic.target.Scheme == "https"
+	if closeNotify {
+		// Look at what follows the alert on the socket
+		ic.raw.SetReadDeadline(ic.idleFrom.Add(wait))
+		ic.raw.Read(make([]byte, 1))
+		rawErr = ic.raw.readErr()
+	}
+
+	switch {
+	case errors.Is(rawErr, io.EOF):
+		return "FIN", after, closeNotify
+	case errors.Is(rawErr, syscall.ECONNRESET):
+		return "RST", after, closeNotify
+	case errors.Is(rawErr, syscall.ETIMEDOUT):
+		return "KeepAliveFailed", after, closeNotify // TCP keepalive probes went unanswered
+	case closeNotify:
+		return "CloseNotify", after, closeNotify // Alert sent, socket left open
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "None", after, closeNotify
+	}
+	return "Error: " + err.Error(), after, closeNotify
+}
+
+// probeIdleClose keeps a keep-alive connection idle and records how the
+// server ends it: a graceful FIN, an abrupt RST, or nothing at all within
+// the wait window.
+func probeIdleClose(ctx context.Context, target *url.URL, tlsConfig *tls.Config, maxWait time.Duration) *IdleCloseResult {
+	result := &IdleCloseResult{}
+
+	ic, err := openIdleConnection(ctx, target, tlsConfig, &net.Dialer{})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer ic.conn.Close()
+
+	result.ResponseConnection = ic.response.Header.Get("Connection")
+
+	wait, advertised := ic.idleWindow(maxWait)
+	result.AdvertisedTimeout = advertised
+	result.WaitedSeconds = wait.Seconds()
+
+	closeType, after, closeNotify := ic.waitForClose(ctx, wait)
+	result.CloseType = closeType
+	result.CloseNotify = closeNotify
+	if closeType != "None" {
+		result.ClosedAfterSeconds = float64(after.Milliseconds()) / 1000
+	}
+
+	return result
+}
+
+// probeHalfOpen idles a keep-alive connection past the advertised timeout
+// and then reuses it. A server or middlebox that dropped the connection
+// without a FIN or RST leaves it half-open, so the post-idle request fails.
+func probeHalfOpen(ctx context.Context, target *url.URL, tlsConfig *tls.Config, maxWait time.Duration) *HalfOpenResult {
+	result := &HalfOpenResult{}
+
+	ic, err := openIdleConnection(ctx, target, tlsConfig, &net.Dialer{})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer ic.conn.Close()
+
+	wait, advertised := ic.idleWindow(maxWait)
+	result.AdvertisedTimeout = advertised
+	result.IdleSeconds = wait.Seconds()
+
+	// A connection the server visibly closed is not half-open
+	closeType, _, _ := ic.waitForClose(ctx, wait)
+	if closeType != "None" {
+		result.ClosedByServer = closeType
+		return result
+	}
+
+	result.RequestAttempted = true
+	start := time.Now()
+	resp, err := ic.request(ctx)
+	result.RequestMs = millisecondsSince(start)
+	if err != nil {
+		result.RequestError = describeConnError(err)
+		result.HalfOpen = true
+		return result
+	}
+	result.StatusCode = resp.StatusCode
+
+	return result
+}
+
+// idleWindow returns how long to keep the connection idle: the advertised
+// Keep-Alive timeout plus a margin, bounded by maxWait. It also returns the
+// advertised timeout in seconds, zero when none was sent.
+func (ic *idleConn) idleWindow(maxWait time.Duration) (time.Duration, int) {
+	timeout, ok := parseKeepAliveTimeout(ic.response.Header.Get("Keep-Alive"))
+	if !ok {
+		return maxWait, 0
+	}
+	if timeout+idleWaitMargin < maxWait {
+		return timeout + idleWaitMargin, int(timeout / time.Second)
+	}
+	return maxWait, int(timeout / time.Second)
+}
+
+// describeConnError names the way a request on a reused connection failed.
+func describeConnError(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNRESET):
+		return "RST"
+	case errors.Is(err, syscall.EPIPE):
+		return "Broken pipe"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "EOF"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "Timeout"
+	}
+	return err.Error()
+}
+
+// idleWait returns the requested idle probe window, bounded to maxIdleWait.
+func idleWait(seconds int) time.Duration {
+	if seconds <= 0 {
+		return defaultIdleWait
+	}
+	wait := time.Duration(seconds) * time.Second
+	if wait > maxIdleWait {
+		return maxIdleWait
+	}
+	return wait
+}
+
+// parseKeepAliveTimeout returns the timeout= parameter of a Keep-Alive header.
+func parseKeepAliveTimeout(header string) (time.Duration, bool) {
+	ka := parseKeepAlive(header)
+	if ka == nil || ka.Timeout == nil {
+		return 0, false
+	}
+	return time.Duration(*ka.Timeout) * time.Second, true
+}
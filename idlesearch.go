@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Idle timeout search tuning: connections tested per round and the
+// interval width at which the search stops
+const (
+	idleSearchParallel   = 4
+	idleSearchResolution = 5 * time.Second
+)
+
+// estimateIdleTimeout measures the longest idle period after which a
+// keep-alive connection still carries a request. Each round idles several
+// connections in parallel for durations spread across the remaining
+// interval, then narrows it to between the longest success and the
+// shortest failure. TCP keepalives are disabled so only the path and the
+// server decide when the connection dies.
+func estimateIdleTimeout(ctx context.Context, target *url.URL, tlsConfig *tls.Config, maxIdle time.Duration) *IdleTimeoutEstimate {
+	result := &IdleTimeoutEstimate{MaxTestedSeconds: maxIdle.Seconds()}
+
+	lo, hi := time.Duration(0), maxIdle
+	failedAtHi := false
+	for hi-lo > idleSearchResolution && ctx.Err() == nil {
+		durations := spreadDurations(lo, hi, idleSearchParallel, !failedAtHi)
+		if len(durations) == 0 {
+			break
+		}
+		samples := idleSamples(ctx, target, tlsConfig, durations)
+		result.Samples = append(result.Samples, samples...)
+
+		// Samples are sorted, narrow to the first failure
+		for _, sample := range samples {
+			if sample.Error != "" {
+				result.Error = sample.Error
+				return result
+			}
+			d := time.Duration(sample.Seconds * float64(time.Second))
+			if !sample.Succeeded {
+				hi = d
+				failedAtHi = true
+				break
+			}
+			lo = d
+		}
+	}
+
+	result.LowerBoundSeconds = lo.Seconds()
+	if failedAtHi {
+		result.UpperBoundSeconds = hi.Seconds()
+	}
+	result.Limit = idleLimit(result.Samples, failedAtHi)
+
+	return result
+}
+
+// spreadDurations picks n idle durations between lo and hi. The upper
+// bound itself is only tested while it is not yet known to fail.
+func spreadDurations(lo, hi time.Duration, n int, includeHi bool) []time.Duration {
+	steps := n + 1
+	if includeHi {
+		steps = n
+	}
+	step := (hi - lo) / time.Duration(steps)
+
+	var durations []time.Duration
+	for i := 1; i <= n; i++ {
+		d := (lo + step*time.Duration(i)).Round(time.Second)
+		if d > lo && d <= hi && (len(durations) == 0 || d > durations[len(durations)-1]) {
+			durations = append(durations, d)
+		}
+	}
+	return durations
+}
+
+// idleSamples idles one connection per duration, all at the same time.
+func idleSamples(ctx context.Context, target *url.URL, tlsConfig *tls.Config, durations []time.Duration) []IdleSample {
+	samples := make([]IdleSample, len(durations))
+
+	var wg sync.WaitGroup
+	for i, d := range durations {
+		wg.Add(1)
+		go func(i int, d time.Duration) {
+			defer wg.Done()
+			samples[i] = idleSample(ctx, target, tlsConfig.Clone(), d)
+		}(i, d)
+	}
+	wg.Wait()
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Seconds < samples[j].Seconds })
+	return samples
+}
+
+func idleSample(ctx context.Context, target *url.URL, tlsConfig *tls.Config, idle time.Duration) IdleSample {
+	sample := IdleSample{Seconds: idle.Seconds()}
+
+	dialer := &net.Dialer{KeepAlive: -1}
+	ic, err := openIdleConnection(ctx, target, tlsConfig, dialer)
+	if err != nil {
+		sample.Error = err.Error()
+		return sample
+	}
+	defer ic.conn.Close()
+
+	closeType, _, _ := ic.waitForClose(ctx, idle)
+	if closeType != "None" {
+		sample.Failure = closeType
+		return sample
+	}
+
+	if _, err := ic.request(ctx); err != nil {
+		sample.Failure = "Silent: " + describeConnError(err)
+		return sample
+	}
+	sample.Succeeded = true
+
+	return sample
+}
+
+// idleLimit names what ended the connections at the shortest failing
+// duration: the server closing them visibly, the path dropping them
+// silently, or nothing within range.
+func idleLimit(samples []IdleSample, failed bool) string {
+	if !failed {
+		return "None within range"
+	}
+
+	var first *IdleSample
+	for i := range samples {
+		if !samples[i].Succeeded && (first == nil || samples[i].Seconds < first.Seconds) {
+			first = &samples[i]
+		}
+	}
+	if first == nil {
+		return "Unknown"
+	}
+	if strings.HasPrefix(first.Failure, "Silent") {
+		return "Path (silent drop)"
+	}
+	return "Server (" + first.Failure + ")"
+}
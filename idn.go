@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Punycode parameters, RFC 3492 section 5
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+const acePrefix = "xn--"
+
+// toASCII converts a host name to the form used on the wire: labels with
+// non-ASCII characters are lower-cased and punycode encoded with the xn--
+// prefix. Addresses and ASCII names come back lower-cased only. Unicode
+// normalization is not applied, so decomposed input must already be NFC.
+func toASCII(host string) (string, error) {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || isIPAddress(host) {
+		return host, nil
+	}
+	labels := strings.Split(strings.ToLower(host), ".")
+	for i, label := range labels {
+		if label == "" {
+			return "", fmt.Errorf("invalid host name %q: empty label", host)
+		}
+		if !isASCII(label) {
+			encoded, err := punycodeEncode(label)
+			if err != nil {
+				return "", fmt.Errorf("invalid host name %q: %v", host, err)
+			}
+			label = acePrefix + encoded
+		}
+		if len(label) > 63 {
+			return "", fmt.Errorf("invalid host name %q: label longer than 63 bytes", host)
+		}
+		labels[i] = label
+	}
+	ascii := strings.Join(labels, ".")
+	if len(ascii) > 253 {
+		return "", fmt.Errorf("invalid host name %q: longer than 253 bytes", host)
+	}
+	return ascii, nil
+}
+
+// toUnicode decodes the xn-- labels of host, leaving labels that don't
+// decode as they are.
+func toUnicode(host string) string {
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if strings.HasPrefix(strings.ToLower(label), acePrefix) {
+			if decoded, err := punycodeDecode(label[len(acePrefix):]); err == nil {
+				labels[i] = decoded
+			}
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func isIPAddress(host string) bool {
+	return net.ParseIP(strings.Trim(host, "[]")) != nil
+}
+
+func punycodeAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+func punycodeThreshold(k, bias int) int {
+	switch {
+	case k <= bias:
+		return punyTMin
+	case k >= bias+punyTMax:
+		return punyTMax
+	}
+	return k - bias
+}
+
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+var errPunycode = errors.New("invalid punycode")
+
+// punycodeEncode encodes one label, RFC 3492 section 6.3.
+func punycodeEncode(label string) (string, error) {
+	input := []rune(label)
+	var out []byte
+	for _, r := range input {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := punyInitialN, 0, punyInitialBias
+	for h := basic; h < len(input); {
+		m := int(unicode.MaxRune) + 1
+		for _, r := range input {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+		delta += (m - n) * (h + 1)
+		n = m
+		for _, r := range input {
+			if int(r) < n {
+				delta++
+			}
+			if int(r) != n {
+				continue
+			}
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := punycodeThreshold(k, bias)
+				if q < t {
+					break
+				}
+				out = append(out, punycodeDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punycodeDigit(q))
+			bias = punycodeAdapt(delta, h+1, h == basic)
+			delta = 0
+			h++
+		}
+		delta++
+		n++
+	}
+	return string(out), nil
+}
+
+// punycodeDecode decodes one label without its xn-- prefix, RFC 3492
+// section 6.2.
+func punycodeDecode(s string) (string, error) {
+	var output []rune
+	pos := 0
+	if i := strings.LastIndexByte(s, '-'); i >= 0 {
+		for _, r := range s[:i] {
+			if r >= utf8.RuneSelf {
+				return "", errPunycode
+			}
+			output = append(output, r)
+		}
+		pos = i + 1
+	}
+
+	n, i, bias := punyInitialN, 0, punyInitialBias
+	for pos < len(s) {
+		oldi, w := i, 1
+		for k := punyBase; ; k += punyBase {
+			if pos == len(s) {
+				return "", errPunycode
+			}
+			c := s[pos]
+			pos++
+			var digit int
+			switch {
+			case c >= 'a' && c <= 'z':
+				digit = int(c - 'a')
+			case c >= 'A' && c <= 'Z':
+				digit = int(c - 'A')
+			case c >= '0' && c <= '9':
+				digit = int(c-'0') + 26
+			default:
+				return "", errPunycode
+			}
+			i += digit * w
+			if i > unicode.MaxRune {
+				return "", errPunycode
+			}
+			t := punycodeThreshold(k, bias)
+			if digit < t {
+				break
+			}
+			w *= punyBase - t
+		}
+		bias = punycodeAdapt(i-oldi, len(output)+1, oldi == 0)
+		n += i / (len(output) + 1)
+		i %= len(output) + 1
+		if n > unicode.MaxRune {
+			return "", errPunycode
+		}
+		output = append(output[:i], append([]rune{rune(n)}, output[i:]...)...)
+		i++
+	}
+	return string(output), nil
+}
+
+// Scripts told apart for homograph checks
+var idnScripts = []struct {
+	name  string
+	table *unicode.RangeTable
+}{
+	{"Latin", unicode.Latin}, {"Cyrillic", unicode.Cyrillic}, {"Greek", unicode.Greek},
+	{"Armenian", unicode.Armenian}, {"Georgian", unicode.Georgian}, {"Cherokee", unicode.Cherokee},
+	{"Hebrew", unicode.Hebrew}, {"Arabic", unicode.Arabic}, {"Devanagari", unicode.Devanagari},
+	{"Thai", unicode.Thai}, {"Han", unicode.Han}, {"Hiragana", unicode.Hiragana},
+	{"Katakana", unicode.Katakana}, {"Hangul", unicode.Hangul}, {"Bopomofo", unicode.Bopomofo},
+}
+
+// Script combinations that are normal within one label, the "highly
+// restrictive" profile of Unicode TS 39
+var allowedScriptSets = [][]string{
+	{"Latin", "Han", "Hiragana", "Katakana"},
+	{"Latin", "Han", "Bopomofo"},
+	{"Latin", "Han", "Hangul"},
+}
+
+// Cyrillic and Greek letters that look like Latin ones. A label written
+// only with these passes as a Latin name.
+const latinLookalikes = "аеорсухіјѕһԁӏԛԝвкмнтѵαοτνικρυχ"
+
+// analyzeIDN reports the Unicode and ASCII forms of an internationalized
+// host and warns about labels that mix scripts or imitate Latin ones. It
+// returns nil for plain ASCII names.
+func analyzeIDN(host string) *IDNInfo {
+	ascii, err := toASCII(host)
+	if err != nil {
+		return nil
+	}
+	unicodeHost := toUnicode(ascii)
+	if unicodeHost == ascii {
+		return nil
+	}
+	info := &IDNInfo{ASCII: ascii, Unicode: unicodeHost}
+
+	seen := make(map[string]bool)
+	for _, label := range strings.Split(unicodeHost, ".") {
+		scripts := labelScripts(label)
+		for _, script := range scripts {
+			if !seen[script] {
+				seen[script] = true
+				info.Scripts = append(info.Scripts, script)
+			}
+		}
+		switch {
+		case len(scripts) > 1 && !allowedScripts(scripts):
+			info.Findings = append(info.Findings, Finding{Severity: severityHigh, ID: "idn-mixed-script",
+				Message: fmt.Sprintf("%q mixes %s characters", label, strings.Join(scripts, " and ")),
+				Detail:  "Mixing scripts in one label is the usual way to imitate a well-known name with lookalike characters."})
+		case len(scripts) == 1 && scripts[0] != "Latin" && onlyLatinLookalikes(label):
+			info.Findings = append(info.Findings, Finding{Severity: severityHigh, ID: "idn-whole-script-confusable",
+				Message: fmt.Sprintf("%q is written in %s letters that all look like Latin ones", label, scripts[0]),
+				Detail:  "Browsers show the name as punycode, but elsewhere it reads as the Latin name it imitates."})
+		}
+	}
+	sort.Strings(info.Scripts)
+	return info
+}
+
+// labelScripts lists the scripts of the letters in label, in order of
+// appearance. Digits, hyphens and other common characters have none.
+func labelScripts(label string) []string {
+	var scripts []string
+	for _, r := range label {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		name := "Other"
+		for _, script := range idnScripts {
+			if unicode.Is(script.table, r) {
+				name = script.name
+				break
+			}
+		}
+		if !containsString(scripts, name) {
+			scripts = append(scripts, name)
+		}
+	}
+	return scripts
+}
+
+func allowedScripts(scripts []string) bool {
+	for _, set := range allowedScriptSets {
+		allowed := true
+		for _, script := range scripts {
+			allowed = allowed && containsString(set, script)
+		}
+		if allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func onlyLatinLookalikes(label string) bool {
+	letters := 0
+	for _, r := range label {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		if !strings.ContainsRune(latinLookalikes, r) {
+			return false
+		}
+		letters++
+	}
+	return letters > 0
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestToASCII(t *testing.T) {
+	tests := []struct {
+		host    string
+		want    string
+		wantErr bool
+	}{
+		{host: "Bücher.Example.", want: "xn--bcher-kva.example"},
+		{host: "münchen.de", want: "xn--mnchen-3ya.de"},
+		{host: "пример.рф", want: "xn--e1afmkfd.xn--p1ai"},
+		{host: "例え.テスト", want: "xn--r8jz45g.xn--zckzah"},
+		{host: "EXAMPLE.com", want: "example.com"},
+		{host: "192.0.2.1", want: "192.0.2.1"},
+		{host: "::1", want: "::1"},
+		{host: "", want: ""},
+		{host: "a..b", wantErr: true},
+		{host: ".example.com", wantErr: true},
+		{host: strings.Repeat("ü", 60) + ".de", wantErr: true},
+		{host: strings.Repeat("a", 64) + ".de", wantErr: true},
+		{host: strings.Repeat("a.", 127) + "com", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := toASCII(tt.host)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("toASCII(%q) error = %v, want error %v", tt.host, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("toASCII(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestToUnicode(t *testing.T) {
+	tests := map[string]string{
+		"xn--bcher-kva.example":  "bücher.example",
+		"XN--MNCHEN-3YA.de":      "MüNCHEN.de", // Basic code points keep their case
+		"xn--e1afmkfd.xn--p1ai":  "пример.рф",
+		"example.com":            "example.com",
+		"xn--bcher-kv.example":   "xn--bcher-kv.example", // Truncated, left as is
+		"xn--ab-!!.xn--p1ai":     "xn--ab-!!.рф",
+		"xn--99999999999.com":    "xn--99999999999.com", // Overflows
+		"xn--ü-abc.example.com":  "xn--ü-abc.example.com",
+		"xn--r8jz45g.xn--zckzah": "例え.テスト",
+	}
+	for host, want := range tests {
+		if got := toUnicode(host); got != want {
+			t.Errorf("toUnicode(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+// TestPunycodeRoundTrip uses the sample strings of RFC 3492 section 7.1.
+func TestPunycodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		unicode, encoded string
+	}{
+		{"ليهمابتكلموشعربي؟", "egbpdaj6bu4bxfgehfvwxn"},
+		{"他们为什么不说中文", "ihqwcrb4cv8a8dqg056pqjye"},
+		{"почемужеонинеговорятпорусски", "b1abfaaepdrnnbgefbadotcwatmq2g4l"},
+		{"3年b組金八先生", "3b-ww4c5e180e575a65lsy2b"},
+		{"bücher", "bcher-kva"},
+		{"abc", "abc-"},
+	}
+	for _, tt := range tests {
+		encoded, err := punycodeEncode(tt.unicode)
+		if err != nil || encoded != tt.encoded {
+			t.Errorf("punycodeEncode(%q) = %q, %v, want %q", tt.unicode, encoded, err, tt.encoded)
+		}
+		decoded, err := punycodeDecode(tt.encoded)
+		if err != nil || decoded != tt.unicode {
+			t.Errorf("punycodeDecode(%q) = %q, %v, want %q", tt.encoded, decoded, err, tt.unicode)
+		}
+	}
+
+	for _, invalid := range []string{"bcher-kv", "ab-!!", "ü-abc", "99999999999"} {
+		if decoded, err := punycodeDecode(invalid); err == nil {
+			t.Errorf("punycodeDecode(%q) = %q, want an error", invalid, decoded)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a request pinned to a single IP may take
+const ipProbeTimeout = 15 * time.Second
+
+// probeIPs sends the same request to every resolved address concurrently,
+// keeping the hostname for Host and SNI, so per-POP timings can be compared.
+// With more than one sample each address is requested that many times and
+// the timing distribution is reported alongside the first measurement.
+func probeIPs(ctx context.Context, target *url.URL, ips []string, tlsConfig *tls.Config, samples int) []IPResult {
+	results := make([]IPResult, len(ips))
+
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			results[i] = probeIP(ctx, target, ip, tlsConfig.Clone())
+			if samples > 1 {
+				results[i].Samples = sampleIP(ctx, target, results[i], samples, tlsConfig)
+			}
+		}(i, ip)
+	}
+	wg.Wait()
+
+	flagFramingDifferences(results)
+	flagContentDifferences(results)
+	return results
+}
+
+func probeIP(ctx context.Context, target *url.URL, ip string, tlsConfig *tls.Config) IPResult {
+	result := IPResult{IP: ip}
+
+	ctx, cancel := context.WithTimeout(ctx, ipProbeTimeout)
+	defer cancel()
+
+	_, port, _ := net.SplitHostPort(hostPort(target))
+	addr := net.JoinHostPort(ip, port)
+
+	dialer := &net.Dialer{}
+	var transport http.RoundTripper = &http.Transport{
+		TLSClientConfig:   tlsConfig,
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			// Ignore the hostname and dial the pinned address
+			return dialContext(ctx, dialer, network, addr)
+		},
+	}
+	if _, ok := connReuseFrom(ctx); ok {
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = target.Hostname()
+		}
+		transport = reusePool.transport(ctx, addr, tlsConfig.ServerName)
+		ctx = context.WithValue(ctx, pooledTLSKey{}, tlsConfig)
+	}
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse // Redirects may leave this IP
+		},
+	}
+
+	// The request, TLS details and socket statistics all come from this one
+	// connection, so each address is dialed exactly once
+	var conn net.Conn
+	var start, connectStart, tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			conn = info.Conn
+			result.Reused = info.Reused
+		},
+		ConnectStart: func(network, addr string) {
+			connectStart = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			result.ConnectMs = millisecondsSince(connectStart)
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			result.TLSHandshakeMs = millisecondsSince(tlsStart)
+		},
+		GotFirstResponseByte: func() {
+			result.TTFBMs = millisecondsSince(start)
+			// Read the socket statistics before the transport closes it
+			result.TCP = connTCPInfo(conn)
+		},
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, target.String(), nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	// Asking for gzip explicitly stops the transport from decompressing,
+	// which would hide the framing the server chose
+	req.Header.Set("Accept-Encoding", "gzip")
+	markProbe(req)
+
+	start = time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	result.BodyBytes, result.BodyHash, err = hashBody(resp)
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	result.StatusCode = resp.StatusCode
+	result.TotalMs = millisecondsSince(start)
+	result.Framing = responseFraming(resp)
+	result.ContentLength = resp.ContentLength
+	result.Server = resp.Header.Get("Server")
+	result.PoweredBy = resp.Header.Get("X-Powered-By")
+	result.ETag = resp.Header.Get("ETag")
+	if resp.TLS != nil {
+		result.TLSVersion = tlsVersionToString(resp.TLS.Version)
+		result.CipherSuite = tls.CipherSuiteName(resp.TLS.CipherSuite)
+	}
+
+	return result
+}
+
+// connTCPInfo reads TCP_INFO from the socket underneath conn, returning nil
+// where that is not supported.
+func connTCPInfo(conn net.Conn) *TCPConnInfo {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if pc, ok := conn.(*pooledConn); ok {
+		conn = pc.Conn
+	}
+	if bc, ok := conn.(*budgetConn); ok {
+		conn = bc.Conn
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	var info *TCPConnInfo
+	raw.Control(func(fd uintptr) {
+		info, _ = readTCPInfo(fd)
+	})
+	return info
+}
+
+// responseFraming names how the end of a response body is signalled:
+// chunked, content-length, close-delimited, or none for bodiless responses.
+func responseFraming(resp *http.Response) string {
+	for _, te := range resp.TransferEncoding {
+		if te == "chunked" {
+			return "chunked"
+		}
+	}
+	switch {
+	case resp.ContentLength >= 0 && resp.Header.Get("Content-Length") != "":
+		return "content-length"
+	case resp.ContentLength == 0:
+		return "none" // HEAD, 204 and 304 responses carry no body
+	}
+	return "close-delimited" // The body ends when the server closes the connection
+}
+
+// hashBody reads the response body, returning the bytes received and a
+// truncated SHA-256 of the decoded content. Hashing after decompression
+// keeps backends that compress the same page differently from looking
+// like they serve different content.
+func hashBody(resp *http.Response) (int64, string, error) {
+	counter := &countingReader{r: resp.Body}
+	var body io.Reader = counter
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(counter)
+		if err != nil {
+			return counter.n, "", err
+		}
+		body = gz
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return counter.n, "", err
+	}
+	return counter.n, hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// flagContentDifferences marks addresses whose body differs from the one
+// most addresses serve, typically a stale pool member.
+func flagContentDifferences(results []IPResult) {
+	counts := make(map[string]int)
+	common := ""
+	for _, r := range results {
+		if r.BodyHash == "" {
+			continue
+		}
+		counts[r.BodyHash]++
+		if counts[r.BodyHash] > counts[common] {
+			common = r.BodyHash
+		}
+	}
+	if len(counts) < 2 {
+		return
+	}
+	for i := range results {
+		results[i].ContentDiffers = results[i].BodyHash != "" && results[i].BodyHash != common
+	}
+}
+
+// checkContentStability requests the first address that answered again.
+// If its body changed, the page is generated per request and differing
+// hashes say nothing about the pool, so the per-IP flags are cleared.
+func checkContentStability(ctx context.Context, target *url.URL, results []IPResult, tlsConfig *tls.Config) bool {
+	for i := range results {
+		if results[i].BodyHash == "" {
+			continue
+		}
+		again := probeIP(ctx, target, results[i].IP, tlsConfig.Clone())
+		if again.BodyHash == "" || again.BodyHash == results[i].BodyHash {
+			return false
+		}
+		for j := range results {
+			results[j].ContentDiffers = false
+		}
+		return true
+	}
+	return false
+}
+
+// flagFramingDifferences marks addresses whose framing differs from the one
+// most addresses use. Backends behind one load balancer that frame the same
+// response differently often explain intermittent keep-alive failures.
+func flagFramingDifferences(results []IPResult) {
+	counts := make(map[string]int)
+	common := ""
+	for _, r := range results {
+		if r.Framing == "" {
+			continue
+		}
+		counts[r.Framing]++
+		if counts[r.Framing] > counts[common] {
+			common = r.Framing
+		}
+	}
+	if len(counts) < 2 {
+		return
+	}
+	for i := range results {
+		results[i].FramingDiffers = results[i].Framing != "" && results[i].Framing != common
+	}
+}
+
+// millisecondsSince returns the elapsed time in milliseconds, to 0.01ms.
+func millisecondsSince(t time.Time) float64 {
+	return float64(time.Since(t).Microseconds()/10) / 100
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// parseKeepAlive parses a Keep-Alive header such as "timeout=5, max=100".
+// Parameters may come in any order, with or without spaces around the
+// "=", and quoted values are unquoted. Parameters other than timeout and
+// max are kept as extensions, keyed by their lower-case name. It returns
+// nil for an empty header.
+func parseKeepAlive(header string) *KeepAliveHeader {
+	if strings.TrimSpace(header) == "" {
+		return nil
+	}
+	ka := &KeepAliveHeader{}
+	for _, param := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(param, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		if key == "" {
+			continue
+		}
+		switch key {
+		case "timeout", "max":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				ka.Invalid = append(ka.Invalid, strings.TrimSpace(param))
+				continue
+			}
+			if key == "timeout" {
+				ka.Timeout = &n
+			} else {
+				ka.Max = &n
+			}
+		default:
+			if ka.Extensions == nil {
+				ka.Extensions = make(map[string]string)
+			}
+			ka.Extensions[key] = value
+		}
+	}
+	return ka
+}
+
+// extractTimeoutValue returns the timeout of a Keep-Alive header in
+// seconds, or "Not Defined" when it has none.
+func extractTimeoutValue(keepAliveHeader string) string {
+	if ka := parseKeepAlive(keepAliveHeader); ka != nil && ka.Timeout != nil {
+		return strconv.Itoa(*ka.Timeout)
+	}
+	return "Not Defined"
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeepAlive(t *testing.T) {
+	n := func(v int) *int { return &v }
+	tests := []struct {
+		header string
+		want   *KeepAliveHeader
+	}{
+		{"", nil},
+		{"  ", nil},
+		{"timeout=5, max=100", &KeepAliveHeader{Timeout: n(5), Max: n(100)}},
+		{"max=100,timeout=5", &KeepAliveHeader{Timeout: n(5), Max: n(100)}},
+		{"Timeout = 5 , MAX = 0", &KeepAliveHeader{Timeout: n(5), Max: n(0)}},
+		{`timeout="15", max="3"`, &KeepAliveHeader{Timeout: n(15), Max: n(3)}},
+		{"timeout=5, pipeline=\"yes\", Foo", &KeepAliveHeader{Timeout: n(5),
+			Extensions: map[string]string{"pipeline": "yes", "foo": ""}}},
+		{"timeout=5,,", &KeepAliveHeader{Timeout: n(5)}},
+		{"timeout=abc, max=-1", &KeepAliveHeader{Invalid: []string{"timeout=abc", "max=-1"}}},
+		{"timeout=, max=10", &KeepAliveHeader{Max: n(10), Invalid: []string{"timeout="}}},
+		{"timeout=5, timeout=7", &KeepAliveHeader{Timeout: n(7)}},
+	}
+	for _, tt := range tests {
+		if got := parseKeepAlive(tt.header); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseKeepAlive(%q) = %+v, want %+v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestExtractTimeoutValue(t *testing.T) {
+	tests := map[string]string{
+		"timeout=5, max=100": "5",
+		"max=100":            "Not Defined",
+		"timeout=soon":       "Not Defined",
+		"":                   "Not Defined",
+	}
+	for header, want := range tests {
+		if got := extractTimeoutValue(header); got != want {
+			t.Errorf("extractTimeoutValue(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Accept-Language values the language analysis sends, baseline first
+var probeLanguages = []string{"en-US,en;q=0.9", "fr-FR,fr;q=0.9", "de-DE,de;q=0.9", "ja-JP,ja;q=0.9"}
+
+// A path starting with a locale, such as /fr/ or /en-gb/
+var localePathPattern = regexp.MustCompile(`(?i)^/([a-z]{2})([-_][a-z]{2})?(/|$)`)
+
+// analyzeLanguages requests the target with several Accept-Language values
+// and reports whether the content, Content-Language or redirects change,
+// and whether caches are told about it through Vary.
+func analyzeLanguages(ctx context.Context, target *url.URL, tlsConfig *tls.Config) *LanguageAnalysis {
+	result := &LanguageAnalysis{}
+	client := newProbeClient(tlsConfig)
+	defer client.CloseIdleConnections()
+
+	hashes := make(map[string]bool)
+	languages := make(map[string]bool)
+	cacheHit := false
+	for _, lang := range probeLanguages {
+		variant := LanguageVariant{AcceptLanguage: lang}
+
+		resp, body, err := doRequest(ctx, client, http.MethodGet, target, http.Header{"Accept-Language": {lang}})
+		if err != nil {
+			variant.Error = err.Error()
+			result.Variants = append(result.Variants, variant)
+			continue
+		}
+		variant.StatusCode = resp.StatusCode
+		variant.ContentLanguage = resp.Header.Get("Content-Language")
+		variant.BodyHash = truncatedSHA256(string(body))
+		variant.CacheStatus = cacheSample(resp).CacheStatus
+
+		if location, err := resp.Location(); err == nil {
+			variant.Location = location.String()
+			if m := localePathPattern.FindStringSubmatch(location.Path); m != nil {
+				variant.LocaleRedirect = strings.EqualFold(m[1], lang[:2])
+			}
+		}
+
+		for _, name := range strings.Split(strings.Join(resp.Header.Values("Vary"), ","), ",") {
+			if strings.EqualFold(strings.TrimSpace(name), "Accept-Language") || strings.TrimSpace(name) == "*" {
+				result.VaryAcceptLanguage = true
+			}
+		}
+		if strings.Contains(strings.ToUpper(variant.CacheStatus), "HIT") {
+			cacheHit = true
+		}
+
+		hashes[variant.BodyHash] = true
+		languages[variant.ContentLanguage] = true
+		result.RedirectsToLocale = result.RedirectsToLocale || variant.LocaleRedirect
+		result.Variants = append(result.Variants, variant)
+	}
+
+	result.VariesContent = len(hashes) > 1 || len(languages) > 1 || result.RedirectsToLocale
+
+	if result.VariesContent && !result.VaryAcceptLanguage {
+		issue := "Content depends on Accept-Language but Vary does not list it"
+		if cacheHit {
+			issue += ", and a cache served a hit, so visitors can get another language's cached page"
+		}
+		result.Issues = append(result.Issues, issue)
+	}
+	if !result.VariesContent && result.VaryAcceptLanguage {
+		result.Issues = append(result.Issues, "Vary lists Accept-Language although the content does not change, which splits the cache for nothing")
+	}
+	if result.VariesContent && !result.RedirectsToLocale {
+		var missing []string
+		for _, v := range result.Variants {
+			if v.Error == "" && v.ContentLanguage == "" {
+				missing = append(missing, v.AcceptLanguage)
+			}
+		}
+		if len(missing) > 0 {
+			result.Issues = append(result.Issues, fmt.Sprintf("Translated responses lack Content-Language (for %s)", strings.Join(missing, "; ")))
+		}
+	}
+
+	return result
+}
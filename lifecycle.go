@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How long shutdown waits for in-flight analyses before cancelling them
+const shutdownTimeout = 30 * time.Second
+
+// How long cancelled analyses get to unwind once signalled
+const cancelGracePeriod = 5 * time.Second
+
+// How long queued results and metrics get to reach their stores once the
+// analyses are done
+const flushTimeout = 5 * time.Second
+
+// lifecycle tracks running analyses so shutdown can drain them.
+// Every analysis runs under ctx, which is cancelled when the drain
+// deadline passes. Background jobs started with Go run under jobCtx,
+// which is cancelled as soon as shutdown begins.
+type lifecycle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	jobCtx   context.Context
+	stopJobs context.CancelFunc
+	jobs     sync.WaitGroup
+
+	mu      sync.Mutex
+	drained []func() // Run once the analyses are done, see afterDrain
+}
+
+func newLifecycle() *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	return &lifecycle{ctx: ctx, cancel: cancel, jobCtx: jobCtx, stopJobs: stopJobs}
+}
+
+// baseContext hands the lifecycle context to the http.Server so that
+// request contexts are cancelled along with it.
+func (lc *lifecycle) baseContext(net.Listener) context.Context {
+	return lc.ctx
+}
+
+// track wraps a handler so shutdown waits for it to return.
+func (lc *lifecycle) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lc.wg.Add(1)
+		defer lc.wg.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Go runs fn in the background as a tracked job. Its ctx is cancelled
+// when shutdown begins, and shutdown waits for it to return, after the
+// analyses and the afterDrain functions.
+func (lc *lifecycle) Go(fn func(ctx context.Context)) {
+	lc.jobs.Add(1)
+	go func() {
+		defer lc.jobs.Done()
+		fn(lc.jobCtx)
+	}()
+}
+
+// afterDrain registers fn to run at shutdown once no analysis is left to
+// feed the jobs, e.g. to close the queue a job consumes so it can flush
+// and return.
+func (lc *lifecycle) afterDrain(fn func()) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.drained = append(lc.drained, fn)
+}
+
+// shutdown stops the background jobs and accepting connections, waits up
+// to timeout for in-flight analyses to finish, then cancels whatever is
+// left. Last, it gives the jobs flushTimeout to write what the analyses
+// queued.
+func (lc *lifecycle) shutdown(srv *http.Server, timeout time.Duration) {
+	lc.stopJobs()
+	lc.drain(srv, timeout)
+
+	lc.mu.Lock()
+	drained := lc.drained
+	lc.mu.Unlock()
+	for _, fn := range drained {
+		fn()
+	}
+	if !wait(&lc.jobs, flushTimeout) {
+		log.Printf("Background jobs still running after %v, exiting anyway", flushTimeout)
+	}
+}
+
+// drain waits for the analyses, cancelling them at the deadline.
+func (lc *lifecycle) drain(srv *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Drain deadline exceeded: %v", err)
+	}
+
+	// Tracked handlers may outlive srv.Shutdown, give them what is left
+	deadline, _ := ctx.Deadline()
+	if wait(&lc.wg, time.Until(deadline)) {
+		lc.cancel()
+		return
+	}
+
+	// Signal anything still running to abort
+	log.Printf("Cancelling in-flight analyses")
+	lc.cancel()
+	if !wait(&lc.wg, cancelGracePeriod) {
+		log.Printf("Analyses still running after %v, exiting anyway", cancelGracePeriod)
+	}
+}
+
+// wait blocks until everything wg tracks is done or timeout passes.
+func wait(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
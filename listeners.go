@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Most scheme:port listeners one analysis compares
+const maxListeners = 10
+
+// parseListeners validates "scheme:port" listeners such as "http:8080" and
+// "https:8443". A bare scheme uses its default port.
+func parseListeners(specs []string) ([]string, error) {
+	if len(specs) > maxListeners {
+		return nil, fmt.Errorf("at most %d listeners can be compared", maxListeners)
+	}
+	var listeners []string
+	for _, spec := range specs {
+		scheme, port, ok := strings.Cut(strings.ToLower(strings.TrimSpace(spec)), ":")
+		if !ok {
+			port = map[string]string{"http": "80", "https": "443"}[scheme]
+		}
+		if scheme != "http" && scheme != "https" {
+			return nil, fmt.Errorf("invalid listener %q, expected http:<port> or https:<port>", spec)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("invalid port in listener %q", spec)
+		}
+		listener := scheme + ":" + port
+		if !containsString(listeners, listener) {
+			listeners = append(listeners, listener)
+		}
+	}
+	return listeners, nil
+}
+
+// probeListeners requests the target on every listener from every
+// address, for hosts serving several ports behind one load balancer. The
+// result is a row per address with a cell per listener, in the order given.
+// Without addresses the host name is dialed.
+func probeListeners(ctx context.Context, target *url.URL, ips []string, tlsConfig *tls.Config, listeners []string) *ListenerMatrix {
+	if len(ips) == 0 {
+		ips = []string{target.Hostname()}
+	}
+	matrix := &ListenerMatrix{Listeners: listeners}
+	for _, ip := range ips {
+		matrix.Rows = append(matrix.Rows, ListenerRow{IP: ip, Results: make([]IPResult, len(listeners))})
+	}
+
+	for j, listener := range listeners {
+		scheme, port, _ := strings.Cut(listener, ":")
+		u := *target
+		u.Scheme = scheme
+		u.Host = net.JoinHostPort(target.Hostname(), port)
+
+		// probeIPs runs the addresses of one listener concurrently
+		for i, result := range probeIPs(ctx, &u, ips, tlsConfig, 1) {
+			matrix.Rows[i].Results[j] = result
+		}
+	}
+	return matrix
+}
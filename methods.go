@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// probeMethods sends OPTIONS, TRACE, HEAD and GET to the target and reports
+// which methods it allows and whether HEAD agrees with GET.
+func probeMethods(ctx context.Context, target *url.URL, tlsConfig *tls.Config) *MethodSupport {
+	result := &MethodSupport{}
+	client := newProbeClient(tlsConfig)
+	defer client.CloseIdleConnections()
+
+	options, _, err := doRequest(ctx, client, http.MethodOptions, target, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.OptionsStatus = options.StatusCode
+	result.Allow = options.Header.Get("Allow")
+	for _, method := range strings.Split(result.Allow, ",") {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			result.AllowedMethods = append(result.AllowedMethods, method)
+		}
+	}
+
+	// A server that echoes the request back has TRACE enabled, which can
+	// expose cookies and auth headers added by intermediaries
+	trace, body, err := doRequest(ctx, client, http.MethodTrace, target, nil)
+	if err == nil {
+		result.TraceStatus = trace.StatusCode
+		result.TraceEnabled = trace.StatusCode == http.StatusOK &&
+			(strings.HasPrefix(trace.Header.Get("Content-Type"), "message/http") ||
+				strings.HasPrefix(string(body), "TRACE "))
+	}
+
+	get, getBody, err := doRequest(ctx, client, http.MethodGet, target, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	head, headBody, err := doRequest(ctx, client, http.MethodHead, target, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.HeadStatus = head.StatusCode
+	result.HeadMismatches = compareHeadToGet(head, headBody, get, getBody)
+	result.HeadConsistent = len(result.HeadMismatches) == 0
+
+	return result
+}
+
+// compareHeadToGet lists where a HEAD response differs from GET (RFC 9110
+// section 9.3.2 requires the same headers, minus the body).
+func compareHeadToGet(head *http.Response, headBody []byte, get *http.Response, getBody []byte) []string {
+	var mismatches []string
+
+	if head.StatusCode != get.StatusCode {
+		mismatches = append(mismatches, fmt.Sprintf("Status %d for HEAD, %d for GET", head.StatusCode, get.StatusCode))
+	}
+	if len(headBody) > 0 {
+		mismatches = append(mismatches, fmt.Sprintf("HEAD returned a %d byte body", len(headBody)))
+	}
+	if h, g := head.Header.Get("Content-Type"), get.Header.Get("Content-Type"); h != g {
+		mismatches = append(mismatches, fmt.Sprintf("Content-Type %q for HEAD, %q for GET", h, g))
+	}
+	// Compressed or dynamic bodies can legitimately omit the length on HEAD
+	if cl := head.Header.Get("Content-Length"); cl != "" && get.Header.Get("Content-Encoding") == "" &&
+		cl != fmt.Sprint(len(getBody)) {
+		mismatches = append(mismatches, fmt.Sprintf("Content-Length %s for HEAD, GET body was %d bytes", cl, len(getBody)))
+	}
+	for _, name := range []string{"Connection", "Keep-Alive", "Cache-Control", "Server"} {
+		if h, g := head.Header.Get(name), get.Header.Get(name); h != g {
+			mismatches = append(mismatches, fmt.Sprintf("%s %q for HEAD, %q for GET", name, h, g))
+		}
+	}
+
+	return mismatches
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Points waiting for the sinks; when the sinks fall behind further, new
+// points are dropped rather than holding up analyses
+const metricsQueueSize = 1000
+
+// How often the process-wide counters, such as the DNS query sharing, are
+// written
+const metricsCounterInterval = time.Minute
+
+// metricPoint is one measurement at one time, in the InfluxDB data model.
+// Field values are float64, int64 or string.
+type metricPoint struct {
+	measurement string
+	tags        map[string]string
+	fields      map[string]interface{}
+	time        time.Time
+}
+
+// metricsSink stores points in a time-series database.
+type metricsSink interface {
+	name() string
+	write(ctx context.Context, points []metricPoint) error
+}
+
+// metricsExporter turns analysis events into points and hands them to
+// every sink from a lifecycle job, so the event bus never waits on a
+// database. Shutdown closes the queue once the analyses are done, and the
+// job writes what is left before it returns.
+type metricsExporter struct {
+	sinks []metricsSink
+
+	mu     sync.Mutex
+	queue  chan []metricPoint
+	closed bool
+}
+
+func newMetricsExporter(lc *lifecycle, sinks []metricsSink) *metricsExporter {
+	m := &metricsExporter{sinks: sinks, queue: make(chan []metricPoint, metricsQueueSize)}
+	lc.Go(func(context.Context) { m.run() })
+	lc.Go(m.sampleCounters)
+	lc.afterDrain(m.close)
+	return m
+}
+
+func (m *metricsExporter) record(e Event) {
+	if points := eventPoints(e); len(points) > 0 {
+		m.enqueue(points, e.Target)
+	}
+}
+
+// enqueue hands points to the sinks, dropping them when the queue is full
+// or closed. from names what they describe in the log.
+func (m *metricsExporter) enqueue(points []metricPoint, from string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		log.Printf("Metrics exporter stopped, dropped %d points of %s", len(points), from)
+		return
+	}
+	select {
+	case m.queue <- points:
+	default:
+		log.Printf("Metrics queue full, dropped %d points of %s", len(points), from)
+	}
+}
+
+// sampleCounters writes the counters every metricsCounterInterval, and a
+// last time when shutdown begins.
+func (m *metricsExporter) sampleCounters(ctx context.Context) {
+	ticker := time.NewTicker(metricsCounterInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.enqueue(counterPoints(time.Now()), "the counters")
+			return
+		case <-ticker.C:
+			m.enqueue(counterPoints(time.Now()), "the counters")
+		}
+	}
+}
+
+// counterPoints converts the process-wide counters into points: a
+// "dns_cache" point with the recursive queries sent (cache misses), those
+// answered by an identical query in flight, the negative cache hits and
+// its size. The counts are totals since startup.
+func counterPoints(now time.Time) []metricPoint {
+	dns := dnsLookups.stats()
+	return []metricPoint{{
+		measurement: "dns_cache",
+		fields: map[string]interface{}{
+			"cache_misses":  dns["queriesSent"],
+			"shared":        dns["queriesShared"],
+			"cache_hits":    dns["negativeCacheHits"],
+			"cache_entries": dns["negativeCacheEntries"],
+		},
+		time: now,
+	}}
+}
+
+// close ends the queue; analyses finishing later are not exported.
+func (m *metricsExporter) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+	}
+}
+
+func (m *metricsExporter) run() {
+	for points := range m.queue {
+		for _, sink := range m.sinks {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := sink.write(ctx, points); err != nil {
+				log.Printf("Failed to write metrics to %s: %v", sink.name(), err)
+			}
+			cancel()
+		}
+	}
+}
+
+// eventPoints converts a finished analysis into an "analysis" point with
+// its timings and keep-alive timeout, and an "ip_request" point per A
+// record. Failed analyses are recorded with status error.
+func eventPoints(e Event) []metricPoint {
+	switch e.Type {
+	case eventAnalysisFailed:
+		return []metricPoint{{
+			measurement: "analysis",
+			tags:        map[string]string{"target": e.Target, "status": runError},
+			fields:      map[string]interface{}{"duration_ms": e.DurationMs, "failed": int64(1)},
+			time:        e.Time,
+		}}
+	case eventAnalysisCompleted:
+	default:
+		return nil
+	}
+	r := e.Result
+	if r == nil {
+		return nil
+	}
+
+	status := runPassed
+	if r.Assertions != nil && r.Assertions.Status == "fail" {
+		status = runFailed
+	}
+	analysis := metricPoint{
+		measurement: "analysis",
+		tags:        map[string]string{"target": e.Target, "status": status, "tls_version": r.TLSVersion},
+		fields: map[string]interface{}{
+			"duration_ms":         e.DurationMs,
+			"request_duration_ms": r.RequestDuration,
+			"failed":              int64(0),
+		},
+		time: e.Time,
+	}
+	if timeout, err := strconv.Atoi(r.KeepAliveTimeout); err == nil {
+		analysis.fields["keepalive_timeout_s"] = int64(timeout)
+	}
+	if r.KeepAlive != nil && r.KeepAlive.Max != nil {
+		analysis.fields["keepalive_max"] = int64(*r.KeepAlive.Max)
+	}
+	if ttfb, ok := ttfbPercentile(r, 50); ok {
+		analysis.fields["ttfb_ms"], _ = strconv.ParseFloat(ttfb, 64)
+	}
+	if r.IdleClose != nil && r.IdleClose.ClosedAfterSeconds > 0 {
+		analysis.fields["idle_closed_after_s"] = r.IdleClose.ClosedAfterSeconds
+	}
+	points := []metricPoint{analysis}
+
+	for _, ip := range r.IPResults {
+		if ip.Error != "" {
+			continue
+		}
+		points = append(points, metricPoint{
+			measurement: "ip_request",
+			tags:        map[string]string{"target": e.Target, "ip": ip.IP},
+			fields: map[string]interface{}{
+				"connect_ms":       ip.ConnectMs,
+				"tls_handshake_ms": ip.TLSHandshakeMs,
+				"ttfb_ms":          ip.TTFBMs,
+				"total_ms":         ip.TotalMs,
+				"status_code":      int64(ip.StatusCode),
+			},
+			time: e.Time,
+		})
+	}
+	return points
+}
+
+// influxSink writes points with the InfluxDB line protocol to a write
+// endpoint, /api/v2/write?org=...&bucket=... for InfluxDB 2 and 3 or
+// /write?db=... for InfluxDB 1, at millisecond precision.
+type influxSink struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+func newInfluxSink(endpoint, token string) (*influxSink, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("-influx-url must be an http:// or https:// write URL")
+	}
+	q := u.Query()
+	q.Set("precision", "ms")
+	u.RawQuery = q.Encode()
+	return &influxSink{endpoint: u.String(), token: token, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+func (s *influxSink) name() string { return "InfluxDB" }
+
+func (s *influxSink) write(ctx context.Context, points []metricPoint) error {
+	var body bytes.Buffer
+	for _, p := range points {
+		writeLineProtocol(&body, p)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// writeLineProtocol appends p as one line, tags and fields sorted by key.
+// Empty tag values are left out, as the protocol doesn't allow them.
+func writeLineProtocol(b *bytes.Buffer, p metricPoint) {
+	b.WriteString(lineEscaper(false).Replace(p.measurement))
+	for _, k := range sortedKeys(p.tags) {
+		if p.tags[k] == "" {
+			continue
+		}
+		b.WriteByte(',')
+		b.WriteString(lineEscaper(true).Replace(k))
+		b.WriteByte('=')
+		b.WriteString(lineEscaper(true).Replace(p.tags[k]))
+	}
+	fields := make([]string, 0, len(p.fields))
+	for k := range p.fields {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	for i, k := range fields {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(lineEscaper(true).Replace(k))
+		b.WriteByte('=')
+		switch v := p.fields[k].(type) {
+		case int64:
+			b.WriteString(strconv.FormatInt(v, 10) + "i")
+		case float64:
+			b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		case string:
+			b.WriteString(`"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`)
+		}
+	}
+	b.WriteString(" " + strconv.FormatInt(p.time.UnixMilli(), 10) + "\n")
+}
+
+// lineEscaper escapes measurement names, or with key tag keys, tag values
+// and field keys.
+func lineEscaper(key bool) *strings.Replacer {
+	if key {
+		return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+	}
+	return strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
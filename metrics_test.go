@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteLineProtocol(t *testing.T) {
+	at := time.UnixMilli(1700000000123)
+	tests := []struct {
+		name string
+		p    metricPoint
+		want string
+	}{
+		{"sorted", metricPoint{measurement: "analysis",
+			tags:   map[string]string{"target": "example.com", "status": "pass"},
+			fields: map[string]interface{}{"ttfb_ms": 12.5, "keepalive_max": int64(100), "duration_ms": float64(300)}, time: at},
+			"analysis,status=pass,target=example.com duration_ms=300,keepalive_max=100i,ttfb_ms=12.5 1700000000123\n"},
+		{"empty tag left out", metricPoint{measurement: "ip",
+			tags:   map[string]string{"ip": "192.0.2.1", "region": ""},
+			fields: map[string]interface{}{"status_code": int64(200)}, time: at},
+			"ip,ip=192.0.2.1 status_code=200i 1700000000123\n"},
+		{"measurement escaped", metricPoint{measurement: "dns cache,v2=1",
+			fields: map[string]interface{}{"shared": int64(0)}, time: at},
+			`dns\ cache\,v2=1 shared=0i 1700000000123` + "\n"},
+		{"keys and tags escaped", metricPoint{measurement: "analysis",
+			tags:   map[string]string{"tar get": "a=b,c d"},
+			fields: map[string]interface{}{"a,b=c d": int64(1)}, time: at},
+			`analysis,tar\ get=a\=b\,c\ d a\,b\=c\ d=1i 1700000000123` + "\n"},
+		{"string field quoted", metricPoint{measurement: "analysis",
+			fields: map[string]interface{}{"error": `dial "x": C:\path`}, time: at},
+			`analysis error="dial \"x\": C:\\path" 1700000000123` + "\n"},
+		{"newline escaped", metricPoint{measurement: "analysis",
+			tags:   map[string]string{"target": "a\nb"},
+			fields: map[string]interface{}{"failed": int64(1)}, time: at},
+			`analysis,target=a\nb failed=1i 1700000000123` + "\n"},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		writeLineProtocol(&b, tt.p)
+		if got := b.String(); got != tt.want {
+			t.Errorf("%s:\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInfluxSinkWrite(t *testing.T) {
+	var query, auth, body string
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, auth = r.URL.RawQuery, r.Header.Get("Authorization")
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(status)
+		if status != http.StatusNoContent {
+			io.WriteString(w, "partial write: field type conflict\n")
+		}
+	}))
+	defer srv.Close()
+
+	sink, err := newInfluxSink(srv.URL+"/api/v2/write?org=ops&bucket=keepalive", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	points := []metricPoint{
+		{measurement: "a", fields: map[string]interface{}{"x": int64(1)}, time: time.UnixMilli(1)},
+		{measurement: "b", fields: map[string]interface{}{"y": 2.5}, time: time.UnixMilli(2)},
+	}
+	if err := sink.write(context.Background(), points); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(query, "precision=ms") || !strings.Contains(query, "bucket=keepalive") {
+		t.Errorf("query %q lacks the precision or the bucket", query)
+	}
+	if auth != "Token secret" {
+		t.Errorf("Authorization %q", auth)
+	}
+	if want := "a x=1i 1\nb y=2.5 2\n"; body != want {
+		t.Errorf("body %q, want %q", body, want)
+	}
+
+	status = http.StatusBadRequest
+	if err := sink.write(context.Background(), points); err == nil || !strings.Contains(err.Error(), "field type conflict") {
+		t.Errorf("error %v, want the response detail", err)
+	}
+
+	for _, endpoint := range []string{"", "influxdb:8086/write", "ftp://influxdb/write"} {
+		if _, err := newInfluxSink(endpoint, ""); err == nil {
+			t.Errorf("newInfluxSink(%q) accepted", endpoint)
+		}
+	}
+}
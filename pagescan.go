@@ -0,0 +1,377 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"html"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// htmlTag is a start tag with its attributes, names lower-cased and values
+// unescaped. For script and style, text is the element's content.
+type htmlTag struct {
+	name  string
+	attrs map[string]string
+	text  string
+}
+
+// scanTags hands the start tags of an HTML document to fn one at a time,
+// without building a tree or holding more than one tag, and stops when fn
+// returns false. Comments, end tags and doctypes are skipped, and the
+// content of script, style, textarea and title is read as text, so markup
+// inside it is not mistaken for tags.
+func scanTags(doc string, fn func(tag htmlTag) bool) {
+	for i := 0; i < len(doc); {
+		lt := strings.IndexByte(doc[i:], '<')
+		if lt < 0 {
+			break
+		}
+		i += lt
+		rest := doc[i:]
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			end := strings.Index(rest[4:], "-->")
+			if end < 0 {
+				return
+			}
+			i += 4 + end + 3
+			continue
+		case len(rest) < 2 || !isASCIILetter(rest[1]):
+			// End tags, doctypes, processing instructions and stray <
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				return
+			}
+			i += end + 1
+			continue
+		}
+
+		tag, n := parseStartTag(rest)
+		i += n
+		switch tag.name {
+		case "script", "style", "textarea", "title":
+			end := indexFold(doc[i:], "</"+tag.name)
+			if end < 0 {
+				end = len(doc) - i
+			}
+			tag.text = doc[i : i+end]
+			i += end
+		}
+		if !fn(tag) {
+			return
+		}
+	}
+}
+
+// parseStartTag reads "<name attr=value ...>" from the start of s and
+// returns the tag and the bytes consumed.
+func parseStartTag(s string) (htmlTag, int) {
+	i := 1
+	start := i
+	for i < len(s) && !isTagSpace(s[i]) && s[i] != '>' && s[i] != '/' {
+		i++
+	}
+	tag := htmlTag{name: strings.ToLower(s[start:i]), attrs: make(map[string]string)}
+
+	for i < len(s) {
+		for i < len(s) && (isTagSpace(s[i]) || s[i] == '/') {
+			i++
+		}
+		if i >= len(s) {
+			break
+		}
+		if s[i] == '>' {
+			return tag, i + 1
+		}
+
+		start := i
+		for i < len(s) && !isTagSpace(s[i]) && s[i] != '=' && s[i] != '>' && !(s[i] == '/' && i+1 < len(s) && s[i+1] == '>') {
+			i++
+		}
+		name := strings.ToLower(s[start:i])
+		for i < len(s) && isTagSpace(s[i]) {
+			i++
+		}
+		value := ""
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && isTagSpace(s[i]) {
+				i++
+			}
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				quote := s[i]
+				end := strings.IndexByte(s[i+1:], quote)
+				if end < 0 {
+					end = len(s) - i - 1
+				}
+				value = s[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				start := i
+				for i < len(s) && !isTagSpace(s[i]) && s[i] != '>' {
+					i++
+				}
+				value = s[start:i]
+			}
+		}
+		if _, ok := tag.attrs[name]; !ok && name != "" {
+			tag.attrs[name] = html.UnescapeString(value)
+		}
+	}
+	return tag, len(s)
+}
+
+func isTagSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// indexFold is strings.Index ignoring ASCII case in s, for a lower-case
+// substr. Unlike lower-casing s first, it doesn't copy the rest of the page
+// for every script and style.
+func indexFold(s, substr string) int {
+	for i := 0; ; i++ {
+		j := strings.IndexByte(s[i:], substr[0])
+		if j < 0 {
+			return -1
+		}
+		i += j
+		if len(s)-i < len(substr) {
+			return -1
+		}
+		if hasPrefixFold(s[i:], substr) {
+			return i
+		}
+	}
+}
+
+// hasPrefixFold is strings.HasPrefix ignoring ASCII case in s, for a
+// lower-case prefix.
+func hasPrefixFold(s, prefix string) bool {
+	if len(s) < len(prefix) {
+		return false
+	}
+	for i := 0; i < len(prefix); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// pageResource is a resource of the page with what its CSP check needs.
+type pageResource struct {
+	resource PageResource
+	content  string // Inline script or style text, or the handler code
+	nonce    string
+}
+
+// Script types the browser runs; others, such as application/ld+json or
+// templates, are data blocks no policy applies to
+var scriptTypes = map[string]bool{
+	"": true, "module": true, "text/javascript": true, "application/javascript": true,
+	"application/ecmascript": true, "text/ecmascript": true,
+}
+
+// The directive a <link rel=preload> is checked against, by its as value
+var preloadDirectives = map[string]string{
+	"script": "script-src-elem",
+	"style":  "style-src-elem",
+	"font":   "font-src",
+	"image":  "img-src",
+	"fetch":  "connect-src",
+	"audio":  "media-src",
+	"video":  "media-src",
+	"track":  "media-src",
+	"worker": "worker-src",
+}
+
+// Elements that may appear in <head>; any other start tag opens the body
+var headElements = map[string]bool{
+	"html": true, "head": true, "title": true, "base": true, "link": true, "meta": true,
+	"style": true, "script": true, "noscript": true, "template": true,
+}
+
+// metaPolicy is a <meta http-equiv> Content Security Policy of the page.
+type metaPolicy struct {
+	httpEquiv string // Lower-cased
+	content   string
+	position  int  // Resources of the page before it
+	inHead    bool // Only policies in <head> are honored
+}
+
+// scanPage lists the resources the HTML of page loads or runs, each with
+// the CSP directive it is checked against, and the policies delivered in
+// <meta> elements. URLs are resolved against the page, or its <base href>.
+// With maxResources above zero, it stops once the page has more than that
+// many, keeps the first maxResources and reports the page as truncated.
+func scanPage(doc string, page *url.URL, maxResources int) ([]pageResource, []metaPolicy, bool) {
+	var resources []pageResource
+	var metas []metaPolicy
+	base := page
+	inBody := false
+
+	inline := func(element, attr, kind, directive, content, nonce string) {
+		r := PageResource{Element: element, Attribute: attr, Kind: kind, Directive: directive, Snippet: snippet(content)}
+		if kind == "inline-script" || kind == "inline-style" {
+			sum := sha256.Sum256([]byte(content))
+			r.Hash = "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+		}
+		resources = append(resources, pageResource{resource: r, content: content, nonce: nonce})
+	}
+	add := func(element, attr, raw, directive, nonce string) {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			return
+		}
+		ref, err := base.Parse(raw)
+		if err != nil {
+			return
+		}
+		if ref.Scheme == "javascript" {
+			inline(element, attr, "javascript-url", "script-src-elem", raw, "")
+			return
+		}
+		ref.Fragment = ""
+		resources = append(resources, pageResource{
+			resource: PageResource{Element: element, Attribute: attr, Kind: "url", URL: ref.String(), Directive: directive},
+			nonce:    nonce,
+		})
+	}
+
+	truncated := false
+	scanTags(doc, func(tag htmlTag) bool {
+		a := tag.attrs
+		nonce := a["nonce"]
+		if !headElements[tag.name] {
+			inBody = true
+		}
+		switch tag.name {
+		case "meta":
+			switch equiv := strings.ToLower(strings.TrimSpace(a["http-equiv"])); equiv {
+			case "content-security-policy", "content-security-policy-report-only":
+				metas = append(metas, metaPolicy{httpEquiv: equiv, content: a["content"], position: len(resources), inHead: !inBody})
+			}
+		case "base":
+			if href, ok := a["href"]; ok {
+				add("base", "href", href, "base-uri", "")
+				if u, err := page.Parse(strings.TrimSpace(href)); err == nil && base == page {
+					base = u
+				}
+			}
+		case "script":
+			if !scriptTypes[strings.ToLower(strings.TrimSpace(a["type"]))] {
+				return true
+			}
+			if src, ok := a["src"]; ok {
+				add("script", "src", src, "script-src-elem", nonce)
+			} else if strings.TrimSpace(tag.text) != "" {
+				inline("script", "", "inline-script", "script-src-elem", tag.text, nonce)
+			}
+		case "style":
+			inline("style", "", "inline-style", "style-src-elem", tag.text, nonce)
+		case "link":
+			rels := strings.Fields(strings.ToLower(a["rel"]))
+			switch {
+			case containsString(rels, "stylesheet"):
+				add("link", "href", a["href"], "style-src-elem", nonce)
+			case containsString(rels, "icon") || containsString(rels, "apple-touch-icon"):
+				add("link", "href", a["href"], "img-src", "")
+			case containsString(rels, "manifest"):
+				add("link", "href", a["href"], "manifest-src", "")
+			case containsString(rels, "modulepreload"):
+				add("link", "href", a["href"], "script-src-elem", nonce)
+			case containsString(rels, "preload"):
+				if directive, ok := preloadDirectives[strings.ToLower(a["as"])]; ok {
+					add("link", "href", a["href"], directive, nonce)
+				}
+			}
+		case "img":
+			add("img", "src", a["src"], "img-src", "")
+			addSrcset(add, "img", a["srcset"], "img-src")
+		case "source":
+			// <source> in <picture> has srcset, in <video> and <audio> src
+			add("source", "src", a["src"], "media-src", "")
+			addSrcset(add, "source", a["srcset"], "img-src")
+		case "video", "audio":
+			add(tag.name, "src", a["src"], "media-src", "")
+			if tag.name == "video" {
+				add("video", "poster", a["poster"], "img-src", "")
+			}
+		case "track":
+			add("track", "src", a["src"], "media-src", "")
+		case "iframe", "frame":
+			add(tag.name, "src", a["src"], "frame-src", "")
+		case "object":
+			add("object", "data", a["data"], "object-src", "")
+		case "embed":
+			add("embed", "src", a["src"], "object-src", "")
+		case "form":
+			if action, ok := a["action"]; ok {
+				add("form", "action", action, "form-action", "")
+			} else {
+				add("form", "action", page.String(), "form-action", "")
+			}
+		case "a", "area":
+			if href := strings.TrimSpace(a["href"]); strings.HasPrefix(strings.ToLower(href), "javascript:") {
+				add(tag.name, "href", href, "script-src-elem", "")
+			}
+		}
+
+		names := make([]string, 0, len(a))
+		for name := range a {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			switch {
+			case strings.HasPrefix(name, "on") && len(name) > 2:
+				inline(tag.name, name, "event-handler", "script-src-attr", a[name], "")
+			case name == "style" && strings.TrimSpace(a[name]) != "":
+				inline(tag.name, name, "style-attribute", "style-src-attr", a[name], "")
+			}
+		}
+		if maxResources > 0 && len(resources) > maxResources {
+			resources = resources[:maxResources]
+			truncated = true
+			return false
+		}
+		return true
+	})
+	return resources, metas, truncated
+}
+
+// Longest snippet of inline code kept to identify it
+const maxSnippetLength = 80
+
+// snippet collapses the white space of inline code and shortens it.
+func snippet(code string) string {
+	code = strings.Join(strings.Fields(code), " ")
+	if len(code) > maxSnippetLength {
+		cut := maxSnippetLength
+		for cut > 0 && !utf8.RuneStart(code[cut]) {
+			cut--
+		}
+		code = code[:cut] + "..."
+	}
+	return code
+}
+
+// addSrcset adds each candidate URL of a srcset attribute.
+func addSrcset(add func(element, attr, raw, directive, nonce string), element, srcset, directive string) {
+	for _, candidate := range strings.Split(srcset, ",") {
+		if fields := strings.Fields(candidate); len(fields) > 0 {
+			add(element, "srcset", fields[0], directive, "")
+		}
+	}
+}
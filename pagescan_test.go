@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// benchPage builds a page of about 100KB: a head with meta tags, styles and
+// scripts, and a body of links, images and inline handlers.
+func benchPage() string {
+	var sb strings.Builder
+	sb.WriteString(`<!DOCTYPE html><html lang="en"><head><meta charset="utf-8">`)
+	sb.WriteString(`<meta http-equiv="Content-Security-Policy" content="default-src 'self'; img-src *">`)
+	sb.WriteString(`<title>Benchmark &amp; page</title>`)
+	sb.WriteString(`<link rel="stylesheet" href="/static/site.css" integrity="sha384-abc">`)
+	sb.WriteString(`<style>body { margin: 0 } .x > a { color: red }</style>`)
+	sb.WriteString(`<script src="https://cdn.example.net/lib.js" async></script>`)
+	sb.WriteString(`<script>var s = "<div>not a tag</div>"; if (a < b) { run() }</script></head><body>`)
+	for i := 0; i < 400; i++ {
+		sb.WriteString(`<!-- item --><div class="item" data-id="42"><a href="/articles/`)
+		sb.WriteString(strings.Repeat("x", 20))
+		sb.WriteString(`" onclick="track(this)">Read more</a>`)
+		sb.WriteString(`<img src="https://img.example.net/a.png" srcset="/a-2x.png 2x, /a-3x.png 3x" alt="">`)
+		sb.WriteString(`<p>Some text with an &lt;escaped&gt; tag.</p></div>`)
+	}
+	sb.WriteString(`</body></html>`)
+	return sb.String()
+}
+
+// BenchmarkScanTags covers walking the tags of a 100KB page. Budget: 2ms
+// per page.
+func BenchmarkScanTags(b *testing.B) {
+	doc := benchPage()
+	b.SetBytes(int64(len(doc)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		scanTags(doc, func(htmlTag) bool { return true })
+	}
+}
+
+// BenchmarkScanPage covers collecting the resources and meta policies of
+// the same page for the CSP analysis. Budget: 10ms per page.
+func BenchmarkScanPage(b *testing.B) {
+	doc := benchPage()
+	page, err := url.Parse("https://www.example.com/")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(doc)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		scanPage(doc, page, 0)
+	}
+}
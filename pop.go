@@ -0,0 +1,146 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Airport codes CDNs name their points of presence after
+//
+//go:embed data/pops.json
+var bundledPOPs []byte
+
+type popLocation struct {
+	City    string `json:"city"`
+	Country string `json:"country"`
+}
+
+var popLocations = func() map[string]popLocation {
+	var data struct {
+		POPs map[string]popLocation `json:"pops"`
+	}
+	if err := json.Unmarshal(bundledPOPs, &data); err != nil {
+		panic("invalid bundled POP data: " + err.Error())
+	}
+	return data.POPs
+}()
+
+var (
+	// CloudFront, e.g. FRA56-P1 or IAD89-C1
+	cloudFrontPOP = regexp.MustCompile(`^([A-Z]{3})[0-9]+-`)
+	// Fastly cache nodes, e.g. cache-fra-eddf8230020-FRA or cache-iad-kiad7000119-IAD
+	fastlyNode = regexp.MustCompile(`^cache-[a-z0-9-]+-([A-Z]{3})$`)
+	// Akamai edge servers name themselves after their address in X-Cache,
+	// e.g. "TCP_HIT from a23-45-67-89.deploy.akamaitechnologies.com"
+	akamaiNode = regexp.MustCompile(`from (a(\d+)-(\d+)-(\d+)-(\d+)\.[a-z0-9.-]*akamai[a-z0-9.-]*)`)
+)
+
+// detectCDN identifies the CDNs that served the response from their debug
+// headers and decodes which edge locations handled it.
+func detectCDN(headers http.Header) *CDNDetection {
+	var pops []EdgePOP
+
+	if ray := headers.Get("CF-Ray"); ray != "" {
+		if i := strings.LastIndex(ray, "-"); i >= 0 {
+			pops = append(pops, newEdgePOP("cloudflare", "CF-Ray", ray, ray[i+1:]))
+		}
+	}
+
+	if pop := headers.Get("X-Amz-Cf-Pop"); pop != "" {
+		code := ""
+		if m := cloudFrontPOP.FindStringSubmatch(pop); m != nil {
+			code = m[1]
+		}
+		pops = append(pops, newEdgePOP("cloudfront", "X-Amz-Cf-Pop", pop, code))
+	}
+
+	// Fastly lists the shield first and the edge nearest the client last
+	nodes := strings.Split(headers.Get("X-Served-By"), ",")
+	for i, node := range nodes {
+		node = strings.TrimSpace(node)
+		if m := fastlyNode.FindStringSubmatch(node); m != nil {
+			pop := newEdgePOP("fastly", "X-Served-By", node, m[1])
+			if len(nodes) > 1 && i < len(nodes)-1 {
+				pop.Role = "shield"
+			}
+			pops = append(pops, pop)
+		}
+	}
+
+	for _, header := range []string{"X-Cache", "X-Cache-Remote"} {
+		if m := akamaiNode.FindStringSubmatch(headers.Get(header)); m != nil {
+			pop := EdgePOP{Provider: "akamai", Header: header, Node: m[1], NodeIP: strings.Join(m[2:6], ".")}
+			if header == "X-Cache-Remote" {
+				pop.Role = "parent"
+			}
+			pops = append(pops, pop)
+		}
+	}
+	if edgescape := headers.Get("X-Akamai-Edgescape"); edgescape != "" {
+		pops = append(pops, akamaiEdgescape(edgescape))
+	}
+
+	providers := cdnProviders(headers, pops)
+	if len(providers) == 0 && len(pops) == 0 {
+		return nil
+	}
+	return &CDNDetection{Providers: providers, POPs: pops}
+}
+
+func newEdgePOP(provider, header, node, code string) EdgePOP {
+	pop := EdgePOP{Provider: provider, Header: header, Node: node, Code: strings.ToUpper(code)}
+	if location, ok := popLocations[pop.Code]; ok {
+		pop.City, pop.Country = location.City, location.Country
+	}
+	return pop
+}
+
+// akamaiEdgescape reads the geolocation Akamai adds for the client, which
+// approximates where the serving edge is. It is only sent with Akamai's
+// debug Pragma headers or when the property forwards it.
+func akamaiEdgescape(value string) EdgePOP {
+	pop := EdgePOP{Provider: "akamai", Header: "X-Akamai-Edgescape", Node: value}
+	for _, field := range strings.Split(value, ",") {
+		key, val, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch key {
+		case "city":
+			pop.City = strings.ReplaceAll(val, "+", " ")
+		case "country_code":
+			pop.Country = val
+		}
+	}
+	return pop
+}
+
+// cdnProviders names the CDNs seen, from the POP headers and the
+// providers' other telltale headers.
+func cdnProviders(headers http.Header, pops []EdgePOP) []string {
+	var providers []string
+	add := func(provider string) {
+		for _, p := range providers {
+			if p == provider {
+				return
+			}
+		}
+		providers = append(providers, provider)
+	}
+	for _, pop := range pops {
+		add(pop.Provider)
+	}
+	if headers.Get("CF-Cache-Status") != "" {
+		add("cloudflare")
+	}
+	if headers.Get("X-Amz-Cf-Id") != "" {
+		add("cloudfront")
+	}
+	if headers.Get("Fastly-Debug-Digest") != "" {
+		add("fastly")
+	}
+	if checkAkamai(headers) {
+		add("akamai")
+	}
+	return providers
+}
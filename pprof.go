@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Longest CPU profile one request may take
+const maxCPUProfile = 5 * time.Minute
+
+// servePprof serves runtime profiles for go tool pprof, and counters at
+// /debug/vars, on addr, a listener of its own so they are never reachable
+// on the public port. This uses runtime/pprof rather than net/http/pprof,
+// and no expvar, whose imports alone would add their handlers to
+// http.DefaultServeMux.
+func servePprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprofHandler)
+	mux.HandleFunc("/debug/pprof/profile", cpuProfileHandler)
+	mux.HandleFunc("/debug/vars", debugVarsHandler)
+	log.Printf("Profiling endpoints at http://%s/debug/pprof/", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("Failed to serve profiling endpoints: %v", err)
+	}
+}
+
+// pprofHandler lists the profiles, or writes the one named by the path:
+// heap, allocs, goroutine, block, mutex or threadcreate. ?debug=1 or 2
+// gives text instead of the protobuf format, ?gc=1 collects garbage
+// before a heap profile.
+func pprofHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/")
+	if name == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<html><body><ul>")
+		for _, p := range pprof.Profiles() {
+			fmt.Fprintf(w, `<li><a href="%[1]s?debug=1">%[1]s</a> (%[2]d)</li>`, html.EscapeString(p.Name()), p.Count())
+		}
+		fmt.Fprint(w, `<li><a href="profile?seconds=30">profile</a> (30 s of CPU)</li></ul></body></html>`)
+		return
+	}
+	p := pprof.Lookup(name)
+	if p == nil {
+		http.Error(w, "Unknown profile", http.StatusNotFound)
+		return
+	}
+	debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+	if name == "heap" && r.URL.Query().Get("gc") == "1" {
+		runtime.GC()
+	}
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	}
+	p.WriteTo(w, debug)
+}
+
+// cpuProfileHandler records a CPU profile for ?seconds= (30 by default).
+func cpuProfileHandler(w http.ResponseWriter, r *http.Request) {
+	seconds := 30
+	if s := r.URL.Query().Get("seconds"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || time.Duration(n)*time.Second > maxCPUProfile {
+			http.Error(w, "Invalid seconds", http.StatusBadRequest)
+			return
+		}
+		seconds = n
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+	if err := pprof.StartCPUProfile(w); err != nil {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "Could not start the CPU profile: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	select {
+	case <-time.After(time.Duration(seconds) * time.Second):
+	case <-r.Context().Done():
+	}
+	pprof.StopCPUProfile()
+}
+
+// debugVarsHandler reports internal counters as JSON, in the layout of
+// expvar's /debug/vars so the same collectors can read them.
+func debugVarsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"dns": dnsLookups.stats(),
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Largest response body the follow-up probes read
+const maxProbeBodySize = 10 * 1024 * 1024
+
+// How long a probe client keeps an idle connection for its next request
+const probeIdleTimeout = 5 * time.Second
+
+// newProbeClient returns a client for follow-up probes that reports
+// redirects instead of following them. Its requests share keep-alive
+// connections; callers close them with CloseIdleConnections once done.
+func newProbeClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+			DialContext:     auditDial,
+			IdleConnTimeout: probeIdleTimeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse // Redirects are reported, not followed
+		},
+	}
+}
+
+// doRequest sends a bodiless request with the given extra headers and
+// returns the response with its body.
+func doRequest(ctx context.Context, client *http.Client, method string, target *url.URL, header http.Header) (*http.Response, []byte, error) {
+	return doRequestLimit(ctx, client, method, target, header, maxProbeBodySize)
+}
+
+// doRequestLimit is doRequest reading at most limit bytes of the body.
+func doRequestLimit(ctx context.Context, client *http.Client, method string, target *url.URL, header http.Header, limit int64) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	markProbe(req)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+type runIDKey struct{}
+
+// runIDHandler gives each analysis a run ID. It is sent to the caller in
+// the response and to targets in the -run-id-header header, so a target
+// owner can match analyzer traffic in their logs to a run.
+func runIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := newAuditID()
+		if cfg.RunIDHeader != "" {
+			w.Header().Set(cfg.RunIDHeader, id)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), runIDKey{}, id)))
+	})
+}
+
+// runID returns the run ID carried by ctx, or "" outside an analysis.
+func runID(ctx context.Context) string {
+	id, _ := ctx.Value(runIDKey{}).(string)
+	return id
+}
+
+// markProbe marks a request to the target with the run ID and counts it
+// against the analysis budget.
+func markProbe(req *http.Request) {
+	if b := budgetFrom(req.Context()); b != nil {
+		b.countRequest()
+	}
+	if id := runID(req.Context()); id != "" && cfg.RunIDHeader != "" {
+		req.Header.Set(cfg.RunIDHeader, id)
+	}
+}
+
+// markRawProbe is markProbe for hand-built requests: the header
+// line including CRLF, or "" when there is nothing to send.
+func markRawProbe(ctx context.Context) string {
+	if b := budgetFrom(ctx); b != nil {
+		b.countRequest()
+	}
+	if id := runID(ctx); id != "" && cfg.RunIDHeader != "" {
+		return cfg.RunIDHeader + ": " + id + "\r\n"
+	}
+	return ""
+}
+
+// probeIPsHandler publishes the addresses probes leave from, so targets
+// can allowlist them.
+func probeIPsHandler(w http.ResponseWriter, r *http.Request) {
+	info := struct {
+		IPs    []string `json:"ips"`
+		Header string   `json:"header,omitempty"` // Carries the run ID on every probe
+	}{IPs: []string{}, Header: cfg.RunIDHeader}
+	for _, ip := range strings.Split(cfg.EgressIPs, ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			info.IPs = append(info.IPs, ip)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
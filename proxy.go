@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How long a proxy may take to set up a tunnel when the context has no
+// earlier deadline
+const proxyHandshakeTimeout = 30 * time.Second
+
+// Longest CONNECT response header read
+const maxConnectResponse = 8192
+
+// proxyTunnel dials the TCP connections of one analysis through an HTTP
+// CONNECT or SOCKS5 proxy. Each connection is timed in two stages: reaching
+// the proxy, and the tunnel handshake, which completes once the proxy has
+// connected to the target. Comparing them tells a slow proxy from a slow
+// target.
+type proxyTunnel struct {
+	url *url.URL
+
+	mu       sync.Mutex
+	connect  []float64 // Milliseconds to reach the proxy
+	tunnel   []float64 // Milliseconds from there until the tunnel was up
+	failures []string
+}
+
+type proxyKey struct{}
+
+// newProxyTunnel parses the request's proxy URL, falling back to -proxy.
+// It returns nil when neither is set.
+func newProxyTunnel(raw string) (*proxyTunnel, error) {
+	if raw == "" {
+		raw = cfg.Proxy
+	}
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q", raw)
+	}
+	switch u.Scheme {
+	case "http":
+		if u.Port() == "" {
+			u.Host = net.JoinHostPort(u.Hostname(), "8080")
+		}
+	case "socks5", "socks5h":
+		if u.Port() == "" {
+			u.Host = net.JoinHostPort(u.Hostname(), "1080")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, use http or socks5", u.Scheme)
+	}
+	return &proxyTunnel{url: u}, nil
+}
+
+// withProxy routes the TCP connections dialed with ctx through p.
+func withProxy(ctx context.Context, p *proxyTunnel) context.Context {
+	if p == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, proxyKey{}, p)
+}
+
+// withoutProxy lets DNS and other infrastructure traffic go direct.
+func withoutProxy(ctx context.Context) context.Context {
+	return context.WithValue(ctx, proxyKey{}, (*proxyTunnel)(nil))
+}
+
+func proxyFrom(ctx context.Context) *proxyTunnel {
+	p, _ := ctx.Value(proxyKey{}).(*proxyTunnel)
+	return p
+}
+
+// carries reports whether a dial on network goes through the proxy. Both
+// proxy types only tunnel TCP, so UDP, including DNS, goes direct.
+func (p *proxyTunnel) carries(network string) bool {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return true
+	}
+	return false
+}
+
+// dial connects to the proxy with dialer and opens a tunnel to addr.
+func (p *proxyTunnel) dial(ctx context.Context, dialer *net.Dialer, addr string) (net.Conn, error) {
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", p.url.Host)
+	if err != nil {
+		p.fail(fmt.Sprintf("proxy %s: %v", p.url.Host, err))
+		return nil, fmt.Errorf("failed to reach proxy %s: %v", p.url.Host, err)
+	}
+	connectMs := millisecondsSince(start)
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(proxyHandshakeTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	tunnelStart := time.Now()
+	if p.url.Scheme == "http" {
+		err = p.httpConnect(conn, addr)
+	} else {
+		err = p.socks5Connect(conn, addr)
+	}
+	if err != nil {
+		conn.Close()
+		p.fail(fmt.Sprintf("%s: %v", addr, err))
+		return nil, fmt.Errorf("proxy tunnel to %s failed: %v", addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	p.mu.Lock()
+	p.connect = append(p.connect, connectMs)
+	p.tunnel = append(p.tunnel, millisecondsSince(tunnelStart))
+	p.mu.Unlock()
+	return conn, nil
+}
+
+func (p *proxyTunnel) fail(reason string) {
+	p.mu.Lock()
+	p.failures = append(p.failures, reason)
+	p.mu.Unlock()
+}
+
+// httpConnect asks an HTTP proxy for a tunnel. The response is read a byte
+// at a time so nothing the target sends afterwards is consumed.
+func (p *proxyTunnel) httpConnect(conn net.Conn, addr string) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if p.url.User != nil {
+		password, _ := p.url.User.Password()
+		credentials := p.url.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+
+	var header []byte
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(header, []byte("\r\n\r\n")) {
+		if len(header) >= maxConnectResponse {
+			return errors.New("CONNECT response header too long")
+		}
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return err
+		}
+		header = append(header, b[0])
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(header)), req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy answered CONNECT with %s", resp.Status)
+	}
+	return nil
+}
+
+// SOCKS5 reply codes, RFC 1928 section 6
+var socks5Replies = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5Connect negotiates a SOCKS5 CONNECT, with username and password
+// authentication (RFC 1929) when the proxy URL has credentials. Host names
+// are passed to the proxy to resolve.
+func (p *proxyTunnel) socks5Connect(conn net.Conn, addr string) error {
+	methods := []byte{0} // No authentication
+	if p.url.User != nil {
+		methods = []byte{2} // Username and password
+	}
+	if _, err := conn.Write(append([]byte{5, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 || reply[1] == 0xff {
+		return errors.New("SOCKS5 proxy accepted none of the offered authentication methods")
+	}
+	if reply[1] == 2 {
+		user := p.url.User.Username()
+		password, _ := p.url.User.Password()
+		auth := append([]byte{1, byte(len(user))}, user...)
+		auth = append(append(auth, byte(len(password))), password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.New("SOCKS5 authentication failed")
+		}
+	}
+
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return fmt.Errorf("invalid port in %s", addr)
+	}
+	req := []byte{5, 1, 0} // CONNECT
+	if ip := net.ParseIP(host); ip == nil {
+		req = append(append(req, 3, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 1), ip4...)
+	} else {
+		req = append(append(req, 4), ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// Version, reply, reserved, address type, then the bound address
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		if reason, ok := socks5Replies[head[1]]; ok {
+			return errors.New(reason)
+		}
+		return fmt.Errorf("SOCKS5 reply %d", head[1])
+	}
+	var boundLen int
+	switch head[3] {
+	case 1:
+		boundLen = net.IPv4len
+	case 4:
+		boundLen = net.IPv6len
+	case 3:
+		if _, err := io.ReadFull(conn, head[:1]); err != nil {
+			return err
+		}
+		boundLen = int(head[0])
+	default:
+		return fmt.Errorf("unknown SOCKS5 address type %d", head[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, boundLen+2))
+	return err
+}
+
+// info summarizes where the connection time went.
+func (p *proxyTunnel) info() *ProxyInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	u := *p.url
+	u.User = nil // Keep credentials out of results
+	info := &ProxyInfo{
+		Proxy:       u.String(),
+		Connections: len(p.connect),
+		ConnectMs:   latencyStats(p.connect),
+		TunnelMs:    latencyStats(p.tunnel),
+		Failures:    append([]string(nil), p.failures...),
+	}
+	if info.ConnectMs != nil && info.TunnelMs != nil {
+		info.Summary = fmt.Sprintf("Reaching the proxy took a median %.1f ms, opening the tunnel to the target a further %.1f ms",
+			info.ConnectMs.Median, info.TunnelMs.Median)
+	}
+	return info
+}
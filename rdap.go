@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long RDAP answers are reused; registration data rarely changes
+const rdapCacheTTL = 24 * time.Hour
+const rdapNegativeCacheTTL = time.Hour
+
+var rdapClient = &http.Client{Timeout: 10 * time.Second, Transport: auditedTransport}
+
+type rdapCacheEntry struct {
+	info    *RegistrationInfo
+	expires time.Time
+}
+
+var (
+	rdapCacheMu sync.Mutex
+	rdapCache   = map[string]rdapCacheEntry{}
+)
+
+// lookupRegistration returns RDAP registration data for the domain that
+// owns host. Without a public suffix list the registered domain is found by
+// trying the last two labels, then three (for names like example.co.uk).
+func lookupRegistration(ctx context.Context, host string) *RegistrationInfo {
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(host), "."), ".")
+	if len(labels) < 2 {
+		return &RegistrationInfo{Error: "not a registrable domain"}
+	}
+
+	var info *RegistrationInfo
+	for n := 2; n <= len(labels) && n <= 3; n++ {
+		domain := strings.Join(labels[len(labels)-n:], ".")
+		info = cachedRegistration(ctx, domain)
+		if info.Error == "" {
+			return info
+		}
+	}
+	return info
+}
+
+func cachedRegistration(ctx context.Context, domain string) *RegistrationInfo {
+	rdapCacheMu.Lock()
+	entry, ok := rdapCache[domain]
+	rdapCacheMu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		cached := *entry.info
+		cached.Cached = true
+		return &cached
+	}
+
+	info, err := fetchRegistration(ctx, domain)
+	ttl := rdapCacheTTL
+	if err != nil {
+		info = &RegistrationInfo{Domain: domain, Error: err.Error()}
+		ttl = rdapNegativeCacheTTL
+		if ctx.Err() != nil {
+			return info // Don't remember cancelled lookups
+		}
+	}
+
+	rdapCacheMu.Lock()
+	rdapCache[domain] = rdapCacheEntry{info: info, expires: time.Now().Add(ttl)}
+	rdapCacheMu.Unlock()
+
+	return info
+}
+
+// rdapDomain is the subset of an RFC 9083 domain object we report
+type rdapDomain struct {
+	LDHName string   `json:"ldhName"`
+	Status  []string `json:"status"`
+	Events  []struct {
+		Action string `json:"eventAction"`
+		Date   string `json:"eventDate"`
+	} `json:"events"`
+	Entities []struct {
+		Roles      []string        `json:"roles"`
+		VCardArray json.RawMessage `json:"vcardArray"`
+	} `json:"entities"`
+	Nameservers []struct {
+		LDHName string `json:"ldhName"`
+	} `json:"nameservers"`
+}
+
+func fetchRegistration(ctx context.Context, domain string) (*RegistrationInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(cfg.RDAPURL, "/")+"/domain/"+domain, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+
+	resp, err := rdapClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("RDAP lookup for %s returned %s", domain, resp.Status)
+	}
+
+	var rd rdapDomain
+	if err := json.NewDecoder(resp.Body).Decode(&rd); err != nil {
+		return nil, fmt.Errorf("invalid RDAP response: %v", err)
+	}
+
+	info := &RegistrationInfo{
+		Domain: strings.ToLower(rd.LDHName),
+		Status: rd.Status,
+	}
+	if info.Domain == "" {
+		info.Domain = domain
+	}
+
+	for _, event := range rd.Events {
+		switch event.Action {
+		case "registration":
+			info.Created = event.Date
+		case "expiration":
+			info.Expires = event.Date
+		case "last changed":
+			info.Updated = event.Date
+		}
+	}
+
+	for _, entity := range rd.Entities {
+		for _, role := range entity.Roles {
+			if role == "registrar" {
+				info.Registrar = vcardFullName(entity.VCardArray)
+			}
+		}
+	}
+
+	for _, ns := range rd.Nameservers {
+		info.Nameservers = append(info.Nameservers, strings.ToLower(ns.LDHName))
+	}
+
+	return info, nil
+}
+
+// vcardFullName pulls the "fn" property out of a jCard array:
+// ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "Name"]]]
+func vcardFullName(raw json.RawMessage) string {
+	var card []interface{}
+	if err := json.Unmarshal(raw, &card); err != nil || len(card) < 2 {
+		return ""
+	}
+	properties, ok := card[1].([]interface{})
+	if !ok {
+		return ""
+	}
+	for _, p := range properties {
+		prop, ok := p.([]interface{})
+		if !ok || len(prop) < 4 || prop[0] != "fn" {
+			continue
+		}
+		if name, ok := prop[3].(string); ok {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// How long headless Chrome lets the page's scripts run before the DOM is
+// dumped, and how long the whole rendering may take
+const (
+	renderScriptBudget = 5 * time.Second
+	renderTimeout      = 30 * time.Second
+)
+
+// Most bytes of Chrome's error output kept for the error message
+const maxRenderStderr = 64 * 1024
+
+// renderPage loads target in headless Chrome and returns the DOM after the
+// page's scripts ran, cut to -csp-max-page-bytes, and whether it was cut.
+// Chrome connects on its own, outside the budget and the source address,
+// but through the analysis's proxy when it has one.
+func renderPage(ctx context.Context, target *url.URL, tlsConfig *tls.Config) (string, bool, error) {
+	args := []string{
+		"--headless",
+		"--disable-gpu",
+		"--no-first-run",
+		"--disable-extensions",
+		fmt.Sprintf("--virtual-time-budget=%d", renderScriptBudget.Milliseconds()),
+		"--dump-dom",
+	}
+	if tlsConfig.InsecureSkipVerify {
+		args = append(args, "--ignore-certificate-errors")
+	}
+	if proxy := proxyFrom(ctx); proxy != nil {
+		// Chrome only takes proxy credentials interactively
+		if proxy.url.User != nil {
+			return "", false, fmt.Errorf("headless Chrome cannot authenticate to the proxy %s", proxy.info().Proxy)
+		}
+		args = append(args, "--proxy-server="+proxy.url.String())
+	}
+	args = append(args, target.String())
+
+	ctx, cancel := context.WithTimeout(ctx, renderTimeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{max: cfg.CSPMaxPageBytes}
+	stderr := &limitedBuffer{max: maxRenderStderr}
+	cmd := exec.CommandContext(ctx, cfg.ChromePath, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", false, fmt.Errorf("rendering timed out after %v", renderTimeout)
+		}
+		if detail := lastLine(stderr.buf.String()); detail != "" {
+			return "", false, fmt.Errorf("headless Chrome failed: %v: %s", err, detail)
+		}
+		return "", false, fmt.Errorf("headless Chrome failed: %v", err)
+	}
+	return stdout.buf.String(), stdout.truncated, nil
+}
+
+// limitedBuffer keeps the first max bytes written to it and discards the
+// rest, while still accepting them so the writer is not cut off.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int64
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	room := b.max - int64(b.buf.Len())
+	if int64(len(p)) > room {
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+// renderedResources renders the page and compares what its DOM loads with
+// what the static HTML does. Resources only the rendered DOM has are
+// returned, to be checked against the policies like the others.
+func renderedResources(ctx context.Context, target *url.URL, tlsConfig *tls.Config, static []pageResource) (*CSPRendering, []pageResource) {
+	rendering := &CSPRendering{StaticResources: len(static)}
+	if cfg.ChromePath == "" {
+		rendering.Error = "Rendering is disabled on this server"
+		return rendering, nil
+	}
+	dom, truncated, err := renderPage(ctx, target, tlsConfig)
+	if err != nil {
+		rendering.Error = err.Error()
+		return rendering, nil
+	}
+	rendering.Truncated = truncated
+
+	rendered, _, _ := scanPage(dom, target, cfg.CSPMaxResources)
+	rendering.RenderedResources = len(rendered)
+	inStatic := make(map[string]bool, len(static))
+	for _, r := range static {
+		inStatic[resourceKey(r.resource)] = true
+	}
+	inRendered := make(map[string]bool, len(rendered))
+	var added []pageResource
+	for _, r := range rendered {
+		key := resourceKey(r.resource)
+		inRendered[key] = true
+		if !inStatic[key] {
+			added = append(added, r)
+			rendering.Added = append(rendering.Added, r.resource)
+		}
+	}
+	for _, r := range static {
+		if !inRendered[resourceKey(r.resource)] {
+			rendering.Removed = append(rendering.Removed, r.resource)
+		}
+	}
+	return rendering, added
+}
+
+// resourceKey identifies a resource across the static and rendered page:
+// by URL, or by hash or code for inline content.
+func resourceKey(r PageResource) string {
+	switch {
+	case r.URL != "":
+		return r.Directive + " " + r.URL
+	case r.Hash != "":
+		return r.Directive + " " + r.Hash
+	}
+	return r.Directive + " " + r.Element + " " + r.Attribute + " " + r.Snippet
+}
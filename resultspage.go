@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Functions the page templates may call
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// resultsPage is what index.html and the section templates render.
+type resultsPage struct {
+	Request analysisRequest
+	Result  *response
+	TCP     *TCPResults // Parsed from Result.TCPResults
+	Error   string
+}
+
+// parsePageTemplates loads index.html and the section templates. They are
+// read on every request like the home page, so edits show without a
+// restart.
+func parsePageTemplates() (*template.Template, error) {
+	tmpl, err := template.New("index.html").Funcs(templateFuncs).ParseFiles(filepath.Join(publicDir, "index.html"))
+	if err != nil {
+		return nil, err
+	}
+	return tmpl.ParseGlob(filepath.Join(publicDir, "templates", "*.html"))
+}
+
+// resultsHandler runs an analysis from the form on the home page and
+// renders the results server-side. htmx requests (HX-Request) get only
+// the report to swap into the page; others get the whole page, so results
+// can be linked to and work without JavaScript.
+func resultsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tmpl, err := parsePageTemplates()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	page := resultsPage{Request: analysisRequest{
+		Domain:   strings.TrimSpace(query.Get("domain")),
+		RDAP:     query.Get("rdap") != "",
+		TLSScore: query.Get("tlsScore") != "",
+		Topology: query.Get("topology") != "",
+	}}
+	if page.Request.Domain == "" {
+		page.Error = "Enter a domain to analyze"
+	} else if page.Result, err = runAnalysis(r.Context(), page.Request); err != nil {
+		page.Error = err.Error()
+	} else if page.Result.TCPResults != "" {
+		page.TCP = &TCPResults{}
+		if err := json.Unmarshal([]byte(page.Result.TCPResults), page.TCP); err != nil {
+			page.TCP = &TCPResults{Error: "Failed to parse TCP Results"}
+		}
+	}
+
+	name := "index.html"
+	if r.Header.Get("HX-Request") == "true" {
+		name = "report"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.ExecuteTemplate(w, name, page); err != nil {
+		log.Printf("Rendering results for %s: %v", page.Request.Domain, err)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Results waiting to be stored; when the store falls behind further, new
+// results are dropped rather than holding up analyses
+const resultQueueSize = 100
+
+// resultStore keeps the full result of every analysis outside the process,
+// so any instance can serve it. Keys are relative, /-separated names; get
+// returns the body and when it was stored, or an error wrapping
+// os.ErrNotExist for a missing key.
+type resultStore interface {
+	name() string
+	put(ctx context.Context, key string, body []byte, contentType string) error
+	get(ctx context.Context, key string) ([]byte, time.Time, error)
+}
+
+// results is nil unless -result-store is set.
+var results resultStore
+
+// dirStore keeps results as files below dir, e.g. on a shared volume.
+type dirStore struct {
+	dir string
+}
+
+func (s dirStore) name() string { return "directory " + s.dir }
+
+func (s dirStore) put(_ context.Context, key string, body []byte, _ string) error {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	return os.WriteFile(path, body, 0640)
+}
+
+func (s dirStore) get(_ context.Context, key string) ([]byte, time.Time, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	body, err := os.ReadFile(path)
+	return body, info.ModTime(), err
+}
+
+// newResultStore returns the store -result-store selects, nil when none.
+func newResultStore() (resultStore, error) {
+	switch cfg.ResultStore {
+	case "":
+		return nil, nil
+	case "dir":
+		if cfg.ResultDir == "" {
+			return nil, errors.New("-result-store dir needs -result-dir")
+		}
+		return dirStore{dir: cfg.ResultDir}, nil
+	case "s3":
+		if exportS3 == nil {
+			return nil, errors.New("-result-store s3 needs -s3-bucket")
+		}
+		return exportS3, nil
+	}
+	return nil, errors.New("-result-store must be dir or s3")
+}
+
+// resultKey names the stored result of a completed analysis by day, run
+// ID and target, or is "" when the event has no run ID to name it by. The
+// run history records the same key, which makes it the index.
+func resultKey(e Event) string {
+	if e.Type != eventAnalysisCompleted || e.Result == nil || e.RunID == "" {
+		return ""
+	}
+	target := strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, e.Target)
+	return "results/" + e.Time.Format("2006/01/02") + "/" + e.RunID + "/" + target + ".json"
+}
+
+// resultWriter stores completed results from a lifecycle job, so the
+// event bus never waits on the store. Shutdown closes the queue once the
+// analyses are done, and the job stores what is left before it returns.
+type resultWriter struct {
+	store resultStore
+
+	mu     sync.Mutex
+	queue  chan Event
+	closed bool
+}
+
+func newResultWriter(lc *lifecycle, store resultStore) *resultWriter {
+	w := &resultWriter{store: store, queue: make(chan Event, resultQueueSize)}
+	lc.Go(func(context.Context) { w.run() })
+	lc.afterDrain(w.close)
+	return w
+}
+
+func (w *resultWriter) record(e Event) {
+	if resultKey(e) == "" {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		log.Printf("Result writer stopped, dropped the result of %s", e.Target)
+		return
+	}
+	select {
+	case w.queue <- e:
+	default:
+		log.Printf("Result queue full, dropped the result of %s", e.Target)
+	}
+}
+
+// close ends the queue; analyses finishing later are not stored.
+func (w *resultWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+}
+
+func (w *resultWriter) run() {
+	for e := range w.queue {
+		body, err := json.Marshal(e.Result)
+		if err != nil {
+			log.Printf("Failed to encode the result of %s: %v", e.Target, err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		if err := w.store.put(ctx, resultKey(e), body, "application/json"); err != nil {
+			log.Printf("Failed to store the result of %s in %s: %v", e.Target, w.store.name(), err)
+		}
+		cancel()
+	}
+}
+
+// storedResultHandler serves the result stored under a key from the run
+// history at /api/{key}, which starts with results/. Stored results don't
+// change, so pollers revalidate with If-None-Match or If-Modified-Since and
+// get a 304 rather than the result again.
+func storedResultHandler(w http.ResponseWriter, r *http.Request) {
+	if results == nil {
+		http.Error(w, "Result store is disabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/api/")
+	if !strings.HasPrefix(key, "results/") || exportName(key) != nil {
+		http.Error(w, "Invalid result key", http.StatusBadRequest)
+		return
+	}
+	body, stored, err := results.get(r.Context(), key)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "Result not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if !stored.IsZero() {
+		w.Header().Set("Last-Modified", stored.UTC().Format(http.TimeFormat))
+	}
+	if notModified(r, etag, stored) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(body)
+}
+
+// notModified evaluates the conditional headers of r, RFC 9110 section
+// 13.2.2: If-None-Match when present, else If-Modified-Since.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		return noneMatch(header, etag)
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.IsZero() {
+		return false
+	}
+	// The header has whole seconds only
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// noneMatch reports whether an If-None-Match header lists etag, or is *.
+// The comparison is weak, as RFC 9110 asks for If-None-Match.
+func noneMatch(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
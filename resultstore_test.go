@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoredResultConditional(t *testing.T) {
+	dir := t.TempDir()
+	key := "results/2024/01/31/run/example.com.json"
+	store := dirStore{dir: dir}
+	if err := store.put(context.Background(), key, []byte(`{"domain":"example.com"}`), "application/json"); err != nil {
+		t.Fatal(err)
+	}
+	stored := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(key)), stored, stored); err != nil {
+		t.Fatal(err)
+	}
+	defer func(previous resultStore) { results = previous }(results)
+	results = store
+
+	get := func(method string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/api/"+key, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		storedResultHandler(w, r)
+		return w
+	}
+	first := get(http.MethodGet, nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || first.Body.Len() == 0 {
+		t.Fatalf("GET = %d, ETag %q, %d bytes", first.Code, etag, first.Body.Len())
+	}
+	if got := first.Header().Get("Last-Modified"); got != "Wed, 31 Jan 2024 12:00:00 GMT" {
+		t.Errorf("Last-Modified %q", got)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		code   int
+	}{
+		{"matching ETag", http.MethodGet, http.Header{"If-None-Match": {`"other", W/` + etag}}, http.StatusNotModified},
+		{"any ETag", http.MethodGet, http.Header{"If-None-Match": {"*"}}, http.StatusNotModified},
+		{"other ETag", http.MethodGet, http.Header{"If-None-Match": {`"other"`}}, http.StatusOK},
+		{"ETag takes precedence", http.MethodGet, http.Header{"If-None-Match": {`"other"`},
+			"If-Modified-Since": {"Thu, 01 Feb 2024 00:00:00 GMT"}}, http.StatusOK},
+		{"not modified since", http.MethodGet, http.Header{"If-Modified-Since": {"Wed, 31 Jan 2024 12:00:00 GMT"}}, http.StatusNotModified},
+		{"modified since", http.MethodGet, http.Header{"If-Modified-Since": {"Wed, 31 Jan 2024 11:59:59 GMT"}}, http.StatusOK},
+		{"invalid date", http.MethodGet, http.Header{"If-Modified-Since": {"yesterday"}}, http.StatusOK},
+		{"head", http.MethodHead, nil, http.StatusOK},
+		{"post", http.MethodPost, nil, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := get(tt.method, tt.header)
+		if w.Code != tt.code {
+			t.Errorf("%s: %d, want %d", tt.name, w.Code, tt.code)
+		}
+		if (w.Code == http.StatusNotModified || tt.method == http.MethodHead) && w.Body.Len() > 0 {
+			t.Errorf("%s: %d bytes of body", tt.name, w.Body.Len())
+		}
+	}
+}
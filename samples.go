@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"math"
+	"net/url"
+	"sort"
+)
+
+// Most requests sampleCount may send to each address
+const maxSampleCount = 20
+
+// clampSampleCount bounds the requested sample count; zero and one both
+// mean a single measurement.
+func clampSampleCount(n int) int {
+	if n < 1 {
+		return 1
+	}
+	if n > maxSampleCount {
+		return maxSampleCount
+	}
+	return n
+}
+
+// sampleIP repeats the request to one address, each time on a fresh
+// connection so the TCP and TLS handshakes are measured too, unless the
+// analysis reuses connections, and summarizes the distribution. first is the measurement probeIPs already
+// took and counts as the first sample. Samples run one after another so
+// they don't compete for the same path.
+func sampleIP(ctx context.Context, target *url.URL, first IPResult, count int, tlsConfig *tls.Config) *LatencySamples {
+	samples := []IPResult{first}
+	for len(samples) < count && ctx.Err() == nil {
+		samples = append(samples, probeIP(ctx, target, first.IP, tlsConfig.Clone()))
+	}
+	return summarizeSamples(samples)
+}
+
+// summarizeSamples reports the timing distribution of the samples that got
+// a response and counts those that failed.
+func summarizeSamples(samples []IPResult) *LatencySamples {
+	var connect, handshake, ttfb, total []float64
+	result := &LatencySamples{Count: len(samples)}
+	for _, s := range samples {
+		if s.Error != "" && s.StatusCode == 0 {
+			result.Failed++
+			continue
+		}
+		if s.Reused {
+			result.Reused++
+			ttfb = append(ttfb, s.TTFBMs)
+			total = append(total, s.TotalMs)
+			continue // No connect or handshake to time
+		}
+		connect = append(connect, s.ConnectMs)
+		if s.TLSHandshakeMs > 0 {
+			handshake = append(handshake, s.TLSHandshakeMs)
+		}
+		ttfb = append(ttfb, s.TTFBMs)
+		total = append(total, s.TotalMs)
+	}
+
+	result.ConnectMs = latencyStats(connect)
+	result.TLSHandshakeMs = latencyStats(handshake)
+	result.TTFBMs = latencyStats(ttfb)
+	result.TotalMs = latencyStats(total)
+	return result
+}
+
+// latencyStats returns the min, median, 95th percentile and max of the
+// values, or nil when there are none. Percentiles use the nearest-rank
+// method, so with few samples p95 is the maximum.
+func latencyStats(values []float64) *LatencyStats {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	return &LatencyStats{
+		Min:    sorted[0],
+		Median: percentile(sorted, 50),
+		P95:    percentile(sorted, 95),
+		Max:    sorted[len(sorted)-1],
+	}
+}
+
+// percentile returns the nearest-rank percentile p of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
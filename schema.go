@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaEndpoint pairs an endpoint with the body it accepts and the one it
+// returns.
+type schemaEndpoint struct {
+	path     string
+	request  interface{}
+	response interface{}
+}
+
+// The JSON endpoints whose bodies /api/schema describes
+var schemaEndpoints = []schemaEndpoint{
+	{"/analyze", analysisRequest{}, response{}},
+	{"/analyze/batch", batchRequest{}, BatchResult{}},
+	{"/analyze/regions", analysisRequest{}, RegionalReport{}},
+	{"/api/subdomains", subdomainRequest{}, SubdomainDiscovery{}},
+	{"/api/tcp", tcpServiceRequest{}, TCPServiceResult{}},
+	{"/api/udp", udpProbeRequest{}, UDPProbeResult{}},
+	{"/api/check", checkDefinition{}, CheckResult{}},
+	{"/api/validate", analysisRequest{}, TargetValidation{}},
+	{"/api/csp/merge", cspMergeRequest{}, CSPMerge{}},
+	{"/api/sla", nil, SLAReport{}},
+	{"/api/history", nil, RunPage{}},
+	{"/api/history/export", nil, ExportResult{}},
+	{"/api/{resultKey}", nil, response{}},
+	{"/api/version", nil, versionInfo{}},
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// schemaBuilder derives JSON Schemas from the Go types the handlers encode,
+// so the published schema cannot drift from the responses. Each struct
+// type becomes one entry of $defs. Request bodies have no required
+// fields, as anything left out takes its default.
+type schemaBuilder struct {
+	defs    map[string]interface{}
+	request bool // Describing a request body
+}
+
+// apiSchema describes every JSON endpoint: its request and response body
+// schemas by path, referring to the shared $defs.
+func apiSchema() map[string]interface{} {
+	b := &schemaBuilder{defs: make(map[string]interface{})}
+	endpoints := make(map[string]interface{})
+	for _, e := range schemaEndpoints {
+		endpoint := make(map[string]interface{})
+		if e.request != nil {
+			b.request = true
+			endpoint["request"] = b.schema(reflect.TypeOf(e.request))
+			b.request = false
+		}
+		endpoint["response"] = b.schema(reflect.TypeOf(e.response))
+		endpoints[e.path] = endpoint
+	}
+	return map[string]interface{}{
+		"$schema":   jsonSchemaDialect,
+		"$id":       "/api/schema",
+		"title":     "Domain Keep-Alive Analyzer API",
+		"version":   version,
+		"endpoints": endpoints,
+		"$defs":     b.defs,
+	}
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case durationType:
+		return map[string]interface{}{"type": "integer", "description": "Nanoseconds"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		name := schemaName(t)
+		if _, ok := b.defs[name]; !ok {
+			b.defs[name] = nil // Placeholder for recursive types
+			b.defs[name] = b.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + name}
+	}
+	return map[string]interface{}{} // interface{} accepts anything
+}
+
+// object describes a struct the way encoding/json encodes it: exported
+// fields under their json names, embedded structs flattened, and in
+// responses the fields without omitempty required.
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := []string{}
+	b.addFields(t, properties, &required)
+	object := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if !b.request {
+		object["required"] = required
+	}
+	return object
+}
+
+func (b *schemaBuilder) addFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				b.addFields(ft, properties, required)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		s := b.schema(field.Type)
+		nullable := !strings.Contains(options, "omitempty") && nilable(field.Type)
+		if nullable {
+			s = map[string]interface{}{"anyOf": []interface{}{s, map[string]interface{}{"type": "null"}}}
+		}
+		properties[name] = s
+		if !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// nilable reports whether encoding/json may write null for a value of t.
+func nilable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		return true
+	}
+	return false
+}
+
+// schemaName is the $defs key of a struct type, its Go name capitalized.
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[size:]
+}
+
+// schemaHandler publishes the JSON Schemas of the API's request and
+// response bodies, for generating client models and spotting breaking
+// changes between versions.
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(apiSchema())
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSchemaMatchesJSON encodes every request and response type of
+// /api/schema, once empty and once with every field set, and validates the
+// JSON against the published schema.
+func TestSchemaMatchesJSON(t *testing.T) {
+	schema := apiSchema()
+	defs := schema["$defs"].(map[string]interface{})
+	endpoints := schema["endpoints"].(map[string]interface{})
+
+	for _, e := range schemaEndpoints {
+		endpoint := endpoints[e.path].(map[string]interface{})
+		bodies := map[string]interface{}{"response": e.response}
+		if e.request != nil {
+			bodies["request"] = e.request
+		}
+		for kind, body := range bodies {
+			s := endpoint[kind].(map[string]interface{})
+			for _, filled := range []bool{false, true} {
+				v := reflect.New(reflect.TypeOf(body)).Elem()
+				if filled {
+					fill(v, 0)
+				}
+				raw, err := json.Marshal(v.Interface())
+				if err != nil {
+					t.Fatalf("%s %s: %v", e.path, kind, err)
+				}
+				var doc interface{}
+				json.Unmarshal(raw, &doc)
+				if err := validate(defs, s, doc, "$"); err != nil {
+					t.Errorf("%s %s (filled %v): %v", e.path, kind, filled, err)
+				}
+			}
+		}
+	}
+}
+
+// TestSchemaRequired checks that a response's required fields are exactly
+// those encoding/json writes for an empty value, the ones without
+// omitempty, and that requests require nothing.
+func TestSchemaRequired(t *testing.T) {
+	schema := apiSchema()
+	defs := schema["$defs"].(map[string]interface{})
+
+	for _, e := range schemaEndpoints {
+		rt := reflect.TypeOf(e.response)
+		raw, err := json.Marshal(reflect.New(rt).Elem().Interface())
+		if err != nil {
+			t.Fatalf("%s: %v", e.path, err)
+		}
+		var empty map[string]interface{}
+		json.Unmarshal(raw, &empty)
+		var written []string
+		for name := range empty {
+			written = append(written, name)
+		}
+
+		def := defs[schemaName(rt)].(map[string]interface{})
+		required := append([]string(nil), def["required"].([]string)...)
+		sort.Strings(written)
+		sort.Strings(required)
+		if !reflect.DeepEqual(written, required) {
+			t.Errorf("%s: required %v, but an empty %s encodes %v", e.path, required, rt.Name(), written)
+		}
+
+		if e.request != nil {
+			if def := defs[schemaName(reflect.TypeOf(e.request))].(map[string]interface{}); def["required"] != nil {
+				t.Errorf("%s: the request requires %v", e.path, def["required"])
+			}
+		}
+	}
+}
+
+// fill sets every field v reaches to a non-zero value, giving slices and
+// maps one element, down to a depth that stops recursive types.
+func fill(v reflect.Value, depth int) {
+	if depth > 8 {
+		return
+	}
+	if v.Type() == timeType {
+		v.Set(reflect.ValueOf(time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem(), depth+1)
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Slice:
+		s := reflect.MakeSlice(v.Type(), 1, 1)
+		fill(s.Index(0), depth+1)
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fill(v.Index(i), depth+1)
+		}
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		key := reflect.New(v.Type().Key()).Elem()
+		fill(key, depth+1)
+		elem := reflect.New(v.Type().Elem()).Elem()
+		fill(elem, depth+1)
+		m.SetMapIndex(key, elem)
+		v.Set(m)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				fill(v.Field(i), depth+1)
+			}
+		}
+	}
+}
+
+// validate checks doc against the subset of JSON Schema schemaBuilder
+// writes. Objects may only have the properties the schema lists.
+func validate(defs map[string]interface{}, s map[string]interface{}, doc interface{}, path string) error {
+	if ref, ok := s["$ref"].(string); ok {
+		return validate(defs, defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]interface{}), doc, path)
+	}
+	if anyOf, ok := s["anyOf"].([]interface{}); ok {
+		var errs []string
+		for _, alt := range anyOf {
+			err := validate(defs, alt.(map[string]interface{}), doc, path)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err.Error())
+		}
+		return fmt.Errorf("%s matches no alternative: %s", path, strings.Join(errs, "; "))
+	}
+
+	typ, _ := s["type"].(string)
+	switch typ {
+	case "":
+		return nil // Anything
+	case "null":
+		if doc != nil {
+			return fmt.Errorf("%s is %T, not null", path, doc)
+		}
+	case "boolean":
+		if _, ok := doc.(bool); !ok {
+			return fmt.Errorf("%s is %T, not a boolean", path, doc)
+		}
+	case "integer", "number":
+		n, ok := doc.(float64)
+		if !ok {
+			return fmt.Errorf("%s is %T, not a number", path, doc)
+		}
+		if typ == "integer" && n != float64(int64(n)) {
+			return fmt.Errorf("%s is %v, not an integer", path, n)
+		}
+	case "string":
+		if _, ok := doc.(string); !ok {
+			return fmt.Errorf("%s is %T, not a string", path, doc)
+		}
+	case "array":
+		items, ok := doc.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s is %T, not an array", path, doc)
+		}
+		for i, item := range items {
+			if err := validate(defs, s["items"].(map[string]interface{}), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		object, ok := doc.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s is %T, not an object", path, doc)
+		}
+		required, _ := s["required"].([]string)
+		for _, name := range required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s lacks required %s", path, name)
+			}
+		}
+		properties, _ := s["properties"].(map[string]interface{})
+		additional, _ := s["additionalProperties"].(map[string]interface{})
+		for name, value := range object {
+			ps, ok := properties[name].(map[string]interface{})
+			if !ok {
+				ps = additional
+			}
+			if ps == nil {
+				return fmt.Errorf("%s has %s, which the schema does not list", path, name)
+			}
+			if err := validate(defs, ps, value, path+"."+name); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s: unknown schema type %q", path, typ)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// How long the startup self-test may take
+const selfTestTimeout = 15 * time.Second
+
+// runSelfTest checks that the analyzer can resolve names and reach the
+// internet, so a deployment without outbound access fails at startup
+// instead of on its first analysis. It uses the -source-* selection the
+// probes will use.
+func runSelfTest(target string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	defer cancel()
+
+	u, err := url.Parse(target)
+	if err != nil || u.Hostname() == "" {
+		return fmt.Errorf("invalid self-test URL %q", target)
+	}
+
+	source, err := newSourceSelection(nil)
+	if err != nil {
+		return err
+	}
+	ctx = withSource(ctx, source)
+
+	addrs, err := resolverFor(ctx).LookupHost(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("DNS lookup of %s failed: %v", u.Hostname(), err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Transport: auditedTransport}).Do(req)
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %v", target, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	log.Printf("Self-test passed: %s resolved to %v, status %d from %v", u.Hostname(), addrs, resp.StatusCode, source.info().Addresses)
+	return nil
+}
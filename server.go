@@ -1,18 +1,24 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -20,21 +26,78 @@ const port = ":3000"
 const publicDir = "public"
 
 func main() {
+	lc := newLifecycle()
+	if err := loadConfig(lc); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	if cfg.SelfTest {
+		if err := runSelfTest(cfg.SelfTestURL); err != nil {
+			log.Fatalf("Self-test failed: %v", err)
+		}
+	}
+
 	http.HandleFunc("/", homeHandler)
-	http.HandleFunc("/analyze", analyzeHandler)
+	http.HandleFunc("/api/version", versionHandler)
+	http.HandleFunc("/api/schema", schemaHandler)
+	http.HandleFunc("/api/validate", validateHandler)
+	http.HandleFunc("/api/csp/merge", cspMergeHandler)
+	http.HandleFunc("/api/audit", auditExportHandler)
+	http.HandleFunc("/api/sla", slaHandler)
+	http.Handle("/api/history", gzipHandler(http.HandlerFunc(historyHandler)))
+	http.HandleFunc("/api/history/export", historyExportHandler)
+	http.HandleFunc("/api/results/", storedResultHandler)
+	http.HandleFunc("/api/probe-ips", probeIPsHandler)
+	http.Handle("/analyze", lc.track(runIDHandler(auditHandler(gzipHandler(http.HandlerFunc(analyzeHandler))))))
+	http.Handle("/analyze/batch", lc.track(runIDHandler(auditHandler(gzipHandler(http.HandlerFunc(batchHandler))))))
+	http.Handle("/api/subdomains", lc.track(runIDHandler(auditHandler(gzipHandler(http.HandlerFunc(subdomainsHandler))))))
+	http.Handle("/results", lc.track(runIDHandler(auditHandler(gzipHandler(http.HandlerFunc(resultsHandler))))))
+	http.HandleFunc("/api/agents", agentsHandler)
+	http.Handle("/analyze/regions", lc.track(runIDHandler(auditHandler(gzipHandler(http.HandlerFunc(regionsHandler))))))
+	http.Handle("/api/tcp", lc.track(runIDHandler(auditHandler(gzipHandler(http.HandlerFunc(tcpServiceHandler))))))
+	http.Handle("/api/udp", lc.track(runIDHandler(auditHandler(gzipHandler(http.HandlerFunc(udpProbeHandler))))))
+	http.Handle("/api/check", lc.track(runIDHandler(auditHandler(gzipHandler(http.HandlerFunc(checkHandler))))))
 	fs := http.FileServer(http.Dir(publicDir))
 	http.Handle("/public/", http.StripPrefix("/public/", fs))
 
-	log.Printf("Server running at http://localhost%s\n", port)
-	if err := http.ListenAndServe(port, nil); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	srv := &http.Server{
+		Addr:        port,
+		BaseContext: lc.baseContext,
+	}
+
+	if cfg.Coordinator != "" {
+		lc.Go(runAgent)
 	}
+
+	if cfg.PprofAddr != "" {
+		go servePprof(cfg.PprofAddr)
+	}
+
+	go func() {
+		log.Printf("Server running at http://localhost%s (version %s)\n", port, version)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// Wait for a termination signal, then drain in-flight analyses
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	log.Printf("Shutting down, waiting up to %v for in-flight analyses", shutdownTimeout)
+	lc.shutdown(srv, shutdownTimeout)
+	log.Println("Server stopped")
 }
 
 func homeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path == "/" {
-		tmpl := template.Must(template.ParseFiles(filepath.Join(publicDir, "index.html")))
-		tmpl.Execute(w, nil)
+		tmpl, err := parsePageTemplates()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tmpl.Execute(w, resultsPage{})
 		return
 	}
 	http.ServeFile(w, r, filepath.Join(publicDir, r.URL.Path))
@@ -46,9 +109,7 @@ func analyzeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var reqData struct {
-		Domain string `json:"domain"`
-	}
+	var reqData analysisRequest
 
 	err := json.NewDecoder(r.Body).Decode(&reqData)
 	if err != nil {
@@ -56,55 +117,163 @@ func analyzeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	domain := reqData.Domain
-
-	if !strings.HasPrefix(domain, "http://") && !strings.HasPrefix(domain, "https://") {
-		domain = "http://" + domain
+	responseObj, err := runAnalysis(r.Context(), reqData)
+	var invalid badRequestError
+	if errors.As(err, &invalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	parsedURL, err := url.Parse(domain)
 	if err != nil {
-		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("Failed to fetch data: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	dnsDomain := parsedURL.Hostname()
+	w.Header().Set("Content-Type", "application/json")
+	log.Printf("Response: %+v\n", responseObj) // Log the response
+	json.NewEncoder(w).Encode(responseObj)
+}
+
+// badRequestError is a problem with the analysis request rather than the
+// target.
+type badRequestError struct{ error }
+
+// runAnalysis validates one analysis request and runs it under its own
+// budget. Plain http:// targets that fail are retried over HTTPS.
+func runAnalysis(ctx context.Context, reqData analysisRequest) (*response, error) {
+	domain, dnsDomain, err := parseAnalysisRequest(&reqData)
+	if err != nil {
+		return nil, err
+	}
+	parsedURL, _ := url.Parse(domain)
 	port := parsedURL.Port()
 	if port == "" {
 		port = "80"
 	}
 
-	response, err := attemptHTTPConnection(domain, dnsDomain)
+	source, err := newSourceSelection(reqData.Source)
+	if err != nil {
+		return nil, badRequestError{err}
+	}
+
+	proxy, err := newProxyTunnel(reqData.Proxy)
+	if err != nil {
+		return nil, badRequestError{err}
+	}
+
+	b := newBudget(reqData.Budget)
+	ctx, warnings := withWarnings(ctx)
+	ctx, cancel := withBudget(withProxy(withSource(ctx, source), proxy), b)
+	defer cancel()
+	if reqData.ReuseConnections {
+		ctx = withConnReuse(ctx, reqData)
+	}
+	startTime := time.Now()
+	events.publish(ctx, Event{Type: eventAnalysisStarted, Target: dnsDomain})
+	response, err := attemptHTTPConnection(ctx, domain, dnsDomain, reqData)
 	if err != nil && port == "80" {
+		warn(ctx, Finding{Severity: severityLow, ID: "https-fallback",
+			Message: "The http:// request failed, the analysis used https:// instead", Detail: err.Error()})
 		domain = "https://" + dnsDomain
-		parsedURL, err = url.Parse(domain)
-		if err != nil {
-			http.Error(w, "Invalid URL", http.StatusBadRequest)
-			return
-		}
-		port = "443"
-		response, err = attemptHTTPConnection(domain, dnsDomain)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to fetch data: %v", err), http.StatusInternalServerError)
-			return
-		}
+		response, err = attemptHTTPConnection(ctx, domain, dnsDomain, reqData)
+	}
+	if err != nil {
+		events.publish(ctx, Event{Type: eventAnalysisFailed, Target: dnsDomain, DurationMs: millisecondsSince(startTime), Error: err.Error()})
+		return nil, err
 	}
 
-	responseObj := response
-	w.Header().Set("Content-Type", "application/json")
-	log.Printf("Response: %+v\n", responseObj) // Log the response
-	json.NewEncoder(w).Encode(responseObj)
+	response.Budget = b.usage()
+	if response.Budget.Exceeded != "" {
+		warn(ctx, Finding{Severity: severityMedium, ID: "budget-exceeded", Message: response.Budget.Exceeded,
+			Detail: "Stages that ran after the budget was spent are missing or incomplete."})
+	}
+	response.Source = source.info()
+	response.IDN = analyzeIDN(dnsDomain)
+	if proxy != nil {
+		response.Proxy = proxy.info()
+	}
+	response.Assertions = evaluateAssertions(reqData.assertions, &response)
+	if response.Assertions != nil && response.Assertions.Status == "fail" {
+		events.publish(ctx, Event{Type: eventAssertionsFailed, Target: dnsDomain, Result: &response})
+	}
+	response.Warnings = warnings.list()
+	events.publish(ctx, Event{Type: eventAnalysisCompleted, Target: dnsDomain, DurationMs: millisecondsSince(startTime), Result: &response})
+	return &response, nil
 }
 
-func attemptHTTPConnection(domain, dnsDomain string) (response, error) {
-	// Resolve the domain to get A records
-	cnameRecords, aRecords, err := resolveCnameAndARecords(dnsDomain)
+// parseAnalysisRequest checks the options of reqData, parsing the client
+// certificate, pins and listeners into it, and returns the URL to request
+// and its host name, both in punycode.
+func parseAnalysisRequest(reqData *analysisRequest) (string, string, error) {
+	var err error
+	reqData.clientCert, err = loadClientCertificate(*reqData)
+	if err != nil {
+		return "", "", badRequestError{fmt.Errorf("Invalid client certificate: %v", err)}
+	}
+
+	reqData.pins, err = parsePins(reqData.PinSPKI)
+	if err != nil {
+		return "", "", badRequestError{err}
+	}
+
+	if _, ok := tlsProfiles[reqData.TLSProfile]; reqData.TLSProfile != "" && !ok {
+		return "", "", badRequestError{errors.New("Unknown TLS profile")}
+	}
+
+	reqData.Listeners, err = parseListeners(reqData.Listeners)
+	if err != nil {
+		return "", "", badRequestError{err}
+	}
+
+	reqData.assertions, err = parseAssertions(reqData.Assertions)
 	if err != nil {
-		return response{}, fmt.Errorf("failed to resolve DNS records: %v", err)
+		return "", "", badRequestError{err}
 	}
 
-	if len(aRecords) == 0 {
-		return response{}, fmt.Errorf("no A records found for the domain")
+	domain := reqData.Domain
+
+	if !strings.HasPrefix(domain, "http://") && !strings.HasPrefix(domain, "https://") {
+		domain = "http://" + domain
+	}
+
+	parsedURL, err := url.Parse(domain)
+	if err != nil {
+		return "", "", badRequestError{errors.New("Invalid URL")}
+	}
+
+	// DNS, SNI and the Host header all need the punycode form
+	asciiHost, err := toASCII(parsedURL.Hostname())
+	if err != nil {
+		return "", "", badRequestError{err}
+	}
+	if asciiHost != parsedURL.Hostname() {
+		parsedURL.Host = asciiHost
+		if parsedURL.Port() != "" {
+			parsedURL.Host = net.JoinHostPort(asciiHost, parsedURL.Port())
+		}
+		domain = parsedURL.String()
+	}
+
+	return domain, parsedURL.Hostname(), nil
+}
+
+func attemptHTTPConnection(ctx context.Context, domain, dnsDomain string, opts analysisRequest) (response, error) {
+	var cnameRecords, aRecords []string
+	var dnsTiming *dnsTrace
+	clock := newStageClock(ctx, dnsDomain)
+
+	// Resolve the domain to get A records, unless the caller opted out
+	if !opts.SkipDNS {
+		var err error
+		dnsTiming = &dnsTrace{}
+		cnameRecords, aRecords, err = resolveCnameAndARecords(withDNSTrace(ctx, dnsTiming), dnsDomain)
+		if err != nil {
+			return response{}, fmt.Errorf("failed to resolve DNS records: %v", err)
+		}
+
+		if len(aRecords) == 0 {
+			return response{}, fmt.Errorf("no A records found for the domain")
+		}
+		clock.done("dns")
 	}
 
 	// Use the first A record (IP address) for TCP analysis
@@ -112,10 +281,25 @@ func attemptHTTPConnection(domain, dnsDomain string) (response, error) {
 
 	startTime := time.Now()
 
-	finalDomain, tlsVersion, headers, tcpResults, err := httpsGetWithTLSInfo(domain, dnsDomain)
+	clientAuth := &clientAuthRecorder{}
+	tlsConfig := newTLSConfig(opts, clientAuth)
+
+	hello := &clientHelloRecorder{}
+	snapshot := newSnapshotRecorder(opts.CaptureBodyKB)
+
+	finalDomain, tlsState, headers, tcpResults, err := httpsGetWithTLSInfo(ctx, domain, dnsDomain, opts, tlsConfig, hello, snapshot)
 	if err != nil {
 		return response{}, fmt.Errorf("failed to fetch data: %v", err)
 	}
+	clock.done("request")
+	tlsVersion := "Unknown"
+	if tlsState != nil {
+		tlsVersion = tlsVersionToString(tlsState.Version)
+		if !cfg.VerifyTLS && !opts.VerifyTLS {
+			warn(ctx, Finding{Severity: severityInfo, ID: "tls-not-verified", Message: "The certificate chain was not verified",
+				Detail: "Set verifyTLS to make an untrusted certificate fail the analysis. The certificate section still reports whether it would verify."})
+		}
+	}
 
 	// Initialize all header variables with "Not Defined"
 	timeoutValue := "Not Defined"
@@ -183,9 +367,10 @@ func attemptHTTPConnection(domain, dnsDomain string) (response, error) {
 
 	duration := time.Since(startTime).Milliseconds()
 
-	return response{
+	result := response{
 		Domain:           finalDomain,
 		KeepAliveTimeout: timeoutValue,
+		KeepAlive:        parseKeepAlive(headers.Get("Keep-Alive")),
 		RequestDuration:  duration,
 		TLSVersion:       tlsVersion,
 		ConnectionHeader: connectionHeader,
@@ -197,10 +382,191 @@ func attemptHTTPConnection(domain, dnsDomain string) (response, error) {
 		CloudflareHeader: cloudflareHeader,
 		CloudFrontHeader: cloudfrontHeader,
 		AkamaiHeader:     akamaiHeader,
+		CSP:              headers.Values("Content-Security-Policy"),
+		CSPReportOnly:    headers.Values("Content-Security-Policy-Report-Only"),
+		CDN:              detectCDN(headers),
+		Via:              parseVia(headers.Values("Via")),
 		CnameRecords:     cnameRecords,
 		ARecords:         aRecords,
+		DNS:              dnsTiming.result(),
 		TCPResults:       string(tcpResults), // Convert to string if necessary
-	}, nil
+		Snapshot:         snapshot.result(),
+		ClientAuth:       clientAuth.result(),
+		TLSFingerprint:   hello.fingerprint(profileName(opts)),
+		Extensions:       runHeaderDetectors(headers),
+		ServerInfo:       parseServerBanner(headers.Get("Server")),
+		Advisories:       serverAdvisories(headers.Get("Server"), headers.Get("X-Powered-By")),
+	}
+
+	// Follow-up probes run after the timed request
+	target, err := url.Parse(finalDomain)
+	if err != nil {
+		return result, nil
+	}
+
+	if target.Scheme == "https" {
+		result.Certificate = checkCertificate(tlsState, target.Hostname())
+		if opts.TLSScore {
+			result.TLSScore = scoreTLS(ctx, target, result.Certificate)
+		}
+		result.Resumption = probeSessionResumption(ctx, target, tlsConfig.Clone())
+		clock.done("certificate")
+	}
+
+	if !opts.SkipIPs && len(aRecords) > 0 {
+		// The A records belong to the requested host, a redirect may have left it
+		if target.Hostname() != dnsDomain {
+			target, _ = url.Parse(domain)
+		}
+		result.IPResults = probeIPs(ctx, target, aRecords, tlsConfig, clampSampleCount(opts.SampleCount))
+		result.Backends = clusterBackends(result.IPResults)
+		if result.Backends != nil && result.Backends.ContentVersions > 1 {
+			result.Backends.DynamicContent = checkContentStability(ctx, target, result.IPResults, tlsConfig)
+		}
+		clock.done("ipResults")
+	}
+
+	if len(opts.Listeners) > 0 {
+		listenerTarget, _ := url.Parse(domain)
+		result.Listeners = probeListeners(ctx, listenerTarget, aRecords, tlsConfig, opts.Listeners)
+		clock.done("listeners")
+	}
+
+	if opts.DNSBehavior && result.DNS != nil {
+		result.DNS.Behavior = analyzeDNSBehavior(ctx, dnsDomain)
+		clock.done("dnsBehavior")
+	}
+
+	if opts.DelegationCheck && result.DNS != nil {
+		result.DNS.Delegation = checkDelegation(ctx, dnsDomain)
+		clock.done("delegationCheck")
+	}
+
+	result.ETags = analyzeETags(headers.Get("ETag"), result.IPResults)
+
+	if opts.CompareFingerprints && target.Scheme == "https" {
+		result.FingerprintResults = compareFingerprints(ctx, target.String(), opts, clientAuth)
+		clock.done("compareFingerprints")
+	}
+
+	if opts.IdleProbe {
+		result.IdleClose = probeIdleClose(ctx, target, tlsConfig.Clone(), idleWait(opts.IdleProbeSeconds))
+		clock.done("idleProbe")
+	}
+
+	if opts.HalfOpenProbe {
+		result.HalfOpen = probeHalfOpen(ctx, target, tlsConfig.Clone(), idleWait(opts.IdleProbeSeconds))
+		clock.done("halfOpenProbe")
+	}
+
+	if opts.TCPKeepAlive != nil {
+		result.TCPKeepAlive = probeTCPKeepAlive(ctx, target, tlsConfig.Clone(), *opts.TCPKeepAlive, idleWait(opts.IdleProbeSeconds))
+		clock.done("tcpKeepAlive")
+	}
+
+	result.HSTS = analyzeHSTS(ctx, target, headers, dnsDomain)
+
+	if opts.RDAP {
+		if cfg.RDAP {
+			result.Registration = lookupRegistration(ctx, dnsDomain)
+		} else {
+			result.Registration = &RegistrationInfo{Domain: dnsDomain, Error: "RDAP lookups are disabled on this server"}
+		}
+		clock.done("rdap")
+	}
+
+	if opts.SiteHygiene {
+		result.SiteHygiene = probeSiteHygiene(ctx, target, tlsConfig.Clone())
+		clock.done("siteHygiene")
+	}
+
+	if opts.ProbeMethods {
+		result.Methods = probeMethods(ctx, target, tlsConfig.Clone())
+		clock.done("probeMethods")
+	}
+
+	if opts.CacheAnalysis {
+		result.Caching = analyzeCaching(ctx, target, tlsConfig.Clone())
+		clock.done("cacheAnalysis")
+	}
+
+	if opts.VaryAnalysis {
+		result.Vary = analyzeVary(ctx, target, tlsConfig.Clone())
+		clock.done("varyAnalysis")
+	}
+
+	if opts.ProtocolEdgeCases {
+		result.EdgeCases = probeProtocolEdgeCases(ctx, target, tlsConfig.Clone())
+		clock.done("protocolEdgeCases")
+	}
+
+	if opts.CompareUserAgents {
+		result.UserAgentResults = compareUserAgents(ctx, target, tlsConfig.Clone(), opts.UserAgents)
+		clock.done("compareUserAgents")
+	}
+
+	if opts.LanguageAnalysis {
+		result.Languages = analyzeLanguages(ctx, target, tlsConfig.Clone())
+		clock.done("languageAnalysis")
+	}
+
+	if opts.DowngradeProbe {
+		result.Downgrade = probeDowngrade(ctx, target, tlsConfig.Clone())
+		clock.done("downgradeProbe")
+	}
+
+	if opts.HeaderLint {
+		result.HeaderFindings = lintHeaders(ctx, target, tlsConfig.Clone())
+		clock.done("headerLint")
+	}
+
+	if opts.CSPAnalysis {
+		result.CSPAnalysis = analyzeCSP(ctx, target, tlsConfig.Clone(), opts.CSPPolicy, opts.CSPProbeReporting, opts.CSPRender)
+		clock.done("cspAnalysis")
+	}
+
+	if opts.CDNDebug {
+		if result.CDN == nil {
+			result.CDN = &CDNDetection{}
+		}
+		result.CDN.Debug = requestCDNDebug(ctx, target, tlsConfig.Clone())
+		clock.done("cdnDebug")
+	}
+
+	if opts.Topology {
+		result.Topology = inferTopology(ctx, headers, dnsDomain, aRecords, result.CDN)
+		clock.done("topology")
+	}
+
+	if opts.ProbeCNAMEChain {
+		result.CNAMEChain = probeCNAMEChain(ctx, target, dnsDomain, tlsConfig)
+		clock.done("probeCnameChain")
+	}
+
+	if opts.DSCP != nil {
+		result.DSCP = compareDSCP(ctx, target, tlsConfig, *opts.DSCP)
+		clock.done("dscp")
+	}
+
+	if opts.HTTP2Probe {
+		result.HTTP2 = probeHTTP2(ctx, target, tlsConfig.Clone(), idleWait(opts.IdleProbeSeconds))
+		clock.done("http2Probe")
+	}
+
+	if opts.GRPCProbe {
+		if result.ServerInfo == nil {
+			result.ServerInfo = &ServerInfo{}
+		}
+		result.ServerInfo.GRPC = probeGRPC(ctx, target, tlsConfig.Clone(), headers)
+		clock.done("grpcProbe")
+	}
+
+	if opts.EstimateIdleTimeout {
+		result.IdleTimeout = estimateIdleTimeout(ctx, target, tlsConfig.Clone(), idleWait(opts.IdleProbeSeconds))
+		clock.done("estimateIdleTimeout")
+	}
+
+	return result, nil
 }
 
 func checkAkamai(headers http.Header) bool {
@@ -214,11 +580,22 @@ func checkAkamai(headers http.Header) bool {
 	return xAkamaiTransformed || xAkamaiSessionInfo || akamaiOriginHop || trueClientIP || xAkamaiStaging
 }
 
-func httpsGetWithTLSInfo(url string, ip string) (string, string, http.Header, []byte, error) {
+func httpsGetWithTLSInfo(ctx context.Context, url string, ip string, opts analysisRequest, tlsConfig *tls.Config, hello *clientHelloRecorder, snapshot *snapshotRecorder) (string, *tls.ConnectionState, http.Header, []byte, error) {
+	dialer := &net.Dialer{}
+	reads := &readRecorder{}
+	defer reads.release()
 	client := &http.Client{
 		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true, // Use with caution
+			TLSClientConfig:   tlsConfig,
+			ForceAttemptHTTP2: tlsProfiles[opts.TLSProfile].http2,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := dialContext(ctx, dialer, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				// Keep the ClientHello for fingerprinting and the first bytes
+				// read for the TCP analysis
+				return reads.wrap(hello.wrap(conn)), nil
 			},
 		},
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
@@ -231,9 +608,15 @@ func httpsGetWithTLSInfo(url string, ip string) (string, string, http.Header, []
 		},
 	}
 
-	resp, err := client.Get(url)
+	req, err := http.NewRequestWithContext(reads.trace(snapshot.trace(ctx)), http.MethodGet, url, nil)
 	if err != nil {
-		return "", "", nil, nil, err
+		return "", nil, nil, nil, err
+	}
+	markProbe(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", nil, nil, nil, err
 	}
 	defer resp.Body.Close()
 
@@ -252,29 +635,159 @@ func httpsGetWithTLSInfo(url string, ip string) (string, string, http.Header, []
 		port = portString
 	}
 
-	// TCP Analysis using the IP address and port
-	//tcpResults, tcpErr := analyzeTCPHandshake(ip + ":" + port)
-	tcpResults, tcpErr := analyzeTCPHandshake(ip + ":" + port)
-	if tcpErr != nil {
-		fmt.Printf("TCP Error: %v\n", tcpErr)
+	// TCP Analysis on the connection the final response came on, falling
+	// back to a separate connection to the IP address and port
+	var jsonResults []byte
+	if !opts.SkipTCP {
+		tcpResults, ok := reads.tcpResults(resp.TLS)
+		if !ok {
+			warn(ctx, Finding{Severity: severityInfo, ID: "tcp-separate-connection",
+				Message: "Nothing was captured on the analyzed connection, the TCP analysis used a separate one"})
+			var tcpErr error
+			tcpResults, tcpErr = analyzeTCPHandshake(ctx, ip+":"+port, tlsConfig.Clone())
+			if tcpErr != nil {
+				fmt.Printf("TCP Error: %v\n", tcpErr)
+				warn(ctx, Finding{Severity: severityMedium, ID: "tcp-analysis-failed", Message: "The TCP analysis failed", Detail: tcpErr.Error()})
+			}
+		}
+
+		jsonResults, err = json.MarshalIndent(tcpResults, "", " ")
+		if err != nil {
+			fmt.Printf("Error Marshaling TCP JSON: %v\n", err)
+		}
 	}
 
-	jsonResults, err := json.MarshalIndent(tcpResults, "", " ")
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("Error Marshaling TCP JSON: %v\n", err)
+		return "", nil, nil, nil, err
 	}
+	snapshot.record(resp, body)
 
-	_, err = io.ReadAll(resp.Body)
+	return finalURL, resp.TLS, resp.Header, jsonResults, nil
+}
+
+// tcpBuffers recycles the capture buffers of the TCP analysis, which a
+// batch would otherwise allocate for every connection of every target.
+// They hold -tcp-capture-bytes.
+var tcpBuffers = sync.Pool{New: func() interface{} {
+	b := make([]byte, cfg.TCPCaptureBytes)
+	return &b
+}}
+
+// getTCPBuffer returns a zeroed capture buffer; hand it back with
+// tcpBuffers.Put once nothing refers to it.
+func getTCPBuffer() *[]byte {
+	b := tcpBuffers.Get().(*[]byte)
+	for i := range *b {
+		(*b)[i] = 0
+	}
+	return b
+}
+
+// readRecorder keeps the first bytes read from each connection the main
+// request dials, and which connection served the latest response, so the
+// TCP analysis of the final response, after any redirects, needs no second
+// connection. Each connection records straight into a pooled buffer,
+// which release hands back.
+type readRecorder struct {
+	mu       sync.Mutex
+	conns    *readConn // Every wrapped connection, linked through next
+	last     *readConn // Connection of the latest response
+	released bool
+}
+
+// wrap returns conn recording its reads.
+func (rec *readRecorder) wrap(conn net.Conn) net.Conn {
+	c := &readConn{Conn: conn, rec: rec}
+	rec.mu.Lock()
+	c.next, rec.conns = rec.conns, c
+	rec.mu.Unlock()
+	return c
+}
+
+// trace adds a hook to ctx noting the connection each response comes on.
+func (rec *readRecorder) trace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			conn := info.Conn
+			if tlsConn, ok := conn.(*tls.Conn); ok {
+				conn = tlsConn.NetConn()
+			}
+			if c, ok := conn.(*readConn); ok {
+				rec.mu.Lock()
+				rec.last = c
+				rec.mu.Unlock()
+			}
+		},
+	})
+}
+
+// tcpResults analyzes the bytes recorded on the connection of the final
+// response. For HTTPS they are TLS records as sent on the wire, which
+// results.Encrypted flags. It returns false when nothing was read, in
+// which case a separate probe is needed.
+func (rec *readRecorder) tcpResults(state *tls.ConnectionState) (TCPResults, bool) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	results := TCPResults{}
+	if rec.last == nil || len(rec.last.buf) == 0 {
+		return results, false
+	}
+	if state != nil {
+		results.TLSVersion = state.Version
+		results.CipherSuite = state.CipherSuite
+		results.Encrypted = true
+	}
+
+	// Past what was read, the buffer is still zeroed
+	response, err := analyzeTCPResponse(rec.last.buf[:cap(rec.last.buf)])
 	if err != nil {
-		return "", "", nil, nil, err
+		results.Error = err.Error()
+	} else {
+		results.TCPResponse = response
 	}
+	return results, true
+}
 
-	tlsVersion := "Unknown"
-	if resp.TLS != nil {
-		tlsVersion = tlsVersionToString(resp.TLS.Version)
+// release stops recording and returns the buffers to tcpBuffers. The
+// results of tcpResults keep no reference to them.
+func (rec *readRecorder) release() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.released = true
+	for c := rec.conns; c != nil; c = c.next {
+		if c.pooled != nil {
+			tcpBuffers.Put(c.pooled)
+			c.pooled, c.buf = nil, nil
+		}
 	}
+}
 
-	return finalURL, tlsVersion, resp.Header, jsonResults, nil
+type readConn struct {
+	net.Conn
+	rec *readRecorder
+
+	// Guarded by rec.mu
+	next   *readConn
+	pooled *[]byte // Taken from tcpBuffers on the first read
+	buf    []byte  // What was read, in *pooled
+}
+
+func (c *readConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.rec.mu.Lock()
+		if c.pooled == nil && !c.rec.released {
+			c.pooled = getTCPBuffer()
+			c.buf = (*c.pooled)[:0]
+		}
+		if room := cap(c.buf) - len(c.buf); room > 0 {
+			c.buf = append(c.buf, b[:minInt(n, room)]...)
+		}
+		c.rec.mu.Unlock()
+	}
+	return n, err
 }
 
 func tlsVersionToString(version uint16) string {
@@ -292,23 +805,13 @@ func tlsVersionToString(version uint16) string {
 	}
 }
 
-func extractTimeoutValue(keepAliveHeader string) string {
-	if strings.Contains(keepAliveHeader, "timeout=") {
-		parts := strings.Split(keepAliveHeader, "=")
-		if len(parts) > 1 {
-			return parts[1]
-		}
-	}
-	return "Not Defined"
-}
-
-func resolveCnameAndARecords(domain string) ([]string, []string, error) {
-	cnameRecords, err := net.LookupCNAME(domain)
+func resolveCnameAndARecords(ctx context.Context, domain string) ([]string, []string, error) {
+	cnameRecords, err := resolverFor(ctx).LookupCNAME(ctx, domain)
 	if err != nil && !isNotFoundError(err) {
 		return nil, nil, err
 	}
 
-	aRecords, err := net.LookupHost(domain)
+	aRecords, err := resolverFor(ctx).LookupHost(ctx, domain)
 	if err != nil && !isNotFoundError(err) {
 		return nil, nil, err
 	}
@@ -316,7 +819,7 @@ func resolveCnameAndARecords(domain string) ([]string, []string, error) {
 	return []string{cnameRecords}, aRecords, nil
 }
 
-func analyzeTCPHandshake(target string) (TCPResults, error) {
+func analyzeTCPHandshake(ctx context.Context, target string, tlsConfig *tls.Config) (TCPResults, error) {
 	results := TCPResults{}
 	addr, err := net.ResolveTCPAddr("tcp", target)
 	if err != nil {
@@ -326,12 +829,13 @@ func analyzeTCPHandshake(target string) (TCPResults, error) {
 	var conn net.Conn
 
 	// Try to connect via TCP first
-	conn, err = net.DialTCP("tcp", nil, addr)
+	dialer := &net.Dialer{}
+	conn, err = dialContext(ctx, dialer, "tcp", addr.String())
 	if err == nil {
 		defer conn.Close()
 
 		// You gotta say hello!
-		httpRequest := "GET / HTTP/1.1\r\nHost: " + target + "\r\nConnection: close\r\n\r\n"
+		httpRequest := "GET / HTTP/1.1\r\nHost: " + target + "\r\n" + markRawProbe(ctx) + "Connection: close\r\n\r\n"
 		n, err := conn.Write([]byte(httpRequest))
 		if err != nil {
 			log.Println(n, err)
@@ -340,12 +844,12 @@ func analyzeTCPHandshake(target string) (TCPResults, error) {
 		fmt.Printf("CON: Sent %d bytes: %s\n", n, httpRequest)
 	} else {
 		// If TCP connection fails, try TLS
-		conn, err = tls.Dial("tcp", target, &tls.Config{
-			InsecureSkipVerify: true,
-		})
+		rawConn, err := dialContext(ctx, dialer, "tcp", target)
 		if err != nil {
 			return results, fmt.Errorf("error connecting to target: %v\n", err)
 		}
+		tlsConfig.ServerName, _, _ = net.SplitHostPort(target)
+		conn = tls.Client(rawConn, tlsConfig)
 		defer conn.Close()
 	}
 
@@ -357,7 +861,7 @@ func analyzeTCPHandshake(target string) (TCPResults, error) {
 		}
 
 		// Send an HTTP GET request over the TLS connection
-		httpRequest := "GET / HTTP/1.1\r\nHost: " + addr.IP.String() + "\r\nConnection: close\r\n\r\n"
+		httpRequest := "GET / HTTP/1.1\r\nHost: " + addr.IP.String() + "\r\n" + markRawProbe(ctx) + "Connection: close\r\n\r\n"
 		n, err := tlsConn.Write([]byte(httpRequest))
 		if err != nil {
 			return results, fmt.Errorf("error writing to TLS connection: %v\n", err)
@@ -368,13 +872,24 @@ func analyzeTCPHandshake(target string) (TCPResults, error) {
 		results.CipherSuite = tlsConn.ConnectionState().CipherSuite
 	}
 
+	// Unblock pending reads if the analysis is cancelled
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
 	// Set a longer timeout to read the SYN-ACK
 	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
 
-	// Create a buffer to store the SYN-ACK response
-	buf := make([]byte, 256)
+	// One buffer to store the SYN-ACK response, for every retry
+	pooled := getTCPBuffer()
+	defer tcpBuffers.Put(pooled)
+	buf := *pooled
 	maxRetries := 5
 	for retries := 0; retries < maxRetries; retries++ {
+		if ctx.Err() != nil {
+			return results, fmt.Errorf("analysis cancelled: %v\n", ctx.Err())
+		}
 		_, err = conn.Read(buf)
 		if err == nil {
 			break
@@ -402,6 +917,8 @@ func analyzeTCPHandshake(target string) (TCPResults, error) {
 	return results, nil
 }
 
+// analyzeTCPResponse decodes the header at the start of buf. The result
+// keeps no reference to buf, which may be reused.
 func analyzeTCPResponse(buf []byte) (*TCPResponse, error) {
 	if len(buf) < 20 {
 		return nil, fmt.Errorf("Invalid TCP packet length\n")
@@ -431,7 +948,7 @@ func analyzeTCPResponse(buf []byte) (*TCPResponse, error) {
 
 	// Extract and parse TCP options
 	if response.DataOffset > 20 {
-		response.TCPOptions = buf[20:response.DataOffset]
+		response.TCPOptions = append([]byte(nil), buf[20:response.DataOffset]...)
 		parseTCPOptions(response.TCPOptions)
 	}
 
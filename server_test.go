@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"testing"
+)
+
+// replayConn serves a fixed response to every Read, standing in for a
+// socket.
+type replayConn struct {
+	net.Conn
+	r bytes.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// BenchmarkReadRecorder covers the capture of one analysis: recording the
+// first reads of the response connection and decoding them. The capture
+// buffer comes from tcpBuffers, so a warm pool allocates no buffer per
+// analysis. Budget: 20µs and 6 allocs per analysis.
+func BenchmarkReadRecorder(b *testing.B) {
+	discardStdout(b)
+	segment := make([]byte, 4096)
+	copy(segment, synAckHeader)
+	buf := make([]byte, 1024)
+	cfg.TCPCaptureBytes = 256
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		replay := &replayConn{}
+		replay.r.Reset(segment)
+		rec := &readRecorder{}
+		conn := rec.wrap(replay)
+		rec.last = conn.(*readConn)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				break
+			}
+		}
+		if _, ok := rec.tcpResults(nil); !ok {
+			b.Fatal("nothing recorded")
+		}
+		rec.release()
+	}
+}
+
+// synAckHeader is a TCP header with MSS, SACK permitted, timestamps, a NOP
+// and window scale options.
+var synAckHeader = []byte{
+	0x01, 0xbb, 0xc3, 0x50, // Ports 443 and 50000
+	0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02, // Sequence and ack numbers
+	0xa0, 0x12, 0xff, 0xff, // Data offset 40, SYN and ACK, window
+	0x00, 0x00, 0x00, 0x00, // Checksum, urgent pointer
+	0x02, 0x04, 0x05, 0xb4, // MSS 1460
+	0x04, 0x02, // SACK permitted
+	0x08, 0x0a, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, // Timestamps
+	0x01,             // NOP
+	0x03, 0x03, 0x07, // Window scale 7
+}
+
+// BenchmarkAnalyzeTCPResponse covers decoding a header with its options.
+// Budget: 10µs and 5 allocs per header.
+func BenchmarkAnalyzeTCPResponse(b *testing.B) {
+	discardStdout(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := analyzeTCPResponse(synAckHeader); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// discardStdout drops what the benchmark prints, as parseTCPOptions prints
+// each option, until it ends.
+func discardStdout(b *testing.B) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	b.Cleanup(func() {
+		os.Stdout = stdout
+		devNull.Close()
+	})
+}
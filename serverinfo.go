@@ -0,0 +1,82 @@
+package main
+
+import "strings"
+
+// Comment contents that name an operating system, matched case-insensitively
+var knownOperatingSystems = []string{
+	"Ubuntu", "Debian", "CentOS", "Red Hat", "Fedora", "Amazon Linux", "AlmaLinux", "Rocky",
+	"SUSE", "Alpine", "FreeBSD", "OpenBSD", "Win64", "Win32", "Windows", "Unix",
+}
+
+// parseServerBanner splits a Server or X-Powered-By value into its RFC 9110
+// product tokens and comments, e.g. "Apache/2.4.41 (Ubuntu) OpenSSL/1.1.1"
+// becomes Apache 2.4.41 with OS Ubuntu, and OpenSSL 1.1.1.
+func parseServerBanner(banner string) *ServerInfo {
+	banner = strings.TrimSpace(banner)
+	if banner == "" {
+		return nil
+	}
+
+	info := &ServerInfo{Raw: banner}
+	for i := 0; i < len(banner); {
+		switch c := banner[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(':
+			comment, next := readComment(banner, i)
+			i = next
+			if len(info.Components) == 0 {
+				info.Components = append(info.Components, ServerComponent{})
+			}
+			last := &info.Components[len(info.Components)-1]
+			last.Comments = append(last.Comments, comment)
+			if last.OS == "" {
+				last.OS = operatingSystem(comment)
+			}
+		default:
+			end := strings.IndexAny(banner[i:], " \t(")
+			if end < 0 {
+				end = len(banner) - i
+			}
+			product, version, _ := strings.Cut(banner[i:i+end], "/")
+			info.Components = append(info.Components, ServerComponent{Product: product, Version: version})
+			i += end
+		}
+	}
+
+	for _, c := range info.Components {
+		if info.OS == "" {
+			info.OS = c.OS
+		}
+	}
+	return info
+}
+
+// readComment returns the text of the parenthesized comment starting at
+// start, allowing nested parentheses, and the index after it.
+func readComment(s string, start int) (string, int) {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return strings.TrimSpace(s[start+1 : i]), i + 1
+			}
+		}
+	}
+	return strings.TrimSpace(s[start+1:]), len(s) // Unterminated
+}
+
+// operatingSystem returns the OS a banner comment names, if any.
+func operatingSystem(comment string) string {
+	lower := strings.ToLower(comment)
+	for _, os := range knownOperatingSystems {
+		if strings.Contains(lower, strings.ToLower(os)) {
+			return os
+		}
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+	"unicode/utf8"
+)
+
+// Most of the response body a snapshot keeps, in KB
+const maxSnapshotKB = 256
+
+// snapshotRecorder keeps the headers and the start of the body of the
+// analyzed response, with the address that served it, so content changes
+// can be diffed between analyses and investigated later. A nil recorder
+// records nothing.
+type snapshotRecorder struct {
+	limit    int    // Bytes of the body to keep
+	remote   string // Address of the connection the last request used
+	snapshot *ResponseSnapshot
+}
+
+// newSnapshotRecorder returns a recorder keeping up to kb KB of the body,
+// or nil when kb is not positive.
+func newSnapshotRecorder(kb int) *snapshotRecorder {
+	if kb <= 0 {
+		return nil
+	}
+	if kb > maxSnapshotKB {
+		kb = maxSnapshotKB
+	}
+	return &snapshotRecorder{limit: kb * 1024}
+}
+
+// trace returns ctx with a trace noting the connection each request uses.
+// After redirects the last one is the connection of the final response.
+func (s *snapshotRecorder) trace(ctx context.Context) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			s.remote = info.Conn.RemoteAddr().String()
+		},
+	})
+}
+
+// record keeps resp and its complete body.
+func (s *snapshotRecorder) record(resp *http.Response, body []byte) {
+	if s == nil {
+		return
+	}
+	snapshot := &ResponseSnapshot{
+		CapturedAt: time.Now().UTC(),
+		URL:        resp.Request.URL.String(),
+		RemoteAddr: s.remote,
+		StatusCode: resp.StatusCode,
+		Proto:      resp.Proto,
+		Headers:    resp.Header.Clone(),
+		BodyBytes:  len(body),
+		BodyHash:   truncatedSHA256(string(body)),
+	}
+	if len(body) > s.limit {
+		body = body[:s.limit]
+		snapshot.Truncated = true
+	}
+	// Text is kept readable, less a character the cut may have split
+	text := body
+	for i := 0; snapshot.Truncated && i < utf8.UTFMax-1 && !utf8.Valid(text); i++ {
+		text = text[:len(text)-1]
+	}
+	if utf8.Valid(text) {
+		snapshot.Body = string(text)
+		snapshot.BodyEncoding = "text"
+	} else {
+		snapshot.Body = base64.StdEncoding.EncodeToString(body)
+		snapshot.BodyEncoding = "base64"
+	}
+	s.snapshot = snapshot
+}
+
+func (s *snapshotRecorder) result() *ResponseSnapshot {
+	if s == nil {
+		return nil
+	}
+	return s.snapshot
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"syscall"
+	"time"
+)
+
+// setTCPKeepAlive enables TCP keepalives on a socket with explicit idle
+// time, probe interval and probe count.
+func setTCPKeepAlive(fd uintptr, idle, interval time.Duration, count int) error {
+	if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1); err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, int(idle/time.Second)); err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, int(interval/time.Second)); err != nil {
+		return err
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, count)
+}
+
+// bindToDevice sends a socket's traffic out of the named interface
+// regardless of the routing table.
+func bindToDevice(fd uintptr, iface string) error {
+	return syscall.BindToDevice(int(fd), iface)
+}
+
+// setTOS sets the IPv4 TOS byte or IPv6 traffic class of a socket.
+func setTOS(fd uintptr, network string, tos int) error {
+	if strings.HasSuffix(network, "6") {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// setTCPKeepAlive is only implemented on Linux, where the probe interval
+// and count can be set per socket.
+func setTCPKeepAlive(fd uintptr, idle, interval time.Duration, count int) error {
+	return fmt.Errorf("per-socket keepalive settings are not supported on this platform")
+}
+
+// bindToDevice is a no-op elsewhere: the interface is selected through
+// its address alone, which the routing table may still override.
+func bindToDevice(fd uintptr, iface string) error {
+	return nil
+}
+
+// setTOS is only implemented on Linux.
+func setTOS(fd uintptr, network string, tos int) error {
+	return errTOSUnsupported
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// sourceOptions selects where probe traffic leaves this host, replacing
+// -source-ip and -source-interface for one request.
+type sourceOptions struct {
+	IP        string `json:"ip,omitempty"`
+	Interface string `json:"interface,omitempty"`
+}
+
+// sourceSelection binds the dials of one analysis to a local address or
+// interface and records the local addresses they actually used, so the
+// result can be reproduced on multi-homed hosts and split tunnels.
+type sourceSelection struct {
+	ip    net.IP   // Explicit source address
+	iface string   // Interface the sockets are bound to
+	addrs []net.IP // Addresses of iface
+
+	mu   sync.Mutex
+	used []string
+}
+
+type sourceKey struct{}
+
+// newSourceSelection applies the request's choice, falling back to the
+// flags. Without either, dials are left to the routing table and only the
+// addresses used are recorded.
+func newSourceSelection(opts *sourceOptions) (*sourceSelection, error) {
+	ip, iface := cfg.SourceIP, cfg.SourceInterface
+	if opts != nil && (opts.IP != "" || opts.Interface != "") {
+		ip, iface = opts.IP, opts.Interface
+	}
+
+	s := &sourceSelection{iface: iface}
+	if ip != "" {
+		if s.ip = net.ParseIP(ip); s.ip == nil {
+			return nil, fmt.Errorf("invalid source IP %q", ip)
+		}
+	}
+	if iface == "" {
+		if s.ip != nil && !isLocalIP(s.ip) {
+			return nil, fmt.Errorf("%s is not an address of this host", s.ip)
+		}
+		return s, nil
+	}
+
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("unknown source interface %q", iface)
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of %s: %v", iface, err)
+	}
+	for _, addr := range addrs {
+		// Link-local addresses need a zone and reach nothing we probe
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+			s.addrs = append(s.addrs, ipNet.IP)
+		}
+	}
+	if len(s.addrs) == 0 {
+		return nil, fmt.Errorf("interface %s has no usable addresses", iface)
+	}
+	if s.ip != nil && !containsIP(s.addrs, s.ip) {
+		return nil, fmt.Errorf("%s is not an address of %s", s.ip, iface)
+	}
+	return s, nil
+}
+
+// withSource attaches s to ctx for dialContext.
+func withSource(ctx context.Context, s *sourceSelection) context.Context {
+	return context.WithValue(ctx, sourceKey{}, s)
+}
+
+func sourceFrom(ctx context.Context) *sourceSelection {
+	s, _ := ctx.Value(sourceKey{}).(*sourceSelection)
+	return s
+}
+
+func (s *sourceSelection) selected() bool {
+	return s.ip != nil || s.iface != ""
+}
+
+// bind returns a copy of dialer that dials from the selected source.
+// Loopback destinations, such as a local DNS stub, are left alone since
+// they cannot be reached through another interface.
+func (s *sourceSelection) bind(dialer *net.Dialer, network, addr string) *net.Dialer {
+	host, _, _ := net.SplitHostPort(addr)
+	remote := net.ParseIP(host)
+	if !s.selected() || (remote != nil && remote.IsLoopback()) {
+		return dialer
+	}
+
+	d := *dialer
+	if ip := s.localIP(remote); ip != nil {
+		switch network {
+		case "udp", "udp4", "udp6":
+			d.LocalAddr = &net.UDPAddr{IP: ip}
+		default:
+			d.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+	}
+	if s.iface != "" {
+		control := d.Control
+		d.Control = func(network, address string, c syscall.RawConn) error {
+			if control != nil {
+				if err := control(network, address, c); err != nil {
+					return err
+				}
+			}
+			var bindErr error
+			if err := c.Control(func(fd uintptr) {
+				bindErr = bindToDevice(fd, s.iface)
+			}); err != nil {
+				return err
+			}
+			return bindErr
+		}
+	}
+	return &d
+}
+
+// localIP picks the source address for remote: the explicit one, else the
+// interface address of the same family. Host names are assumed to resolve
+// to IPv4 when the interface has an IPv4 address.
+func (s *sourceSelection) localIP(remote net.IP) net.IP {
+	if s.ip != nil || len(s.addrs) == 0 {
+		return s.ip
+	}
+	wantIPv4 := remote == nil || remote.To4() != nil
+	for _, ip := range s.addrs {
+		if (ip.To4() != nil) == wantIPv4 {
+			return ip
+		}
+	}
+	return s.addrs[0]
+}
+
+// record notes the local address of a successful dial.
+func (s *sourceSelection) record(addr net.Addr) {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, used := range s.used {
+		if used == host {
+			return
+		}
+	}
+	s.used = append(s.used, host)
+}
+
+// info reports the selection and the addresses actually used.
+func (s *sourceSelection) info() *SourceInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := &SourceInfo{Interface: s.iface, Addresses: append([]string(nil), s.used...)}
+	if s.ip != nil {
+		info.IP = s.ip.String()
+	}
+	return info
+}
+
+func isLocalIP(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, candidate := range ips {
+		if candidate.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,29 +1,1153 @@
 package main
 
+import (
+	"crypto/tls"
+	"time"
+)
+
+// Request structure
+type analysisRequest struct {
+	Domain  string `json:"domain"`
+	SkipTCP bool   `json:"skipTCP"` // Skip the TCP handshake analysis
+	SkipDNS bool   `json:"skipDNS"` // Skip CNAME/A record resolution
+	SkipIPs bool   `json:"skipIPs"` // Skip the per A record requests
+
+	SampleCount int `json:"sampleCount,omitempty"` // Requests per A record, up to 20
+
+	ReuseConnections bool `json:"reuseConnections"` // Send the per A record requests over pooled keep-alive connections
+
+	CaptureBodyKB int `json:"captureBodyKB,omitempty"` // Keep the headers and this much of the body, up to 256
+
+	// Checked against the result, e.g. "keepAliveTimeout >= 60" or
+	// "tlsVersion == TLS 1.3", see assertionMetrics
+	Assertions []string `json:"assertions,omitempty"`
+
+	assertions []assertion // Parsed from Assertions
+
+	Budget *budgetOptions `json:"budget,omitempty"` // Stricter limits than the server's caps
+	Source *sourceOptions `json:"source,omitempty"` // Local address or interface to probe from
+	Proxy  string         `json:"proxy,omitempty"`  // http:// or socks5:// proxy, replaces -proxy
+
+	// PEM client certificate and key for targets that require mTLS
+	ClientCert string `json:"clientCert,omitempty"`
+	ClientKey  string `json:"clientKey,omitempty"`
+
+	clientCert *tls.Certificate // Parsed from ClientCert/ClientKey
+
+	// Verify the certificate chain and hostname against the system roots
+	// and -ca-bundle, and require one of the SPKI pins in the chain
+	VerifyTLS bool     `json:"verifyTLS"`
+	PinSPKI   []string `json:"pinSPKI,omitempty"` // Base64 SHA-256 of SubjectPublicKeyInfo
+
+	pins map[string]bool // Parsed from PinSPKI
+
+	TLSProfile          string `json:"tlsProfile,omitempty"` // ClientHello variant, see tlsProfiles
+	CompareFingerprints bool   `json:"compareFingerprints"`  // Repeat the request with every profile
+
+	IdleProbe        bool `json:"idleProbe"`                  // Watch how an idle keep-alive connection is closed
+	HalfOpenProbe    bool `json:"halfOpenProbe"`              // Reuse a connection after idling past its timeout
+	IdleProbeSeconds int  `json:"idleProbeSeconds,omitempty"` // Longest idle wait, default 90
+
+	TCPKeepAlive *TCPKeepAliveOptions `json:"tcpKeepAlive,omitempty"` // Idle with TCP keepalives enabled
+
+	EstimateIdleTimeout bool `json:"estimateIdleTimeout"` // Search for the path's idle timeout
+
+	HTTP2Probe bool `json:"http2Probe"` // PING, stream limit and idle GOAWAY on an h2 connection
+	GRPCProbe  bool `json:"grpcProbe"`  // Call the gRPC health and reflection services
+
+	RDAP              bool `json:"rdap"`              // Include registration data, needs the -rdap flag
+	SiteHygiene       bool `json:"siteHygiene"`       // Probe security.txt, robots.txt and change-password
+	ProbeMethods      bool `json:"probeMethods"`      // Send OPTIONS, TRACE and HEAD
+	CacheAnalysis     bool `json:"cacheAnalysis"`     // Send repeated and conditional requests
+	VaryAnalysis      bool `json:"varyAnalysis"`      // Check the Vary header against varied requests
+	ProtocolEdgeCases bool `json:"protocolEdgeCases"` // Trailers, 100-continue and HTTP/1.0 keep-alive
+
+	// Repeat the request per User-Agent, preset names (chrome, curl,
+	// googlebot) or literal strings; empty uses every preset
+	CompareUserAgents bool     `json:"compareUserAgents"`
+	UserAgents        []string `json:"userAgents,omitempty"`
+
+	LanguageAnalysis bool `json:"languageAnalysis"` // Vary Accept-Language
+	DowngradeProbe   bool `json:"downgradeProbe"`   // HTTP/1.0 and Host-less requests
+	HeaderLint       bool `json:"headerLint"`       // Check raw response headers for hygiene problems
+	TLSScore         bool `json:"tlsScore"`         // Grade the TLS versions and cipher suites
+
+	// Check the resources of the page against its Content Security
+	// Policies, or against cspPolicy when given, with cspProbeReporting
+	// whether the reporting endpoints accept reports, and with cspRender
+	// the resources scripts add when the page is rendered
+	CSPAnalysis       bool   `json:"cspAnalysis"`
+	CSPPolicy         string `json:"cspPolicy,omitempty"`
+	CSPProbeReporting bool   `json:"cspProbeReporting"`
+	CSPRender         bool   `json:"cspRender"`
+
+	DSCP *dscpOptions `json:"dscp,omitempty"` // Compare latency and loss with DSCP marking
+
+	DNSBehavior     bool `json:"dnsBehavior"`     // Detect round-robin and wildcard records
+	DelegationCheck bool `json:"delegationCheck"` // Check the zone's delegation, glue and servers
+
+	CDNDebug bool `json:"cdnDebug"` // Repeat the request with Fastly-Debug and Akamai Pragma headers
+	Topology bool `json:"topology"` // Infer the CDN and proxy layers, with ASN lookups
+
+	ProbeCNAMEChain bool `json:"probeCnameChain"` // Request the target under each name of its CNAME chain
+
+	// Other scheme:port listeners of the host to request from every address,
+	// such as "http:8080" and "https:8443"
+	Listeners []string `json:"listeners,omitempty"`
+}
+
+// TCPKeepAliveOptions configures the keepalive probe socket. Zero values
+// fall back to 15s idle, 15s interval and 3 probes.
+type TCPKeepAliveOptions struct {
+	Idle     int `json:"idle,omitempty"`     // Seconds before the first keepalive
+	Interval int `json:"interval,omitempty"` // Seconds between keepalives
+	Count    int `json:"count,omitempty"`    // Unanswered keepalives before giving up
+}
+
 // Response structure
 type response struct {
-	Domain           string   `json:"domain"`
-	KeepAliveTimeout string   `json:"keepAliveTimeout"`
-	RequestDuration  int64    `json:"requestDuration"`
-	TLSVersion       string   `json:"tlsVersion"`
-	ConnectionHeader string   `json:"connectionHeader"`
-	ServerHeader     string   `json:"serverHeader"`
-	PoweredHeader    string   `json:"poweredHeader"`    // X-Powered-By
-	ForwardHeader    string   `json:"forwardHeader"`    // X-Forwarded-For
-	RealIPHeader     string   `json:"realipHeader"`     // X-Real-IP
-	XCacheHeader     string   `json:"xCacheHeader"`     // X-Cache header info
-	CloudflareHeader string   `json:"cloudflareHeader"` // Cloudflare specific headers
-	CloudFrontHeader string   `json:"cloudfrontHeader"` // Indicator for AWS CloudFront
-	AkamaiHeader     string   `json:"akamaiHeader"`
-	CnameRecords     []string `json:"cnameRecords,omitempty"`
-	ARecords         []string `json:"aRecords,omitempty"`
-	TCPResults       string   `json:"tcpResults"` // Keep as a string
+	Domain           string           `json:"domain"`
+	KeepAliveTimeout string           `json:"keepAliveTimeout"`
+	KeepAlive        *KeepAliveHeader `json:"keepAlive,omitempty"` // Parsed Keep-Alive header
+	RequestDuration  int64            `json:"requestDuration"`
+	TLSVersion       string           `json:"tlsVersion"`
+	ConnectionHeader string           `json:"connectionHeader"`
+	ServerHeader     string           `json:"serverHeader"`
+	PoweredHeader    string           `json:"poweredHeader"`    // X-Powered-By
+	ForwardHeader    string           `json:"forwardHeader"`    // X-Forwarded-For
+	RealIPHeader     string           `json:"realipHeader"`     // X-Real-IP
+	XCacheHeader     string           `json:"xCacheHeader"`     // X-Cache header info
+	CloudflareHeader string           `json:"cloudflareHeader"` // Cloudflare specific headers
+	CloudFrontHeader string           `json:"cloudfrontHeader"` // Indicator for AWS CloudFront
+	AkamaiHeader     string           `json:"akamaiHeader"`
+	CSP              []string         `json:"csp,omitempty"`           // Content-Security-Policy headers
+	CSPReportOnly    []string         `json:"cspReportOnly,omitempty"` // Content-Security-Policy-Report-Only headers
+	CSPAnalysis      *CSPAnalysis     `json:"cspAnalysis,omitempty"`   // What the policies block on the page
+	IDN              *IDNInfo         `json:"idn,omitempty"`           // Unicode form and homograph checks of an internationalized name
+	CDN              *CDNDetection    `json:"cdn,omitempty"`           // Providers and the edge locations that served the request
+	Via              []ViaHop         `json:"via,omitempty"`           // Proxies from the Via header, nearest the client first
+	CNAMEChain       *CNAMEChainProbe `json:"cnameChain,omitempty"`    // Requests to each name of the CNAME chain
+	Topology         *Topology        `json:"topology,omitempty"`
+	CnameRecords     []string         `json:"cnameRecords,omitempty"`
+	ARecords         []string         `json:"aRecords,omitempty"`
+	DNS              *DNSResolution   `json:"dns,omitempty"` // The queries behind the records
+	TCPResults       string           `json:"tcpResults"`    // Keep as a string
+
+	Snapshot *ResponseSnapshot `json:"snapshot,omitempty"` // Headers and start of the body, with captureBodyKB
+
+	ClientAuth  *ClientAuthInfo    `json:"clientAuth,omitempty"`
+	Certificate *CertificateCheck  `json:"certificate,omitempty"` // Verified even when the analysis skips verification
+	TLSScore    *TLSScore          `json:"tlsScore,omitempty"`
+	Resumption  *SessionResumption `json:"resumption,omitempty"` // HTTPS targets only
+	IPResults   []IPResult         `json:"ipResults,omitempty"`  // One entry per A record
+	Listeners   *ListenerMatrix    `json:"listeners,omitempty"`  // IPResults for every listener
+	Backends    *BackendAnalysis   `json:"backends,omitempty"`   // IPResults grouped into backend builds
+
+	TLSFingerprint     *TLSFingerprint     `json:"tlsFingerprint,omitempty"` // ClientHello the analyzer presented
+	FingerprintResults []FingerprintResult `json:"fingerprintResults,omitempty"`
+
+	IdleClose *IdleCloseResult `json:"idleClose,omitempty"`
+	HalfOpen  *HalfOpenResult  `json:"halfOpen,omitempty"`
+
+	TCPKeepAlive *TCPKeepAliveResult  `json:"tcpKeepAlive,omitempty"`
+	IdleTimeout  *IdleTimeoutEstimate `json:"idleTimeout,omitempty"`
+	HTTP2        *HTTP2Result         `json:"http2,omitempty"`
+
+	Extensions map[string]map[string]interface{} `json:"extensions,omitempty"` // Registered detectors, by vendor
+
+	Registration *RegistrationInfo  `json:"registration,omitempty"` // From RDAP
+	HSTS         *HSTSInfo          `json:"hsts,omitempty"`
+	SiteHygiene  *SiteHygiene       `json:"siteHygiene,omitempty"`
+	Methods      *MethodSupport     `json:"methods,omitempty"`
+	Caching      *CacheAnalysis     `json:"caching,omitempty"`
+	Vary         *VaryAnalysis      `json:"vary,omitempty"`
+	EdgeCases    *ProtocolEdgeCases `json:"edgeCases,omitempty"`
+
+	UserAgentResults []UserAgentResult `json:"userAgentResults,omitempty"`
+	Languages        *LanguageAnalysis `json:"languages,omitempty"`
+	Downgrade        *DowngradeResult  `json:"downgrade,omitempty"`
+	HeaderFindings   []Finding         `json:"headerFindings,omitempty"`
+
+	ServerInfo *ServerInfo       `json:"serverInfo,omitempty"` // Parsed Server header
+	ETags      *ETagAnalysis     `json:"etags,omitempty"`
+	Advisories *ServerAdvisories `json:"advisories,omitempty"` // From the Server and X-Powered-By banners
+
+	DSCP *DSCPComparison `json:"dscp,omitempty"`
+
+	Assertions *AssertionReport `json:"assertions,omitempty"` // Pass or fail of the request's assertions
+
+	Budget   *BudgetUsage `json:"budget,omitempty"`   // What the analysis consumed
+	Warnings []Finding    `json:"warnings,omitempty"` // Non-fatal problems that make results partial or less reliable
+	Source   *SourceInfo  `json:"source,omitempty"`   // Where the probes were sent from
+	Proxy    *ProxyInfo   `json:"proxy,omitempty"`    // Time spent reaching the proxy and the target
+}
+
+// CDNDetection lists the CDNs seen in the response headers and the points
+// of presence their debug headers name.
+type CDNDetection struct {
+	Providers []string      `json:"providers,omitempty"`
+	POPs      []EdgePOP     `json:"pops,omitempty"`
+	Debug     *CDNDebugInfo `json:"debug,omitempty"` // Answer to the CDN debug headers
+}
+
+// EdgePOP is one edge location decoded from a CDN header. Code is the IATA
+// airport code the CDN names the location after; City and Country are empty
+// for codes the analyzer doesn't know.
+type EdgePOP struct {
+	Provider string `json:"provider"`
+	Header   string `json:"header"`
+	Node     string `json:"node"` // Header value naming the node
+	Code     string `json:"code,omitempty"`
+	City     string `json:"city,omitempty"`
+	Country  string `json:"country,omitempty"`
+	NodeIP   string `json:"nodeIp,omitempty"` // Akamai edge servers
+	Role     string `json:"role,omitempty"`   // "shield" or "parent" for a tier behind the edge
+}
+
+// IDNInfo shows an internationalized host name in both forms. Findings
+// flag labels that could imitate another name.
+type IDNInfo struct {
+	Unicode  string    `json:"unicode"`
+	ASCII    string    `json:"ascii"` // Punycode, as sent in DNS, SNI and Host
+	Scripts  []string  `json:"scripts,omitempty"`
+	Findings []Finding `json:"findings,omitempty"`
+}
+
+// Topology is the chain of CDNs and proxies inferred between the client and
+// the origin. Diagram draws it on one line.
+type Topology struct {
+	Hops       []TopologyHop `json:"hops"` // Nearest the client first, the origin last
+	Diagram    string        `json:"diagram"`
+	CNAMEChain []string      `json:"cnameChain,omitempty"`
+	ASNs       []ASNInfo     `json:"asns,omitempty"` // Networks announcing the A records
+	Error      string        `json:"error,omitempty"`
+}
+
+// CNAMEChainProbe compares the target requested under each name of its
+// CNAME chain, the queried name first.
+type CNAMEChainProbe struct {
+	Hops    []CNAMEHop `json:"hops,omitempty"`
+	Changes []string   `json:"changes,omitempty"` // Differences between consecutive names
+	Error   string     `json:"error,omitempty"`
+}
+
+// CNAMEHop is one request to a name of the CNAME chain.
+type CNAMEHop struct {
+	Name             string           `json:"name"`
+	Addresses        []string         `json:"addresses,omitempty"`
+	ConnectMs        float64          `json:"connectMs,omitempty"`
+	TLSHandshakeMs   float64          `json:"tlsHandshakeMs,omitempty"`
+	TTFBMs           float64          `json:"ttfbMs,omitempty"`
+	TotalMs          float64          `json:"totalMs,omitempty"`
+	StatusCode       int              `json:"statusCode,omitempty"`
+	Server           string           `json:"server,omitempty"`
+	Connection       string           `json:"connection,omitempty"`
+	KeepAliveTimeout string           `json:"keepAliveTimeout,omitempty"`
+	KeepAlive        *KeepAliveHeader `json:"keepAlive,omitempty"`
+	TLSVersion       string           `json:"tlsVersion,omitempty"`
+	Error            string           `json:"error,omitempty"`
+}
+
+// ViaHop is one entry of the Via header.
+type ViaHop struct {
+	Protocol   string `json:"protocol"` // "HTTP" unless the entry names another
+	Version    string `json:"version"`
+	ReceivedBy string `json:"receivedBy"`         // Host and port, or a pseudonym
+	Pseudonym  bool   `json:"pseudonym"`          // ReceivedBy is a name the proxy chose, not a host
+	Comment    string `json:"comment,omitempty"`  // Usually the proxy software
+	Provider   string `json:"provider,omitempty"` // CDN known to send this entry
+	Raw        string `json:"raw"`
+}
+
+// TopologyHop is one layer of the topology and what it was recognized by.
+type TopologyHop struct {
+	Provider string   `json:"provider"` // CDN, Via pseudonym, or network for an unrecognized edge
+	Role     string   `json:"role"`     // "edge", "cdn", "proxy" or "origin"
+	Evidence []string `json:"evidence,omitempty"`
+}
+
+// ASNInfo is the network announcing an address, from Team Cymru.
+type ASNInfo struct {
+	IP       string `json:"ip"`
+	ASN      int    `json:"asn,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Prefix   string `json:"prefix,omitempty"`
+	Country  string `json:"country,omitempty"`
+	Registry string `json:"registry,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// CDNDebugInfo is what the CDNs reported about a request sent with their
+// debug headers.
+type CDNDebugInfo struct {
+	RequestHeaders map[string]string `json:"requestHeaders"` // The debug headers sent
+	StatusCode     int               `json:"statusCode,omitempty"`
+	Fastly         *FastlyDebug      `json:"fastly,omitempty"`
+	Akamai         *AkamaiDebug      `json:"akamai,omitempty"`
+	POPs           []EdgePOP         `json:"pops,omitempty"` // Decoded from the debug response
+	Summary        string            `json:"summary,omitempty"`
+	Error          string            `json:"error,omitempty"`
+}
+
+// FastlyDebug holds the Fastly-Debug-* response headers.
+type FastlyDebug struct {
+	Path             []FastlyDebugNode `json:"path,omitempty"`      // Fastly-Debug-Path
+	TTL              []FastlyDebugTTL  `json:"ttl,omitempty"`       // Fastly-Debug-TTL
+	Digest           string            `json:"digest,omitempty"`    // Hash of the cache key
+	Cache            string            `json:"cache,omitempty"`     // X-Cache, one status per node
+	CacheHits        string            `json:"cacheHits,omitempty"` // X-Cache-Hits
+	SurrogateKey     string            `json:"surrogateKey,omitempty"`
+	SurrogateControl string            `json:"surrogateControl,omitempty"`
+}
+
+// FastlyDebugNode is a cache node the request passed through.
+type FastlyDebugNode struct {
+	Role string `json:"role"` // "delivery" or "fetch"
+	Node string `json:"node"`
+	Code string `json:"code,omitempty"` // POP
+	Time int64  `json:"time,omitempty"` // Unix time on the node
+}
+
+// FastlyDebugTTL is the cache state of the object on one node. The
+// numbers are missing for misses and passes.
+type FastlyDebugTTL struct {
+	Status string   `json:"status"` // "hit", "miss" or "pass"
+	Node   string   `json:"node"`
+	TTL    *float64 `json:"ttlSeconds,omitempty"` // Remaining
+	Grace  *float64 `json:"graceSeconds,omitempty"`
+	Age    *float64 `json:"ageSeconds,omitempty"`
+}
+
+// AkamaiDebug holds the answers to Akamai's Pragma debug directives.
+type AkamaiDebug struct {
+	CacheStatus  string            `json:"cacheStatus,omitempty"`  // X-Cache, e.g. TCP_HIT
+	ParentStatus string            `json:"parentStatus,omitempty"` // X-Cache-Remote
+	Cacheable    *bool             `json:"cacheable,omitempty"`    // X-Check-Cacheable
+	CacheKey     string            `json:"cacheKey,omitempty"`
+	CacheKeyTTL  string            `json:"cacheKeyTTL,omitempty"` // TTL field of the cache key
+	TrueCacheKey string            `json:"trueCacheKey,omitempty"`
+	RequestID    string            `json:"requestId,omitempty"`
+	Serial       string            `json:"serial,omitempty"`
+	Staging      bool              `json:"staging,omitempty"`     // Served by the staging network
+	SessionInfo  map[string]string `json:"sessionInfo,omitempty"` // X-Akamai-Session-Info variables
+}
+
+// ProxyInfo splits the connection time of proxied connections into
+// reaching the proxy and the tunnel handshake, which ends once the proxy
+// has connected to the target.
+type ProxyInfo struct {
+	Proxy       string        `json:"proxy"` // Without credentials
+	Connections int           `json:"connections"`
+	ConnectMs   *LatencyStats `json:"connectMs,omitempty"`
+	TunnelMs    *LatencyStats `json:"tunnelMs,omitempty"`
+	Failures    []string      `json:"failures,omitempty"`
+	Summary     string        `json:"summary,omitempty"`
+}
+
+// DSCPComparison contrasts requests whose packets carry a DSCP codepoint
+// with unmarked ones to the same address.
+type DSCPComparison struct {
+	Codepoint string          `json:"codepoint"`
+	DSCP      int             `json:"dscp"`
+	IP        string          `json:"ip,omitempty"`
+	Unmarked  *LatencySamples `json:"unmarked,omitempty"`
+	Marked    *LatencySamples `json:"marked,omitempty"`
+	Summary   string          `json:"summary,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// DNSResolution lists the queries sent while resolving the target.
+type DNSResolution struct {
+	DurationMs  float64    `json:"durationMs"`
+	Queries     []DNSQuery `json:"queries"`
+	Retries     int        `json:"retries"`     // Queries repeated to the same server
+	TCPFallback bool       `json:"tcpFallback"` // A truncated answer was retried over TCP
+
+	Behavior   *DNSBehavior     `json:"behavior,omitempty"`   // Rotation and wildcard checks
+	Delegation *DelegationCheck `json:"delegation,omitempty"` // Health of the authoritative path
+}
+
+// DelegationCheck is the zone's delegation as the parent publishes it and
+// how each delegated server answers for the zone.
+type DelegationCheck struct {
+	Zone         string                `json:"zone,omitempty"`
+	Parent       string                `json:"parent,omitempty"`
+	ParentServer string                `json:"parentServer,omitempty"` // Queried for the delegation
+	Delegation   []string              `json:"delegation,omitempty"`   // NS names in the parent
+	Nameservers  []DelegatedNameserver `json:"nameservers,omitempty"`
+	Findings     []Finding             `json:"findings,omitempty"`
+	Error        string                `json:"error,omitempty"`
+}
+
+// DelegatedNameserver is one NS of the delegation.
+type DelegatedNameserver struct {
+	Name      string                   `json:"name"`
+	Glue      []string                 `json:"glue,omitempty"`
+	Addresses []DelegatedServerAddress `json:"addresses,omitempty"`
+	Error     string                   `json:"error,omitempty"`
+}
+
+// DelegatedServerAddress is a non-recursive SOA and NS query to one address
+// of a delegated server.
+type DelegatedServerAddress struct {
+	Address       string   `json:"address"`
+	RTTMs         float64  `json:"rttMs,omitempty"`
+	RCode         string   `json:"rcode,omitempty"`
+	Authoritative bool     `json:"authoritative"`
+	Serial        uint32   `json:"serial,omitempty"`
+	NS            []string `json:"ns,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// DNSBehavior compares repeated answers for the target and looks for
+// wildcard records next to and below it.
+type DNSBehavior struct {
+	Server         string             `json:"server,omitempty"`
+	Answers        [][]string         `json:"answers,omitempty"` // A records in answer order, per query
+	Addresses      []string           `json:"addresses,omitempty"`
+	Stable         bool               `json:"stable"`  // Every answer had the same set
+	Rotates        bool               `json:"rotates"` // Same set, changing order
+	Wildcard       bool               `json:"wildcard"`
+	WildcardProbes []DNSWildcardProbe `json:"wildcardProbes,omitempty"`
+	Summary        string             `json:"summary,omitempty"`
+	Error          string             `json:"error,omitempty"`
+}
+
+// DNSWildcardProbe is the answer for a random name near the target.
+type DNSWildcardProbe struct {
+	Name       string   `json:"name"`
+	RCode      string   `json:"rcode,omitempty"`
+	Addresses  []string `json:"addresses,omitempty"`
+	Wildcard   bool     `json:"wildcard"`
+	SameAsName bool     `json:"sameAsName,omitempty"` // Same addresses as the target
+	Error      string   `json:"error,omitempty"`
+}
+
+// DNSQuery is one query attempt to one server.
+type DNSQuery struct {
+	Name          string  `json:"name"`
+	Type          string  `json:"type"`
+	Server        string  `json:"server"`
+	Network       string  `json:"network"`
+	Attempt       int     `json:"attempt"`
+	RTTMs         float64 `json:"rttMs"`
+	RequestBytes  int     `json:"requestBytes"`
+	ResponseBytes int     `json:"responseBytes,omitempty"`
+	ClientUDPSize uint16  `json:"clientUdpSize,omitempty"` // EDNS0 payload size offered
+	ServerUDPSize uint16  `json:"serverUdpSize,omitempty"` // EDNS0 payload size in the answer
+	RCode         string  `json:"rcode,omitempty"`
+	Truncated     bool    `json:"truncated,omitempty"`
+	Authoritative bool    `json:"authoritative,omitempty"`
+	Answers       int     `json:"answers,omitempty"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// SourceInfo records the source selection of a probe and the local
+// addresses its connections used.
+type SourceInfo struct {
+	IP        string   `json:"ip,omitempty"`
+	Interface string   `json:"interface,omitempty"`
+	Addresses []string `json:"addresses"`
+}
+
+// BudgetUsage compares what an analysis consumed with its limits.
+type BudgetUsage struct {
+	Requests      int64   `json:"requests"`
+	MaxRequests   int64   `json:"maxRequests"`
+	Denied        int64   `json:"denied,omitempty"` // Requests not sent because the budget was spent
+	Bytes         int64   `json:"bytes"`
+	MaxBytes      int64   `json:"maxBytes"`
+	DurationMs    float64 `json:"durationMs"`
+	MaxDurationMs int64   `json:"maxDurationMs"`
+	Exceeded      string  `json:"exceeded,omitempty"` // The limit that stopped the analysis
+}
+
+// AgentInfo is a probe agent registered with this coordinator.
+type AgentInfo struct {
+	Region       string    `json:"region"`
+	URL          string    `json:"url"`
+	RegisteredAt time.Time `json:"registeredAt"`
+	LastSeen     time.Time `json:"lastSeen"`
+}
+
+// SubdomainDiscovery lists the names found below a zone that resolve.
+type SubdomainDiscovery struct {
+	Zone       string               `json:"zone"`
+	Tried      int                  `json:"tried"` // Names from the wordlist and CT logs
+	Candidates []SubdomainCandidate `json:"candidates"`
+	Truncated  bool                 `json:"truncated,omitempty"` // More resolved than are listed
+	Wildcard   []string             `json:"wildcard,omitempty"`  // Addresses of the zone's wildcard record
+	Errors     []string             `json:"errors,omitempty"`
+}
+
+// SubdomainCandidate is a name that resolved and where it was found.
+type SubdomainCandidate struct {
+	Name      string   `json:"name"`
+	Sources   []string `json:"sources"` // "wordlist" and/or "ct"
+	Addresses []string `json:"addresses"`
+	Wildcard  bool     `json:"wildcard,omitempty"` // Answered by the wildcard record
+}
+
+// BatchResult is one analysis per domain of a batch.
+type BatchResult struct {
+	Subdomains *SubdomainDiscovery `json:"subdomains,omitempty"`
+	Results    []BatchEntry        `json:"results"`
+	Skipped    int                 `json:"skipped,omitempty"` // Domains beyond the batch limit
+}
+
+// BatchEntry repeats the headline values of an analysis next to the full
+// result, for comparing a zone at a glance.
+type BatchEntry struct {
+	Domain           string    `json:"domain"`
+	KeepAliveTimeout string    `json:"keepAliveTimeout,omitempty"`
+	TLSVersion       string    `json:"tlsVersion,omitempty"`
+	TLSGrade         string    `json:"tlsGrade,omitempty"`
+	RequestDuration  int64     `json:"requestDuration,omitempty"`
+	Error            string    `json:"error,omitempty"`
+	Result           *response `json:"result,omitempty"`
+}
+
+// RegionalReport is one analysis run by every registered agent.
+type RegionalReport struct {
+	Domain      string         `json:"domain"`
+	Regions     []RegionResult `json:"regions"`
+	Differences []string       `json:"differences,omitempty"` // What the regions saw differently
+}
+
+// RegionResult is the analysis one agent returned.
+type RegionResult struct {
+	Region     string    `json:"region"`
+	Agent      string    `json:"agent"`
+	DurationMs float64   `json:"durationMs"`
+	Result     *response `json:"result,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// TCPServiceResult describes a non-HTTP TCP service.
+type TCPServiceResult struct {
+	Address         string          `json:"address"`
+	Protocol        string          `json:"protocol"`
+	ConnectMs       float64         `json:"connectMs"`
+	Banner          string          `json:"banner,omitempty"`
+	Capabilities    []string        `json:"capabilities,omitempty"` // Reply to EHLO, CAPABILITY, CAPA, FEAT or PING
+	StartTLSOffered bool            `json:"startTLSOffered"`
+	TLS             *TCPServiceTLS  `json:"tls,omitempty"`
+	Idle            *TCPServiceIdle `json:"idle,omitempty"`
+	Source          *SourceInfo     `json:"source,omitempty"`
+	Proxy           *ProxyInfo      `json:"proxy,omitempty"`
+	Error           string          `json:"error,omitempty"`
+}
+
+// TCPServiceTLS is the TLS session negotiated with a TCP service.
+type TCPServiceTLS struct {
+	Mode        string            `json:"mode"` // implicit or starttls
+	Version     string            `json:"version"`
+	CipherSuite string            `json:"cipherSuite"`
+	Certificate *CertificateCheck `json:"certificate,omitempty"`
+}
+
+// TCPServiceIdle reports how a TCP service ends an idle connection.
+type TCPServiceIdle struct {
+	CloseType          string  `json:"closeType"` // FIN, RST, CloseNotify or None
+	ClosedAfterSeconds float64 `json:"closedAfterSeconds,omitempty"`
+	WaitedSeconds      float64 `json:"waitedSeconds"`
+	Message            string  `json:"message,omitempty"` // Sent before closing, e.g. 421 timeout
+}
+
+// UDPProbeResult reports whether a UDP service answered.
+type UDPProbeResult struct {
+	Address       string      `json:"address"`
+	Protocol      string      `json:"protocol"`
+	Reachable     bool        `json:"reachable"`
+	Attempts      int         `json:"attempts"`
+	RTTMs         float64     `json:"rttMs,omitempty"`
+	ResponseBytes int         `json:"responseBytes,omitempty"`
+	Summary       string      `json:"summary,omitempty"` // What the reply said
+	QUICVersions  []string    `json:"quicVersions,omitempty"`
+	Source        *SourceInfo `json:"source,omitempty"`
+	Error         string      `json:"error,omitempty"`
+}
+
+// CheckResult is the outcome of a declarative check run via /api/check.
+type CheckResult struct {
+	Name       string            `json:"name,omitempty"`
+	Passed     bool              `json:"passed"`
+	DurationMs float64           `json:"durationMs"`
+	Steps      []CheckStepResult `json:"steps"`
+}
+
+// AssertionReport is the outcome of a request's assertions. Status is
+// fail when any assertion failed.
+type AssertionReport struct {
+	Status  string            `json:"status"` // pass or fail
+	Results []AssertionResult `json:"results"`
+}
+
+// AssertionResult is one assertion and the value it was tested against.
+type AssertionResult struct {
+	Assertion string `json:"assertion"`
+	Actual    string `json:"actual,omitempty"`
+	Passed    bool   `json:"passed"`
+	Error     string `json:"error,omitempty"` // The value is missing or not comparable
+}
+
+// RunRecord is the outcome of one analysis kept in the run history.
+type RunRecord struct {
+	Time              time.Time `json:"time"`
+	RunID             string    `json:"runId,omitempty"`
+	Target            string    `json:"target"`
+	Status            string    `json:"status"` // pass, fail (an assertion failed) or error
+	DurationMs        float64   `json:"durationMs"`
+	Error             string    `json:"error,omitempty"`
+	RequestDurationMs int64     `json:"requestDurationMs,omitempty"`
+	TTFBMs            float64   `json:"ttfbMs,omitempty"` // Median over the per-IP requests
+	KeepAliveTimeout  string    `json:"keepAliveTimeout,omitempty"`
+	TLSVersion        string    `json:"tlsVersion,omitempty"`
+	ResultKey         string    `json:"resultKey,omitempty"` // Of the complete result, with -result-store
+}
+
+// RunPage is one page of /api/history.
+type RunPage struct {
+	Runs       []map[string]interface{} `json:"runs"`                 // RunRecords, with only the fields asked for
+	NextCursor string                   `json:"nextCursor,omitempty"` // Pass as cursor for the next page, absent on the last
+}
+
+// ExportResult says where /api/history/export wrote the runs.
+type ExportResult struct {
+	Destination string `json:"destination"` // file or s3
+	Location    string `json:"location"`    // Path or object URL
+	Runs        int    `json:"runs"`
+	Bytes       int    `json:"bytes"`
+}
+
+// SLAReport is the availability of one target over a time range.
+// Availability is time-weighted from the start of the range, or from the
+// first run when none came before it.
+type SLAReport struct {
+	Target          string    `json:"target"`
+	From            time.Time `json:"from"`
+	To              time.Time `json:"to"`
+	Runs            int       `json:"runs"`
+	FailedRuns      int       `json:"failedRuns"`
+	Availability    float64   `json:"availability"`   // Percent of the observed time
+	RunSuccessRate  float64   `json:"runSuccessRate"` // Percent of runs that passed
+	ObservedSeconds float64   `json:"observedSeconds"`
+	DowntimeSeconds float64   `json:"downtimeSeconds"`
+	MTTRSeconds     float64   `json:"mttrSeconds,omitempty"` // Mean time to recovery of the ended outages
+	Outages         []Outage  `json:"outages"`
+}
+
+// Outage is a window of failed runs, up to the next successful one.
+type Outage struct {
+	Start           time.Time  `json:"start"`
+	End             *time.Time `json:"end,omitempty"` // The successful run that ended it
+	DurationSeconds float64    `json:"durationSeconds"`
+	Ongoing         bool       `json:"ongoing,omitempty"`
+	Error           string     `json:"error,omitempty"` // Of the first failed run
+}
+
+// CheckStepResult is one request of a check and the assertions it failed.
+type CheckStepResult struct {
+	Name       string   `json:"name"`
+	Method     string   `json:"method"`
+	URL        string   `json:"url"`
+	StatusCode int      `json:"statusCode,omitempty"`
+	LatencyMs  float64  `json:"latencyMs"` // Until the body was read
+	Passed     bool     `json:"passed"`
+	Failures   []string `json:"failures,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// CertificateCheck describes the target's certificate and why a verifying
+// client would reject it.
+type CertificateCheck struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	DNSNames    []string  `json:"dnsNames,omitempty"`
+	NotBefore   string    `json:"notBefore"`
+	NotAfter    string    `json:"notAfter"`
+	ChainLength int       `json:"chainLength"` // Certificates the server sent
+	Verified    bool      `json:"verified"`    // Against the system roots and -ca-bundle
+	Findings    []Finding `json:"findings,omitempty"`
+}
+
+// TLSScore grades the target's TLS configuration.
+type TLSScore struct {
+	Grade              string     `json:"grade"`              // A to F, or T for an untrusted certificate
+	GradeIgnoringTrust string     `json:"gradeIgnoringTrust"` // The grade the configuration alone earns
+	Versions           []string   `json:"versions"`
+	Suites             []TLSSuite `json:"suites"` // Below TLS 1.3 in the server's preference order
+	Findings           []Finding  `json:"findings,omitempty"`
+	NotTested          []string   `json:"notTested,omitempty"` // Outside what Go's TLS client can negotiate
+	Error              string     `json:"error,omitempty"`
+}
+
+// TLSSuite is a cipher suite the target accepted.
+type TLSSuite struct {
+	Name           string `json:"name"`
+	Version        string `json:"version"`
+	ForwardSecrecy bool   `json:"forwardSecrecy"`
+	AEAD           bool   `json:"aead"`
+	Weak           bool   `json:"weak,omitempty"` // RC4 or 3DES
+}
+
+// ETagAnalysis infers how ETags are generated and whether they hold across
+// the addresses behind the name.
+type ETagAnalysis struct {
+	Main                 *ETagInfo  `json:"main,omitempty"`
+	PerIP                []ETagInfo `json:"perIp,omitempty"`
+	ConsistentAcrossPool bool       `json:"consistentAcrossPool"` // Same content has the same ETag everywhere
+	Findings             []Finding  `json:"findings,omitempty"`
+}
+
+// ETagInfo is one ETag and what its format reveals.
+type ETagInfo struct {
+	IP         string `json:"ip,omitempty"`
+	ETag       string `json:"etag"`
+	Weak       bool   `json:"weak"`
+	Format     string `json:"format"`            // e.g. mtime-size, inode-size-mtime, content-digest, opaque
+	Backend    string `json:"backend,omitempty"` // Server the format points to
+	LeaksInode bool   `json:"leaksInode"`
+	LeaksMTime bool   `json:"leaksMtime"`
+	MTime      string `json:"mtime,omitempty"` // Decoded modification time
+}
+
+// ServerInfo is a Server header split into its product tokens.
+type ServerInfo struct {
+	Raw        string            `json:"raw"`
+	OS         string            `json:"os,omitempty"` // First operating system named in a comment
+	Components []ServerComponent `json:"components"`
+	GRPC       *GRPCInfo         `json:"grpc,omitempty"`
+}
+
+// GRPCInfo reports whether the target answers as a gRPC service. Health is
+// the health service's status, or the gRPC status it failed with, such as
+// UNIMPLEMENTED. Reflection names the reflection service that listed
+// Services.
+type GRPCInfo struct {
+	Suspected       bool     `json:"suspected"` // The analysis response had a gRPC content type or status
+	IsGRPC          bool     `json:"isGrpc"`
+	Health          string   `json:"health,omitempty"`
+	Reflection      string   `json:"reflection,omitempty"`
+	Services        []string `json:"services,omitempty"`
+	ReflectionError string   `json:"reflectionError,omitempty"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// ServerComponent is one product/version token and the comments after it.
+type ServerComponent struct {
+	Product  string   `json:"product,omitempty"`
+	Version  string   `json:"version,omitempty"`
+	OS       string   `json:"os,omitempty"`
+	Comments []string `json:"comments,omitempty"`
+}
+
+// ServerAdvisories lists known vulnerabilities and end of life status for
+// the versions the target advertises.
+type ServerAdvisories struct {
+	Products    []ProductAdvisory `json:"products"`
+	DataUpdated string            `json:"dataUpdated,omitempty"`
+	Disclaimer  string            `json:"disclaimer"`
+}
+
+// ProductAdvisory is one product/version found in a banner.
+type ProductAdvisory struct {
+	Source     string     `json:"source"` // Header the version came from
+	Product    string     `json:"product"`
+	Version    string     `json:"version"`
+	EndOfLife  bool       `json:"endOfLife"`
+	EOLNote    string     `json:"eolNote,omitempty"`
+	EOLURL     string     `json:"eolUrl,omitempty"`
+	Advisories []Advisory `json:"advisories,omitempty"`
+}
+
+// Advisory is a published vulnerability affecting a version.
+type Advisory struct {
+	ID      string `json:"id"`
+	Summary string `json:"summary"`
+	FixedIn string `json:"fixedIn"`
+	URL     string `json:"url"`
+}
+
+// Finding is a problem a check found, with a stable ID, how much it
+// matters, and why.
+type Finding struct {
+	Severity string `json:"severity"` // info, low, medium or high
+	ID       string `json:"id"`
+	Header   string `json:"header,omitempty"`
+	Message  string `json:"message"`
+	Detail   string `json:"detail,omitempty"` // Why it matters
+}
+
+// DowngradeResult compares how the target answers HTTP/1.1, HTTP/1.0 and
+// Host-less requests.
+type DowngradeResult struct {
+	HTTP11   *RawResponse `json:"http11"`
+	HTTP10   *RawResponse `json:"http10"`
+	NoHost   *RawResponse `json:"noHost"`
+	Findings []string     `json:"findings,omitempty"`
+}
+
+// RawResponse summarizes the answer to a hand-built request.
+type RawResponse struct {
+	Proto      string `json:"proto,omitempty"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Server     string `json:"server,omitempty"`
+	Connection string `json:"connection,omitempty"`
+	Upgrade    string `json:"upgrade,omitempty"`
+	BodyHash   string `json:"bodyHash,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// LanguageAnalysis reports how the target negotiates content language.
+type LanguageAnalysis struct {
+	Variants           []LanguageVariant `json:"variants"`
+	VariesContent      bool              `json:"variesContent"`
+	RedirectsToLocale  bool              `json:"redirectsToLocale"` // e.g. / redirects to /fr/
+	VaryAcceptLanguage bool              `json:"varyAcceptLanguage"`
+	Issues             []string          `json:"issues,omitempty"`
+}
+
+// LanguageVariant is the response to one Accept-Language value.
+type LanguageVariant struct {
+	AcceptLanguage  string `json:"acceptLanguage"`
+	StatusCode      int    `json:"statusCode,omitempty"`
+	ContentLanguage string `json:"contentLanguage,omitempty"`
+	Location        string `json:"location,omitempty"`
+	LocaleRedirect  bool   `json:"localeRedirect"` // Location path starts with the requested language
+	BodyHash        string `json:"bodyHash,omitempty"`
+	CacheStatus     string `json:"cacheStatus,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// UserAgentResult is the response to one User-Agent, compared with the
+// first one requested.
+type UserAgentResult struct {
+	Name        string   `json:"name"` // Preset name, or custom
+	UserAgent   string   `json:"userAgent"`
+	StatusCode  int      `json:"statusCode,omitempty"`
+	KeepAlive   string   `json:"keepAlive,omitempty"`
+	Connection  string   `json:"connection,omitempty"`
+	Server      string   `json:"server,omitempty"`
+	BodyBytes   int      `json:"bodyBytes"`
+	BodyHash    string   `json:"bodyHash,omitempty"` // Truncated SHA-256
+	Differs     bool     `json:"differs"`
+	Differences []string `json:"differences,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// MethodSupport reports the HTTP methods a target allows and whether its
+// HEAD responses match GET.
+type MethodSupport struct {
+	OptionsStatus  int      `json:"optionsStatus"`
+	Allow          string   `json:"allow,omitempty"` // Raw Allow header from OPTIONS
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+	TraceStatus    int      `json:"traceStatus,omitempty"`
+	TraceEnabled   bool     `json:"traceEnabled"` // TRACE echoes the request, a security issue
+	HeadStatus     int      `json:"headStatus,omitempty"`
+	HeadConsistent bool     `json:"headConsistent"`
+	HeadMismatches []string `json:"headMismatches,omitempty"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// SiteHygiene reports the well-known endpoints a site publishes.
+type SiteHygiene struct {
+	SecurityTxt    *SecurityTxt       `json:"securityTxt"`
+	RobotsTxt      *RobotsTxt         `json:"robotsTxt"`
+	ChangePassword *WellKnownRedirect `json:"changePassword"`
+}
+
+// SecurityTxt holds the parsed fields of an RFC 9116 security.txt.
+type SecurityTxt struct {
+	Present            bool     `json:"present"`
+	Location           string   `json:"location,omitempty"`
+	Signed             bool     `json:"signed"` // PGP cleartext signature
+	Contact            []string `json:"contact,omitempty"`
+	Expires            string   `json:"expires,omitempty"`
+	Encryption         []string `json:"encryption,omitempty"`
+	Policy             []string `json:"policy,omitempty"`
+	Canonical          []string `json:"canonical,omitempty"`
+	PreferredLanguages string   `json:"preferredLanguages,omitempty"`
+	Acknowledgments    []string `json:"acknowledgments,omitempty"`
+	Hiring             []string `json:"hiring,omitempty"`
+	Problems           []string `json:"problems,omitempty"`
+	Error              string   `json:"error,omitempty"`
+}
+
+// RobotsTxt summarizes a robots.txt file.
+type RobotsTxt struct {
+	Present       bool     `json:"present"`
+	StatusCode    int      `json:"statusCode,omitempty"`
+	UserAgents    []string `json:"userAgents,omitempty"`
+	DisallowRules int      `json:"disallowRules"`
+	AllowRules    int      `json:"allowRules"`
+	DisallowsAll  bool     `json:"disallowsAll"` // Some group has Disallow: /
+	Sitemaps      []string `json:"sitemaps,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// WellKnownRedirect reports a well-known URL that should redirect elsewhere.
+type WellKnownRedirect struct {
+	Present    bool   `json:"present"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Location   string `json:"location,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// HSTSInfo describes the Strict-Transport-Security policy and whether the
+// domain is, or could be, on the HSTS preload list.
+type HSTSInfo struct {
+	Header            string   `json:"header,omitempty"`
+	MaxAge            int64    `json:"maxAge"`
+	IncludeSubDomains bool     `json:"includeSubDomains"`
+	Preload           bool     `json:"preload"`
+	HTTPRedirect      string   `json:"httpRedirect"`            // Yes, No or Not reachable
+	PreloadStatus     string   `json:"preloadStatus"`           // Preloaded, Preloaded via <parent>, Pending, Not preloaded...
+	PreloadSource     string   `json:"preloadSource,omitempty"` // snapshot or hstspreload.org
+	Gaps              []string `json:"gaps,omitempty"`          // Unmet preload requirements
+}
+
+// RegistrationInfo is the RDAP registration record of the analyzed domain.
+type RegistrationInfo struct {
+	Domain      string   `json:"domain"`
+	Registrar   string   `json:"registrar,omitempty"`
+	Created     string   `json:"created,omitempty"`
+	Updated     string   `json:"updated,omitempty"`
+	Expires     string   `json:"expires,omitempty"`
+	Status      []string `json:"status,omitempty"`
+	Nameservers []string `json:"nameservers,omitempty"`
+	Cached      bool     `json:"cached"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// IdleTimeoutEstimate brackets the end-to-end idle timeout: connections
+// idle for LowerBoundSeconds still worked, ones idle for UpperBoundSeconds
+// did not (zero when nothing failed up to MaxTestedSeconds).
+type IdleTimeoutEstimate struct {
+	LowerBoundSeconds float64      `json:"lowerBoundSeconds"`
+	UpperBoundSeconds float64      `json:"upperBoundSeconds,omitempty"`
+	MaxTestedSeconds  float64      `json:"maxTestedSeconds"`
+	Limit             string       `json:"limit,omitempty"` // What ended the connections
+	Samples           []IdleSample `json:"samples,omitempty"`
+	Error             string       `json:"error,omitempty"`
+}
+
+// IdleSample is one connection idled for Seconds before being reused.
+type IdleSample struct {
+	Seconds   float64 `json:"seconds"`
+	Succeeded bool    `json:"succeeded"`
+	Failure   string  `json:"failure,omitempty"` // Close seen while idle, or Silent: <error>
+	Error     string  `json:"error,omitempty"`   // The connection could not be set up
+}
+
+// TCPKeepAliveResult reports whether a connection kept alive with TCP
+// keepalives survived the idle period, and for how long it lasted.
+type TCPKeepAliveResult struct {
+	Idle            int     `json:"idle"`
+	Interval        int     `json:"interval"`
+	Count           int     `json:"count"`
+	IdleSeconds     float64 `json:"idleSeconds"`         // How long the connection was left idle
+	Survived        bool    `json:"survived"`            // Still usable after IdleSeconds
+	SurvivedSeconds float64 `json:"survivedSeconds"`     // Longest idle period survived
+	CloseType       string  `json:"closeType,omitempty"` // How it was lost, as in IdleCloseResult
+	Error           string  `json:"error,omitempty"`
+}
+
+// IdleCloseResult describes how the target ended an idle keep-alive
+// connection. CloseType is FIN (graceful), RST (abrupt), CloseNotify (TLS
+// alert only), Data (unsolicited bytes) or None (still open when the wait ended).
+type IdleCloseResult struct {
+	AdvertisedTimeout  int     `json:"advertisedTimeout,omitempty"` // Seconds, from Keep-Alive
+	ResponseConnection string  `json:"responseConnection,omitempty"`
+	WaitedSeconds      float64 `json:"waitedSeconds"`
+	CloseType          string  `json:"closeType,omitempty"`
+	CloseNotify        bool    `json:"closeNotify,omitempty"` // TLS close_notify sent before closing
+	ClosedAfterSeconds float64 `json:"closedAfterSeconds,omitempty"`
+	Error              string  `json:"error,omitempty"`
+}
+
+// HTTP2Result describes an h2 connection, where keep-alive is governed by
+// PING and GOAWAY frames rather than the Keep-Alive header. CloseType is
+// FIN, RST or None (still open when the wait ended) when the connection
+// ended without a GOAWAY.
+type HTTP2Result struct {
+	Settings           map[string]uint32 `json:"settings,omitempty"` // The server's SETTINGS by name
+	PingAnswered       bool              `json:"pingAnswered"`
+	PingRTTMs          float64           `json:"pingRttMs,omitempty"`
+	Streams            *HTTP2StreamLimit `json:"streams,omitempty"`
+	WaitedSeconds      float64           `json:"waitedSeconds,omitempty"`
+	GoAway             *HTTP2GoAway      `json:"goAway,omitempty"`
+	CloseType          string            `json:"closeType,omitempty"`
+	ClosedAfterSeconds float64           `json:"closedAfterSeconds,omitempty"`
+	ServerPings        int               `json:"serverPings,omitempty"` // PINGs the server sent
+	Error              string            `json:"error,omitempty"`
+}
+
+// HTTP2StreamLimit is the outcome of opening one stream more than the
+// server's MAX_CONCURRENT_STREAMS.
+type HTTP2StreamLimit struct {
+	Advertised uint32 `json:"advertised"`
+	Opened     int    `json:"opened"`
+	Answered   int    `json:"answered"`
+	Refused    int    `json:"refused"`         // Reset with REFUSED_STREAM
+	Reset      int    `json:"reset,omitempty"` // Reset with another error
+	Enforced   bool   `json:"enforced"`
+}
+
+// HTTP2GoAway is a GOAWAY frame the server sent.
+type HTTP2GoAway struct {
+	During       string  `json:"during"`       // "ping", "streams" or "idle"
+	AfterSeconds float64 `json:"afterSeconds"` // Since that stage began
+	ErrorCode    string  `json:"errorCode,omitempty"`
+	LastStreamID uint32  `json:"lastStreamId"`
+	Debug        string  `json:"debug,omitempty"`
+}
+
+// TLSFingerprint identifies a ClientHello.
+type TLSFingerprint struct {
+	Profile string `json:"profile"`
+	JA3     string `json:"ja3"`
+	JA3Hash string `json:"ja3Hash"`
+	JA4     string `json:"ja4"`
+}
+
+// FingerprintResult is the response to a request made with one TLS profile.
+type FingerprintResult struct {
+	Profile     string          `json:"profile"`
+	Fingerprint *TLSFingerprint `json:"fingerprint,omitempty"`
+	StatusCode  int             `json:"statusCode,omitempty"`
+	Protocol    string          `json:"protocol,omitempty"`
+	KeepAlive   string          `json:"keepAlive,omitempty"`
+	Connection  string          `json:"connection,omitempty"`
+	Server      string          `json:"server,omitempty"`
+	CacheStatus string          `json:"cacheStatus,omitempty"` // X-Cache
+	Differs     bool            `json:"differs"`               // Served differently than the default profile
+	Error       string          `json:"error,omitempty"`
+}
+
+// IPResult holds the timings of a request pinned to a single A record.
+// Durations are in milliseconds.
+type IPResult struct {
+	IP             string          `json:"ip"`
+	ConnectMs      float64         `json:"connectMs"`
+	TLSHandshakeMs float64         `json:"tlsHandshakeMs,omitempty"`
+	TTFBMs         float64         `json:"ttfbMs"`
+	TotalMs        float64         `json:"totalMs"`
+	Reused         bool            `json:"reused,omitempty"` // Sent over a pooled connection, with reuseConnections
+	StatusCode     int             `json:"statusCode,omitempty"`
+	Framing        string          `json:"framing,omitempty"`       // chunked, content-length, close-delimited or none
+	ContentLength  int64           `json:"contentLength,omitempty"` // -1 when not declared
+	BodyBytes      int64           `json:"bodyBytes,omitempty"`
+	FramingDiffers bool            `json:"framingDiffers,omitempty"` // Framing differs from most other IPs
+	Server         string          `json:"server,omitempty"`
+	PoweredBy      string          `json:"poweredBy,omitempty"`
+	ETag           string          `json:"etag,omitempty"`
+	BodyHash       string          `json:"bodyHash,omitempty"`       // Truncated SHA-256 of the decoded body
+	ContentDiffers bool            `json:"contentDiffers,omitempty"` // Body differs from most other IPs
+	TLSVersion     string          `json:"tlsVersion,omitempty"`
+	CipherSuite    string          `json:"cipherSuite,omitempty"`
+	TCP            *TCPConnInfo    `json:"tcp,omitempty"`     // Socket statistics, Linux only
+	Samples        *LatencySamples `json:"samples,omitempty"` // Timing distribution with sampleCount
+	Error          string          `json:"error,omitempty"`
+}
+
+// ListenerMatrix holds the results of requesting every address on every
+// listener.
+type ListenerMatrix struct {
+	Listeners []string      `json:"listeners"` // Column order of each row's results
+	Rows      []ListenerRow `json:"rows"`
+}
+
+// ListenerRow is one address across all listeners.
+type ListenerRow struct {
+	IP      string     `json:"ip"`
+	Results []IPResult `json:"results"`
+}
+
+// LatencySamples summarizes repeated requests to one address. Failed
+// samples are counted but left out of the statistics.
+type LatencySamples struct {
+	Count          int           `json:"count"`
+	Failed         int           `json:"failed,omitempty"`
+	Reused         int           `json:"reused,omitempty"` // Samples sent over a pooled connection
+	ConnectMs      *LatencyStats `json:"connectMs,omitempty"`
+	TLSHandshakeMs *LatencyStats `json:"tlsHandshakeMs,omitempty"`
+	TTFBMs         *LatencyStats `json:"ttfbMs,omitempty"`
+	TotalMs        *LatencyStats `json:"totalMs,omitempty"`
+}
+
+// LatencyStats describes the spread of one timing across samples.
+type LatencyStats struct {
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	P95    float64 `json:"p95"`
+	Max    float64 `json:"max"`
+}
+
+// TCPConnInfo holds kernel TCP statistics for the connection a request used.
+type TCPConnInfo struct {
+	RTTMs         float64 `json:"rttMs"`
+	RTTVarMs      float64 `json:"rttVarMs"`
+	SendMSS       uint32  `json:"sendMss"`
+	ReceiveMSS    uint32  `json:"receiveMss"`
+	CongestionWnd uint32  `json:"congestionWindow"` // In segments
+	Retransmits   uint32  `json:"retransmits"`
+}
+
+// BackendAnalysis groups the resolved addresses into probable backend pools.
+type BackendAnalysis struct {
+	Summary          string           `json:"summary"`
+	Clusters         []BackendCluster `json:"clusters"`
+	DifferingHeaders []string         `json:"differingHeaders,omitempty"` // Headers that tell the clusters apart
+	ContentVersions  int              `json:"contentVersions"`            // Distinct response bodies served
+	IdenticalContent bool             `json:"identicalContent"`
+	DynamicContent   bool             `json:"dynamicContent,omitempty"` // Body changes between requests to one IP
+}
+
+// BackendCluster is a set of addresses that answered with the same build.
+type BackendCluster struct {
+	IPs        []string `json:"ips"`
+	Server     string   `json:"server,omitempty"`
+	PoweredBy  string   `json:"poweredBy,omitempty"`
+	ETagFormat string   `json:"etagFormat"` // Shape of the ETag, see etagFormat
+}
+
+// ClientAuthInfo reports whether the target asked for a client certificate.
+type ClientAuthInfo struct {
+	Requested       bool     `json:"requested"`
+	CertificateSent bool     `json:"certificateSent"`
+	AcceptableCAs   []string `json:"acceptableCAs,omitempty"` // From the CertificateRequest
+}
+
+// SessionResumption reports whether the target resumes TLS sessions.
+type SessionResumption struct {
+	Version string `json:"version,omitempty"` // Version negotiated on the first handshake
+	Resumed bool   `json:"resumed"`           // Second handshake resumed via ticket/PSK
+	Error   string `json:"error,omitempty"`
+}
+
+// HalfOpenResult reports whether a request on a connection left idle past
+// the advertised timeout still works. HalfOpen is set when the server gave
+// no sign of closing but the post-idle request failed.
+type HalfOpenResult struct {
+	AdvertisedTimeout int     `json:"advertisedTimeout,omitempty"` // Seconds, from Keep-Alive
+	IdleSeconds       float64 `json:"idleSeconds"`
+	ClosedByServer    string  `json:"closedByServer,omitempty"` // FIN/RST seen while idle
+	RequestAttempted  bool    `json:"requestAttempted"`
+	StatusCode        int     `json:"statusCode,omitempty"`
+	RequestMs         float64 `json:"requestMs,omitempty"`
+	RequestError      string  `json:"requestError,omitempty"` // RST, EOF, Timeout...
+	HalfOpen          bool    `json:"halfOpen"`
+	Error             string  `json:"error,omitempty"`
 }
 
 // TCPResults is the structure to hold TCP handshake and analysis results.
+// The response is decoded from the first bytes read on the connection of
+// the final response, or on a separate one when nothing was read there.
 type TCPResults struct {
 	TLSVersion  uint16       `json:"tls_version,omitempty"`
 	CipherSuite uint16       `json:"cipher_suite,omitempty"`
+	Encrypted   bool         `json:"encrypted,omitempty"` // The bytes are TLS records, not plaintext
 	TCPResponse *TCPResponse `json:"tcp_response,omitempty"`
 	Error       string       `json:"error,omitempty"`
 }
@@ -49,3 +1173,281 @@ type TCPResponse struct {
 	CWRFlag         bool   `json:"cwr_flag"`
 	TCPOptions      []byte `json:"tcp_options"`
 }
+
+// CacheAnalysis reports how caches in front of the target treat repeated and
+// conditional requests.
+type CacheAnalysis struct {
+	CacheControl    string             `json:"cacheControl,omitempty"`
+	Directives      map[string]string  `json:"directives,omitempty"`
+	ETag            string             `json:"etag,omitempty"`
+	LastModified    string             `json:"lastModified,omitempty"`
+	Expires         string             `json:"expires,omitempty"`
+	Samples         []CacheSample      `json:"samples,omitempty"`
+	Transitions     []string           `json:"transitions,omitempty"` // Cache status changes, e.g. "MISS -> HIT"
+	IfNoneMatch     *ConditionalResult `json:"ifNoneMatch,omitempty"`
+	IfModifiedSince *ConditionalResult `json:"ifModifiedSince,omitempty"`
+	Issues          []string           `json:"issues,omitempty"`
+	Error           string             `json:"error,omitempty"`
+}
+
+// CacheSample is one plain GET made by the cache analyzer.
+type CacheSample struct {
+	StatusCode  int    `json:"statusCode"`
+	CacheStatus string `json:"cacheStatus,omitempty"`
+	Age         int    `json:"age"` // -1 when no Age header was sent
+}
+
+// ConditionalResult is a revalidation request using one validator.
+type ConditionalResult struct {
+	Header      string `json:"header"`
+	Value       string `json:"value"`
+	StatusCode  int    `json:"statusCode,omitempty"`
+	NotModified bool   `json:"notModified"`
+	BodyBytes   int    `json:"bodyBytes,omitempty"`
+	ETag        string `json:"etag,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// VaryAnalysis reports whether the Vary header covers every request header
+// the response actually depends on.
+type VaryAnalysis struct {
+	Vary        string          `json:"vary,omitempty"`
+	VaryHeaders []string        `json:"varyHeaders,omitempty"`
+	StableBody  bool            `json:"stableBody"` // Repeated identical requests return the same body
+	Dimensions  []VaryDimension `json:"dimensions,omitempty"`
+	Issues      []string        `json:"issues,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// VaryDimension is the response to one changed request header.
+type VaryDimension struct {
+	Header      string   `json:"header"`
+	Value       string   `json:"value"`
+	Listed      bool     `json:"listed"` // Header appears in Vary
+	Differs     bool     `json:"differs"`
+	Differences []string `json:"differences,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// ProtocolEdgeCases reports HTTP/1.1 behaviour that breaks some clients and
+// proxies.
+type ProtocolEdgeCases struct {
+	Trailers        *TrailerResult        `json:"trailers,omitempty"`
+	ExpectContinue  *ExpectContinueResult `json:"expectContinue,omitempty"`
+	HTTP10KeepAlive *HTTP10Result         `json:"http10KeepAlive,omitempty"`
+	Issues          []string              `json:"issues,omitempty"`
+}
+
+// TrailerResult lists the trailers announced and sent for a TE: trailers GET.
+type TrailerResult struct {
+	Declared []string `json:"declared,omitempty"` // From the Trailer header
+	Received []string `json:"received,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// ExpectContinueResult is the server's answer to a chunked POST sent with
+// Expect: 100-continue.
+type ExpectContinueResult struct {
+	Behavior   string  `json:"behavior,omitempty"` // 100 Continue, Final response or No interim response
+	InterimMs  float64 `json:"interimMs,omitempty"`
+	StatusCode int     `json:"statusCode,omitempty"` // Final status
+	Error      string  `json:"error,omitempty"`
+}
+
+// HTTP10Result is the response to an HTTP/1.0 request asking for keep-alive.
+type HTTP10Result struct {
+	ResponseProto    string `json:"responseProto,omitempty"`
+	StatusCode       int    `json:"statusCode,omitempty"`
+	Connection       string `json:"connection,omitempty"`
+	KeepAlive        string `json:"keepAlive,omitempty"`
+	Framing          string `json:"framing,omitempty"`
+	KeepAliveOffered bool   `json:"keepAliveOffered"`
+	ReuseAttempted   bool   `json:"reuseAttempted"`
+	Reused           bool   `json:"reused"`
+	ReuseError       string `json:"reuseError,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// KeepAliveHeader is a parsed Keep-Alive response header.
+type KeepAliveHeader struct {
+	Timeout    *int              `json:"timeout,omitempty"` // Seconds an idle connection is kept open
+	Max        *int              `json:"max,omitempty"`     // Requests allowed before the connection is closed
+	Extensions map[string]string `json:"extensions,omitempty"`
+	Invalid    []string          `json:"invalid,omitempty"` // timeout or max parameters that aren't numbers
+}
+
+// TargetValidation is the /api/validate verdict on an analysis request.
+// Nothing is sent to the target; the estimates are lower bounds, as
+// redirects and most optional stages add requests of their own.
+type TargetValidation struct {
+	Domain          string          `json:"domain"`                // As given
+	Valid           bool            `json:"valid"`                 // The request would be accepted by /analyze
+	Error           string          `json:"error,omitempty"`       // Why it would be rejected
+	URL             string          `json:"url,omitempty"`         // Normalized URL the analysis requests first
+	Host            string          `json:"host,omitempty"`        // ASCII (punycode) host name
+	UnicodeHost     string          `json:"unicodeHost,omitempty"` // Only when it differs from host
+	Resolvable      bool            `json:"resolvable"`
+	ResolveError    string          `json:"resolveError,omitempty"`
+	Addresses       []TargetAddress `json:"addresses,omitempty"`
+	Policy          string          `json:"policy,omitempty"`          // blocked when the dial policy refuses any address, else allowed
+	Stages          []string        `json:"stages,omitempty"`          // Stages the analysis would run, in order
+	MinRequests     int             `json:"minRequests,omitempty"`     // Requests of the main request, per-IP and listener stages
+	IdleWaitSeconds int             `json:"idleWaitSeconds,omitempty"` // Up to this long spent holding idle connections
+	Budget          *BudgetUsage    `json:"budget,omitempty"`          // Limits the analysis would run under
+}
+
+// TargetAddress is one address the target resolves to.
+type TargetAddress struct {
+	IP     string `json:"ip"`
+	Scope  string `json:"scope"`  // public, private, loopback, link-local, multicast or unspecified
+	Policy string `json:"policy"` // allowed or blocked by -block-private-targets
+}
+
+// ResponseSnapshot is the analyzed response as received, kept for diffing
+// content between analyses.
+type ResponseSnapshot struct {
+	CapturedAt   time.Time           `json:"capturedAt"`
+	URL          string              `json:"url"`                  // After redirects
+	RemoteAddr   string              `json:"remoteAddr,omitempty"` // Address that served it
+	StatusCode   int                 `json:"statusCode"`
+	Proto        string              `json:"proto"`
+	Headers      map[string][]string `json:"headers"`
+	Body         string              `json:"body"`
+	BodyEncoding string              `json:"bodyEncoding"` // text, or base64 for binary bodies
+	BodyBytes    int                 `json:"bodyBytes"`    // Size of the whole decoded body
+	BodyHash     string              `json:"bodyHash"`     // Truncated SHA-256 of the whole body
+	Truncated    bool                `json:"truncated,omitempty"`
+}
+
+// CSPAnalysis runs the resources of the page against its Content Security
+// Policies, listing what enforcement blocks.
+type CSPAnalysis struct {
+	Policies  []CSPPolicy    `json:"policies"`  // Each enforced on its own, a resource must pass all
+	Resources []PageResource `json:"resources"` // What the page's HTML loads and runs
+	Blocked   []CSPViolation `json:"blocked"`
+	Findings  []Finding      `json:"findings,omitempty"` // Problems with <meta> policies and how they combine with the header, trackers
+	Reporting *CSPReporting  `json:"reporting,omitempty"`
+	Origins   []CSPOrigin    `json:"origins,omitempty"` // External origins by category
+	Error     string         `json:"error,omitempty"`
+
+	ServiceWorkers []CSPServiceWorker `json:"serviceWorkers,omitempty"`
+	Manifest       *CSPManifest       `json:"manifest,omitempty"`
+	ConnectSrc     string             `json:"connectSrc,omitempty"` // Covers the endpoints the page's scripts connect to
+	Rendering      *CSPRendering      `json:"rendering,omitempty"`
+}
+
+// CSPRendering compares the resources of the static HTML with those of the
+// DOM after headless Chrome ran the page's scripts.
+type CSPRendering struct {
+	StaticResources   int            `json:"staticResources"`
+	RenderedResources int            `json:"renderedResources"`
+	Added             []PageResource `json:"added,omitempty"`     // Injected by scripts, also checked against the policies
+	Removed           []PageResource `json:"removed,omitempty"`   // In the HTML but gone from the rendered DOM
+	Truncated         bool           `json:"truncated,omitempty"` // Only the first -csp-max-page-bytes of the DOM were scanned
+	Error             string         `json:"error,omitempty"`
+}
+
+// CSPPolicy is one policy, as delivered and by directive.
+type CSPPolicy struct {
+	Source     string              `json:"source"` // header, report-only, meta or request (cspPolicy)
+	Raw        string              `json:"raw"`
+	Directives map[string][]string `json:"directives"`
+}
+
+// PageResource is a URL the page loads, or inline code it runs.
+type PageResource struct {
+	Element   string `json:"element"`
+	Attribute string `json:"attribute,omitempty"`
+	Kind      string `json:"kind"`              // url, inline-script, inline-style, event-handler, style-attribute or javascript-url
+	URL       string `json:"url,omitempty"`     // Resolved against the page
+	Directive string `json:"directive"`         // Effective directive it is checked against
+	Snippet   string `json:"snippet,omitempty"` // Start of inline code
+	Hash      string `json:"hash,omitempty"`    // Hash source allowing inline script or style
+}
+
+// CSPViolation is a resource a policy blocks, or reports when report-only.
+type CSPViolation struct {
+	Resource   PageResource `json:"resource"`
+	Policy     int          `json:"policy"`    // Index into policies
+	Directive  string       `json:"directive"` // The directive whose source list it fails
+	ReportOnly bool         `json:"reportOnly,omitempty"`
+}
+
+// CSPServiceWorker is a service worker the page's scripts register.
+type CSPServiceWorker struct {
+	URL                   string   `json:"url"`
+	Scope                 string   `json:"scope"`        // As registered, or the default
+	RegisteredIn          string   `json:"registeredIn"` // Script URL, or inline
+	StatusCode            int      `json:"statusCode,omitempty"`
+	ServiceWorkerAllowed  string   `json:"serviceWorkerAllowed,omitempty"`
+	ContentSecurityPolicy string   `json:"contentSecurityPolicy,omitempty"` // The worker's own policy, which governs its imports and fetches
+	Imports               []string `json:"imports,omitempty"`               // importScripts URLs
+	Error                 string   `json:"error,omitempty"`
+}
+
+// CSPManifest is the web app manifest the page links.
+type CSPManifest struct {
+	URL        string   `json:"url"`
+	StatusCode int      `json:"statusCode,omitempty"`
+	StartURL   string   `json:"startUrl,omitempty"`
+	Scope      string   `json:"scope,omitempty"`
+	Images     []string `json:"images,omitempty"` // Icons and screenshots, checked against img-src
+	Error      string   `json:"error,omitempty"`
+}
+
+// CSPOrigin is an external origin the page loads from or a policy allows.
+type CSPOrigin struct {
+	Origin     string   `json:"origin"`           // scheme://host[:port], or the host-source as the policy lists it
+	Category   string   `json:"category"`         // analytics, ads, cdn, fonts, social or other
+	Vendor     string   `json:"vendor,omitempty"` // From data/thirdparty.json
+	Resources  int      `json:"resources"`        // Resources of the page loaded from it
+	Directives []string `json:"directives,omitempty"`
+	Listed     bool     `json:"listed"` // A policy names it by host
+}
+
+// CSPMerge is a baseline policy merged with another and an overlay.
+type CSPMerge struct {
+	Policy     string              `json:"policy"`
+	Directives map[string][]string `json:"directives"`
+	Changes    []CSPChange         `json:"changes"` // What the policy and overlay changed in the baseline
+}
+
+// CSPChange is one change the merge made, or refused.
+type CSPChange struct {
+	Directive string   `json:"directive"`
+	Change    string   `json:"change"` // added, sources-added, none-dropped or kept-baseline
+	Sources   []string `json:"sources,omitempty"`
+	From      string   `json:"from"` // policy or overlay
+}
+
+// CSPReporting is where violations of the page's policies are reported.
+type CSPReporting struct {
+	Endpoints       []ReportingEndpoint   `json:"endpoints,omitempty"` // From Reporting-Endpoints and Report-To
+	ReportURI       []string              `json:"reportUri,omitempty"` // report-uri directives, resolved
+	ReportTo        []string              `json:"reportTo,omitempty"`  // Endpoint names of report-to directives
+	ReportURIProbes []ReportEndpointProbe `json:"reportUriProbes,omitempty"`
+
+	// A Reporting-Endpoints header and reporting directives that would
+	// cover both browsers with and without the Reporting API
+	SuggestedReportingEndpoints string `json:"suggestedReportingEndpoints,omitempty"`
+	SuggestedDirectives         string `json:"suggestedDirectives,omitempty"`
+
+	Findings []Finding `json:"findings,omitempty"`
+}
+
+// ReportingEndpoint is a named endpoint reports can be sent to.
+type ReportingEndpoint struct {
+	Name   string               `json:"name"`
+	URL    string               `json:"url"`
+	Header string               `json:"header"`           // Reporting-Endpoints or Report-To
+	MaxAge int                  `json:"maxAge,omitempty"` // Seconds, Report-To only
+	Probe  *ReportEndpointProbe `json:"probe,omitempty"`
+}
+
+// ReportEndpointProbe is the answer of an endpoint to an empty report.
+type ReportEndpointProbe struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Accepts    bool   `json:"accepts"` // Answered 2xx
+	Error      string `json:"error,omitempty"`
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Common labels tried by subdomain discovery
+//
+//go:embed data/subdomains.txt
+var bundledSubdomainWords []byte
+
+// Most candidates one discovery returns, and the lookups run in parallel
+const (
+	maxSubdomainCandidates = 200
+	subdomainLookupWorkers = 8
+)
+
+// Largest certificate transparency answer read
+const maxCTResponseSize = 32 * 1024 * 1024
+
+var ctClient = &http.Client{Timeout: 60 * time.Second, Transport: auditedTransport}
+
+type subdomainOptions struct {
+	Wordlist bool     `json:"wordlist"`        // Try the bundled common labels
+	Words    []string `json:"words,omitempty"` // Extra labels to try
+
+	// Names from certificates logged for the zone, needs the -ct-logs flag
+	CertificateTransparency bool `json:"certificateTransparency"`
+}
+
+type subdomainRequest struct {
+	Domain string `json:"domain"`
+	subdomainOptions
+}
+
+// subdomainsHandler lists the subdomains of a zone that resolve, as
+// candidates for /analyze/batch.
+func subdomainsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req subdomainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Domain == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(discoverSubdomains(r.Context(), req.Domain, req.subdomainOptions))
+}
+
+// discoverSubdomains collects names below zone from the wordlist and
+// certificate transparency logs and keeps the ones that resolve. When the
+// zone has a wildcard record, guessed names answering with the wildcard's
+// addresses are dropped; names from certificates are kept.
+func discoverSubdomains(ctx context.Context, zone string, opts subdomainOptions) *SubdomainDiscovery {
+	discovery := &SubdomainDiscovery{}
+	zone, err := toASCII(hostOf(zone))
+	if err != nil {
+		discovery.Errors = append(discovery.Errors, err.Error())
+		return discovery
+	}
+	discovery.Zone = zone
+	sources := make(map[string][]string)
+	add := func(name, source string) {
+		name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+		name = strings.TrimPrefix(name, "*.")
+		if name == zone || !strings.HasSuffix(name, "."+zone) || containsString(sources[name], source) {
+			return
+		}
+		sources[name] = append(sources[name], source)
+	}
+
+	var words []string
+	if opts.Wordlist {
+		words = append(words, subdomainWords()...)
+	}
+	for _, word := range append(words, opts.Words...) {
+		add(word+"."+zone, "wordlist")
+	}
+
+	if opts.CertificateTransparency {
+		if !cfg.CTLogs {
+			discovery.Errors = append(discovery.Errors, "Certificate transparency lookups are disabled on this server")
+		} else if names, err := fetchCTNames(ctx, zone); err != nil {
+			discovery.Errors = append(discovery.Errors, err.Error())
+		} else {
+			for _, name := range names {
+				add(name, "ct")
+			}
+		}
+	}
+	discovery.Tried = len(sources)
+
+	resolver := resolverFor(ctx)
+	if addrs, err := resolver.LookupHost(ctx, randomLabel()+"."+zone); err == nil {
+		discovery.Wildcard = addrs
+	}
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Resolve with a few workers; results keep the sorted order
+	found := make([]*SubdomainCandidate, len(names))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < subdomainLookupWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				addrs, err := resolver.LookupHost(ctx, names[j])
+				if err != nil || len(addrs) == 0 {
+					continue
+				}
+				candidate := &SubdomainCandidate{Name: names[j], Sources: sources[names[j]], Addresses: addrs}
+				if discovery.Wildcard != nil && sameAddresses(addrs, discovery.Wildcard) {
+					if !containsString(candidate.Sources, "ct") {
+						continue
+					}
+					candidate.Wildcard = true
+				}
+				found[j] = candidate
+			}
+		}()
+	}
+	for j := range names {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, candidate := range found {
+		if candidate == nil {
+			continue
+		}
+		if len(discovery.Candidates) == maxSubdomainCandidates {
+			discovery.Truncated = true
+			break
+		}
+		discovery.Candidates = append(discovery.Candidates, *candidate)
+	}
+	if ctx.Err() != nil {
+		discovery.Errors = append(discovery.Errors, ctx.Err().Error())
+	}
+	return discovery
+}
+
+func subdomainWords() []string {
+	var words []string
+	scanner := bufio.NewScanner(bytes.NewReader(bundledSubdomainWords))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	return words
+}
+
+// fetchCTNames returns the names on certificates logged for zone and its
+// subdomains, from crt.sh's JSON output or a service answering the same
+// way.
+func fetchCTNames(ctx context.Context, zone string) ([]string, error) {
+	query := url.Values{"q": {"%." + zone}, "output": {"json"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(cfg.CTURL, "/")+"/?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ctClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("certificate transparency lookup for %s returned %s", zone, resp.Status)
+	}
+
+	// One entry per certificate, name_value holds its names one per line
+	var entries []struct {
+		CommonName string `json:"common_name"`
+		NameValue  string `json:"name_value"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxCTResponseSize)).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("invalid certificate transparency response: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.CommonName)
+		names = append(names, strings.Split(entry.NameValue, "\n")...)
+	}
+	return names, nil
+}
+
+// hostOf returns the host of a domain given as a name or a URL.
+func hostOf(domain string) string {
+	if strings.Contains(domain, "://") {
+		if u, err := url.Parse(domain); err == nil {
+			return u.Hostname()
+		}
+	}
+	return domain
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// blockedAddressError is returned for connections the target policy does
+// not allow.
+type blockedAddressError struct {
+	ip    net.IP
+	scope string
+}
+
+func (e blockedAddressError) Error() string {
+	return fmt.Sprintf("connections to %s are blocked: %s addresses are not allowed with -block-private-targets", e.ip, e.scope)
+}
+
+// checkTargetAddress applies the target policy to ip: with
+// -block-private-targets only public addresses may be probed.
+func checkTargetAddress(ip net.IP) error {
+	if !cfg.BlockPrivateTargets {
+		return nil
+	}
+	if scope := addressScope(ip); scope != "public" {
+		return blockedAddressError{ip: ip, scope: scope}
+	}
+	return nil
+}
+
+// targetPolicyVerdict is "allowed" or "blocked", as reported by
+// /api/validate.
+func targetPolicyVerdict(ip net.IP) string {
+	if checkTargetAddress(ip) != nil {
+		return "blocked"
+	}
+	return "allowed"
+}
+
+type targetPolicyKey struct{}
+
+// withoutTargetPolicy exempts DNS queries and third-party services, whose
+// addresses come from the configuration rather than the request, from the
+// target policy.
+func withoutTargetPolicy(ctx context.Context) context.Context {
+	return context.WithValue(ctx, targetPolicyKey{}, true)
+}
+
+func targetPolicyExempt(ctx context.Context) bool {
+	exempt, _ := ctx.Value(targetPolicyKey{}).(bool)
+	return exempt
+}
+
+// policyDialer checks the address dialer connects to against the target
+// policy once it is resolved, so host names pointing into a private
+// network are caught as well as literal addresses. Proxied connections are
+// checked by dialContext instead, since the dialer only reaches the proxy.
+func policyDialer(ctx context.Context, dialer *net.Dialer) *net.Dialer {
+	if !cfg.BlockPrivateTargets || targetPolicyExempt(ctx) {
+		return dialer
+	}
+
+	d := *dialer
+	control := d.Control
+	d.Control = func(network, address string, c syscall.RawConn) error {
+		if control != nil {
+			if err := control(network, address, c); err != nil {
+				return err
+			}
+		}
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if i := strings.IndexByte(host, '%'); i >= 0 {
+			host = host[:i]
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return fmt.Errorf("connections to %s are blocked: not an IP address", host)
+		}
+		return checkTargetAddress(ip)
+	}
+	return &d
+}
@@ -0,0 +1,28 @@
+//go:build linux && (amd64 || arm64)
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// readTCPInfo returns the kernel's TCP_INFO statistics for a socket.
+func readTCPInfo(fd uintptr) (*TCPConnInfo, error) {
+	var info syscall.TCPInfo
+	size := uint32(syscall.SizeofTCPInfo)
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.SOL_TCP, syscall.TCP_INFO,
+		uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+
+	return &TCPConnInfo{
+		RTTMs:         float64(info.Rtt) / 1000,
+		RTTVarMs:      float64(info.Rttvar) / 1000,
+		SendMSS:       info.Snd_mss,
+		ReceiveMSS:    info.Rcv_mss,
+		CongestionWnd: info.Snd_cwnd,
+		Retransmits:   info.Total_retrans,
+	}, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux || !(amd64 || arm64)
+
+package main
+
+import "fmt"
+
+// readTCPInfo needs the Linux TCP_INFO socket option.
+func readTCPInfo(fd uintptr) (*TCPConnInfo, error) {
+	return nil, fmt.Errorf("TCP_INFO is not supported on this platform")
+}
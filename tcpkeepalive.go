@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Defaults for the TCP keepalive probe, matching common LB idle timeouts
+const (
+	defaultKeepAliveIdle     = 15 * time.Second
+	defaultKeepAliveInterval = 15 * time.Second
+	defaultKeepAliveCount    = 3
+)
+
+// probeTCPKeepAlive holds an idle connection open with TCP keepalives
+// enabled and reports whether the path still drops it. Keepalive packets
+// carry no HTTP data, so a NAT, firewall or CDN that only tracks payload
+// will still expire the connection.
+func probeTCPKeepAlive(ctx context.Context, target *url.URL, tlsConfig *tls.Config, opts TCPKeepAliveOptions, maxWait time.Duration) *TCPKeepAliveResult {
+	idle := secondsOr(opts.Idle, defaultKeepAliveIdle)
+	interval := secondsOr(opts.Interval, defaultKeepAliveInterval)
+	count := opts.Count
+	if count <= 0 {
+		count = defaultKeepAliveCount
+	}
+
+	result := &TCPKeepAliveResult{
+		Idle:     int(idle / time.Second),
+		Interval: int(interval / time.Second),
+		Count:    count,
+	}
+
+	dialer := &net.Dialer{
+		KeepAlive: -1, // Configured per socket below
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			if err := c.Control(func(fd uintptr) {
+				sockErr = setTCPKeepAlive(fd, idle, interval, count)
+			}); err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+
+	ic, err := openIdleConnection(ctx, target, tlsConfig, dialer)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer ic.conn.Close()
+
+	result.IdleSeconds = maxWait.Seconds()
+
+	closeType, after, _ := ic.waitForClose(ctx, maxWait)
+	if closeType != "None" {
+		result.CloseType = closeType
+		result.SurvivedSeconds = float64(after.Milliseconds()) / 1000
+		return result
+	}
+
+	// Nothing arrived while idle, make sure the connection really is alive
+	if _, err := ic.request(ctx); err != nil {
+		result.CloseType = "PostIdleRequestFailed: " + describeConnError(err)
+		return result
+	}
+	result.SurvivedSeconds = maxWait.Seconds()
+	result.Survived = true
+
+	return result
+}
+
+// secondsOr converts a positive number of seconds, or returns fallback.
+func secondsOr(seconds int, fallback time.Duration) time.Duration {
+	if seconds <= 0 {
+		return fallback
+	}
+	return time.Duration(seconds) * time.Second
+}
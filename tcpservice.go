@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// How long to wait for a banner or a reply to a command
+const serviceReplyTimeout = 10 * time.Second
+
+// Longest reply line kept
+const maxServiceLine = 4096
+
+// serviceProtocol describes how to talk to one kind of TCP service far
+// enough to read its banner, list its capabilities and upgrade to TLS.
+type serviceProtocol struct {
+	banner          replyReader // Reads the greeting, nil when there is none
+	capability      string      // Command listing capabilities
+	capabilityReply replyReader
+	startTLS        string // Command starting the TLS upgrade
+	startTLSReply   replyReader
+	startTLSOK      string // Reply prefix accepting it
+	advertised      string // Capability advertising STARTTLS
+}
+
+type replyReader func(br *bufio.Reader) ([]string, error)
+
+var serviceProtocols = map[string]serviceProtocol{
+	"smtp": {banner: readCodedReply, capability: "EHLO http-keepalive", capabilityReply: readCodedReply,
+		startTLS: "STARTTLS", startTLSReply: readCodedReply, startTLSOK: "220", advertised: "STARTTLS"},
+	"ftp": {banner: readCodedReply, capability: "FEAT", capabilityReply: readCodedReply,
+		startTLS: "AUTH TLS", startTLSReply: readCodedReply, startTLSOK: "234", advertised: "AUTH TLS"},
+	"imap": {banner: readLine, capability: "a1 CAPABILITY", capabilityReply: readIMAPReply,
+		startTLS: "a2 STARTTLS", startTLSReply: readIMAPReply, startTLSOK: "a2 OK", advertised: "STARTTLS"},
+	"pop3": {banner: readLine, capability: "CAPA", capabilityReply: readPOP3List,
+		startTLS: "STLS", startTLSReply: readLine, startTLSOK: "+OK", advertised: "STLS"},
+	"redis": {capability: "PING", capabilityReply: readLine},
+	"raw":   {banner: readLine},
+}
+
+// Ports protocols are commonly found on, used when none is given
+var serviceDefaults = map[string]struct {
+	port        string
+	implicitTLS string // Port of the TLS wrapped variant
+}{
+	"smtp":  {"25", "465"},
+	"ftp":   {"21", "990"},
+	"imap":  {"143", "993"},
+	"pop3":  {"110", "995"},
+	"redis": {"6379", "6380"},
+}
+
+// tcpServiceRequest asks for a probe of a non-HTTP TCP service.
+type tcpServiceRequest struct {
+	Address     string `json:"address"`               // host:port, the port defaults per protocol
+	Protocol    string `json:"protocol"`              // smtp, imap, pop3, ftp, redis or raw
+	TLS         bool   `json:"tls"`                   // TLS from the first byte, e.g. SMTPS on 465
+	StartTLS    bool   `json:"startTLS"`              // Upgrade with the protocol's STARTTLS command
+	IdleSeconds int    `json:"idleSeconds,omitempty"` // Wait this long for the server to close an idle connection
+
+	Source *sourceOptions `json:"source,omitempty"` // Local address or interface to probe from
+	Proxy  string         `json:"proxy,omitempty"`  // http:// or socks5:// proxy, replaces -proxy
+}
+
+// probeTCPService connects to a TCP service, reads its banner and
+// capabilities, optionally upgrades to TLS, then optionally idles to see
+// when the server closes the connection.
+func probeTCPService(ctx context.Context, req tcpServiceRequest) *TCPServiceResult {
+	result := &TCPServiceResult{Address: req.Address, Protocol: req.Protocol}
+	proto := serviceProtocols[req.Protocol]
+	host, _, _ := net.SplitHostPort(req.Address)
+
+	dialCtx, cancel := context.WithTimeout(ctx, tlsProbeTimeout)
+	defer cancel()
+	start := time.Now()
+	rawConn, err := dialContext(dialCtx, &net.Dialer{}, "tcp", req.Address)
+	if err != nil {
+		result.Error = describeConnError(err)
+		return result
+	}
+	result.ConnectMs = millisecondsSince(start)
+	raw := &closeRecorder{Conn: rawConn}
+	var conn net.Conn = raw
+	defer func() { conn.Close() }()
+
+	if req.TLS {
+		tlsConn, info, err := serviceHandshake(dialCtx, raw, host, "implicit")
+		if err != nil {
+			result.Error = fmt.Sprintf("TLS handshake failed: %v", err)
+			return result
+		}
+		conn, result.TLS = tlsConn, info
+	}
+	br := bufio.NewReader(conn)
+
+	if proto.banner != nil {
+		lines, err := serviceReply(conn, br, proto.banner)
+		if err != nil {
+			result.Error = fmt.Sprintf("no banner: %v", err)
+			return result
+		}
+		result.Banner = strings.Join(lines, "\n")
+	}
+
+	if proto.capability != "" {
+		lines, err := serviceCommand(conn, br, proto.capability, proto.capabilityReply)
+		if err != nil {
+			result.Error = fmt.Sprintf("%s failed: %v", strings.Fields(proto.capability)[0], err)
+			return result
+		}
+		result.Capabilities = lines
+		if req.Protocol == "redis" {
+			result.Banner = strings.Join(lines, "\n")
+		}
+		for _, line := range lines {
+			if proto.advertised != "" && strings.Contains(strings.ToUpper(line), proto.advertised) {
+				result.StartTLSOffered = true
+			}
+		}
+	}
+
+	if req.StartTLS && result.TLS == nil {
+		if proto.startTLS == "" {
+			result.Error = req.Protocol + " has no STARTTLS command"
+			return result
+		}
+		lines, err := serviceCommand(conn, br, proto.startTLS, proto.startTLSReply)
+		if err != nil || len(lines) == 0 || !strings.HasPrefix(lines[len(lines)-1], proto.startTLSOK) {
+			result.Error = fmt.Sprintf("STARTTLS refused: %s", strings.Join(lines, " "))
+			if err != nil {
+				result.Error = fmt.Sprintf("STARTTLS failed: %v", err)
+			}
+			return result
+		}
+		if br.Buffered() > 0 {
+			result.Error = "server sent data after accepting STARTTLS" // Would be injected into the TLS session
+			return result
+		}
+		tlsConn, info, err := serviceHandshake(ctx, raw, host, "starttls")
+		if err != nil {
+			result.Error = fmt.Sprintf("TLS handshake failed: %v", err)
+			return result
+		}
+		conn, result.TLS = tlsConn, info
+		br = bufio.NewReader(conn)
+	}
+
+	if req.IdleSeconds > 0 {
+		result.Idle = idleService(ctx, conn, br, raw, result.TLS != nil, idleWait(req.IdleSeconds))
+	}
+	return result
+}
+
+// serviceHandshake runs a TLS handshake over an established connection and
+// describes the session, including what a verifying client would make of
+// the certificate.
+func serviceHandshake(ctx context.Context, conn net.Conn, host, mode string) (*tls.Conn, *TCPServiceTLS, error) {
+	ctx, cancel := context.WithTimeout(ctx, tlsProbeTimeout)
+	defer cancel()
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify: true, // Trust is checked by checkCertificate
+		ServerName:         host,
+	})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, nil, err
+	}
+	state := tlsConn.ConnectionState()
+	return tlsConn, &TCPServiceTLS{
+		Mode:        mode,
+		Version:     tlsVersionToString(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		Certificate: checkCertificate(&state, host),
+	}, nil
+}
+
+// idleService leaves the connection idle and reports when and how the
+// server ends it. Servers often announce the timeout first, such as SMTP's
+// "421 timeout", which is kept as the message.
+func idleService(ctx context.Context, conn net.Conn, br *bufio.Reader, raw *closeRecorder, isTLS bool, wait time.Duration) *TCPServiceIdle {
+	scheme := "tcp"
+	if isTLS {
+		scheme = "https" // Makes waitForClose look past a close_notify
+	}
+	ic := &idleConn{conn: conn, br: br, raw: raw, target: &url.URL{Scheme: scheme}, idleFrom: time.Now()}
+	idle := &TCPServiceIdle{WaitedSeconds: wait.Seconds()}
+
+	closeType, after, _ := ic.waitForClose(ctx, wait)
+	if closeType == "Data" {
+		line, _ := readLine(br)
+		idle.Message = strings.Join(line, " ")
+		closeType, after, _ = ic.waitForClose(ctx, wait)
+	}
+	idle.CloseType = closeType
+	if closeType != "None" {
+		idle.ClosedAfterSeconds = after.Seconds()
+	}
+	return idle
+}
+
+func serviceCommand(conn net.Conn, br *bufio.Reader, command string, read replyReader) ([]string, error) {
+	conn.SetWriteDeadline(time.Now().Add(serviceReplyTimeout))
+	if _, err := io.WriteString(conn, command+"\r\n"); err != nil {
+		return nil, err
+	}
+	return serviceReply(conn, br, read)
+}
+
+func serviceReply(conn net.Conn, br *bufio.Reader, read replyReader) ([]string, error) {
+	conn.SetReadDeadline(time.Now().Add(serviceReplyTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	return read(br)
+}
+
+// readLine reads one CRLF or LF terminated line.
+func readLine(br *bufio.Reader) ([]string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil && line == "" {
+		return nil, err
+	}
+	if len(line) > maxServiceLine {
+		line = line[:maxServiceLine]
+	}
+	return []string{strings.TrimRight(line, "\r\n")}, nil
+}
+
+// readCodedReply reads an SMTP or FTP reply, whose lines continue while
+// the status code is followed by a dash.
+func readCodedReply(br *bufio.Reader) ([]string, error) {
+	var lines []string
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, line[0])
+		if len(line[0]) < 4 || line[0][3] != '-' {
+			return lines, nil
+		}
+	}
+}
+
+// readIMAPReply reads untagged lines up to the tagged completion.
+func readIMAPReply(br *bufio.Reader) ([]string, error) {
+	var lines []string
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, line[0])
+		if !strings.HasPrefix(line[0], "* ") {
+			return lines, nil
+		}
+	}
+}
+
+// readPOP3List reads the reply to a multi-line POP3 command: a status
+// line and, when positive, the lines up to the terminating dot.
+func readPOP3List(br *bufio.Reader) ([]string, error) {
+	lines, err := readLine(br)
+	if err != nil || !strings.HasPrefix(lines[0], "+OK") {
+		return lines, err
+	}
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			return lines, err
+		}
+		if line[0] == "." {
+			return lines, nil
+		}
+		lines = append(lines, line[0])
+	}
+}
+
+// tcpServiceHandler probes the non-HTTP TCP service described in the
+// request body.
+func tcpServiceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req tcpServiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Protocol = strings.ToLower(req.Protocol)
+	if req.Protocol == "" {
+		req.Protocol = "raw"
+	}
+	if _, ok := serviceProtocols[req.Protocol]; !ok {
+		http.Error(w, "Unknown protocol", http.StatusBadRequest)
+		return
+	}
+	if _, _, err := net.SplitHostPort(req.Address); err != nil {
+		defaults, ok := serviceDefaults[req.Protocol]
+		if !ok || req.Address == "" {
+			http.Error(w, "address must be host:port", http.StatusBadRequest)
+			return
+		}
+		port := defaults.port
+		if req.TLS {
+			port = defaults.implicitTLS
+		}
+		req.Address = net.JoinHostPort(req.Address, port)
+	}
+
+	source, err := newSourceSelection(req.Source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	proxy, err := newProxyTunnel(req.Proxy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := withBudget(withProxy(withSource(r.Context(), source), proxy), newBudget(nil))
+	defer cancel()
+	result := probeTCPService(ctx, req)
+	result.Source = source.info()
+	if proxy != nil {
+		result.Proxy = proxy.info()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Bundled third-party origin categories, replaced at startup by
+// -third-party-file
+//
+//go:embed data/thirdparty.json
+var bundledThirdParty []byte
+
+// thirdPartyData is the format of data/thirdparty.json. A domain covers
+// its subdomains; the longest matching domain decides.
+type thirdPartyData struct {
+	Updated string            `json:"updated"`
+	Origins []thirdPartyEntry `json:"origins"`
+}
+
+type thirdPartyEntry struct {
+	Domain   string `json:"domain"`
+	Vendor   string `json:"vendor"`
+	Category string `json:"category"` // analytics, ads, cdn, fonts or social
+}
+
+var thirdParty = mustParseThirdParty(bundledThirdParty)
+
+func mustParseThirdParty(data []byte) *thirdPartyData {
+	parsed, err := parseThirdParty(data)
+	if err != nil {
+		panic("invalid bundled third-party data: " + err.Error())
+	}
+	return parsed
+}
+
+func parseThirdParty(data []byte) (*thirdPartyData, error) {
+	var parsed thirdPartyData
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, err
+	}
+	for i, e := range parsed.Origins {
+		if e.Domain == "" || e.Category == "" {
+			return nil, fmt.Errorf("origin %d needs a domain and a category", i)
+		}
+		parsed.Origins[i].Domain = strings.ToLower(strings.TrimSuffix(e.Domain, "."))
+	}
+	return &parsed, nil
+}
+
+// loadThirdPartyFile replaces the bundled third-party data.
+func loadThirdPartyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	parsed, err := parseThirdParty(data)
+	if err != nil {
+		return err
+	}
+	thirdParty = parsed
+	return nil
+}
+
+// categorizeHost returns the vendor and category of host, or "other" when
+// the list doesn't know it.
+func categorizeHost(host string) (string, string) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	best := thirdPartyEntry{Category: "other"}
+	for _, e := range thirdParty.Origins {
+		if (host == e.Domain || strings.HasSuffix(host, "."+e.Domain)) && len(e.Domain) > len(best.Domain) {
+			best = e
+		}
+	}
+	return best.Vendor, best.Category
+}
+
+// cspOrigins lists the external origins the page loads from and those its
+// policies allow by host, each categorized, so what a policy lets in is a
+// deliberate choice. Origins a policy lists but nothing loads from are
+// candidates for removal.
+func cspOrigins(page *url.URL, resources []PageResource, policies []*cspPolicy) []CSPOrigin {
+	var origins []CSPOrigin
+	index := make(map[string]int)
+	var urls []*url.URL // Of origins, for matching policy sources
+
+	for _, r := range resources {
+		u, err := url.Parse(r.URL)
+		if r.Kind != "url" || err != nil || u.Host == "" || selfMatches(u, page) {
+			continue
+		}
+		origin := u.Scheme + "://" + u.Host
+		i, ok := index[origin]
+		if !ok {
+			vendor, category := categorizeHost(u.Hostname())
+			i = len(origins)
+			index[origin] = i
+			origins = append(origins, CSPOrigin{Origin: origin, Category: category, Vendor: vendor})
+			urls = append(urls, &url.URL{Scheme: u.Scheme, Host: u.Host})
+		}
+		origins[i].Resources++
+		if !containsString(origins[i].Directives, r.Directive) {
+			origins[i].Directives = append(origins[i].Directives, r.Directive)
+		}
+	}
+
+	for _, p := range policies {
+		for _, name := range p.order {
+			for _, source := range p.directives[name] {
+				host := cspSourceHost(source)
+				if host == "" {
+					continue
+				}
+				matched := false
+				for i, u := range urls {
+					if cspSourceMatches(source, u, page) {
+						origins[i].Listed, matched = true, true
+					}
+				}
+				key := strings.ToLower(source)
+				if _, ok := index[key]; matched || ok {
+					continue
+				}
+				vendor, category := categorizeHost(strings.TrimPrefix(host, "*."))
+				index[key] = len(origins)
+				origins = append(origins, CSPOrigin{Origin: key, Category: category, Vendor: vendor, Listed: true})
+			}
+		}
+	}
+
+	sort.SliceStable(origins, func(i, j int) bool {
+		if origins[i].Category != origins[j].Category {
+			return origins[i].Category < origins[j].Category
+		}
+		return origins[i].Origin < origins[j].Origin
+	})
+	return origins
+}
+
+// cspSourceHost returns the host of a host-source, or "" for keywords,
+// nonces, hashes, scheme sources and *.
+func cspSourceHost(source string) string {
+	if source == "*" || strings.HasPrefix(source, "'") || strings.HasSuffix(source, ":") && !strings.Contains(source, "/") {
+		return ""
+	}
+	rest := source
+	if i := strings.Index(rest, "://"); i >= 0 {
+		rest = rest[i+3:]
+	}
+	if i := strings.Index(rest, "/"); i >= 0 {
+		rest = rest[:i]
+	}
+	if i := strings.LastIndex(rest, ":"); i >= 0 && !strings.HasSuffix(rest, "]") {
+		rest = rest[:i]
+	}
+	return strings.ToLower(rest)
+}
+
+// trackerFindings points out the analytics and advertising origins the
+// page loads from.
+func trackerFindings(origins []CSPOrigin) []Finding {
+	var trackers []string
+	for _, o := range origins {
+		if o.Resources > 0 && (o.Category == "analytics" || o.Category == "ads") {
+			trackers = append(trackers, fmt.Sprintf("%s (%s, %s)", o.Origin, o.Vendor, o.Category))
+		}
+	}
+	if len(trackers) == 0 {
+		return nil
+	}
+	return []Finding{{Severity: severityInfo, ID: "third-party-trackers",
+		Message: fmt.Sprintf("The page loads from %d analytics or advertising origins", len(trackers)),
+		Detail:  strings.Join(trackers, ", ")}}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"sync"
+)
+
+// clientAuthRecorder remembers whether a target asked for a client
+// certificate during any handshake of an analysis.
+type clientAuthRecorder struct {
+	mu   sync.Mutex
+	info ClientAuthInfo
+}
+
+func (rec *clientAuthRecorder) result() *ClientAuthInfo {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	info := rec.info
+	return &info
+}
+
+// newTLSConfig builds the client TLS configuration used for connections to
+// the target. A per-request client certificate takes precedence over the
+// one configured at startup. Certificates are only verified when asked
+// to, or when the request pins the target's keys.
+func newTLSConfig(opts analysisRequest, rec *clientAuthRecorder) *tls.Config {
+	cert := cfg.clientCert
+	if opts.clientCert != nil {
+		cert = opts.clientCert
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: true, // Use with caution
+		GetClientCertificate: func(req *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			rec.mu.Lock()
+			defer rec.mu.Unlock()
+
+			rec.info.Requested = true
+			rec.info.AcceptableCAs = decodeDistinguishedNames(req.AcceptableCAs)
+			if cert == nil {
+				// Continue the handshake without a certificate
+				return &tls.Certificate{}, nil
+			}
+			rec.info.CertificateSent = true
+			return cert, nil
+		},
+	}
+
+	tlsConfig.VerifyConnection = verifyPeer(cfg.VerifyTLS || opts.VerifyTLS, cfg.rootCAs, opts.pins)
+
+	if profile, ok := tlsProfiles[opts.TLSProfile]; ok {
+		profile.apply(tlsConfig)
+	}
+
+	return tlsConfig
+}
+
+// loadClientCertificate parses the PEM certificate and key sent with a request
+func loadClientCertificate(opts analysisRequest) (*tls.Certificate, error) {
+	if opts.ClientCert == "" && opts.ClientKey == "" {
+		return nil, nil
+	}
+	cert, err := tls.X509KeyPair([]byte(opts.ClientCert), []byte(opts.ClientKey))
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// decodeDistinguishedNames turns the DER encoded names from a
+// CertificateRequest into readable strings.
+func decodeDistinguishedNames(names [][]byte) []string {
+	var decoded []string
+	for _, raw := range names {
+		var rdn pkix.RDNSequence
+		if _, err := asn1.Unmarshal(raw, &rdn); err != nil {
+			decoded = append(decoded, fmt.Sprintf("%x", raw))
+			continue
+		}
+		var name pkix.Name
+		name.FillFromRDNSequence(&rdn)
+		decoded = append(decoded, name.String())
+	}
+	return decoded
+}
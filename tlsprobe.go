@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// How long a single TLS probe connection may take
+const tlsProbeTimeout = 10 * time.Second
+
+// probeSessionResumption performs two handshakes against the target sharing
+// a session cache and reports whether the second one resumed the session.
+// Go's TLS client never sends early data, so 0-RTT acceptance is not tested.
+func probeSessionResumption(ctx context.Context, target *url.URL, tlsConfig *tls.Config) *SessionResumption {
+	result := &SessionResumption{}
+
+	tlsConfig.ServerName = target.Hostname()
+	tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+
+	addr := hostPort(target)
+
+	first, err := tlsHandshakeAndRequest(ctx, addr, target.Hostname(), tlsConfig)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Version = tlsVersionToString(first.Version)
+
+	second, err := tlsHandshakeAndRequest(ctx, addr, target.Hostname(), tlsConfig)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Resumed = second.DidResume
+
+	return result
+}
+
+// tlsHandshakeAndRequest completes a handshake and a HEAD request so that
+// TLS 1.3 session tickets, which arrive after the handshake, are processed.
+func tlsHandshakeAndRequest(ctx context.Context, addr, host string, tlsConfig *tls.Config) (tls.ConnectionState, error) {
+	ctx, cancel := context.WithTimeout(ctx, tlsProbeTimeout)
+	defer cancel()
+
+	conn, err := dialContext(ctx, &net.Dialer{}, "tcp", addr)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = host
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	deadline, _ := ctx.Deadline()
+	tlsConn.SetDeadline(deadline)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return tls.ConnectionState{}, err
+	}
+
+	httpRequest := "HEAD / HTTP/1.1\r\nHost: " + host + "\r\n" + markRawProbe(ctx) + "Connection: close\r\n\r\n"
+	if _, err := tlsConn.Write([]byte(httpRequest)); err != nil {
+		return tls.ConnectionState{}, err
+	}
+	// Drain the response, a read error here still leaves a usable state
+	io.Copy(io.Discard, tlsConn)
+
+	return tlsConn.ConnectionState(), nil
+}
+
+// hostPort returns host:port for a URL, filling in the scheme default.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
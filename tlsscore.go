@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Grades from best to worst; a configuration gets the worst grade any of
+// its problems caps it at
+var tlsGrades = []string{"A", "A-", "B", "C", "F"}
+
+// scoreTLS handshakes with the target once per protocol version and then
+// enumerates the TLS 1.2 cipher suites it accepts, in its preference
+// order, to grade the configuration. Go's TLS client does not implement
+// DHE or SSLv3, so DH parameter sizes and SSLv3 support are not tested.
+func scoreTLS(ctx context.Context, target *url.URL, cert *CertificateCheck) *TLSScore {
+	score := &TLSScore{NotTested: []string{"SSLv3", "DHE key exchange and DH parameter size"}}
+	addr, host := hostPort(target), target.Hostname()
+
+	for _, version := range []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13} {
+		state, err := tlsHandshake(ctx, addr, host, version, suitesFor(version))
+		if err != nil {
+			continue
+		}
+		score.Versions = append(score.Versions, tlsVersionToString(version))
+		if version == tls.VersionTLS13 {
+			score.Suites = append(score.Suites, describeSuite(state.CipherSuite, version))
+		}
+	}
+	if len(score.Versions) == 0 {
+		score.Error = "no TLS handshake succeeded"
+		return score
+	}
+
+	// Below TLS 1.3 the client's offer decides the suite, so removing each
+	// accepted suite from the offer reveals the next one the server takes
+	legacy := uint16(0)
+	for _, v := range []uint16{tls.VersionTLS12, tls.VersionTLS11, tls.VersionTLS10} {
+		if score.supports(v) {
+			legacy = v
+			break
+		}
+	}
+	if legacy != 0 {
+		offer := suitesFor(legacy)
+		for len(offer) > 0 && ctx.Err() == nil {
+			state, err := tlsHandshake(ctx, addr, host, legacy, offer)
+			if err != nil {
+				break
+			}
+			score.Suites = append(score.Suites, describeSuite(state.CipherSuite, legacy))
+			offer = removeSuite(offer, state.CipherSuite)
+		}
+	}
+
+	gradeTLS(score)
+	score.GradeIgnoringTrust = score.Grade
+	if cert != nil && !cert.Verified {
+		score.Grade = "T" // Untrusted certificate, as SSL Labs reports it
+	}
+	return score
+}
+
+func (s *TLSScore) supports(version uint16) bool {
+	name := tlsVersionToString(version)
+	for _, v := range s.Versions {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// gradeTLS turns the supported versions and suites into findings and a
+// letter grade.
+func gradeTLS(s *TLSScore) {
+	worst := 0
+	limit := func(grade, severity, id, message, detail string) {
+		for i, g := range tlsGrades {
+			if g == grade && i > worst {
+				worst = i
+			}
+		}
+		s.Findings = append(s.Findings, Finding{Severity: severity, ID: id, Message: message, Detail: detail})
+	}
+
+	tls13, tls12 := s.supports(tls.VersionTLS13), s.supports(tls.VersionTLS12)
+	if !tls12 && !tls13 {
+		limit("C", severityHigh, "tls-no-modern-version", "Neither TLS 1.2 nor TLS 1.3 is supported", "")
+	} else if !tls13 {
+		limit("A-", severityLow, "tls-no-tls13", "TLS 1.3 is not supported", "")
+	}
+	for _, v := range []uint16{tls.VersionTLS10, tls.VersionTLS11} {
+		if s.supports(v) {
+			limit("B", severityMedium, "tls-legacy-version", tlsVersionToString(v)+" is supported",
+				"TLS 1.0 and 1.1 are deprecated by RFC 8996 and rejected by current browsers.")
+		}
+	}
+
+	var weak, rsaKex []string
+	forwardSecrecy, aead := tls13, tls13
+	for _, suite := range s.Suites {
+		if suite.Weak {
+			weak = append(weak, suite.Name)
+		}
+		if strings.HasPrefix(suite.Name, "TLS_RSA_") {
+			rsaKex = append(rsaKex, suite.Name)
+		}
+		forwardSecrecy = forwardSecrecy || suite.ForwardSecrecy
+		aead = aead || suite.AEAD
+	}
+	if len(weak) > 0 {
+		limit("C", severityHigh, "tls-weak-cipher", "RC4 or 3DES cipher suites are accepted", strings.Join(weak, ", "))
+	}
+	if !forwardSecrecy {
+		limit("C", severityHigh, "tls-no-forward-secrecy", "No cipher suite provides forward secrecy",
+			"A stolen private key decrypts every recorded session.")
+	} else if len(rsaKex) > 0 {
+		limit("B", severityMedium, "tls-rsa-key-exchange", "RSA key exchange suites are accepted",
+			"Clients that pick them get no forward secrecy: "+strings.Join(rsaKex, ", "))
+	}
+	if !aead {
+		limit("B", severityMedium, "tls-cbc-only", "Only CBC mode cipher suites are available",
+			"CBC suites in TLS are prone to padding oracle attacks such as Lucky13.")
+	}
+
+	s.Grade = tlsGrades[worst]
+}
+
+// tlsHandshake completes a handshake restricted to one protocol version
+// and, below TLS 1.3, to the given suites. Certificates are not verified;
+// the configuration is graded, trust is reported separately.
+func tlsHandshake(ctx context.Context, addr, host string, version uint16, suites []uint16) (tls.ConnectionState, error) {
+	ctx, cancel := context.WithTimeout(ctx, tlsProbeTimeout)
+	defer cancel()
+
+	conn, err := dialContext(ctx, &net.Dialer{}, "tcp", addr)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify: true, // Trust is checked by checkCertificate
+		ServerName:         host,
+		MinVersion:         version,
+		MaxVersion:         version,
+		CipherSuites:       suites,
+	})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return tls.ConnectionState{}, err
+	}
+	return tlsConn.ConnectionState(), nil
+}
+
+// suitesFor lists every cipher suite Go implements for a version below
+// TLS 1.3, including the insecure ones, which are what is being looked for.
+func suitesFor(version uint16) []uint16 {
+	var ids []uint16
+	for _, list := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, suite := range list {
+			for _, v := range suite.SupportedVersions {
+				if v == version && v != tls.VersionTLS13 {
+					ids = append(ids, suite.ID)
+					break
+				}
+			}
+		}
+	}
+	return ids
+}
+
+func removeSuite(suites []uint16, id uint16) []uint16 {
+	var rest []uint16
+	for _, s := range suites {
+		if s != id {
+			rest = append(rest, s)
+		}
+	}
+	return rest
+}
+
+// describeSuite classifies a negotiated cipher suite.
+func describeSuite(id uint16, version uint16) TLSSuite {
+	name := tls.CipherSuiteName(id)
+	suite := TLSSuite{Name: name, Version: tlsVersionToString(version)}
+	if version == tls.VersionTLS13 {
+		suite.ForwardSecrecy, suite.AEAD = true, true
+		return suite
+	}
+	suite.ForwardSecrecy = strings.HasPrefix(name, "TLS_ECDHE_")
+	suite.AEAD = strings.Contains(name, "_GCM_") || strings.Contains(name, "CHACHA20_POLY1305")
+	suite.Weak = strings.Contains(name, "_RC4_") || strings.Contains(name, "3DES")
+	return suite
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// A records whose network is looked up
+const maxTopologyASNLookups = 4
+
+// cdnSignature is how a provider shows up in DNS and the response.
+type cdnSignature struct {
+	provider string
+	cnames   []string // CNAME target suffixes
+	asns     []int
+	via      []string // Substrings of a Via entry, lower case
+	servers  []string // Server header prefixes, lower case
+}
+
+var cdnSignatures = []cdnSignature{
+	{provider: "cloudflare", cnames: []string{".cdn.cloudflare.net."}, asns: []int{13335}, servers: []string{"cloudflare"}},
+	{provider: "cloudfront", cnames: []string{".cloudfront.net."}, via: []string{"cloudfront"}, servers: []string{"cloudfront"}},
+	{provider: "fastly", cnames: []string{".fastly.net.", ".fastlylb.net."}, asns: []int{54113}},
+	{provider: "akamai", cnames: []string{".akamaiedge.net.", ".edgekey.net.", ".edgesuite.net.", ".akamai.net.", ".akamaized.net."},
+		asns: []int{20940, 16625, 21342, 12222, 35994}, servers: []string{"akamaighost", "akamainetstorage"}},
+	{provider: "azure-front-door", cnames: []string{".azurefd.net.", ".azureedge.net."}},
+	{provider: "google", asns: []int{15169, 396982}, via: []string{"google"}, servers: []string{"gws", "google frontend"}},
+	{provider: "imperva", cnames: []string{".incapdns.net."}, asns: []int{19551}},
+	{provider: "heroku", cnames: []string{".herokudns.com.", ".herokuapp.com."}, via: []string{"vegur"}},
+	{provider: "varnish", via: []string{"varnish"}, servers: []string{"varnish"}},
+}
+
+// inferTopology combines the CNAME chain, the networks announcing the A
+// records, the Via chain and the CDN and Server headers into the layers a
+// request passes through, e.g. Cloudflare in front of Fastly in front of
+// the origin. DNS names the layer clients connect to; the Via entries,
+// added by each proxy as the response passes back, give the order of the
+// layers behind it. Layers seen only in other headers are placed after
+// those, as their position can't be told.
+func inferTopology(ctx context.Context, headers http.Header, name string, addrs []string, cdn *CDNDetection) *Topology {
+	topology := &Topology{}
+
+	if servers, err := systemNameservers(); err != nil {
+		topology.Error = err.Error()
+	} else {
+		if topology.CNAMEChain, err = lookupCNAMEChain(ctx, servers[0], name); err != nil {
+			topology.Error = err.Error()
+		}
+		for i, addr := range addrs {
+			if i == maxTopologyASNLookups {
+				break
+			}
+			topology.ASNs = append(topology.ASNs, lookupASN(ctx, servers[0], addr))
+		}
+	}
+
+	// The Via header of a plain Varnish is Fastly's when Fastly is detected
+	fastly := cdn != nil && containsString(cdn.Providers, "fastly")
+	add := func(provider, role, evidence string) {
+		if provider == "varnish" && fastly {
+			provider = "fastly"
+		}
+		for i := range topology.Hops {
+			if topology.Hops[i].Provider == provider {
+				topology.Hops[i].Evidence = append(topology.Hops[i].Evidence, evidence)
+				return
+			}
+		}
+		topology.Hops = append(topology.Hops, TopologyHop{Provider: provider, Role: role, Evidence: []string{evidence}})
+	}
+
+	for _, cname := range topology.CNAMEChain {
+		if sig := matchSignature(func(sig cdnSignature) bool { return hasAnySuffix(strings.ToLower(cname), sig.cnames) }); sig != nil {
+			add(sig.provider, "edge", "CNAME "+cname)
+		}
+	}
+	for _, asn := range topology.ASNs {
+		if asn.ASN == 0 {
+			continue
+		}
+		evidence := fmt.Sprintf("%s in AS%d %s", asn.IP, asn.ASN, asn.Name)
+		if sig := matchSignature(func(sig cdnSignature) bool { return containsInt(sig.asns, asn.ASN) }); sig != nil {
+			add(sig.provider, "edge", evidence)
+		} else if len(topology.Hops) == 0 {
+			add(fmt.Sprintf("AS%d %s", asn.ASN, asn.Name), "edge", evidence)
+		}
+	}
+
+	for _, hop := range parseVia(headers.Values("Via")) {
+		if hop.Provider != "" {
+			add(hop.Provider, "cdn", "Via: "+hop.Raw)
+		} else {
+			add(hop.ReceivedBy, "proxy", "Via: "+hop.Raw)
+		}
+	}
+
+	if cdn != nil {
+		for _, provider := range cdn.Providers {
+			add(provider, "cdn", "response headers")
+		}
+	}
+
+	origin := TopologyHop{Provider: "origin", Role: "origin"}
+	if server := headers.Get("Server"); server != "" {
+		if sig := matchSignature(func(sig cdnSignature) bool { return hasAnyPrefix(strings.ToLower(server), sig.servers) }); sig != nil {
+			add(sig.provider, "cdn", "Server: "+server)
+		} else {
+			origin.Evidence = append(origin.Evidence, "Server: "+server)
+		}
+	}
+	if len(topology.Hops) > 0 {
+		topology.Hops[0].Role = "edge"
+	}
+	topology.Hops = append(topology.Hops, origin)
+
+	topology.Diagram = topologyDiagram(topology.Hops)
+	return topology
+}
+
+func matchSignature(match func(cdnSignature) bool) *cdnSignature {
+	for i := range cdnSignatures {
+		if match(cdnSignatures[i]) {
+			return &cdnSignatures[i]
+		}
+	}
+	return nil
+}
+
+// topologyDiagram draws the hops as "client → cloudflare → fastly →
+// origin (nginx)".
+func topologyDiagram(hops []TopologyHop) string {
+	parts := []string{"client"}
+	for _, hop := range hops {
+		part := hop.Provider
+		if hop.Role == "origin" {
+			for _, evidence := range hop.Evidence {
+				if strings.HasPrefix(evidence, "Server: ") {
+					part += " (" + strings.TrimPrefix(evidence, "Server: ") + ")"
+				}
+			}
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " → ")
+}
+
+func hasAnySuffix(s string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(s string, substrings []string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadCABundle returns the system roots plus the certificates in the PEM
+// bundle at path, so internal CAs are trusted alongside public ones.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pemData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool() // No system roots on this platform
+	}
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// parsePins decodes SPKI pins, base64 SHA-256 hashes of a certificate's
+// SubjectPublicKeyInfo as produced by
+//
+//	openssl x509 -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+//
+// A "sha256/" or curl style "sha256//" prefix is accepted.
+func parsePins(pins []string) (map[string]bool, error) {
+	if len(pins) == 0 {
+		return nil, nil
+	}
+	parsed := make(map[string]bool, len(pins))
+	for _, pin := range pins {
+		pin = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(pin), "sha256/"), "/")
+		raw, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("invalid SPKI pin %q, expected a base64 SHA-256 hash", pin)
+		}
+		parsed[pin] = true
+	}
+	return parsed, nil
+}
+
+// spkiHash is the pin of a certificate's public key.
+func spkiHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// verifyPeer returns the tls.Config.VerifyConnection callback enforcing
+// chain verification against roots (nil for the system roots) when verify
+// is set, and the SPKI pins when there are any. InsecureSkipVerify stays
+// on so the many probes that dial by IP keep working; the hostname checked
+// is the SNI the probe sent.
+func verifyPeer(verify bool, roots *x509.CertPool, pins map[string]bool) func(tls.ConnectionState) error {
+	if !verify && len(pins) == 0 {
+		return nil
+	}
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("server sent no certificate")
+		}
+
+		chain := cs.PeerCertificates
+		if verify {
+			opts := x509.VerifyOptions{
+				Roots:         roots,
+				DNSName:       cs.ServerName,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			chains, err := cs.PeerCertificates[0].Verify(opts)
+			if err != nil {
+				return err
+			}
+			chain = chains[0]
+		}
+
+		if len(pins) == 0 {
+			return nil
+		}
+		for _, cert := range chain {
+			if pins[spkiHash(cert)] {
+				return nil
+			}
+		}
+		return fmt.Errorf("no certificate in the chain matches a pinned SPKI hash (leaf is %s)", spkiHash(cs.PeerCertificates[0]))
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// UDP gives no connection to fail, so each probe is sent a few times and
+// silence is only concluded after the last wait
+const (
+	udpAttempts    = 3
+	udpReplyWait   = 2 * time.Second
+	quicMinInitial = 1200 // Servers drop smaller Initial datagrams
+)
+
+var udpDefaultPorts = map[string]string{"dns": "53", "quic": "443", "ntp": "123"}
+
+// udpProbeRequest asks whether a UDP service answers from here.
+type udpProbeRequest struct {
+	Address  string `json:"address"`           // host:port, the port defaults per protocol
+	Protocol string `json:"protocol"`          // dns, quic, ntp or raw
+	Name     string `json:"name,omitempty"`    // DNS: name to query, default "."
+	Payload  string `json:"payload,omitempty"` // raw: hex encoded datagram
+
+	Source *sourceOptions `json:"source,omitempty"` // Local address or interface to probe from
+}
+
+// udpProtocol builds a datagram and interprets the reply, returning a
+// summary or an error when the reply is not a valid answer to it.
+type udpProtocol struct {
+	request func(req udpProbeRequest) ([]byte, error)
+	parse   func(sent, reply []byte, result *UDPProbeResult) error
+}
+
+var udpProtocols = map[string]udpProtocol{
+	"dns":  {dnsQuery, parseDNSReply},
+	"quic": {quicVersionProbe, parseQUICReply},
+	"ntp":  {ntpRequest, parseNTPReply},
+	"raw": {func(req udpProbeRequest) ([]byte, error) {
+		return hex.DecodeString(strings.ReplaceAll(req.Payload, " ", ""))
+	}, func(sent, reply []byte, result *UDPProbeResult) error {
+		result.Summary = fmt.Sprintf("%d bytes: %x", len(reply), reply[:minInt(len(reply), 32)])
+		return nil
+	}},
+}
+
+// probeUDP sends the protocol's request until a valid reply arrives or
+// the attempts run out.
+func probeUDP(ctx context.Context, req udpProbeRequest) *UDPProbeResult {
+	result := &UDPProbeResult{Address: req.Address, Protocol: req.Protocol}
+	proto := udpProtocols[req.Protocol]
+
+	payload, err := proto.request(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	conn, err := dialContext(ctx, &net.Dialer{}, "udp", req.Address)
+	if err != nil {
+		result.Error = describeConnError(err)
+		return result
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 64*1024)
+	var invalid error
+	for result.Attempts < udpAttempts && ctx.Err() == nil {
+		result.Attempts++
+		start := time.Now()
+		if _, err := conn.Write(payload); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		conn.SetReadDeadline(start.Add(udpReplyWait))
+		for {
+			n, err := conn.Read(buf)
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break // Try again
+			}
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				result.Error = "ICMP port unreachable: nothing listens on the port"
+				return result
+			}
+			if err != nil {
+				result.Error = describeConnError(err)
+				return result
+			}
+			if err := proto.parse(payload, buf[:n], result); err != nil {
+				invalid = err
+				continue // Stray or malformed datagram, keep waiting
+			}
+			result.Reachable = true
+			result.RTTMs = millisecondsSince(start)
+			result.ResponseBytes = n
+			return result
+		}
+	}
+
+	if invalid != nil {
+		result.Error = "unexpected reply: " + invalid.Error()
+	} else {
+		result.Error = fmt.Sprintf("no reply after %d attempts; the port is filtered or nothing listens", result.Attempts)
+	}
+	return result
+}
+
+// dnsQuery builds a recursive A query for req.Name.
+func dnsQuery(req udpProbeRequest) ([]byte, error) {
+	return newDNSQuery(req.Name, dnsTypeA, true)
+}
+
+func parseDNSReply(sent, reply []byte, result *UDPProbeResult) error {
+	if len(reply) < 12 || reply[0] != sent[0] || reply[1] != sent[1] || reply[2]&0x80 == 0 {
+		return errors.New("reply is not a DNS response to the query")
+	}
+	result.Summary = fmt.Sprintf("%s, %d answers", dnsRCodeName(int(reply[3]&0x0f)), binary.BigEndian.Uint16(reply[6:]))
+	if reply[3]&0x80 == 0 {
+		result.Summary += ", recursion not available"
+	}
+	return nil
+}
+
+// quicVersionProbe builds a padded Initial-sized long header packet with
+// a reserved version. Servers must answer it with a Version Negotiation
+// packet (RFC 9000 section 6), which proves QUIC is reachable without a
+// handshake.
+func quicVersionProbe(udpProbeRequest) ([]byte, error) {
+	pkt := make([]byte, quicMinInitial)
+	pkt[0] = 0xc0                                   // Long header, fixed bit
+	binary.BigEndian.PutUint32(pkt[1:], 0x1a2a3a4a) // Reserved version, RFC 9000 section 15
+	pkt[5] = 8
+	rand.Read(pkt[6:14]) // Destination connection ID
+	pkt[14] = 8
+	rand.Read(pkt[15:23]) // Source connection ID
+	return pkt, nil
+}
+
+var quicVersionNames = map[uint32]string{
+	0x00000001: "QUIC v1",
+	0x6b3343cf: "QUIC v2",
+	0xff00001d: "draft-29",
+}
+
+func parseQUICReply(sent, reply []byte, result *UDPProbeResult) error {
+	// Version Negotiation: long header, version 0, then the connection IDs
+	// swapped and the supported versions
+	if len(reply) < 7 || reply[0]&0x80 == 0 || binary.BigEndian.Uint32(reply[1:]) != 0 {
+		return errors.New("reply is not a QUIC Version Negotiation packet")
+	}
+	offset := 5
+	for i := 0; i < 2; i++ {
+		if offset >= len(reply) {
+			return errors.New("truncated QUIC Version Negotiation packet")
+		}
+		offset += 1 + int(reply[offset])
+	}
+	result.QUICVersions = nil
+	for ; offset+4 <= len(reply); offset += 4 {
+		v := binary.BigEndian.Uint32(reply[offset:])
+		if v&0x0f0f0f0f == 0x0a0a0a0a {
+			continue // Greased
+		}
+		name, ok := quicVersionNames[v]
+		if !ok {
+			name = fmt.Sprintf("0x%08x", v)
+		}
+		result.QUICVersions = append(result.QUICVersions, name)
+	}
+	result.Summary = "QUIC versions: " + strings.Join(result.QUICVersions, ", ")
+	return nil
+}
+
+// ntpRequest builds an SNTP client request (RFC 4330).
+func ntpRequest(udpProbeRequest) ([]byte, error) {
+	pkt := make([]byte, 48)
+	pkt[0] = 0x23 // Version 4, client mode
+	// Transmit timestamp, echoed by the server as the originate timestamp
+	rand.Read(pkt[40:48])
+	return pkt, nil
+}
+
+// Seconds between the NTP epoch (1900) and the Unix epoch
+const ntpEpochOffset = 2208988800
+
+func parseNTPReply(sent, reply []byte, result *UDPProbeResult) error {
+	if len(reply) < 48 || reply[0]&0x07 != 4 || string(reply[24:32]) != string(sent[40:48]) {
+		return errors.New("reply is not an NTP server response to the request")
+	}
+	stratum := reply[1]
+	secs := binary.BigEndian.Uint32(reply[40:])
+	frac := binary.BigEndian.Uint32(reply[44:])
+	serverTime := time.Unix(int64(secs)-ntpEpochOffset, int64(frac)*1e9>>32)
+	result.Summary = fmt.Sprintf("stratum %d, clock offset %v", stratum, serverTime.Sub(time.Now()).Round(time.Millisecond))
+	if stratum == 0 {
+		result.Summary = "kiss-o'-death: " + strings.TrimRight(string(reply[12:16]), "\x00")
+	}
+	return nil
+}
+
+// udpProbeHandler checks whether the UDP service in the request body
+// answers from the analyzer's network.
+func udpProbeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req udpProbeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Protocol = strings.ToLower(req.Protocol)
+	if _, ok := udpProtocols[req.Protocol]; !ok {
+		http.Error(w, "Unknown protocol", http.StatusBadRequest)
+		return
+	}
+	if _, _, err := net.SplitHostPort(req.Address); err != nil {
+		port, ok := udpDefaultPorts[req.Protocol]
+		if !ok || req.Address == "" {
+			http.Error(w, "address must be host:port", http.StatusBadRequest)
+			return
+		}
+		req.Address = net.JoinHostPort(req.Address, port)
+	}
+
+	source, err := newSourceSelection(req.Source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := withBudget(withSource(r.Context(), source), newBudget(nil))
+	defer cancel()
+	result := probeUDP(ctx, req)
+	result.Source = source.info()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// User-Agent presets for the bot-detection comparison, in the order they
+// are requested. The first one is the baseline the others are diffed against.
+var userAgentPresets = []struct {
+	name      string
+	userAgent string
+}{
+	{"chrome", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"},
+	{"curl", "curl/8.4.0"},
+	{"googlebot", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"},
+}
+
+// Headers that change on every response and would make every comparison differ
+var volatileHeaders = map[string]bool{
+	"Date":             true,
+	"Age":              true,
+	"Expires":          true,
+	"Set-Cookie":       true,
+	"Cf-Ray":           true,
+	"X-Request-Id":     true,
+	"X-Amz-Cf-Id":      true,
+	"X-Amzn-Requestid": true,
+	"X-Amzn-Trace-Id":  true,
+	"Report-To":        true,
+	"Nel":              true,
+}
+
+// compareUserAgents repeats the request with several User-Agent strings
+// and reports where the answer differs from the first one, which reveals
+// bot management that serves some clients differently. Each entry of
+// agents is a preset name or a literal User-Agent; empty means all presets.
+func compareUserAgents(ctx context.Context, target *url.URL, tlsConfig *tls.Config, agents []string) []UserAgentResult {
+	client := newProbeClient(tlsConfig)
+	defer client.CloseIdleConnections()
+
+	var results []UserAgentResult
+	var baseline *http.Response
+	var baselineBody string
+	for _, agent := range resolveUserAgents(agents) {
+		result := UserAgentResult{Name: agent[0], UserAgent: agent[1]}
+
+		resp, body, err := doRequest(ctx, client, http.MethodGet, target, http.Header{"User-Agent": {agent[1]}})
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		result.StatusCode = resp.StatusCode
+		result.KeepAlive = resp.Header.Get("Keep-Alive")
+		result.Connection = resp.Header.Get("Connection")
+		result.Server = resp.Header.Get("Server")
+		result.BodyBytes = len(body)
+		result.BodyHash = truncatedSHA256(string(body))
+
+		if baseline == nil {
+			baseline, baselineBody = resp, result.BodyHash
+		} else {
+			result.Differences = diffResponses(baseline, resp)
+			if result.BodyHash != baselineBody {
+				result.Differences = append(result.Differences, "Body differs")
+			}
+			result.Differs = len(result.Differences) > 0
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// resolveUserAgents returns name and User-Agent pairs for the requested
+// agents, expanding preset names.
+func resolveUserAgents(agents []string) [][2]string {
+	var resolved [][2]string
+	if len(agents) == 0 {
+		for _, p := range userAgentPresets {
+			resolved = append(resolved, [2]string{p.name, p.userAgent})
+		}
+		return resolved
+	}
+
+	for _, agent := range agents {
+		pair := [2]string{"custom", agent}
+		for _, p := range userAgentPresets {
+			if strings.EqualFold(agent, p.name) {
+				pair = [2]string{p.name, p.userAgent}
+			}
+		}
+		resolved = append(resolved, pair)
+	}
+	return resolved
+}
+
+// diffResponses lists status and header differences between two responses,
+// ignoring headers that change on every request.
+func diffResponses(base, resp *http.Response) []string {
+	var diffs []string
+	if resp.StatusCode != base.StatusCode {
+		diffs = append(diffs, fmt.Sprintf("Status %d instead of %d", resp.StatusCode, base.StatusCode))
+	}
+
+	names := make(map[string]bool)
+	for name := range base.Header {
+		names[name] = true
+	}
+	for name := range resp.Header {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		a, b := strings.Join(base.Header.Values(name), ", "), strings.Join(resp.Header.Values(name), ", ")
+		switch {
+		case a == "" && b != "":
+			diffs = append(diffs, fmt.Sprintf("Adds %s", name))
+		case a != "" && b == "":
+			diffs = append(diffs, fmt.Sprintf("Drops %s", name))
+		case a != b && !volatileHeaders[name]:
+			diffs = append(diffs, fmt.Sprintf("%s %q instead of %q", name, b, a))
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Longest time /api/validate waits for the target to resolve
+const validateResolveTimeout = 5 * time.Second
+
+// validateAnalysis checks an analysis request the way runAnalysis does and
+// resolves the target, without sending it anything, so a form can report
+// problems before the analysis is launched.
+func validateAnalysis(ctx context.Context, reqData analysisRequest) *TargetValidation {
+	v := &TargetValidation{Domain: reqData.Domain}
+
+	domain, dnsDomain, err := parseAnalysisRequest(&reqData)
+	var source *sourceSelection
+	if err == nil {
+		source, err = newSourceSelection(reqData.Source)
+	}
+	if err == nil {
+		_, err = newProxyTunnel(reqData.Proxy)
+	}
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	v.Valid = true
+	v.URL = domain
+	v.Host = dnsDomain
+	if unicode := toUnicode(dnsDomain); unicode != dnsDomain {
+		v.UnicodeHost = unicode
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(dnsDomain); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		// Resolved from the source the analysis would use
+		ctx, cancel := context.WithTimeout(withSource(ctx, source), validateResolveTimeout)
+		defer cancel()
+		addrs, err := resolverFor(ctx).LookupIPAddr(ctx, dnsDomain)
+		if err != nil {
+			v.ResolveError = err.Error()
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	v.Resolvable = len(ips) > 0
+	for _, ip := range ips {
+		verdict := targetPolicyVerdict(ip)
+		v.Addresses = append(v.Addresses, TargetAddress{IP: ip.String(), Scope: addressScope(ip), Policy: verdict})
+		if v.Policy != "blocked" {
+			v.Policy = verdict
+		}
+	}
+
+	v.Stages = plannedStages(reqData)
+	b := newBudget(reqData.Budget)
+	v.Budget = &BudgetUsage{MaxRequests: b.maxRequests, MaxBytes: b.maxBytes, MaxDurationMs: b.maxDuration.Milliseconds()}
+	// The addresses are only probed one by one when the analysis resolves
+	// them itself
+	addresses := 1
+	if !reqData.SkipDNS && len(v.Addresses) > 0 {
+		addresses = len(v.Addresses)
+	}
+	v.MinRequests = 1 + len(reqData.Listeners)*addresses
+	if !reqData.SkipIPs && !reqData.SkipDNS {
+		v.MinRequests += len(v.Addresses) * clampSampleCount(reqData.SampleCount)
+	}
+	for _, stage := range v.Stages {
+		switch stage {
+		case "idleProbe", "halfOpenProbe", "tcpKeepAlive", "http2Probe", "estimateIdleTimeout":
+			v.IdleWaitSeconds += int(idleWait(reqData.IdleProbeSeconds) / time.Second)
+		}
+	}
+	return v
+}
+
+// plannedStages lists the stages an analysis of reqData runs, named after
+// their options and in the order attemptHTTPConnection runs them.
+func plannedStages(reqData analysisRequest) []string {
+	stages := []string{}
+	if !reqData.SkipDNS {
+		stages = append(stages, "dns")
+	}
+	stages = append(stages, "request")
+	for _, stage := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"ipResults", !reqData.SkipIPs && !reqData.SkipDNS},
+		{"listeners", len(reqData.Listeners) > 0},
+		{"dnsBehavior", reqData.DNSBehavior && !reqData.SkipDNS},
+		{"delegationCheck", reqData.DelegationCheck && !reqData.SkipDNS},
+		{"compareFingerprints", reqData.CompareFingerprints},
+		{"idleProbe", reqData.IdleProbe},
+		{"halfOpenProbe", reqData.HalfOpenProbe},
+		{"tcpKeepAlive", reqData.TCPKeepAlive != nil},
+		{"rdap", reqData.RDAP},
+		{"siteHygiene", reqData.SiteHygiene},
+		{"probeMethods", reqData.ProbeMethods},
+		{"cacheAnalysis", reqData.CacheAnalysis},
+		{"varyAnalysis", reqData.VaryAnalysis},
+		{"protocolEdgeCases", reqData.ProtocolEdgeCases},
+		{"compareUserAgents", reqData.CompareUserAgents},
+		{"languageAnalysis", reqData.LanguageAnalysis},
+		{"downgradeProbe", reqData.DowngradeProbe},
+		{"headerLint", reqData.HeaderLint},
+		{"cspAnalysis", reqData.CSPAnalysis},
+		{"cdnDebug", reqData.CDNDebug},
+		{"topology", reqData.Topology},
+		{"probeCnameChain", reqData.ProbeCNAMEChain},
+		{"dscp", reqData.DSCP != nil},
+		{"http2Probe", reqData.HTTP2Probe},
+		{"grpcProbe", reqData.GRPCProbe},
+		{"estimateIdleTimeout", reqData.EstimateIdleTimeout},
+	} {
+		if stage.enabled {
+			stages = append(stages, stage.name)
+		}
+	}
+	return stages
+}
+
+// addressScope classifies where ip lives, so callers can see a target
+// pointing into a private network before probing it.
+func addressScope(ip net.IP) string {
+	switch {
+	case ip.IsLoopback():
+		return "loopback"
+	case ip.IsUnspecified():
+		return "unspecified"
+	case ip.IsLinkLocalUnicast(), ip.IsLinkLocalMulticast():
+		return "link-local"
+	case ip.IsMulticast():
+		return "multicast"
+	case ip.IsPrivate():
+		return "private"
+	}
+	return "public"
+}
+
+// validateHandler takes the same body as /analyze and answers with
+// TargetValidation. Problems with the request are reported in the body
+// rather than with a 400.
+func validateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var reqData analysisRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(validateAnalysis(r.Context(), reqData))
+}
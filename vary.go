@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// varyProbe is a request header the Vary analysis changes, with the value
+// the baseline request sends and the alternative it compares against.
+type varyProbe struct {
+	header      string
+	baseline    string
+	alternative string
+}
+
+var varyProbes = []varyProbe{
+	{"Accept-Encoding", "identity", "gzip, deflate, br"},
+	{"User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"},
+	{"Accept-Language", "en-US,en;q=0.9", "fr-FR,fr;q=0.9"},
+}
+
+// Response headers that describe the selected representation
+var representationHeaders = []string{"Content-Encoding", "Content-Type", "Content-Language", "Content-Location"}
+
+// analyzeVary changes one request header at a time and checks that every
+// header which changes the response is listed in Vary. A shared cache keys
+// only on the URL and the Vary headers, so an unlisted one lets a variant
+// meant for one client be served to everybody else.
+func analyzeVary(ctx context.Context, target *url.URL, tlsConfig *tls.Config) *VaryAnalysis {
+	result := &VaryAnalysis{}
+	client := newProbeClient(tlsConfig)
+	defer client.CloseIdleConnections()
+
+	baseline := http.Header{}
+	for _, p := range varyProbes {
+		baseline.Set(p.header, p.baseline)
+	}
+
+	// Two identical requests show whether the body can be compared at all
+	first, firstBody, err := doRequest(ctx, client, http.MethodGet, target, baseline)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	_, secondBody, err := doRequest(ctx, client, http.MethodGet, target, baseline)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.StableBody = truncatedSHA256(string(firstBody)) == truncatedSHA256(string(secondBody))
+
+	result.Vary = strings.Join(first.Header.Values("Vary"), ", ")
+	listed := make(map[string]bool)
+	for _, name := range strings.Split(result.Vary, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			result.VaryHeaders = append(result.VaryHeaders, name)
+			listed[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	if listed["*"] {
+		result.Issues = append(result.Issues, "Vary: * makes the response uncacheable for shared caches")
+	}
+	if listed["User-Agent"] {
+		result.Issues = append(result.Issues, "Vary: User-Agent fragments shared caches into one entry per browser build")
+	}
+
+	for _, p := range varyProbes {
+		dim := VaryDimension{Header: p.header, Value: p.alternative, Listed: listed[p.header] || listed["*"]}
+
+		header := baseline.Clone()
+		header.Set(p.header, p.alternative)
+		resp, body, err := doRequest(ctx, client, http.MethodGet, target, header)
+		if err != nil {
+			dim.Error = err.Error()
+			result.Dimensions = append(result.Dimensions, dim)
+			continue
+		}
+
+		if resp.StatusCode != first.StatusCode {
+			dim.Differences = append(dim.Differences, fmt.Sprintf("Status %d instead of %d", resp.StatusCode, first.StatusCode))
+		}
+		for _, name := range representationHeaders {
+			if a, b := first.Header.Get(name), resp.Header.Get(name); a != b {
+				dim.Differences = append(dim.Differences, fmt.Sprintf("%s %q instead of %q", name, b, a))
+			}
+		}
+		if result.StableBody && truncatedSHA256(string(body)) != truncatedSHA256(string(firstBody)) {
+			dim.Differences = append(dim.Differences, "Body differs")
+		}
+		dim.Differs = len(dim.Differences) > 0
+
+		if dim.Differs && !dim.Listed {
+			result.Issues = append(result.Issues, fmt.Sprintf("Response changes with %s but Vary does not list it, a shared cache can serve the wrong variant", p.header))
+		}
+		result.Dimensions = append(result.Dimensions, dim)
+	}
+
+	return result
+}
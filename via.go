@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+)
+
+// parseVia decomposes the Via headers of a response into hops, nearest
+// the client first. Each proxy adds its entry as the response passes back,
+// so the header lists them the other way round. Entries look like
+// "1.1 varnish", "HTTP/1.1 proxy.example.com:8080 (squid)" or
+// "1.1 d111.cloudfront.net (CloudFront)".
+func parseVia(values []string) []ViaHop {
+	entries := splitVia(values)
+	hops := make([]ViaHop, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		hops = append(hops, parseViaEntry(entries[i]))
+	}
+	if len(hops) == 0 {
+		return nil
+	}
+	return hops
+}
+
+// parseViaEntry parses one received-protocol, received-by and optional
+// comment, RFC 9110 section 7.6.3.
+func parseViaEntry(entry string) ViaHop {
+	hop := ViaHop{Raw: entry, Protocol: "HTTP"}
+
+	protocol, rest, _ := strings.Cut(entry, " ")
+	if name, version, ok := strings.Cut(protocol, "/"); ok {
+		hop.Protocol, hop.Version = name, version
+	} else {
+		hop.Version = protocol
+	}
+
+	// received-by runs to the first space or comment
+	rest = strings.TrimSpace(rest)
+	end := strings.IndexAny(rest, " \t(")
+	if end < 0 {
+		end = len(rest)
+	}
+	hop.ReceivedBy = rest[:end]
+	hop.Comment = strings.Join(viaComments(rest[end:]), " ")
+
+	// A host has a dot, a port or is an address; anything else is a
+	// pseudonym the proxy chose to hide its name
+	hop.Pseudonym = hop.ReceivedBy != "" && !strings.ContainsAny(hop.ReceivedBy, ".:[")
+
+	if sig := matchSignature(func(sig cdnSignature) bool { return containsAny(strings.ToLower(entry), sig.via) }); sig != nil {
+		hop.Provider = sig.provider
+	}
+	return hop
+}
+
+// viaComments returns the contents of the parenthesized comments in s.
+// Akamai sends two, as in "akamai.net(ghost) (AkamaiGHost)".
+func viaComments(s string) []string {
+	var comments []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			if depth == 0 {
+				start = i + 1
+			}
+			depth++
+		case ')':
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth == 0 {
+				comments = append(comments, strings.TrimSpace(s[start:i]))
+			}
+		}
+	}
+	return comments
+}
+
+// splitVia splits Via header values into their entries. Commas inside a
+// comment don't separate entries.
+func splitVia(values []string) []string {
+	var entries []string
+	for _, value := range values {
+		depth, start := 0, 0
+		for i := 0; i <= len(value); i++ {
+			if i < len(value) {
+				switch value[i] {
+				case '(':
+					depth++
+					continue
+				case ')':
+					if depth > 0 {
+						depth--
+					}
+					continue
+				case ',':
+					if depth > 0 {
+						continue
+					}
+				default:
+					continue
+				}
+			}
+			if entry := strings.TrimSpace(value[start:i]); entry != "" {
+				entries = append(entries, entry)
+			}
+			start = i + 1
+		}
+	}
+	return entries
+}
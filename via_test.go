@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVia(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []ViaHop
+	}{
+		{"empty", []string{"", " , "}, nil},
+		{"cdn", []string{"1.1 d111.cloudfront.net (CloudFront)"}, []ViaHop{
+			{Protocol: "HTTP", Version: "1.1", ReceivedBy: "d111.cloudfront.net", Comment: "CloudFront",
+				Provider: "cloudfront", Raw: "1.1 d111.cloudfront.net (CloudFront)"},
+		}},
+		{"two comments", []string{"1.1 akamai.net(ghost) (AkamaiGHost)"}, []ViaHop{
+			{Protocol: "HTTP", Version: "1.1", ReceivedBy: "akamai.net", Comment: "ghost AkamaiGHost",
+				Raw: "1.1 akamai.net(ghost) (AkamaiGHost)"},
+		}},
+		{"comma in nested comment", []string{"1.1 varnish, HTTP/1.1 proxy.example.com:8080 (squid/3.5 (Linux, x86))"}, []ViaHop{
+			{Protocol: "HTTP", Version: "1.1", ReceivedBy: "proxy.example.com:8080", Comment: "squid/3.5 (Linux, x86)",
+				Raw: "HTTP/1.1 proxy.example.com:8080 (squid/3.5 (Linux, x86))"},
+			{Protocol: "HTTP", Version: "1.1", ReceivedBy: "varnish", Pseudonym: true, Provider: "varnish", Raw: "1.1 varnish"},
+		}},
+		{"hops across headers", []string{"1.0 fred, 1.1 p.example.net", "2 [2001:db8::1]:443"}, []ViaHop{
+			{Protocol: "HTTP", Version: "2", ReceivedBy: "[2001:db8::1]:443", Raw: "2 [2001:db8::1]:443"},
+			{Protocol: "HTTP", Version: "1.1", ReceivedBy: "p.example.net", Raw: "1.1 p.example.net"},
+			{Protocol: "HTTP", Version: "1.0", ReceivedBy: "fred", Pseudonym: true, Raw: "1.0 fred"},
+		}},
+		{"other protocol", []string{"SPDY/3 edge"}, []ViaHop{
+			{Protocol: "SPDY", Version: "3", ReceivedBy: "edge", Pseudonym: true, Raw: "SPDY/3 edge"},
+		}},
+		{"unbalanced comment", []string{"1.1 proxy.example.com (squid, 1.1 other"}, []ViaHop{
+			{Protocol: "HTTP", Version: "1.1", ReceivedBy: "proxy.example.com", Raw: "1.1 proxy.example.com (squid, 1.1 other"},
+		}},
+	}
+	for _, tt := range tests {
+		if got := parseVia(tt.values); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseVia(%q) =\n%+v\nwant\n%+v", tt.name, tt.values, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// warningList collects the non-fatal problems of one analysis, such as a
+// stage that fell back to a weaker method, so partial results say what
+// they are missing.
+type warningList struct {
+	mu       sync.Mutex
+	findings []Finding
+}
+
+type warningsKey struct{}
+
+func withWarnings(ctx context.Context) (context.Context, *warningList) {
+	w := &warningList{}
+	return context.WithValue(ctx, warningsKey{}, w), w
+}
+
+// warn records a warning on the analysis ctx belongs to. Repeats of the
+// same warning are kept once.
+func warn(ctx context.Context, f Finding) {
+	w, _ := ctx.Value(warningsKey{}).(*warningList)
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, existing := range w.findings {
+		if existing.ID == f.ID && existing.Message == f.Message {
+			return
+		}
+	}
+	w.findings = append(w.findings, f)
+}
+
+func (w *warningList) list() []Finding {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]Finding(nil), w.findings...)
+}